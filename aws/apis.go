@@ -22,6 +22,9 @@ type ec2API interface {
 	ModifyImageAttribute(ctx context.Context, params *ec2.ModifyImageAttributeInput,
 		optFns ...func(*ec2.Options),
 	) (*ec2.ModifyImageAttributeOutput, error)
+	ModifySnapshotAttribute(ctx context.Context, params *ec2.ModifySnapshotAttributeInput,
+		optFns ...func(*ec2.Options),
+	) (*ec2.ModifySnapshotAttributeOutput, error)
 	RegisterImage(ctx context.Context, params *ec2.RegisterImageInput,
 		optFns ...func(*ec2.Options),
 	) (*ec2.RegisterImageOutput, error)
@@ -50,6 +53,12 @@ type s3API interface {
 	) (*s3.HeadBucketOutput, error)
 	CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options),
 	) (*s3.CreateBucketOutput, error)
+	PutBucketTagging(ctx context.Context, params *s3.PutBucketTaggingInput, optFns ...func(*s3.Options),
+	) (*s3.PutBucketTaggingOutput, error)
+	PutPublicAccessBlock(ctx context.Context, params *s3.PutPublicAccessBlockInput, optFns ...func(*s3.Options),
+	) (*s3.PutPublicAccessBlockOutput, error)
+	PutBucketEncryption(ctx context.Context, params *s3.PutBucketEncryptionInput, optFns ...func(*s3.Options),
+	) (*s3.PutBucketEncryptionOutput, error)
 	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options),
 	) (*s3.HeadObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options),
@@ -0,0 +1,110 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// checksumPartSize returns the part size to upload an image of the given size with.
+// It matches the adjustment of the S3 upload manager for large images of known size,
+// which it can't do itself for the non-seekable checksumReader. A negative size is unknown.
+func checksumPartSize(size int64) int64 {
+	partSize := s3manager.DefaultUploadPartSize
+	if size > 0 && size/partSize >= int64(s3manager.MaxUploadParts) {
+		partSize = size/int64(s3manager.MaxUploadParts) + 1
+	}
+	return partSize
+}
+
+// checksumReader computes the SHA256 checksums S3 stores for an object
+// uploaded from it, both for a single part and for a multipart upload.
+type checksumReader struct {
+	r        io.Reader
+	partSize int64
+
+	full     hash.Hash
+	part     hash.Hash
+	partRead int64
+	parts    [][]byte
+}
+
+func newChecksumReader(r io.Reader, partSize int64) *checksumReader {
+	return &checksumReader{r: r, partSize: partSize, full: sha256.New(), part: sha256.New()}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	data := p[:n]
+	c.full.Write(data)
+	for len(data) > 0 {
+		chunk := data[:min(int64(len(data)), c.partSize-c.partRead)]
+		c.part.Write(chunk)
+		c.partRead += int64(len(chunk))
+		data = data[len(chunk):]
+		if c.partRead == c.partSize {
+			c.finishPart()
+		}
+	}
+	return n, err
+}
+
+func (c *checksumReader) finishPart() {
+	c.parts = append(c.parts, c.part.Sum(nil))
+	c.part.Reset()
+	c.partRead = 0
+}
+
+// matches reports whether the checksum S3 returned for the object matches the data read.
+// Checksums of multipart uploads have the form <checksum of part checksums>-<number of parts>.
+func (c *checksumReader) matches(remote string) bool {
+	if c.partRead > 0 {
+		c.finishPart()
+	}
+	if !strings.Contains(remote, "-") {
+		return remote == base64.StdEncoding.EncodeToString(c.full.Sum(nil))
+	}
+	composite := sha256.New()
+	for _, part := range c.parts {
+		composite.Write(part)
+	}
+	return remote == fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(composite.Sum(nil)), len(c.parts))
+}
+
+// verifyChecksum compares the SHA256 checksum S3 stored for the uploaded blob with the checksum of the data read by checksums.
+func (u *Uploader) verifyChecksum(ctx context.Context, blobName string, checksums *checksumReader) error {
+	s3C, err := u.s3(ctx)
+	if err != nil {
+		return err
+	}
+	u.log.Printf("Verifying checksum of blob %s", blobName)
+	resp, err := s3C.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &u.config.AWS.Bucket,
+		Key:          &blobName,
+		ChecksumMode: s3types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return fmt.Errorf("getting checksum of blob %s: %w", blobName, err)
+	}
+	if resp.ChecksumSHA256 == nil {
+		return fmt.Errorf("blob %s has no SHA256 checksum", blobName)
+	}
+	if !checksums.matches(*resp.ChecksumSHA256) {
+		return fmt.Errorf("checksum mismatch for blob %s: S3 stored %s, which doesn't match the uploaded image", blobName, *resp.ChecksumSHA256)
+	}
+	return nil
+}
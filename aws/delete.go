@@ -0,0 +1,57 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// DeletePlan returns the operations Delete performs, without calling any AWS API.
+func (u *Uploader) DeletePlan() uploader.Plan {
+	var plan uploader.Plan
+	region := u.config.AWS.Region
+
+	for _, r := range append([]string{region}, u.config.AWS.ReplicationRegions...) {
+		plan.AddDetail(uploader.ActionDeleteIfExists, "ami", u.config.AWS.AMIName, r, "with backing snapshots")
+	}
+	plan.Add(uploader.ActionDeleteIfExists, "snapshot", u.config.AWS.SnapshotName, region)
+	plan.Add(uploader.ActionDeleteIfExists, "s3 object", u.blobPath(u.config.AWS.BlobName), region)
+	if u.config.AWS.DataImage != "" {
+		plan.Add(uploader.ActionDeleteIfExists, "snapshot", u.config.AWS.DataSnapshotName, region)
+		plan.Add(uploader.ActionDeleteIfExists, "s3 object", u.blobPath(u.config.AWS.DataBlobName), region)
+	}
+	return plan
+}
+
+// Delete removes the AMIs an upload with the current config creates in all regions,
+// together with their backing snapshots and snapshots or blobs left behind by a failed upload.
+// The bucket is shared and kept.
+func (u *Uploader) Delete(ctx context.Context) error {
+	for _, region := range append([]string{u.config.AWS.Region}, u.config.AWS.ReplicationRegions...) {
+		if err := u.ensureImageDeleted(ctx, region); err != nil {
+			return fmt.Errorf("deleting image in region %s: %w", region, err)
+		}
+	}
+	if err := u.ensureSnapshotDeleted(ctx, u.config.AWS.SnapshotName); err != nil {
+		return fmt.Errorf("deleting snapshot: %w", err)
+	}
+	if err := u.ensureBlobDeleted(ctx, u.config.AWS.BlobName); err != nil {
+		return fmt.Errorf("deleting blob: %w", err)
+	}
+	if u.config.AWS.DataImage != "" {
+		if err := u.ensureSnapshotDeleted(ctx, u.config.AWS.DataSnapshotName); err != nil {
+			return fmt.Errorf("deleting data snapshot: %w", err)
+		}
+		if err := u.ensureBlobDeleted(ctx, u.config.AWS.DataBlobName); err != nil {
+			return fmt.Errorf("deleting data blob: %w", err)
+		}
+	}
+	return nil
+}
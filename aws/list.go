@@ -0,0 +1,71 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// List returns the AMIs owned by the account whose name starts with the configured name
+// in the upload region and all replication regions.
+func (u *Uploader) List(ctx context.Context) ([]uploader.ListedImage, error) {
+	namePattern := u.config.NamePrefix + u.config.Name + "*"
+	images := []uploader.ListedImage{}
+	for _, region := range append([]string{u.config.AWS.Region}, u.config.AWS.ReplicationRegions...) {
+		ec2C, err := u.ec2(ctx, region)
+		if err != nil {
+			return nil, fmt.Errorf("creating ec2 client: %w", err)
+		}
+		resp, err := ec2C.DescribeImages(ctx, &ec2.DescribeImagesInput{
+			Owners: []string{"self"},
+			Filters: []ec2types.Filter{
+				{
+					Name:   toPtr("name"),
+					Values: []string{namePattern},
+				},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("describing images in region %s: %w", region, err)
+		}
+		for _, image := range resp.Images {
+			images = append(images, listedImage(region, image))
+		}
+	}
+	return images, nil
+}
+
+// listedImage converts an AMI to a listed image, referenced by its ARN.
+func listedImage(region string, image ec2types.Image) uploader.ListedImage {
+	var name, amiID, ownerID string
+	if image.Name != nil {
+		name = *image.Name
+	}
+	if image.ImageId != nil {
+		amiID = *image.ImageId
+	}
+	if image.OwnerId != nil {
+		ownerID = *image.OwnerId
+	}
+	listed := uploader.ListedImage{
+		Provider:  "aws",
+		Region:    region,
+		Name:      name,
+		Reference: getAMIARN(region, ownerID, amiID),
+	}
+	if image.CreationDate != nil {
+		// Creation dates are RFC3339 timestamps. Unparsable dates are left zero.
+		listed.CreatedAt, _ = time.Parse(time.RFC3339, *image.CreationDate)
+	}
+	return listed
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// Plan returns the operations an upload with the current config performs, without calling any AWS API.
+func (u *Uploader) Plan() uploader.Plan {
+	var plan uploader.Plan
+	region := u.config.AWS.Region
+	allRegions := append([]string{region}, u.config.AWS.ReplicationRegions...)
+
+	for _, r := range allRegions {
+		plan.Add(uploader.ActionDeleteIfExists, "ami", u.config.AWS.AMIName, r)
+	}
+	plan.Add(uploader.ActionDeleteIfExists, "snapshot", u.config.AWS.SnapshotName, region)
+	plan.Add(uploader.ActionDeleteIfExists, "s3 object", u.blobPath(u.config.AWS.BlobName), region)
+	if u.config.AWS.DataImage != "" {
+		plan.Add(uploader.ActionDeleteIfExists, "snapshot", u.config.AWS.DataSnapshotName, region)
+		plan.Add(uploader.ActionDeleteIfExists, "s3 object", u.blobPath(u.config.AWS.DataBlobName), region)
+	}
+
+	plan.Add(uploader.ActionCreateIfMissing, "s3 bucket", u.config.AWS.Bucket, region)
+	u.planImport(&plan, u.config.AWS.BlobName, u.config.AWS.SnapshotName)
+	if u.config.AWS.DataImage != "" {
+		u.planImport(&plan, u.config.AWS.DataBlobName, u.config.AWS.DataSnapshotName)
+	}
+	plan.Add(uploader.ActionCreate, "ami", u.config.AWS.AMIName, region)
+
+	finalizeRegions := []string{region}
+	for _, r := range u.config.AWS.ReplicationRegions {
+		if slices.Contains(finalizeRegions, r) {
+			continue
+		}
+		plan.AddDetail(uploader.ActionCopy, "ami", u.config.AWS.AMIName, r, "from "+region)
+		finalizeRegions = append(finalizeRegions, r)
+	}
+	for _, r := range finalizeRegions {
+		plan.AddDetail(uploader.ActionUpdate, "ami", u.config.AWS.AMIName, r, "tag image and backing snapshots")
+		if u.config.AWS.Publish.UnwrapOrZero() {
+			plan.AddDetail(uploader.ActionUpdate, "ami", u.config.AWS.AMIName, r, "grant launch permission to all")
+		}
+		if accountIDs := u.config.AWS.ShareWithAccountIDs; len(accountIDs) > 0 {
+			plan.AddDetail(uploader.ActionUpdate, "ami", u.config.AWS.AMIName, r,
+				"grant launch and backing snapshot volume permission to accounts "+strings.Join(accountIDs, ", "))
+		}
+	}
+	return plan
+}
+
+// planImport adds the operations of importImage.
+func (u *Uploader) planImport(plan *uploader.Plan, blobName, snapshotName string) {
+	region := u.config.AWS.Region
+	plan.Add(uploader.ActionUpload, "s3 object", u.blobPath(blobName), region)
+	plan.Add(uploader.ActionCreate, "snapshot", snapshotName, region)
+	if !u.config.KeepTempArtifacts.UnwrapOrZero() {
+		plan.Add(uploader.ActionDelete, "s3 object", u.blobPath(blobName), region)
+	}
+}
+
+func (u *Uploader) blobPath(blobName string) string {
+	return fmt.Sprintf("s3://%s/%s", u.config.AWS.Bucket, blobName)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	assert := assert.New(t)
+
+	u := &Uploader{
+		config: config.Config{AWS: config.AWSConfig{
+			Region:             "eu-central-1",
+			ReplicationRegions: []string{"us-east-1", "eu-central-1", "us-east-1"},
+			AMIName:            "my-ami",
+			Bucket:             "my-bucket",
+			BlobName:           "my-blob.raw",
+			SnapshotName:       "my-snapshot",
+			Publish:            config.Some(true),
+		}},
+		log: log.Default(),
+	}
+
+	want := uploader.Plan{
+		{Action: uploader.ActionDeleteIfExists, Kind: "ami", Name: "my-ami", Location: "eu-central-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "ami", Name: "my-ami", Location: "us-east-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "ami", Name: "my-ami", Location: "eu-central-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "ami", Name: "my-ami", Location: "us-east-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "snapshot", Name: "my-snapshot", Location: "eu-central-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "s3 object", Name: "s3://my-bucket/my-blob.raw", Location: "eu-central-1"},
+		{Action: uploader.ActionCreateIfMissing, Kind: "s3 bucket", Name: "my-bucket", Location: "eu-central-1"},
+		{Action: uploader.ActionUpload, Kind: "s3 object", Name: "s3://my-bucket/my-blob.raw", Location: "eu-central-1"},
+		{Action: uploader.ActionCreate, Kind: "snapshot", Name: "my-snapshot", Location: "eu-central-1"},
+		{Action: uploader.ActionDelete, Kind: "s3 object", Name: "s3://my-bucket/my-blob.raw", Location: "eu-central-1"},
+		{Action: uploader.ActionCreate, Kind: "ami", Name: "my-ami", Location: "eu-central-1"},
+		{Action: uploader.ActionCopy, Kind: "ami", Name: "my-ami", Location: "us-east-1", Detail: "from eu-central-1"},
+		{Action: uploader.ActionUpdate, Kind: "ami", Name: "my-ami", Location: "eu-central-1", Detail: "tag image and backing snapshots"},
+		{Action: uploader.ActionUpdate, Kind: "ami", Name: "my-ami", Location: "eu-central-1", Detail: "grant launch permission to all"},
+		{Action: uploader.ActionUpdate, Kind: "ami", Name: "my-ami", Location: "us-east-1", Detail: "tag image and backing snapshots"},
+		{Action: uploader.ActionUpdate, Kind: "ami", Name: "my-ami", Location: "us-east-1", Detail: "grant launch permission to all"},
+	}
+	assert.Equal(want, u.Plan())
+}
+
+func TestDeletePlan(t *testing.T) {
+	assert := assert.New(t)
+
+	u := &Uploader{
+		config: config.Config{AWS: config.AWSConfig{
+			Region:             "eu-central-1",
+			ReplicationRegions: []string{"us-east-1"},
+			AMIName:            "my-ami",
+			Bucket:             "my-bucket",
+			BlobName:           "my-blob.raw",
+			SnapshotName:       "my-snapshot",
+			DataImage:          "data.raw",
+			DataBlobName:       "my-blob-data.raw",
+			DataSnapshotName:   "my-snapshot-data",
+		}},
+		log: log.Default(),
+	}
+
+	want := uploader.Plan{
+		{Action: uploader.ActionDeleteIfExists, Kind: "ami", Name: "my-ami", Location: "eu-central-1", Detail: "with backing snapshots"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "ami", Name: "my-ami", Location: "us-east-1", Detail: "with backing snapshots"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "snapshot", Name: "my-snapshot", Location: "eu-central-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "s3 object", Name: "s3://my-bucket/my-blob.raw", Location: "eu-central-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "snapshot", Name: "my-snapshot-data", Location: "eu-central-1"},
+		{Action: uploader.ActionDeleteIfExists, Kind: "s3 object", Name: "s3://my-bucket/my-blob-data.raw", Location: "eu-central-1"},
+	}
+	assert.Equal(want, u.DeletePlan())
+}
+
+func TestPlanRenderedAMIName(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.ConfigFile{Base: config.Config{
+		Provider:     "aws",
+		Name:         "name",
+		ImageVersion: "1.2.3",
+		AWS: config.AWSConfig{
+			Region:             "eu-central-1",
+			ReplicationRegions: []string{"us-east-1"},
+			AMIName:            "{{.Name}}-{{.VersionMajor}}.{{.VersionMinor}}",
+			Bucket:             "my-bucket",
+			Publish:            config.Some(false),
+		},
+	}}
+	cfg, err := conf.RenderedVariant(func(string) ([]byte, error) { return nil, os.ErrNotExist }, "")
+	require.NoError(err)
+
+	u, err := NewUploader(cfg, log.Default(), nil)
+	require.NoError(err)
+	assert.Contains(u.Plan(), uploader.Operation{
+		Action: uploader.ActionCreate, Kind: "ami", Name: "name-1.2", Location: "eu-central-1",
+	})
+}
@@ -0,0 +1,32 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Preflight performs cheap read-only calls representative of the permissions an upload needs
+// and reports the outcome of each check.
+func (u *Uploader) Preflight(ctx context.Context, report func(check string, err error)) {
+	_, err := u.accountID(ctx)
+	report("sts:GetCallerIdentity", err)
+
+	_, err = u.bucketExists(ctx)
+	report(fmt.Sprintf("s3:HeadBucket %s", u.config.AWS.Bucket), err)
+
+	regions := append([]string{u.config.AWS.Region}, u.config.AWS.ReplicationRegions...)
+	for _, region := range regions {
+		_, err := u.findImage(ctx, region)
+		if errors.Is(err, errAMIDoesNotExist) {
+			err = nil
+		}
+		report(fmt.Sprintf("ec2:DescribeImages in %s", region), err)
+	}
+}
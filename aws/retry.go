@@ -0,0 +1,32 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// retryDelay is the delay before the first retry of a throttled operation.
+const retryDelay = 5 * time.Second
+
+// isTransient reports whether an AWS API error is caused by throttling or a temporary service failure.
+func isTransient(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "Throttling", "ThrottlingException", "ThrottledException", "RequestLimitExceeded",
+		"RequestThrottled", "RequestThrottledException", "TooManyRequestsException",
+		"InternalError", "InternalFailure", "ServiceUnavailable", "Unavailable":
+		return true
+	}
+	return false
+}
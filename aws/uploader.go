@@ -12,8 +12,14 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -23,11 +29,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/internal/retry"
+	"github.com/edgelesssys/uplosi/uploader"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
-	waitInterval = 15 * time.Second // 15 seconds
-	maxWait      = 30 * time.Minute // 30 minutes
+	defaultWaitInterval = 15 * time.Second // 15 seconds
+	defaultMaxWait      = 30 * time.Minute // 30 minutes
 )
 
 var errAMIDoesNotExist = errors.New("ami does not exist")
@@ -36,17 +45,35 @@ var errAMIDoesNotExist = errors.New("ami does not exist")
 type Uploader struct {
 	config config.Config
 
-	log *log.Logger
+	ec2Client        func(ctx context.Context, region string) (ec2API, error)
+	s3Client         func(ctx context.Context, region string) (s3API, error)
+	s3UploaderClient func(ctx context.Context, region string) (s3UploaderAPI, error)
+	stsClient        func(ctx context.Context, region string) (stsAPI, error)
+
+	retryDelay time.Duration
+
+	log      *log.Logger
+	progress uploader.ProgressReporter
 }
 
-func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
+func NewUploader(config config.Config, log *log.Logger, progress uploader.ProgressReporter) (*Uploader, error) {
+	if progress == nil {
+		progress = uploader.NopProgress{}
+	}
+	clients := clientFactory{config: config.AWS}
 	return &Uploader{
-		config: config,
-		log:    log,
+		config:           config,
+		ec2Client:        clients.ec2,
+		s3Client:         clients.s3,
+		s3UploaderClient: clients.s3Uploader,
+		stsClient:        clients.sts,
+		retryDelay:       retryDelay,
+		log:              log,
+		progress:         progress,
 	}, nil
 }
 
-func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (refs []string, retErr error) {
+func (u *Uploader) Upload(ctx context.Context, req *uploader.Request) (uploadResults []uploader.UploadResult, retErr error) {
 	allRegions := make([]string, 0, len(u.config.AWS.ReplicationRegions)+1)
 	allRegions = append(allRegions, u.config.AWS.Region)
 	allRegions = append(allRegions, u.config.AWS.ReplicationRegions...)
@@ -64,12 +91,20 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (re
 			return nil, fmt.Errorf("pre-cleaning: ensuring no image under the name %s in region %s: %w", u.config.Name, region, err)
 		}
 	}
-	if err := u.ensureSnapshotDeleted(ctx); err != nil {
+	if err := u.ensureSnapshotDeleted(ctx, u.config.AWS.SnapshotName); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no snapshot using the same name exists: %w", err)
 	}
-	if err := u.ensureBlobDeleted(ctx); err != nil {
+	if err := u.ensureBlobDeleted(ctx, u.config.AWS.BlobName); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no blob using the same name exists: %w", err)
 	}
+	if u.config.AWS.DataImage != "" {
+		if err := u.ensureSnapshotDeleted(ctx, u.config.AWS.DataSnapshotName); err != nil {
+			return nil, fmt.Errorf("pre-cleaning: ensuring no data snapshot using the same name exists: %w", err)
+		}
+		if err := u.ensureBlobDeleted(ctx, u.config.AWS.DataBlobName); err != nil {
+			return nil, fmt.Errorf("pre-cleaning: ensuring no data blob using the same name exists: %w", err)
+		}
+	}
 
 	// Ensure bucket exists.
 	// While the blob is only created temporarily, the bucket is persistent.
@@ -78,19 +113,36 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (re
 	}
 
 	// create primary image
-	if err := u.uploadBlob(ctx, image); err != nil {
-		return nil, fmt.Errorf("uploading image to s3: %w", err)
+	var img io.Reader = req.Image
+	if req.Streamed() {
+		// Hide Seek from the S3 upload manager, so it buffers the parts of the stream instead.
+		img = struct{ io.Reader }{req.Image}
 	}
-	defer func(retErr *error) {
-		if err := u.ensureBlobDeleted(ctx); err != nil {
-			*retErr = errors.Join(*retErr, fmt.Errorf("post-cleaning: deleting temporary blob from s3: %w", err))
-		}
-	}(&retErr)
-	snapshotID, err := u.importSnapshot(ctx)
+	snapshotID, err := u.importImage(ctx, u.config.AWS.BlobName, u.config.AWS.SnapshotName, img, req.Size)
 	if err != nil {
-		return nil, fmt.Errorf("importing snapshot: %w", err)
+		return nil, err
 	}
-	primaryAMIID, err := u.createImageFromSnapshot(ctx, snapshotID)
+
+	// import optional data image as additional snapshot
+	var dataSnapshotID string
+	if u.config.AWS.DataImage != "" {
+		dataImage, err := os.Open(u.config.AWS.DataImage)
+		if err != nil {
+			return nil, fmt.Errorf("opening data image: %w", err)
+		}
+		defer dataImage.Close()
+		dataImageFi, err := dataImage.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("getting data image stats: %w", err)
+		}
+		dataSnapshotID, err = u.importImage(ctx, u.config.AWS.DataBlobName, u.config.AWS.DataSnapshotName, dataImage, dataImageFi.Size())
+		if err != nil {
+			return nil, fmt.Errorf("data image: %w", err)
+		}
+	}
+
+	u.progress.Stage(uploader.StageCreateImage)
+	primaryAMIID, err := u.createImageFromSnapshot(ctx, snapshotID, dataSnapshotID)
 	if err != nil {
 		return nil, fmt.Errorf("creating image from snapshot: %w", err)
 	}
@@ -100,33 +152,99 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (re
 	}
 
 	// replicate image
+	// Replication copies the AMI instead of importing the blob per region,
+	// as VM Import/Export only imports from buckets in the same region.
+	var replicationRegions []string
 	for _, region := range u.config.AWS.ReplicationRegions {
-		if _, alreadyReplicated := amiIDs[region]; alreadyReplicated {
+		if region == u.config.AWS.Region || slices.Contains(replicationRegions, region) {
 			u.log.Printf("image was already replicated in region %s. Skipping.", region)
 			continue
 		}
-		amiID, err := u.replicateImage(ctx, primaryAMIID, region)
-		if err != nil {
-			return nil, fmt.Errorf("replicating image to region %s: %w", region, err)
+		replicationRegions = append(replicationRegions, region)
+	}
+	if len(replicationRegions) > 0 {
+		u.progress.Stage(uploader.StageReplicateImage)
+	}
+	replicatedAMIIDs, replicateErr := forEachRegion(replicationRegions, u.config.AWS.MaxConcurrentReplications,
+		func(region string) (string, error) {
+			amiID, err := u.replicateImage(ctx, primaryAMIID, region)
+			if err != nil {
+				return "", fmt.Errorf("replicating image to region %s: %w", region, err)
+			}
+			return amiID, nil
+		},
+	)
+	finalizeRegions := []string{u.config.AWS.Region}
+	for i, region := range replicationRegions {
+		if replicatedAMIIDs[i] == "" {
+			continue
 		}
-		amiIDs[region] = amiID
+		amiIDs[region] = replicatedAMIIDs[i]
+		finalizeRegions = append(finalizeRegions, region)
 	}
 
-	// wait for replication, tag, publish
-	amiARNs := make([]string, 0, len(allRegions))
-	for _, region := range allRegions {
-		if err := u.waitForImage(ctx, amiIDs[region], region); err != nil {
-			return nil, fmt.Errorf("waiting for image to become available in region %s: %w", region, err)
-		}
-		if err := u.tagImageAndSnapshot(ctx, amiIDs[region], region); err != nil {
-			return nil, fmt.Errorf("tagging image in region %s: %w", region, err)
-		}
-		if err := u.publishImage(ctx, amiIDs[region], region); err != nil {
-			return nil, fmt.Errorf("publishing image in region %s: %w", region, err)
-		}
-		amiARNs = append(amiARNs, getAMIARN(region, accountID, amiIDs[region]))
+	// Wait for replication, tag and publish in every region as soon as the image
+	// becomes available there, so the run isn't serialized on the slowest region.
+	// Regions that failed to replicate are skipped, their errors are reported together with the others.
+	uploadResults, finalizeErr := forEachRegion(finalizeRegions, 0, func(region string) (uploader.UploadResult, error) {
+		return u.finalizeRegion(ctx, region, accountID, amiIDs[region])
+	})
+	if err := errors.Join(replicateErr, finalizeErr); err != nil {
+		return nil, err
 	}
-	return amiARNs, nil
+	return uploadResults, nil
+}
+
+// finalizeRegion waits for the image in a region to become available, then tags and publishes it.
+func (u *Uploader) finalizeRegion(ctx context.Context, region, accountID, amiID string) (uploader.UploadResult, error) {
+	if err := u.waitForImage(ctx, amiID, region); err != nil {
+		return uploader.UploadResult{}, fmt.Errorf("waiting for image to become available in region %s: %w", region, err)
+	}
+	snapshotIDs, err := u.tagImageAndSnapshot(ctx, amiID, region)
+	if err != nil {
+		return uploader.UploadResult{}, fmt.Errorf("tagging image in region %s: %w", region, err)
+	}
+	if err := u.publishImage(ctx, amiID, region); err != nil {
+		return uploader.UploadResult{}, fmt.Errorf("publishing image in region %s: %w", region, err)
+	}
+	return u.uploadResult(region, accountID, amiID, snapshotIDs), nil
+}
+
+// uploadResult describes the AMI created in a region. The encryption and sharing state
+// is the one the AMI was created and published with, so it doesn't need to be described again.
+func (u *Uploader) uploadResult(region, accountID, amiID string, snapshotIDs []string) uploader.UploadResult {
+	return uploader.UploadResult{
+		Provider:     "aws",
+		Region:       region,
+		Reference:    getAMIARN(region, accountID, amiID),
+		ResourceType: "ami",
+		ImageID:      amiID,
+		SnapshotIDs:  snapshotIDs,
+		Encrypted:    u.config.AWS.Encrypted.UnwrapOrZero(),
+		Public:       u.config.AWS.Publish.UnwrapOrZero(),
+		SharedWith:   slices.Clone(u.config.AWS.ShareWithAccountIDs),
+	}
+}
+
+// forEachRegion calls fn for all regions concurrently, running at most limit calls at once.
+// A limit of 0 or less means no limit. The results are ordered like regions, independent of
+// the order in which the calls complete. A failing region doesn't stop the others, the errors
+// of all regions are joined and the results of failed regions are left zero.
+func forEachRegion[T any](regions []string, limit int, fn func(region string) (T, error)) ([]T, error) {
+	results := make([]T, len(regions))
+	errs := make([]error, len(regions))
+	var group errgroup.Group
+	if limit > 0 {
+		group.SetLimit(limit)
+	}
+	for i, region := range regions {
+		group.Go(func() error {
+			results[i], errs[i] = fn(region)
+			return nil
+		})
+	}
+	_ = group.Wait()
+	return results, errors.Join(errs...)
 }
 
 func (u *Uploader) bucketExists(ctx context.Context) (bool, error) {
@@ -164,9 +282,9 @@ func (u *Uploader) ensureBucket(ctx context.Context) error {
 	}
 	u.log.Printf("Bucket %s doesn't exist. Creating.", bucket)
 	var createBucketConfig *s3types.CreateBucketConfiguration
-	if u.config.AWS.BucketLocationConstraint != "" {
+	if locationConstraint := bucketLocationConstraint(u.config.AWS.BucketLocationConstraint, u.config.AWS.Region); locationConstraint != "" {
 		createBucketConfig = &s3types.CreateBucketConfiguration{
-			LocationConstraint: s3types.BucketLocationConstraint(u.config.AWS.BucketLocationConstraint),
+			LocationConstraint: s3types.BucketLocationConstraint(locationConstraint),
 		}
 	}
 	req := &s3.CreateBucketInput{
@@ -177,33 +295,154 @@ func (u *Uploader) ensureBucket(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("creating bucket %s: %w", bucket, err)
 	}
+
+	// Governance settings are only applied to buckets created by uplosi.
+	if len(u.config.AWS.BucketTags) > 0 {
+		u.log.Printf("Tagging bucket %s", bucket)
+		if _, err := s3C.PutBucketTagging(ctx, &s3.PutBucketTaggingInput{
+			Bucket:  &bucket,
+			Tagging: &s3types.Tagging{TagSet: bucketTagSet(u.config.AWS.BucketTags)},
+		}); err != nil {
+			return fmt.Errorf("tagging bucket %s: %w", bucket, err)
+		}
+	}
+	if u.config.AWS.BucketBlockPublicAccess.UnwrapOrZero() {
+		u.log.Printf("Blocking public access to bucket %s", bucket)
+		if _, err := s3C.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: &bucket,
+			PublicAccessBlockConfiguration: &s3types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       toPtr(true),
+				BlockPublicPolicy:     toPtr(true),
+				IgnorePublicAcls:      toPtr(true),
+				RestrictPublicBuckets: toPtr(true),
+			},
+		}); err != nil {
+			return fmt.Errorf("blocking public access to bucket %s: %w", bucket, err)
+		}
+	}
+	if keyID := u.config.AWS.BucketEncryptionKMSKeyID; keyID != "" {
+		u.log.Printf("Enabling SSE-KMS default encryption of bucket %s", bucket)
+		if _, err := s3C.PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+			Bucket: &bucket,
+			ServerSideEncryptionConfiguration: &s3types.ServerSideEncryptionConfiguration{
+				Rules: []s3types.ServerSideEncryptionRule{{
+					ApplyServerSideEncryptionByDefault: &s3types.ServerSideEncryptionByDefault{
+						SSEAlgorithm:   s3types.ServerSideEncryptionAwsKms,
+						KMSMasterKeyID: &keyID,
+					},
+					BucketKeyEnabled: toPtr(true),
+				}},
+			},
+		}); err != nil {
+			return fmt.Errorf("enabling encryption of bucket %s: %w", bucket, err)
+		}
+	}
 	return nil
 }
 
-func (u *Uploader) uploadBlob(ctx context.Context, img io.Reader) error {
-	blobName := u.config.AWS.BlobName
+// bucketTagSet converts the configured bucket tags into a tag set sorted by key.
+func bucketTagSet(tags map[string]string) []s3types.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	tagSet := make([]s3types.Tag, 0, len(tags))
+	for _, key := range keys {
+		tagSet = append(tagSet, s3types.Tag{Key: toPtr(key), Value: toPtr(tags[key])})
+	}
+	return tagSet
+}
+
+// importImage uploads img of the given size as temporary blob and imports it as snapshot.
+// The names are taken as rendered from the config, so blob and snapshot names support the same templates as other fields.
+func (u *Uploader) importImage(ctx context.Context, blobName, snapshotName string, img io.Reader, size int64) (snapshotID string, retErr error) {
+	if err := u.uploadBlob(ctx, blobName, img, size); err != nil {
+		return "", fmt.Errorf("uploading image to s3: %w", err)
+	}
+	defer func(retErr *error) {
+		if u.config.KeepTempArtifacts.UnwrapOrZero() {
+			u.log.Printf("Keeping temporary blob %s", u.blobPath(blobName))
+			return
+		}
+		cleanupCtx, cancel := uploader.CleanupContext(ctx)
+		defer cancel()
+		if err := u.ensureBlobDeleted(cleanupCtx, blobName); err != nil {
+			*retErr = errors.Join(*retErr, fmt.Errorf("post-cleaning: deleting temporary blob from s3: %w", err))
+		}
+	}(&retErr)
+	snapshotID, err := u.importSnapshot(ctx, blobName, snapshotName)
+	if err != nil {
+		return "", fmt.Errorf("importing snapshot: %w", err)
+	}
+	return snapshotID, nil
+}
+
+// uploadBlob uploads img of the given size as blob. A negative size is unknown.
+// If the upload is verified, the checksum S3 stored is compared with the checksum of img afterwards.
+func (u *Uploader) uploadBlob(ctx context.Context, blobName string, img io.Reader, size int64) error {
 	uploadC, err := u.s3uploader(ctx)
 	if err != nil {
 		return err
 	}
 	u.log.Printf("Uploading os image as temporary blob %s", blobName)
+	u.progress.Stage(uploader.StageUploadBlob)
+	img = uploader.NewProgressReader(img, size, u.progress)
 
-	_, err = uploadC.Upload(ctx, &s3.PutObjectInput{
+	var checksums *checksumReader
+	var opts []func(*s3manager.Uploader)
+	if u.config.VerifyUpload.UnwrapOrZero() {
+		partSize := checksumPartSize(size)
+		checksums = newChecksumReader(img, partSize)
+		img = checksums
+		opts = append(opts, func(up *s3manager.Uploader) { up.PartSize = partSize })
+	}
+	_, err = uploadC.Upload(ctx, u.putObjectInput(blobName, img), opts...)
+	if err != nil {
+		return err
+	}
+	if checksums == nil {
+		return nil
+	}
+	return u.verifyChecksum(ctx, blobName, checksums)
+}
+
+// putObjectInput builds the request uploading img as blob.
+// The blob is encrypted with the bucket encryption key, if configured, since existing buckets
+// may lack the default encryption and bucket policies often require the encryption headers.
+func (u *Uploader) putObjectInput(blobName string, img io.Reader) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
 		Bucket:            &u.config.AWS.Bucket,
 		Key:               &blobName,
 		Body:              img,
 		ChecksumAlgorithm: s3types.ChecksumAlgorithmSha256,
-	})
-	return err
+	}
+	if keyID := u.config.AWS.BucketEncryptionKMSKeyID; keyID != "" {
+		input.ServerSideEncryption = s3types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = &keyID
+		input.BucketKeyEnabled = toPtr(true)
+	}
+	if len(u.config.AWS.Tags) > 0 {
+		input.Tagging = toPtr(objectTagging(u.config.AWS.Tags))
+	}
+	return input
 }
 
-func (u *Uploader) ensureBlobDeleted(ctx context.Context) error {
+// objectTagging encodes tags as URL query parameters, as expected by the Tagging header of S3 uploads.
+func objectTagging(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func (u *Uploader) ensureBlobDeleted(ctx context.Context, blobName string) error {
 	s3C, err := u.s3(ctx)
 	if err != nil {
 		return err
 	}
 	bucket := u.config.AWS.Bucket
-	blobName := u.config.AWS.BlobName
 
 	bucketExists, err := u.bucketExists(ctx)
 	if err != nil {
@@ -234,16 +473,32 @@ func (u *Uploader) ensureBlobDeleted(ctx context.Context) error {
 	return err
 }
 
-func (u *Uploader) importSnapshot(ctx context.Context) (string, error) {
-	blobName := u.config.AWS.BlobName
-	snapshotName := u.config.AWS.SnapshotName
+func (u *Uploader) importSnapshot(ctx context.Context, blobName, snapshotName string) (string, error) {
 	ec2C, err := u.ec2(ctx, u.config.AWS.Region)
 	if err != nil {
 		return "", fmt.Errorf("creating ec2 client: %w", err)
 	}
 	u.log.Printf("Importing %s as snapshot %s", blobName, snapshotName)
+	u.progress.Stage(uploader.StageImportSnapshot)
+
+	var importResp *ec2.ImportSnapshotOutput
+	err = retry.Transient(ctx, u.log, "Importing snapshot", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.retryDelay, func() (err error) {
+		importResp, err = ec2C.ImportSnapshot(ctx, u.importSnapshotInput(blobName, snapshotName))
+		return err
+	})
+	if err != nil {
+		log.Println(bucketPermissionHelpText)
+		return "", fmt.Errorf("importing snapshot: %w", err)
+	}
+	if importResp.ImportTaskId == nil {
+		return "", fmt.Errorf("importing snapshot: no import task ID returned")
+	}
+	u.log.Printf("Waiting for snapshot %s to be ready", snapshotName)
+	return u.waitForSnapshotImport(ctx, ec2C, *importResp.ImportTaskId, u.waitInterval(), u.snapshotImportTimeout())
+}
 
-	importResp, err := ec2C.ImportSnapshot(ctx, &ec2.ImportSnapshotInput{
+func (u *Uploader) importSnapshotInput(blobName, snapshotName string) *ec2.ImportSnapshotInput {
+	input := &ec2.ImportSnapshotInput{
 		ClientData: &ec2types.ClientData{
 			Comment: &snapshotName,
 		},
@@ -256,26 +511,24 @@ func (u *Uploader) importSnapshot(ctx context.Context) (string, error) {
 				S3Key:    &blobName,
 			},
 		},
-	})
-	if err != nil {
-		log.Println(bucketPermissionHelpText)
-		return "", fmt.Errorf("importing snapshot: %w", err)
 	}
-	if importResp.ImportTaskId == nil {
-		return "", fmt.Errorf("importing snapshot: no import task ID returned")
+	if u.config.AWS.Encrypted.UnwrapOrZero() {
+		input.Encrypted = toPtr(true)
+		if u.config.AWS.KMSKeyID != "" {
+			input.KmsKeyId = &u.config.AWS.KMSKeyID
+		}
 	}
-	u.log.Printf("Waiting for snapshot %s to be ready", snapshotName)
-	return waitForSnapshotImport(ctx, ec2C, *importResp.ImportTaskId)
+	return input
 }
 
-func (u *Uploader) ensureSnapshotDeleted(ctx context.Context) error {
+func (u *Uploader) ensureSnapshotDeleted(ctx context.Context, snapshotName string) error {
 	ec2C, err := u.ec2(ctx, u.config.AWS.Region)
 	if err != nil {
 		return fmt.Errorf("creating ec2 client: %w", err)
 	}
 	region := u.config.AWS.Region
 
-	snapshots, err := u.findSnapshots(ctx)
+	snapshots, err := u.findSnapshots(ctx, snapshotName)
 	if err != nil {
 		return fmt.Errorf("finding snapshots: %w", err)
 	}
@@ -301,28 +554,30 @@ func (u *Uploader) ensureImageDeleted(ctx context.Context, region string) error
 		u.log.Printf("Image %s in %s doesn't exist. Nothing to clean up.", u.config.Name, region)
 		return nil
 	}
-	snapshotID, err := getBackingSnapshotID(ctx, ec2C, amiID)
+	snapshotIDs, err := getBackingSnapshotIDs(ctx, ec2C, amiID)
 	if err == errAMIDoesNotExist {
 		u.log.Printf("Image %s doesn't exist. Nothing to clean up.", amiID)
 		return nil
 	}
-	u.log.Printf("Deleting image %s in %s with backing snapshot", amiID, region)
+	u.log.Printf("Deleting image %s in %s with backing snapshots", amiID, region)
 	_, err = ec2C.DeregisterImage(ctx, &ec2.DeregisterImageInput{
 		ImageId: &amiID,
 	})
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
-	_, err = ec2C.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
-		SnapshotId: &snapshotID,
-	})
-	if err != nil {
-		return fmt.Errorf("deleting snapshot: %w", err)
+	for _, snapshotID := range snapshotIDs {
+		_, err = ec2C.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+			SnapshotId: &snapshotID,
+		})
+		if err != nil {
+			return fmt.Errorf("deleting snapshot %s: %w", snapshotID, err)
+		}
 	}
 	return nil
 }
 
-func (u *Uploader) findSnapshots(ctx context.Context) ([]string, error) {
+func (u *Uploader) findSnapshots(ctx context.Context, snapshotName string) ([]string, error) {
 	ec2C, err := u.ec2(ctx, u.config.AWS.Region)
 	if err != nil {
 		return nil, fmt.Errorf("creating ec2 client: %w", err)
@@ -331,7 +586,7 @@ func (u *Uploader) findSnapshots(ctx context.Context) ([]string, error) {
 		Filters: []ec2types.Filter{
 			{
 				Name:   toPtr("tag:Name"),
-				Values: []string{u.config.AWS.SnapshotName},
+				Values: []string{snapshotName},
 			},
 		},
 	})
@@ -348,7 +603,7 @@ func (u *Uploader) findSnapshots(ctx context.Context) ([]string, error) {
 	return snapshotIDs, nil
 }
 
-func (u *Uploader) createImageFromSnapshot(ctx context.Context, snapshotID string) (string, error) {
+func (u *Uploader) createImageFromSnapshot(ctx context.Context, snapshotID, dataSnapshotID string) (string, error) {
 	imageName := u.config.AWS.AMIName
 	ec2C, err := u.ec2(ctx, u.config.AWS.Region)
 	if err != nil {
@@ -356,26 +611,7 @@ func (u *Uploader) createImageFromSnapshot(ctx context.Context, snapshotID strin
 	}
 	u.log.Printf("Creating image %s in %s", imageName, u.config.AWS.Region)
 
-	// TODO(malt3): make UEFI var store configurable (secure boot)
-	createReq, err := ec2C.RegisterImage(ctx, &ec2.RegisterImageInput{
-		Name:         &imageName,
-		Architecture: ec2types.ArchitectureValuesX8664,
-		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
-			{
-				DeviceName: toPtr("/dev/xvda"),
-				Ebs: &ec2types.EbsBlockDevice{
-					DeleteOnTermination: toPtr(true),
-					SnapshotId:          &snapshotID,
-				},
-			},
-		},
-		BootMode:           ec2types.BootModeValuesUefi,
-		Description:        toPtr(u.config.AWS.AMIDescription),
-		EnaSupport:         toPtr(true),
-		RootDeviceName:     toPtr("/dev/xvda"),
-		TpmSupport:         ec2types.TpmSupportValuesV20,
-		VirtualizationType: toPtr("hvm"),
-	})
+	createReq, err := ec2C.RegisterImage(ctx, u.registerImageInput(snapshotID, dataSnapshotID))
 	if err != nil {
 		return "", fmt.Errorf("creating image: %w", err)
 	}
@@ -385,6 +621,95 @@ func (u *Uploader) createImageFromSnapshot(ctx context.Context, snapshotID strin
 	return *createReq.ImageId, nil
 }
 
+// registerImageInput builds the request registering an AMI from the imported snapshots.
+func (u *Uploader) registerImageInput(snapshotID, dataSnapshotID string) *ec2.RegisterImageInput {
+	blockDeviceMappings := []ec2types.BlockDeviceMapping{
+		{
+			DeviceName: toPtr(u.rootDeviceName()),
+			Ebs:        u.ebsBlockDevice(snapshotID),
+		},
+	}
+	if dataSnapshotID != "" {
+		blockDeviceMappings = append(blockDeviceMappings, ec2types.BlockDeviceMapping{
+			DeviceName: toPtr(u.config.AWS.DataDeviceName),
+			Ebs:        u.ebsBlockDevice(dataSnapshotID),
+		})
+	}
+
+	var sriovNetSupport *string
+	if u.config.AWS.SriovNetSupport.UnwrapOrZero() {
+		sriovNetSupport = toPtr("simple")
+	}
+
+	// ENA and NitroTPM are enabled by default, unless the virtualization type doesn't support them.
+	hvm := u.config.AWS.VirtualizationType != "paravirtual"
+
+	var tpmSupport ec2types.TpmSupportValues
+	if u.config.AWS.TPMSupport.UnwrapOr(hvm) {
+		tpmSupport = ec2types.TpmSupportValuesV20
+	}
+
+	var uefiData *string
+	if u.config.AWS.UEFIData != "" {
+		uefiData = toPtr(u.config.AWS.UEFIData)
+	}
+
+	// Paravirtual AMIs can't boot with UEFI.
+	bootMode := ec2types.BootModeValuesUefi
+	if !hvm {
+		bootMode = ec2types.BootModeValuesLegacyBios
+	}
+
+	return &ec2.RegisterImageInput{
+		Name:                toPtr(u.config.AWS.AMIName),
+		Architecture:        ec2Architecture(u.config.AWS.Architecture),
+		BlockDeviceMappings: blockDeviceMappings,
+		BootMode:            bootMode,
+		Description:         toPtr(u.config.AWS.AMIDescription),
+		EnaSupport:          toPtr(u.config.AWS.EnaSupport.UnwrapOr(hvm)),
+		RootDeviceName:      toPtr(u.rootDeviceName()),
+		SriovNetSupport:     sriovNetSupport,
+		TpmSupport:          tpmSupport,
+		UefiData:            uefiData,
+		VirtualizationType:  toPtr(u.config.AWS.VirtualizationType),
+	}
+}
+
+func (u *Uploader) rootDeviceName() string {
+	if u.config.AWS.RootDeviceName == "" {
+		return "/dev/xvda"
+	}
+	return u.config.AWS.RootDeviceName
+}
+
+// ec2Architecture maps the configured architecture to the EC2 architecture of the AMI.
+// x86_64 is used if the architecture is unset.
+func ec2Architecture(arch string) ec2types.ArchitectureValues {
+	switch arch {
+	case "arm64":
+		return ec2types.ArchitectureValuesArm64
+	default:
+		return ec2types.ArchitectureValuesX8664
+	}
+}
+
+// ebsBlockDevice returns the EBS volume of a block device mapping backed by the given snapshot.
+// The KMS key isn't set here, as RegisterImage doesn't support it. Instead, the snapshot is already
+// encrypted with the key on import.
+func (u *Uploader) ebsBlockDevice(snapshotID string) *ec2types.EbsBlockDevice {
+	ebs := &ec2types.EbsBlockDevice{
+		DeleteOnTermination: toPtr(true),
+		SnapshotId:          &snapshotID,
+	}
+	if u.config.AWS.EBSVolumeType != "" {
+		ebs.VolumeType = ec2types.VolumeType(u.config.AWS.EBSVolumeType)
+	}
+	if u.config.AWS.Encrypted.UnwrapOrZero() {
+		ebs.Encrypted = toPtr(true)
+	}
+	return ebs
+}
+
 func (u *Uploader) replicateImage(ctx context.Context, amiID string, targetRegion string) (string, error) {
 	imageName := u.config.AWS.AMIName
 	ec2C, err := u.ec2(ctx, targetRegion)
@@ -393,10 +718,14 @@ func (u *Uploader) replicateImage(ctx context.Context, amiID string, targetRegio
 	}
 	u.log.Printf("Replicating image %s to %s", imageName, targetRegion)
 
-	replicateReq, err := ec2C.CopyImage(ctx, &ec2.CopyImageInput{
-		Name:          &imageName,
-		SourceImageId: &amiID,
-		SourceRegion:  &u.config.AWS.Region,
+	var replicateReq *ec2.CopyImageOutput
+	err = retry.Transient(ctx, u.log, "Replicating image to "+targetRegion, u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.retryDelay, func() (err error) {
+		replicateReq, err = ec2C.CopyImage(ctx, &ec2.CopyImageInput{
+			Name:          &imageName,
+			SourceImageId: &amiID,
+			SourceRegion:  &u.config.AWS.Region,
+		})
+		return err
 	})
 	if err != nil {
 		return "", fmt.Errorf("replicating image: %w", err)
@@ -446,44 +775,85 @@ func (u *Uploader) waitForImage(ctx context.Context, amiID, region string) error
 	if err != nil {
 		return fmt.Errorf("creating ec2 client: %w", err)
 	}
-	waiter := ec2.NewImageAvailableWaiter(ec2C)
+	waiter := ec2.NewImageAvailableWaiter(ec2C, func(o *ec2.ImageAvailableWaiterOptions) {
+		o.MinDelay = u.waitInterval()
+		o.MaxDelay = max(o.MaxDelay, o.MinDelay)
+	})
 	err = waiter.Wait(ctx, &ec2.DescribeImagesInput{
 		ImageIds: []string{amiID},
-	}, maxWait)
+	}, u.maxWait())
 	if err != nil {
 		return fmt.Errorf("waiting for image: %w", err)
 	}
 	return nil
 }
 
-func (u *Uploader) tagImageAndSnapshot(ctx context.Context, amiID, region string) error {
+// tagImageAndSnapshot tags the AMI and its backing snapshots and returns the IDs of the snapshots.
+func (u *Uploader) tagImageAndSnapshot(ctx context.Context, amiID, region string) ([]string, error) {
 	imageName := u.config.AWS.AMIName
 	ec2C, err := u.ec2(ctx, region)
 	if err != nil {
-		return fmt.Errorf("creating ec2 client: %w", err)
+		return nil, fmt.Errorf("creating ec2 client: %w", err)
 	}
-	u.log.Printf("Tagging backing snapshot of image %s in %s", amiID, region)
-	snapshotID, err := getBackingSnapshotID(ctx, ec2C, amiID)
+	u.log.Printf("Tagging backing snapshots of image %s in %s", amiID, region)
+	snapshots, err := getBackingSnapshots(ctx, ec2C, amiID)
 	if err != nil {
-		return fmt.Errorf("getting backing snapshot ID: %w", err)
+		return nil, fmt.Errorf("getting backing snapshot IDs: %w", err)
 	}
 	_, err = ec2C.CreateTags(ctx, &ec2.CreateTagsInput{
-		Resources: []string{amiID, snapshotID},
-		Tags: []ec2types.Tag{
-			{
-				Key:   toPtr("Name"),
-				Value: toPtr(imageName),
-			},
-		},
+		Resources: []string{amiID},
+		Tags:      resourceTags(imageName, u.config.AWS.Tags),
 	})
 	if err != nil {
-		return fmt.Errorf("tagging ami and snapshot: %w", err)
+		return nil, fmt.Errorf("tagging ami: %w", err)
 	}
-	return nil
+	snapshotIDs := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotIDs = append(snapshotIDs, snapshot.snapshotID)
+		_, err = ec2C.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{snapshot.snapshotID},
+			Tags:      resourceTags(u.snapshotName(snapshot.deviceName), u.config.AWS.Tags),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("tagging snapshot %s: %w", snapshot.snapshotID, err)
+		}
+	}
+	return snapshotIDs, nil
 }
 
+// snapshotName returns the name of the snapshot backing the device. Pre-cleaning finds
+// the snapshots of a previous upload by these names.
+func (u *Uploader) snapshotName(deviceName string) string {
+	switch {
+	case u.config.AWS.DataImage != "" && deviceName == u.config.AWS.DataDeviceName:
+		return u.config.AWS.DataSnapshotName
+	case deviceName == u.rootDeviceName():
+		return u.config.AWS.SnapshotName
+	default:
+		return u.config.AWS.AMIName
+	}
+}
+
+// resourceTags returns the Name tag followed by the configured tags sorted by key.
+func resourceTags(name string, tags map[string]string) []ec2types.Tag {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	ec2Tags := []ec2types.Tag{{Key: toPtr("Name"), Value: toPtr(name)}}
+	for _, key := range keys {
+		ec2Tags = append(ec2Tags, ec2types.Tag{Key: toPtr(key), Value: toPtr(tags[key])})
+	}
+	return ec2Tags
+}
+
+// publishImage grants launch permissions for the AMI to everyone if the image is published
+// and to the configured accounts. The accounts also get permission to create volumes from
+// the backing snapshots, which they need to copy the AMI.
 func (u *Uploader) publishImage(ctx context.Context, amiID, region string) error {
-	if !u.config.AWS.Publish.UnwrapOr(false) {
+	permissions := u.launchPermissions()
+	if len(permissions) == 0 {
 		return nil
 	}
 
@@ -491,24 +861,62 @@ func (u *Uploader) publishImage(ctx context.Context, amiID, region string) error
 	if err != nil {
 		return fmt.Errorf("creating ec2 client: %w", err)
 	}
-	u.log.Printf("Publishing ami %s in %s", amiID, region)
+	if u.config.AWS.Publish.UnwrapOrZero() {
+		u.log.Printf("Publishing ami %s in %s", amiID, region)
+	}
+	accountIDs := u.config.AWS.ShareWithAccountIDs
+	if len(accountIDs) > 0 {
+		u.log.Printf("Sharing ami %s in %s with accounts %v", amiID, region, accountIDs)
+	}
 
 	_, err = ec2C.ModifyImageAttribute(ctx, &ec2.ModifyImageAttributeInput{
 		ImageId: &amiID,
 		LaunchPermission: &ec2types.LaunchPermissionModifications{
-			Add: []ec2types.LaunchPermission{
-				{
-					Group: ec2types.PermissionGroupAll,
-				},
-			},
+			Add: permissions,
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("publishing image: %w", err)
 	}
+	if len(accountIDs) == 0 {
+		return nil
+	}
+
+	snapshotIDs, err := getBackingSnapshotIDs(ctx, ec2C, amiID)
+	if err != nil {
+		return fmt.Errorf("getting backing snapshot IDs: %w", err)
+	}
+	volumePermissions := make([]ec2types.CreateVolumePermission, 0, len(accountIDs))
+	for _, accountID := range accountIDs {
+		volumePermissions = append(volumePermissions, ec2types.CreateVolumePermission{UserId: toPtr(accountID)})
+	}
+	for _, snapshotID := range snapshotIDs {
+		_, err = ec2C.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId: toPtr(snapshotID),
+			Attribute:  ec2types.SnapshotAttributeNameCreateVolumePermission,
+			CreateVolumePermission: &ec2types.CreateVolumePermissionModifications{
+				Add: volumePermissions,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("sharing snapshot %s: %w", snapshotID, err)
+		}
+	}
 	return nil
 }
 
+// launchPermissions returns the launch permissions to add to the AMI.
+func (u *Uploader) launchPermissions() []ec2types.LaunchPermission {
+	var permissions []ec2types.LaunchPermission
+	if u.config.AWS.Publish.UnwrapOrZero() {
+		permissions = append(permissions, ec2types.LaunchPermission{Group: ec2types.PermissionGroupAll})
+	}
+	for _, accountID := range u.config.AWS.ShareWithAccountIDs {
+		permissions = append(permissions, ec2types.LaunchPermission{UserId: toPtr(accountID)})
+	}
+	return permissions
+}
+
 func (u *Uploader) accountID(ctx context.Context) (string, error) {
 	stsC, err := u.sts(ctx)
 	if err != nil {
@@ -525,31 +933,74 @@ func (u *Uploader) accountID(ctx context.Context) (string, error) {
 }
 
 func (u *Uploader) ec2(ctx context.Context, region string) (ec2API, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	return u.ec2Client(ctx, region)
+}
+
+func (u *Uploader) s3(ctx context.Context) (s3API, error) {
+	return u.s3Client(ctx, u.config.AWS.Region)
+}
+
+func (u *Uploader) s3uploader(ctx context.Context) (s3UploaderAPI, error) {
+	return u.s3UploaderClient(ctx, u.config.AWS.Region)
+}
+
+func (u *Uploader) sts(ctx context.Context) (stsAPI, error) {
+	return u.stsClient(ctx, u.config.AWS.Region)
+}
+
+// clientFactory creates the AWS API clients for a region.
+type clientFactory struct {
+	config config.AWSConfig
+}
+
+// loadConfig loads the default AWS config for region, using the configured shared config profile
+// and endpoint if set.
+func (f clientFactory) loadConfig(ctx context.Context, region string) (aws.Config, error) {
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if f.config.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(f.config.Profile))
+	}
+	if f.config.EndpointURL != "" {
+		opts = append(opts, awsconfig.WithBaseEndpoint(f.config.EndpointURL))
+	}
+	return awsconfig.LoadDefaultConfig(ctx, opts...)
+}
+
+// s3Options returns the options of S3 clients. Custom endpoints use path-style addressing,
+// since S3-compatible services and emulators like LocalStack usually don't serve bucket subdomains.
+func (f clientFactory) s3Options() []func(*s3.Options) {
+	if f.config.EndpointURL == "" {
+		return nil
+	}
+	return []func(*s3.Options){func(o *s3.Options) { o.UsePathStyle = true }}
+}
+
+func (f clientFactory) ec2(ctx context.Context, region string) (ec2API, error) {
+	cfg, err := f.loadConfig(ctx, region)
 	if err != nil {
 		return nil, err
 	}
 	return ec2.NewFromConfig(cfg), nil
 }
 
-func (u *Uploader) s3(ctx context.Context) (s3API, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(u.config.AWS.Region))
+func (f clientFactory) s3(ctx context.Context, region string) (s3API, error) {
+	cfg, err := f.loadConfig(ctx, region)
 	if err != nil {
 		return nil, err
 	}
-	return s3.NewFromConfig(cfg), nil
+	return s3.NewFromConfig(cfg, f.s3Options()...), nil
 }
 
-func (u *Uploader) s3uploader(ctx context.Context) (s3UploaderAPI, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(u.config.AWS.Region))
+func (f clientFactory) s3Uploader(ctx context.Context, region string) (s3UploaderAPI, error) {
+	cfg, err := f.loadConfig(ctx, region)
 	if err != nil {
 		return nil, err
 	}
-	return s3manager.NewUploader(s3.NewFromConfig(cfg)), nil
+	return s3manager.NewUploader(s3.NewFromConfig(cfg, f.s3Options()...)), nil
 }
 
-func (u *Uploader) sts(ctx context.Context) (stsAPI, error) {
-	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(u.config.AWS.Region))
+func (f clientFactory) sts(ctx context.Context, region string) (stsAPI, error) {
+	cfg, err := f.loadConfig(ctx, region)
 	if err != nil {
 		return nil, err
 	}
@@ -558,11 +1009,39 @@ func (u *Uploader) sts(ctx context.Context) (stsAPI, error) {
 
 const bucketPermissionHelpText = "Importing snapshot failed with \"deleted\" status. This may indicate a missing service role for the AWS service \"vmie.amazonaws.com\" to access the snapshot. See https://docs.aws.amazon.com/vm-import/latest/userguide/required-permissions.html#vmimport-role for details."
 
-func waitForSnapshotImport(ctx context.Context, ec2C ec2API, importTaskID string) (string, error) {
+// waitInterval returns the configured interval between polls of long-running operations.
+func (u *Uploader) waitInterval() time.Duration {
+	if u.config.PollIntervalSeconds.IsSome() {
+		return time.Duration(u.config.PollIntervalSeconds.Unwrap()) * time.Second
+	}
+	return defaultWaitInterval
+}
+
+// maxWait returns the configured timeout of long-running operations.
+func (u *Uploader) maxWait() time.Duration {
+	if u.config.OperationTimeoutSeconds.IsSome() {
+		return time.Duration(u.config.OperationTimeoutSeconds.Unwrap()) * time.Second
+	}
+	return defaultMaxWait
+}
+
+// snapshotImportTimeout returns the timeout of snapshot imports, which can be set separately
+// since imports of large images take much longer than other operations.
+func (u *Uploader) snapshotImportTimeout() time.Duration {
+	if u.config.AWS.SnapshotImportTimeoutMinutes.IsSome() {
+		return time.Duration(u.config.AWS.SnapshotImportTimeoutMinutes.Unwrap()) * time.Minute
+	}
+	return u.maxWait()
+}
+
+// waitForSnapshotImport polls the import snapshot task every interval until the snapshot is imported
+// or the timeout is exceeded. The progress of the import is logged and reported while the task is active.
+func (u *Uploader) waitForSnapshotImport(ctx context.Context, ec2C ec2API, importTaskID string, interval, timeout time.Duration,
+) (string, error) {
 	start := time.Now()
 	for {
-		if time.Since(start) > maxWait {
-			return "", fmt.Errorf("importing snapshot: timeout")
+		if time.Since(start) > timeout {
+			return "", fmt.Errorf("importing snapshot: timed out after %s", timeout)
 		}
 		taskResp, err := ec2C.DescribeImportSnapshotTasks(ctx, &ec2.DescribeImportSnapshotTasksInput{
 			ImportTaskIds: []string{importTaskID},
@@ -587,14 +1066,18 @@ func waitForSnapshotImport(ctx context.Context, ec2C ec2API, importTaskID string
 		case string(ec2types.SnapshotStatePending):
 			// continue waiting
 		case string("active"):
-			// continue waiting
+			detail := taskResp.ImportSnapshotTasks[0].SnapshotTaskDetail
+			if detail.Progress != nil {
+				u.log.Printf("Snapshot import progress: %s%%", *detail.Progress)
+			}
+			reportImportProgress(detail, u.progress)
 		case string(ec2types.SnapshotStateCompleted):
 			// done
 			return *taskResp.ImportSnapshotTasks[0].SnapshotTaskDetail.SnapshotId, nil
 		case string(ec2types.SnapshotStateError):
 			return "", fmt.Errorf("importing snapshot: task failed with message %q", statusMessage)
 		case string("deleted"):
-			log.Println(bucketPermissionHelpText)
+			u.log.Println(bucketPermissionHelpText)
 			return "", fmt.Errorf("importing snapshot: import state deleted with message %q", statusMessage)
 		default:
 			return "", fmt.Errorf("importing snapshot: status %s with message %q",
@@ -602,37 +1085,101 @@ func waitForSnapshotImport(ctx context.Context, ec2C ec2API, importTaskID string
 				statusMessage,
 			)
 		}
-		time.Sleep(waitInterval)
+		time.Sleep(interval)
 	}
 }
 
-func getBackingSnapshotID(ctx context.Context, ec2C ec2API, amiID string) (string, error) {
+// reportImportProgress reports the progress of an active import snapshot task in bytes of the disk image.
+// AWS only reports the progress as percentage, so it's skipped if the disk image size is unknown.
+func reportImportProgress(detail *ec2types.SnapshotTaskDetail, progress uploader.ProgressReporter) {
+	if detail.Progress == nil || detail.DiskImageSize == nil {
+		return
+	}
+	percent, err := strconv.Atoi(*detail.Progress)
+	if err != nil {
+		return
+	}
+	total := int64(*detail.DiskImageSize)
+	progress.Bytes(total*int64(percent)/100, total)
+}
+
+func getBackingSnapshotIDs(ctx context.Context, ec2C ec2API, amiID string) ([]string, error) {
+	snapshots, err := getBackingSnapshots(ctx, ec2C, amiID)
+	if err != nil {
+		return nil, err
+	}
+	snapshotIDs := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotIDs = append(snapshotIDs, snapshot.snapshotID)
+	}
+	return snapshotIDs, nil
+}
+
+// backingSnapshot is a snapshot backing a block device of an AMI.
+type backingSnapshot struct {
+	deviceName string
+	snapshotID string
+}
+
+func getBackingSnapshots(ctx context.Context, ec2C ec2API, amiID string) ([]backingSnapshot, error) {
 	describeResp, err := ec2C.DescribeImages(ctx, &ec2.DescribeImagesInput{
 		ImageIds: []string{amiID},
 	})
 	if err != nil || len(describeResp.Images) == 0 {
-		return "", errAMIDoesNotExist
+		return nil, errAMIDoesNotExist
 	}
 	if len(describeResp.Images) != 1 {
-		return "", fmt.Errorf("describing image: expected 1 image, got %d", len(describeResp.Images))
+		return nil, fmt.Errorf("describing image: expected 1 image, got %d", len(describeResp.Images))
 	}
 	image := describeResp.Images[0]
-	if len(image.BlockDeviceMappings) != 1 {
-		return "", fmt.Errorf("found %d block device mappings for image %s, expected 1", len(image.BlockDeviceMappings), amiID)
+	if len(image.BlockDeviceMappings) == 0 {
+		return nil, fmt.Errorf("found no block device mappings for image %s", amiID)
+	}
+	snapshots := make([]backingSnapshot, 0, len(image.BlockDeviceMappings))
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs == nil {
+			return nil, fmt.Errorf("image %s does not have an EBS block device mapping", amiID)
+		}
+		if mapping.Ebs.SnapshotId == nil {
+			return nil, fmt.Errorf("image %s does not have an EBS snapshot", amiID)
+		}
+		var deviceName string
+		if mapping.DeviceName != nil {
+			deviceName = *mapping.DeviceName
+		}
+		snapshots = append(snapshots, backingSnapshot{deviceName: deviceName, snapshotID: *mapping.Ebs.SnapshotId})
 	}
-	if image.BlockDeviceMappings[0].Ebs == nil {
-		return "", fmt.Errorf("image %s does not have an EBS block device mapping", amiID)
+	return snapshots, nil
+}
+
+// bucketLocationConstraint returns the location constraint for a new bucket.
+// Without a configured constraint, the bucket is created in the upload region, as snapshots
+// can only be imported from buckets in the same region. The region us-east-1 has no constraint.
+func bucketLocationConstraint(configured, region string) string {
+	if configured != "" {
+		return configured
 	}
-	ebs := image.BlockDeviceMappings[0].Ebs
-	if ebs.SnapshotId == nil {
-		return "", fmt.Errorf("image %s does not have an EBS snapshot", amiID)
+	if region == "us-east-1" {
+		return ""
+	}
+	return region
+}
+
+// partitionForRegion returns the AWS partition a region belongs to.
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	default:
+		return "aws"
 	}
-	return *ebs.SnapshotId, nil
 }
 
 // getAMIARN returns the arn of the AMI with the given region, account ID and ami ID.
 func getAMIARN(region, accountID, amiID string) string {
-	return fmt.Sprintf("arn:aws:ec2:%s:%s:image/%s", region, accountID, amiID)
+	return fmt.Sprintf("arn:%s:ec2:%s:%s:image/%s", partitionForRegion(region), region, accountID, amiID)
 }
 
 func toPtr[T any](v T) *T {
@@ -0,0 +1,1235 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterImageInput(t *testing.T) {
+	testCases := map[string]struct {
+		awsConfig          config.AWSConfig
+		wantVirtualization string
+		wantEna            bool
+		wantSriov          *string
+		wantVolumeType     ec2types.VolumeType
+		wantEncrypted      *bool
+		wantArchitecture   ec2types.ArchitectureValues
+		wantRootDevice     string
+		wantTPMSupport     ec2types.TpmSupportValues
+		wantUEFIData       *string
+		wantBootMode       ec2types.BootModeValues
+	}{
+		"defaults": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "hvm",
+				EnaSupport:         config.Some(true),
+				SriovNetSupport:    config.Some(false),
+			},
+			wantVirtualization: "hvm",
+			wantTPMSupport:     ec2types.TpmSupportValuesV20,
+			wantEna:            true,
+		},
+		"sriov enabled": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "hvm",
+				EnaSupport:         config.Some(true),
+				SriovNetSupport:    config.Some(true),
+			},
+			wantVirtualization: "hvm",
+			wantTPMSupport:     ec2types.TpmSupportValuesV20,
+			wantEna:            true,
+			wantSriov:          toPtr("simple"),
+		},
+		"paravirtual defaults": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "paravirtual",
+			},
+			wantVirtualization: "paravirtual",
+			wantBootMode:       ec2types.BootModeValuesLegacyBios,
+		},
+		"hvm without ena and tpm set": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "hvm",
+			},
+			wantVirtualization: "hvm",
+			wantTPMSupport:     ec2types.TpmSupportValuesV20,
+			wantEna:            true,
+		},
+		"paravirtual without ena": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "paravirtual",
+				EnaSupport:         config.Some(false),
+				TPMSupport:         config.Some(false),
+			},
+			wantVirtualization: "paravirtual",
+			wantBootMode:       ec2types.BootModeValuesLegacyBios,
+		},
+		"volume type and encryption": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "hvm",
+				EnaSupport:         config.Some(true),
+				EBSVolumeType:      "gp3",
+				Encrypted:          config.Some(true),
+				KMSKeyID:           "alias/my-key",
+			},
+			wantVirtualization: "hvm",
+			wantTPMSupport:     ec2types.TpmSupportValuesV20,
+			wantEna:            true,
+			wantVolumeType:     ec2types.VolumeTypeGp3,
+			wantEncrypted:      toPtr(true),
+		},
+		"arm64 with custom root device": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "hvm",
+				EnaSupport:         config.Some(true),
+				Architecture:       "arm64",
+				RootDeviceName:     "/dev/sda1",
+			},
+			wantVirtualization: "hvm",
+			wantEna:            true,
+			wantArchitecture:   ec2types.ArchitectureValuesArm64,
+			wantRootDevice:     "/dev/sda1",
+			wantTPMSupport:     ec2types.TpmSupportValuesV20,
+		},
+		"uefi var store without tpm": {
+			awsConfig: config.AWSConfig{
+				VirtualizationType: "hvm",
+				EnaSupport:         config.Some(true),
+				TPMSupport:         config.Some(false),
+				UEFIData:           "dGVzdA==",
+			},
+			wantVirtualization: "hvm",
+			wantEna:            true,
+			wantUEFIData:       toPtr("dGVzdA=="),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			tc.awsConfig.AMIName = "my-ami"
+			u := &Uploader{config: config.Config{AWS: tc.awsConfig}, log: log.Default()}
+
+			input := u.registerImageInput("snap-root", "")
+			assert.Equal("my-ami", *input.Name)
+			assert.Equal(tc.wantVirtualization, *input.VirtualizationType)
+			assert.Equal(tc.wantEna, *input.EnaSupport)
+			assert.Equal(tc.wantSriov, input.SriovNetSupport)
+			assert.Len(input.BlockDeviceMappings, 1)
+			assert.Equal("snap-root", *input.BlockDeviceMappings[0].Ebs.SnapshotId)
+			wantArchitecture := tc.wantArchitecture
+			if wantArchitecture == "" {
+				wantArchitecture = ec2types.ArchitectureValuesX8664
+			}
+			assert.Equal(wantArchitecture, input.Architecture)
+			wantRootDevice := tc.wantRootDevice
+			if wantRootDevice == "" {
+				wantRootDevice = "/dev/xvda"
+			}
+			assert.Equal(wantRootDevice, *input.RootDeviceName)
+			assert.Equal(wantRootDevice, *input.BlockDeviceMappings[0].DeviceName)
+			assert.Equal(tc.wantVolumeType, input.BlockDeviceMappings[0].Ebs.VolumeType)
+			assert.Equal(tc.wantEncrypted, input.BlockDeviceMappings[0].Ebs.Encrypted)
+			assert.Equal(tc.wantTPMSupport, input.TpmSupport)
+			assert.Equal(tc.wantUEFIData, input.UefiData)
+			wantBootMode := tc.wantBootMode
+			if wantBootMode == "" {
+				wantBootMode = ec2types.BootModeValuesUefi
+			}
+			assert.Equal(wantBootMode, input.BootMode)
+			assert.Nil(input.BlockDeviceMappings[0].Ebs.KmsKeyId)
+		})
+	}
+}
+
+func TestImportSnapshotInput(t *testing.T) {
+	testCases := map[string]struct {
+		awsConfig     config.AWSConfig
+		wantEncrypted *bool
+		wantKMSKeyID  *string
+	}{
+		"unencrypted by default": {},
+		"encrypted with default key": {
+			awsConfig:     config.AWSConfig{Encrypted: config.Some(true)},
+			wantEncrypted: toPtr(true),
+		},
+		"encrypted with kms key": {
+			awsConfig:     config.AWSConfig{Encrypted: config.Some(true), KMSKeyID: "alias/my-key"},
+			wantEncrypted: toPtr(true),
+			wantKMSKeyID:  toPtr("alias/my-key"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			tc.awsConfig.Bucket = "my-bucket"
+			u := &Uploader{config: config.Config{AWS: tc.awsConfig}, log: log.Default()}
+
+			input := u.importSnapshotInput("image.raw", "my-snapshot")
+			assert.Equal("my-bucket", *input.DiskContainer.UserBucket.S3Bucket)
+			assert.Equal("image.raw", *input.DiskContainer.UserBucket.S3Key)
+			assert.Equal(tc.wantEncrypted, input.Encrypted)
+			assert.Equal(tc.wantKMSKeyID, input.KmsKeyId)
+		})
+	}
+}
+
+func TestUploadResult(t *testing.T) {
+	testCases := map[string]struct {
+		awsConfig config.AWSConfig
+		want      uploader.UploadResult
+	}{
+		"private": {
+			awsConfig: config.AWSConfig{Encrypted: config.Some(false), Publish: config.Some(false)},
+			want: uploader.UploadResult{
+				Provider:     "aws",
+				Region:       "eu-central-1",
+				Reference:    "arn:aws:ec2:eu-central-1:000000000000:image/ami-123",
+				ResourceType: "ami",
+				ImageID:      "ami-123",
+				SnapshotIDs:  []string{"snap-root", "snap-data"},
+			},
+		},
+		"encrypted and shared": {
+			awsConfig: config.AWSConfig{
+				Encrypted:           config.Some(true),
+				KMSKeyID:            "alias/my-key",
+				Publish:             config.Some(false),
+				ShareWithAccountIDs: []string{"123456789012"},
+			},
+			want: uploader.UploadResult{
+				Provider:     "aws",
+				Region:       "eu-central-1",
+				Reference:    "arn:aws:ec2:eu-central-1:000000000000:image/ami-123",
+				ResourceType: "ami",
+				ImageID:      "ami-123",
+				SnapshotIDs:  []string{"snap-root", "snap-data"},
+				Encrypted:    true,
+				SharedWith:   []string{"123456789012"},
+			},
+		},
+		"public": {
+			awsConfig: config.AWSConfig{Publish: config.Some(true)},
+			want: uploader.UploadResult{
+				Provider:     "aws",
+				Region:       "eu-central-1",
+				Reference:    "arn:aws:ec2:eu-central-1:000000000000:image/ami-123",
+				ResourceType: "ami",
+				ImageID:      "ami-123",
+				SnapshotIDs:  []string{"snap-root", "snap-data"},
+				Public:       true,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u := &Uploader{config: config.Config{AWS: tc.awsConfig}}
+			result := u.uploadResult("eu-central-1", "000000000000", "ami-123", []string{"snap-root", "snap-data"})
+			assert.Equal(t, tc.want, result)
+		})
+	}
+}
+
+func TestPartitionForRegion(t *testing.T) {
+	testCases := map[string]struct {
+		region        string
+		wantPartition string
+		wantARN       string
+	}{
+		"commercial": {
+			region:        "eu-central-1",
+			wantPartition: "aws",
+			wantARN:       "arn:aws:ec2:eu-central-1:000000000000:image/ami-123",
+		},
+		"commercial us": {
+			region:        "us-east-1",
+			wantPartition: "aws",
+			wantARN:       "arn:aws:ec2:us-east-1:000000000000:image/ami-123",
+		},
+		"govcloud": {
+			region:        "us-gov-west-1",
+			wantPartition: "aws-us-gov",
+			wantARN:       "arn:aws-us-gov:ec2:us-gov-west-1:000000000000:image/ami-123",
+		},
+		"china": {
+			region:        "cn-north-1",
+			wantPartition: "aws-cn",
+			wantARN:       "arn:aws-cn:ec2:cn-north-1:000000000000:image/ami-123",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			assert.Equal(tc.wantPartition, partitionForRegion(tc.region))
+			assert.Equal(tc.wantARN, getAMIARN(tc.region, "000000000000", "ami-123"))
+		})
+	}
+}
+
+func TestBucketLocationConstraint(t *testing.T) {
+	testCases := map[string]struct {
+		configured string
+		region     string
+		want       string
+	}{
+		"configured":    {configured: "eu-west-1", region: "eu-central-1", want: "eu-west-1"},
+		"upload region": {region: "eu-central-1", want: "eu-central-1"},
+		"us-east-1":     {region: "us-east-1", want: ""},
+		"govcloud":      {region: "us-gov-west-1", want: "us-gov-west-1"},
+		"china":         {region: "cn-northwest-1", want: "cn-northwest-1"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, bucketLocationConstraint(tc.configured, tc.region))
+		})
+	}
+}
+
+func TestForEachRegion(t *testing.T) {
+	regions := []string{"eu-central-1", "us-east-1", "ap-south-1"}
+	// Complete the regions in reverse order.
+	delays := map[string]time.Duration{
+		"eu-central-1": 30 * time.Millisecond,
+		"us-east-1":    15 * time.Millisecond,
+		"ap-south-1":   0,
+	}
+
+	testCases := map[string]struct {
+		failing []string
+		wantErr bool
+	}{
+		"success": {},
+		"single region fails": {
+			failing: []string{"us-east-1"},
+			wantErr: true,
+		},
+		"multiple regions fail": {
+			failing: []string{"eu-central-1", "ap-south-1"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var mu sync.Mutex
+			var completed []string
+			results, err := forEachRegion(regions, 0, func(region string) (string, error) {
+				time.Sleep(delays[region])
+				mu.Lock()
+				completed = append(completed, region)
+				mu.Unlock()
+				if slices.Contains(tc.failing, region) {
+					return "", errors.New("failed in " + region)
+				}
+				return "ami-" + region, nil
+			})
+
+			// all regions are processed, even if some fail
+			assert.ElementsMatch(regions, completed)
+			if tc.wantErr {
+				assert.Error(err)
+				for i, region := range regions {
+					if slices.Contains(tc.failing, region) {
+						assert.ErrorContains(err, region)
+						assert.Zero(results[i])
+					} else {
+						assert.Equal("ami-"+region, results[i])
+					}
+				}
+				return
+			}
+			assert.NoError(err)
+			assert.Equal([]string{"ami-eu-central-1", "ami-us-east-1", "ami-ap-south-1"}, results)
+		})
+	}
+}
+
+func TestForEachRegionLimit(t *testing.T) {
+	testCases := map[string]struct {
+		limit   int
+		wantMax int
+	}{
+		"unlimited": {
+			limit:   0,
+			wantMax: 6,
+		},
+		"limited": {
+			limit:   2,
+			wantMax: 2,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			regions := []string{"a", "b", "c", "d", "e", "f"}
+			var mu sync.Mutex
+			var running, maxRunning int
+			results, err := forEachRegion(regions, tc.limit, func(region string) (string, error) {
+				mu.Lock()
+				running++
+				maxRunning = max(maxRunning, running)
+				mu.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return "ami-" + region, nil
+			})
+
+			assert.NoError(err)
+			assert.Equal([]string{"ami-a", "ami-b", "ami-c", "ami-d", "ami-e", "ami-f"}, results)
+			assert.LessOrEqual(maxRunning, tc.wantMax)
+			if tc.limit > 0 {
+				assert.Equal(tc.limit, maxRunning)
+			}
+		})
+	}
+}
+
+func TestImportImageTemplatedNames(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	conf := config.Config{
+		Provider:     "aws",
+		Name:         "my-image",
+		ImageVersion: "1.2.3",
+		NamePrefix:   "pr-42-",
+		AWS: config.AWSConfig{
+			Region:           "eu-central-1",
+			Bucket:           "my-bucket",
+			BlobName:         "{{.Name}}/{{.VersionMajor}}.{{.VersionMinor}}/{{.VersionPatch}}.raw",
+			SnapshotName:     "{{.Name}}-v{{.VersionMajor}}",
+			DataBlobName:     "{{.Name}}/{{.Version}}-data.raw",
+			DataSnapshotName: "{{.Name}}-{{.Version}}-data",
+		},
+	}
+	require.NoError(conf.SetDefaults())
+	unexpectedLookup := func(string) ([]byte, error) { return nil, errors.New("unexpected lookup") }
+	require.NoError(conf.Render(unexpectedLookup, unexpectedLookup))
+
+	ec2C := &stubEC2API{}
+	s3C := &stubS3API{}
+	s3UploaderC := &stubS3UploaderAPI{}
+	progress := &stubProgress{}
+	u := &Uploader{
+		config:           conf,
+		ec2Client:        func(context.Context, string) (ec2API, error) { return ec2C, nil },
+		s3Client:         func(context.Context, string) (s3API, error) { return s3C, nil },
+		s3UploaderClient: func(context.Context, string) (s3UploaderAPI, error) { return s3UploaderC, nil },
+		log:              log.New(io.Discard, "", 0),
+		progress:         progress,
+	}
+
+	_, err := u.importImage(context.Background(), u.config.AWS.BlobName, u.config.AWS.SnapshotName, strings.NewReader("image"), 5)
+	require.NoError(err)
+	_, err = u.importImage(context.Background(), u.config.AWS.DataBlobName, u.config.AWS.DataSnapshotName, strings.NewReader("data"), 4)
+	require.NoError(err)
+
+	wantBlobs := []string{"my-image/1.2/3.raw", "my-image/1.2.3-data.raw"}
+	assert.Equal(wantBlobs, s3UploaderC.keys)
+	assert.Equal(wantBlobs, s3C.deletedKeys)
+	require.Len(ec2C.imports, 2)
+	assert.Equal("my-image/1.2/3.raw", *ec2C.imports[0].DiskContainer.UserBucket.S3Key)
+	assert.Equal("pr-42-my-image-v1", *ec2C.imports[0].Description)
+	assert.Equal("my-image/1.2.3-data.raw", *ec2C.imports[1].DiskContainer.UserBucket.S3Key)
+	assert.Equal("pr-42-my-image-1.2.3-data", *ec2C.imports[1].Description)
+	assert.Equal([]string{
+		"upload blob", "5/5", "import snapshot",
+		"upload blob", "4/4", "import snapshot",
+	}, progress.events)
+}
+
+func TestImportImageKeepTempArtifacts(t *testing.T) {
+	testCases := map[string]struct {
+		keep        config.Option[bool]
+		wantDeleted []string
+	}{
+		"default": {
+			wantDeleted: []string{"my-blob.raw"},
+		},
+		"delete": {
+			keep:        config.Some(false),
+			wantDeleted: []string{"my-blob.raw"},
+		},
+		"keep": {
+			keep: config.Some(true),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			s3C := &stubS3API{}
+			u := &Uploader{
+				config: config.Config{
+					KeepTempArtifacts: tc.keep,
+					AWS: config.AWSConfig{
+						Region: "eu-central-1",
+						Bucket: "my-bucket",
+					},
+				},
+				ec2Client:        func(context.Context, string) (ec2API, error) { return &stubEC2API{}, nil },
+				s3Client:         func(context.Context, string) (s3API, error) { return s3C, nil },
+				s3UploaderClient: func(context.Context, string) (s3UploaderAPI, error) { return &stubS3UploaderAPI{}, nil },
+				log:              log.New(io.Discard, "", 0),
+				progress:         &stubProgress{},
+			}
+
+			_, err := u.importImage(context.Background(), "my-blob.raw", "my-snapshot", strings.NewReader("image"), 5)
+			require.NoError(err)
+			assert.Equal(tc.wantDeleted, s3C.deletedKeys)
+		})
+	}
+}
+
+func TestPutObjectInput(t *testing.T) {
+	testCases := map[string]struct {
+		aws         config.AWSConfig
+		wantSSE     s3types.ServerSideEncryption
+		wantKeyID   *string
+		wantTagging *string
+	}{
+		"plain": {
+			aws: config.AWSConfig{Bucket: "my-bucket"},
+		},
+		"encrypted": {
+			aws:       config.AWSConfig{Bucket: "my-bucket", BucketEncryptionKMSKeyID: "alias/my-key"},
+			wantSSE:   s3types.ServerSideEncryptionAwsKms,
+			wantKeyID: toPtr("alias/my-key"),
+		},
+		"tagged": {
+			aws: config.AWSConfig{
+				Bucket: "my-bucket",
+				Tags:   map[string]string{"team": "os team", "cost-center": "1234", "path": "a/b"},
+			},
+			wantTagging: toPtr("cost-center=1234&path=a%2Fb&team=os+team"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			u := &Uploader{config: config.Config{AWS: tc.aws}}
+			input := u.putObjectInput("my-blob", strings.NewReader("image"))
+			assert.Equal("my-bucket", *input.Bucket)
+			assert.Equal("my-blob", *input.Key)
+			assert.Equal(s3types.ChecksumAlgorithmSha256, input.ChecksumAlgorithm)
+			assert.Equal(tc.wantSSE, input.ServerSideEncryption)
+			assert.Equal(tc.wantKeyID, input.SSEKMSKeyId)
+			assert.Equal(tc.wantTagging, input.Tagging)
+		})
+	}
+}
+
+func TestResourceTags(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]ec2types.Tag{{Key: toPtr("Name"), Value: toPtr("my-ami")}}, resourceTags("my-ami", nil))
+	assert.Equal([]ec2types.Tag{
+		{Key: toPtr("Name"), Value: toPtr("my-ami")},
+		{Key: toPtr("cost-center"), Value: toPtr("1234")},
+		{Key: toPtr("team"), Value: toPtr("os")},
+	}, resourceTags("my-ami", map[string]string{"team": "os", "cost-center": "1234"}))
+}
+
+func TestImportImageCanceled(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3C := &stubS3API{}
+	ec2C := &cancelingEC2API{cancel: cancel}
+	u := &Uploader{
+		config: config.Config{
+			APIMaxAttempts: config.Some(1),
+			AWS:            config.AWSConfig{Region: "eu-central-1", Bucket: "my-bucket"},
+		},
+		ec2Client:        func(context.Context, string) (ec2API, error) { return ec2C, nil },
+		s3Client:         func(context.Context, string) (s3API, error) { return s3C, nil },
+		s3UploaderClient: func(context.Context, string) (s3UploaderAPI, error) { return &stubS3UploaderAPI{}, nil },
+		log:              log.New(io.Discard, "", 0),
+		progress:         &stubProgress{},
+	}
+
+	_, err := u.importImage(ctx, "my-blob.raw", "my-snapshot", strings.NewReader("image"), 5)
+	require.ErrorIs(err, context.Canceled)
+	assert.Equal([]string{"my-blob.raw"}, s3C.deletedKeys, "temporary blob must be deleted after cancellation")
+}
+
+// cancelingEC2API cancels the upload when the snapshot import is started, as if Ctrl-C was pressed.
+type cancelingEC2API struct {
+	ec2API
+
+	cancel context.CancelFunc
+}
+
+func (s *cancelingEC2API) ImportSnapshot(ctx context.Context, _ *ec2.ImportSnapshotInput, _ ...func(*ec2.Options),
+) (*ec2.ImportSnapshotOutput, error) {
+	s.cancel()
+	return nil, ctx.Err()
+}
+
+func TestWaitDurations(t *testing.T) {
+	testCases := map[string]struct {
+		conf             config.Config
+		wantWaitInterval time.Duration
+		wantMaxWait      time.Duration
+	}{
+		"defaults": {
+			wantWaitInterval: 15 * time.Second,
+			wantMaxWait:      30 * time.Minute,
+		},
+		"configured": {
+			conf: config.Config{
+				PollIntervalSeconds:     config.Some(5),
+				OperationTimeoutSeconds: config.Some(7200),
+			},
+			wantWaitInterval: 5 * time.Second,
+			wantMaxWait:      2 * time.Hour,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			u := &Uploader{config: tc.conf}
+			assert.Equal(tc.wantWaitInterval, u.waitInterval())
+			assert.Equal(tc.wantMaxWait, u.maxWait())
+		})
+	}
+}
+
+func TestWaitForSnapshotImport(t *testing.T) {
+	active := func(progress string) ec2types.SnapshotTaskDetail {
+		return ec2types.SnapshotTaskDetail{Status: toPtr("active"), Progress: toPtr(progress), DiskImageSize: toPtr(100.0)}
+	}
+	testCases := map[string]struct {
+		details      []ec2types.SnapshotTaskDetail
+		wantSnapshot string
+		wantLogs     []string
+		wantEvents   []string
+		wantErr      bool
+	}{
+		"increasing progress": {
+			details: []ec2types.SnapshotTaskDetail{
+				{Status: toPtr(string(ec2types.SnapshotStatePending))},
+				active("10"),
+				active("42"),
+				{Status: toPtr(string(ec2types.SnapshotStateCompleted)), SnapshotId: toPtr("snap-1")},
+			},
+			wantSnapshot: "snap-1",
+			wantLogs:     []string{"Snapshot import progress: 10%", "Snapshot import progress: 42%"},
+			wantEvents:   []string{"10/100", "42/100"},
+		},
+		"error": {
+			details: []ec2types.SnapshotTaskDetail{
+				active("10"),
+				{Status: toPtr(string(ec2types.SnapshotStateError)), StatusMessage: toPtr("invalid image")},
+			},
+			wantLogs:   []string{"Snapshot import progress: 10%"},
+			wantEvents: []string{"10/100"},
+			wantErr:    true,
+		},
+		"timeout": {
+			details: []ec2types.SnapshotTaskDetail{{Status: toPtr(string(ec2types.SnapshotStatePending))}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ec2C := &stubImportTaskEC2API{details: tc.details}
+			var logs strings.Builder
+			progress := &stubProgress{}
+			u := &Uploader{log: log.New(&logs, "", 0), progress: progress}
+
+			snapshotID, err := u.waitForSnapshotImport(context.Background(), ec2C, "import-snap-1", time.Millisecond, 50*time.Millisecond)
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				assert.Equal(tc.wantSnapshot, snapshotID)
+			}
+			var gotLogs []string
+			if logs.Len() > 0 {
+				gotLogs = strings.Split(strings.TrimSpace(logs.String()), "\n")
+			}
+			assert.Equal(tc.wantLogs, gotLogs)
+			assert.Equal(tc.wantEvents, progress.events)
+		})
+	}
+}
+
+// stubImportTaskEC2API returns the snapshot task details in order, repeating the last one.
+type stubImportTaskEC2API struct {
+	ec2API
+
+	details []ec2types.SnapshotTaskDetail
+}
+
+func (s *stubImportTaskEC2API) DescribeImportSnapshotTasks(_ context.Context, _ *ec2.DescribeImportSnapshotTasksInput, _ ...func(*ec2.Options),
+) (*ec2.DescribeImportSnapshotTasksOutput, error) {
+	detail := s.details[0]
+	if len(s.details) > 1 {
+		s.details = s.details[1:]
+	}
+	return &ec2.DescribeImportSnapshotTasksOutput{
+		ImportSnapshotTasks: []ec2types.ImportSnapshotTask{{SnapshotTaskDetail: &detail}},
+	}, nil
+}
+
+func TestSnapshotImportTimeout(t *testing.T) {
+	testCases := map[string]struct {
+		conf        config.Config
+		wantTimeout time.Duration
+	}{
+		"default": {
+			wantTimeout: 30 * time.Minute,
+		},
+		"operation timeout": {
+			conf:        config.Config{OperationTimeoutSeconds: config.Some(600)},
+			wantTimeout: 10 * time.Minute,
+		},
+		"snapshot import timeout": {
+			conf: config.Config{
+				OperationTimeoutSeconds: config.Some(600),
+				AWS:                     config.AWSConfig{SnapshotImportTimeoutMinutes: config.Some(120)},
+			},
+			wantTimeout: 2 * time.Hour,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u := &Uploader{config: tc.conf}
+			assert.Equal(t, tc.wantTimeout, u.snapshotImportTimeout())
+		})
+	}
+}
+
+func TestClientFactory(t *testing.T) {
+	testCases := map[string]struct {
+		endpointURL      string
+		wantBaseEndpoint *string
+		wantPathStyle    bool
+	}{
+		"default endpoint": {},
+		"custom endpoint": {
+			endpointURL:      "http://localhost:4566",
+			wantBaseEndpoint: toPtr("http://localhost:4566"),
+			wantPathStyle:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			f := clientFactory{config: config.AWSConfig{EndpointURL: tc.endpointURL}}
+			cfg, err := f.loadConfig(context.Background(), "eu-central-1")
+			require.NoError(err)
+			assert.Equal("eu-central-1", cfg.Region)
+			assert.Equal(tc.wantBaseEndpoint, cfg.BaseEndpoint)
+
+			s3Options := s3.NewFromConfig(cfg, f.s3Options()...).Options()
+			assert.Equal(tc.wantBaseEndpoint, s3Options.BaseEndpoint)
+			assert.Equal(tc.wantPathStyle, s3Options.UsePathStyle)
+		})
+	}
+}
+
+func TestClientFactoryProfile(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte("[profile other]\nregion = us-west-2\n"), 0o600))
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+	testCases := map[string]struct {
+		profile string
+		wantErr bool
+	}{
+		"default profile": {},
+		"configured profile": {
+			profile: "other",
+		},
+		"unknown profile": {
+			profile: "unknown",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			f := clientFactory{config: config.AWSConfig{Profile: tc.profile}}
+			cfg, err := f.loadConfig(context.Background(), "eu-central-1")
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal("eu-central-1", cfg.Region)
+		})
+	}
+}
+
+func TestReportImportProgress(t *testing.T) {
+	testCases := map[string]struct {
+		detail     *ec2types.SnapshotTaskDetail
+		wantEvents []string
+	}{
+		"progress and size": {
+			detail:     &ec2types.SnapshotTaskDetail{Progress: toPtr("42"), DiskImageSize: toPtr(200.0)},
+			wantEvents: []string{"84/200"},
+		},
+		"no size": {
+			detail: &ec2types.SnapshotTaskDetail{Progress: toPtr("42")},
+		},
+		"no progress": {
+			detail: &ec2types.SnapshotTaskDetail{DiskImageSize: toPtr(200.0)},
+		},
+		"invalid progress": {
+			detail: &ec2types.SnapshotTaskDetail{Progress: toPtr("almost"), DiskImageSize: toPtr(200.0)},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			progress := &stubProgress{}
+			reportImportProgress(tc.detail, progress)
+			assert.Equal(t, tc.wantEvents, progress.events)
+		})
+	}
+}
+
+func TestReplicateImageRetry(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+	denied := &smithy.GenericAPIError{Code: "UnauthorizedOperation"}
+
+	testCases := map[string]struct {
+		copyErrs    []error
+		maxAttempts config.Option[int]
+		wantCalls   int
+		wantErr     bool
+	}{
+		"success": {
+			wantCalls: 1,
+		},
+		"throttled then success": {
+			copyErrs:  []error{throttled, throttled},
+			wantCalls: 3,
+		},
+		"non-transient error": {
+			copyErrs:  []error{denied},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		"attempts exhausted": {
+			copyErrs:    []error{throttled, throttled, throttled},
+			maxAttempts: config.Some(2),
+			wantCalls:   2,
+			wantErr:     true,
+		},
+		"retries disabled": {
+			copyErrs:    []error{throttled},
+			maxAttempts: config.Some(1),
+			wantCalls:   1,
+			wantErr:     true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			ec2C := &stubEC2API{copyErrs: tc.copyErrs}
+			u := &Uploader{
+				config: config.Config{
+					APIMaxAttempts: tc.maxAttempts,
+					AWS:            config.AWSConfig{Region: "eu-central-1", AMIName: "my-ami"},
+				},
+				ec2Client: func(context.Context, string) (ec2API, error) { return ec2C, nil },
+				log:       log.New(io.Discard, "", 0),
+			}
+
+			amiID, err := u.replicateImage(context.Background(), "ami-source", "us-east-1")
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				assert.Equal("ami-copy", amiID)
+			}
+			assert.Equal(tc.wantCalls, ec2C.copyCalls)
+		})
+	}
+}
+
+func TestPublishImage(t *testing.T) {
+	images := []ec2types.Image{{
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{Ebs: &ec2types.EbsBlockDevice{SnapshotId: toPtr("snap-root")}},
+			{Ebs: &ec2types.EbsBlockDevice{SnapshotId: toPtr("snap-data")}},
+		},
+	}}
+
+	testCases := map[string]struct {
+		publish             bool
+		shareWithAccountIDs []string
+		wantPermissions     []ec2types.LaunchPermission
+		wantSharedSnapshots []string
+	}{
+		"private": {},
+		"public": {
+			publish:         true,
+			wantPermissions: []ec2types.LaunchPermission{{Group: ec2types.PermissionGroupAll}},
+		},
+		"shared with accounts": {
+			shareWithAccountIDs: []string{"123456789012", "210987654321"},
+			wantPermissions: []ec2types.LaunchPermission{
+				{UserId: toPtr("123456789012")},
+				{UserId: toPtr("210987654321")},
+			},
+			wantSharedSnapshots: []string{"snap-root", "snap-data"},
+		},
+		"public and shared with accounts": {
+			publish:             true,
+			shareWithAccountIDs: []string{"123456789012"},
+			wantPermissions: []ec2types.LaunchPermission{
+				{Group: ec2types.PermissionGroupAll},
+				{UserId: toPtr("123456789012")},
+			},
+			wantSharedSnapshots: []string{"snap-root", "snap-data"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ec2C := &stubEC2API{images: images}
+			u := &Uploader{
+				config: config.Config{AWS: config.AWSConfig{
+					Publish:             config.Some(tc.publish),
+					ShareWithAccountIDs: tc.shareWithAccountIDs,
+				}},
+				ec2Client: func(context.Context, string) (ec2API, error) { return ec2C, nil },
+				log:       log.New(io.Discard, "", 0),
+			}
+
+			require.NoError(u.publishImage(context.Background(), "ami-1", "eu-central-1"))
+
+			if tc.wantPermissions == nil {
+				assert.Empty(ec2C.imageAttributeMods)
+			} else {
+				require.Len(ec2C.imageAttributeMods, 1)
+				assert.Equal(tc.wantPermissions, ec2C.imageAttributeMods[0].LaunchPermission.Add)
+			}
+			var sharedSnapshots []string
+			for _, mod := range ec2C.snapshotAttributeMods {
+				sharedSnapshots = append(sharedSnapshots, *mod.SnapshotId)
+				require.Len(mod.CreateVolumePermission.Add, len(tc.shareWithAccountIDs))
+				for i, accountID := range tc.shareWithAccountIDs {
+					assert.Equal(accountID, *mod.CreateVolumePermission.Add[i].UserId)
+				}
+			}
+			assert.Equal(tc.wantSharedSnapshots, sharedSnapshots)
+		})
+	}
+}
+
+func TestTagImageAndSnapshot(t *testing.T) {
+	images := []ec2types.Image{{
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{DeviceName: toPtr("/dev/xvda"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: toPtr("snap-root")}},
+			{DeviceName: toPtr("/dev/xvdb"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: toPtr("snap-data")}},
+		},
+	}}
+
+	testCases := map[string]struct {
+		dataImage string
+		wantNames map[string]string
+	}{
+		"with data image": {
+			dataImage: "data.raw",
+			wantNames: map[string]string{
+				"ami-1":     "my-ami",
+				"snap-root": "my-snapshot",
+				"snap-data": "my-snapshot-data",
+			},
+		},
+		"without data image": {
+			wantNames: map[string]string{
+				"ami-1":     "my-ami",
+				"snap-root": "my-snapshot",
+				"snap-data": "my-ami",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			ec2C := &stubEC2API{images: images}
+			u := &Uploader{
+				config: config.Config{AWS: config.AWSConfig{
+					AMIName:          "my-ami",
+					SnapshotName:     "my-snapshot",
+					DataImage:        tc.dataImage,
+					DataSnapshotName: "my-snapshot-data",
+					DataDeviceName:   "/dev/xvdb",
+					Tags:             map[string]string{"team": "a"},
+				}},
+				ec2Client: func(context.Context, string) (ec2API, error) { return ec2C, nil },
+				log:       log.New(io.Discard, "", 0),
+			}
+
+			snapshotIDs, err := u.tagImageAndSnapshot(context.Background(), "ami-1", "eu-central-1")
+			require.NoError(err)
+			assert.Equal([]string{"snap-root", "snap-data"}, snapshotIDs)
+
+			names := map[string]string{}
+			for _, input := range ec2C.createTags {
+				require.Len(input.Resources, 1)
+				assert.Equal(resourceTags(*input.Tags[0].Value, map[string]string{"team": "a"}), input.Tags)
+				names[input.Resources[0]] = *input.Tags[0].Value
+			}
+			assert.Equal(tc.wantNames, names)
+		})
+	}
+}
+
+type stubS3API struct {
+	s3API
+
+	deletedKeys []string
+}
+
+func (s *stubS3API) HeadBucket(_ context.Context, _ *s3.HeadBucketInput, _ ...func(*s3.Options),
+) (*s3.HeadBucketOutput, error) {
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (s *stubS3API) HeadObject(_ context.Context, _ *s3.HeadObjectInput, _ ...func(*s3.Options),
+) (*s3.HeadObjectOutput, error) {
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func (s *stubS3API) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, _ ...func(*s3.Options),
+) (*s3.DeleteObjectOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.deletedKeys = append(s.deletedKeys, *params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+type stubS3UploaderAPI struct {
+	keys []string
+}
+
+func (s *stubS3UploaderAPI) Upload(_ context.Context, input *s3.PutObjectInput, _ ...func(*s3manager.Uploader),
+) (*s3manager.UploadOutput, error) {
+	s.keys = append(s.keys, *input.Key)
+	if _, err := io.Copy(io.Discard, input.Body); err != nil {
+		return nil, err
+	}
+	return &s3manager.UploadOutput{}, nil
+}
+
+// stubProgress records stages and byte progress as "done/total".
+type stubProgress struct {
+	events []string
+}
+
+func (s *stubProgress) Stage(name string) {
+	s.events = append(s.events, name)
+}
+
+func (s *stubProgress) Bytes(done, total int64) {
+	s.events = append(s.events, fmt.Sprintf("%d/%d", done, total))
+}
+
+// stubEC2API implements the subset of ec2API exercised by the tests.
+// Calling any other method panics.
+type stubEC2API struct {
+	ec2API
+
+	images    []ec2types.Image
+	imports   []*ec2.ImportSnapshotInput
+	copyErrs  []error
+	copyCalls int
+
+	imageAttributeMods    []*ec2.ModifyImageAttributeInput
+	snapshotAttributeMods []*ec2.ModifySnapshotAttributeInput
+	createTags            []*ec2.CreateTagsInput
+}
+
+func (s *stubEC2API) CopyImage(_ context.Context, _ *ec2.CopyImageInput, _ ...func(*ec2.Options),
+) (*ec2.CopyImageOutput, error) {
+	s.copyCalls++
+	if len(s.copyErrs) > 0 {
+		err := s.copyErrs[0]
+		s.copyErrs = s.copyErrs[1:]
+		return nil, err
+	}
+	return &ec2.CopyImageOutput{ImageId: toPtr("ami-copy")}, nil
+}
+
+func (s *stubEC2API) ImportSnapshot(_ context.Context, params *ec2.ImportSnapshotInput, _ ...func(*ec2.Options),
+) (*ec2.ImportSnapshotOutput, error) {
+	s.imports = append(s.imports, params)
+	return &ec2.ImportSnapshotOutput{ImportTaskId: toPtr("import-snap-1")}, nil
+}
+
+func (s *stubEC2API) DescribeImportSnapshotTasks(_ context.Context, _ *ec2.DescribeImportSnapshotTasksInput, _ ...func(*ec2.Options),
+) (*ec2.DescribeImportSnapshotTasksOutput, error) {
+	return &ec2.DescribeImportSnapshotTasksOutput{
+		ImportSnapshotTasks: []ec2types.ImportSnapshotTask{{
+			SnapshotTaskDetail: &ec2types.SnapshotTaskDetail{
+				Status:     toPtr(string(ec2types.SnapshotStateCompleted)),
+				SnapshotId: toPtr("snap-1"),
+			},
+		}},
+	}, nil
+}
+
+func (s *stubEC2API) DescribeImages(_ context.Context, _ *ec2.DescribeImagesInput, _ ...func(*ec2.Options),
+) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: s.images}, nil
+}
+
+func (s *stubEC2API) ModifyImageAttribute(_ context.Context, params *ec2.ModifyImageAttributeInput, _ ...func(*ec2.Options),
+) (*ec2.ModifyImageAttributeOutput, error) {
+	s.imageAttributeMods = append(s.imageAttributeMods, params)
+	return &ec2.ModifyImageAttributeOutput{}, nil
+}
+
+func (s *stubEC2API) ModifySnapshotAttribute(_ context.Context, params *ec2.ModifySnapshotAttributeInput, _ ...func(*ec2.Options),
+) (*ec2.ModifySnapshotAttributeOutput, error) {
+	s.snapshotAttributeMods = append(s.snapshotAttributeMods, params)
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
+func (s *stubEC2API) CreateTags(_ context.Context, params *ec2.CreateTagsInput, _ ...func(*ec2.Options),
+) (*ec2.CreateTagsOutput, error) {
+	s.createTags = append(s.createTags, params)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func TestChecksumReader(t *testing.T) {
+	data := []byte("0123456789")
+	full := sha256.Sum256(data)
+	part1, part2, part3 := sha256.Sum256(data[:4]), sha256.Sum256(data[4:8]), sha256.Sum256(data[8:])
+	composite := sha256.Sum256(slices.Concat(part1[:], part2[:], part3[:]))
+
+	testCases := map[string]struct {
+		partSize  int64
+		remote    string
+		wantMatch bool
+	}{
+		"single part": {
+			partSize:  5 << 20,
+			remote:    base64.StdEncoding.EncodeToString(full[:]),
+			wantMatch: true,
+		},
+		"multipart": {
+			partSize:  4,
+			remote:    base64.StdEncoding.EncodeToString(composite[:]) + "-3",
+			wantMatch: true,
+		},
+		"multipart with wrong part count": {
+			partSize: 4,
+			remote:   base64.StdEncoding.EncodeToString(composite[:]) + "-2",
+		},
+		"mismatch": {
+			partSize: 5 << 20,
+			remote:   base64.StdEncoding.EncodeToString(part1[:]),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			checksums := newChecksumReader(iotest.OneByteReader(bytes.NewReader(data)), tc.partSize)
+			read, err := io.ReadAll(checksums)
+			assert.NoError(err)
+			assert.Equal(data, read)
+			assert.Equal(tc.wantMatch, checksums.matches(tc.remote))
+		})
+	}
+}
+
+func TestChecksumPartSize(t *testing.T) {
+	testCases := map[string]struct {
+		size int64
+		want int64
+	}{
+		"unknown size":  {size: -1, want: s3manager.DefaultUploadPartSize},
+		"small image":   {size: 1 << 30, want: s3manager.DefaultUploadPartSize},
+		"at part limit": {size: s3manager.DefaultUploadPartSize * int64(s3manager.MaxUploadParts), want: s3manager.DefaultUploadPartSize + 1},
+		"large image":   {size: 100 << 30, want: (100<<30)/int64(s3manager.MaxUploadParts) + 1},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, checksumPartSize(tc.size))
+		})
+	}
+}
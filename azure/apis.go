@@ -66,6 +66,8 @@ type azurePageblobAPI interface {
 	UploadPages(ctx context.Context, body io.ReadSeekCloser, contentRange blob.HTTPRange,
 		options *pageblob.UploadPagesOptions,
 	) (pageblob.UploadPagesResponse, error)
+	NewGetPageRangesPager(options *pageblob.GetPageRangesOptions) *runtime.Pager[pageblob.GetPageRangesResponse]
+	DownloadBuffer(ctx context.Context, buffer []byte, options *blob.DownloadBufferOptions) (int64, error)
 }
 
 type azureGalleriesAPI interface {
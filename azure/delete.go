@@ -0,0 +1,45 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// DeletePlan returns the operations Delete performs, without calling any Azure API.
+func (u *Uploader) DeletePlan() uploader.Plan {
+	var plan uploader.Plan
+	rg := u.config.Azure.ResourceGroup
+	location := u.config.Azure.Location
+	versionName := path.Join(rg, u.config.Azure.SharedImageGallery, u.config.Azure.ImageDefinitionName, u.config.ImageVersion)
+	diskName := path.Join(rg, u.config.Azure.DiskName)
+
+	plan.Add(uploader.ActionDeleteIfExists, "image version", versionName, location)
+	plan.Add(uploader.ActionDeleteIfExists, "managed image", diskName, location)
+	plan.Add(uploader.ActionDeleteIfExists, "disk", diskName, location)
+	return plan
+}
+
+// Delete removes the image version an upload with the current config creates,
+// together with a managed image and disk left behind by a failed upload.
+// The resource group, gallery and image definition are shared and kept.
+func (u *Uploader) Delete(ctx context.Context) error {
+	if err := u.ensureImageVersionDeleted(ctx); err != nil {
+		return fmt.Errorf("deleting image version: %w", err)
+	}
+	if err := u.ensureManagedImageDeleted(ctx); err != nil {
+		return fmt.Errorf("deleting managed image: %w", err)
+	}
+	if err := u.ensureDiskDeleted(ctx); err != nil {
+		return fmt.Errorf("deleting disk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+
+	armcomputev6 "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// List returns all versions of the configured image definition.
+func (u *Uploader) List(ctx context.Context) ([]uploader.ListedImage, error) {
+	rg := u.config.Azure.ResourceGroup
+	sigName := u.config.Azure.SharedImageGallery
+	defName := u.config.Azure.ImageDefinitionName
+
+	images := []uploader.ListedImage{}
+	pager := u.imageVersions.NewListByGalleryImagePager(rg, sigName, defName,
+		&armcomputev6.GalleryImageVersionsClientListByGalleryImageOptions{})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing image versions of %s/%s/%s: %w", rg, sigName, defName, err)
+		}
+		for _, version := range page.Value {
+			if version == nil {
+				continue
+			}
+			image := uploader.ListedImage{
+				Provider:  "azure",
+				Name:      deref(version.Name),
+				Region:    deref(version.Location),
+				Reference: deref(version.ID),
+			}
+			if version.Properties != nil && version.Properties.PublishingProfile != nil &&
+				version.Properties.PublishingProfile.PublishedDate != nil {
+				image.CreatedAt = *version.Properties.PublishingProfile.PublishedDate
+			}
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package azure
+
+import (
+	"path"
+	"strings"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// Plan returns the operations an upload with the current config performs, without calling any Azure API.
+func (u *Uploader) Plan() uploader.Plan {
+	var plan uploader.Plan
+	rg := u.config.Azure.ResourceGroup
+	location := u.config.Azure.Location
+	sigName := u.config.Azure.SharedImageGallery
+	defName := u.config.Azure.ImageDefinitionName
+	versionName := path.Join(rg, sigName, defName, u.config.ImageVersion)
+	diskName := path.Join(rg, u.config.Azure.DiskName)
+
+	plan.Add(uploader.ActionDeleteIfExists, "image version", versionName, location)
+	plan.Add(uploader.ActionDeleteIfExists, "managed image", diskName, location)
+	if u.config.Azure.ResumeUploads.UnwrapOrZero() {
+		plan.AddDetail(uploader.ActionDeleteIfExists, "disk", diskName, location, "unless an interrupted upload can be resumed")
+	} else {
+		plan.Add(uploader.ActionDeleteIfExists, "disk", diskName, location)
+	}
+
+	plan.Add(uploader.ActionCreateIfMissing, "resource group", rg, location)
+	gallerySharing := "sharing profile " + u.config.Azure.SharingProfile
+	if u.config.Azure.SharingProfile == "groups" {
+		gallerySharing += " with " + strings.Join(u.config.Azure.ShareWith, ", ")
+	}
+	if u.config.Azure.ForceSharingUpdate.UnwrapOrZero() {
+		gallerySharing += ", updating the sharing of an existing gallery"
+	}
+	plan.AddDetail(uploader.ActionCreateIfMissing, "gallery", path.Join(rg, sigName), location, gallerySharing)
+	plan.AddDetail(uploader.ActionCreateIfMissing, "image definition", path.Join(rg, sigName, defName), location,
+		"attestation variant "+u.config.Azure.AttestationVariant)
+
+	plan.Add(uploader.ActionCreate, "disk", diskName, location)
+	if len(u.config.Azure.VMGS) > 0 {
+		plan.AddDetail(uploader.ActionUpload, "disk", diskName, location, "with VM guest state from "+u.config.Azure.VMGSFile)
+	} else {
+		plan.Add(uploader.ActionUpload, "disk", diskName, location)
+	}
+	plan.Add(uploader.ActionCreate, "managed image", diskName, location)
+	regions := append([]string{location}, u.config.Azure.ReplicationRegions...)
+	plan.AddDetail(uploader.ActionCreate, "image version", versionName, location,
+		"replicated to "+strings.Join(regions, ", "))
+	if !u.config.KeepTempArtifacts.UnwrapOrZero() {
+		plan.Add(uploader.ActionDelete, "disk", diskName, location)
+	}
+	return plan
+}
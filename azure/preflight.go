@@ -0,0 +1,40 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	armcomputev6 "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// Preflight performs cheap read-only calls representative of the permissions an upload needs
+// and reports the outcome of each check.
+func (u *Uploader) Preflight(ctx context.Context, report func(check string, err error)) {
+	rg := u.config.Azure.ResourceGroup
+	sigName := u.config.Azure.SharedImageGallery
+
+	_, err := u.groups.CheckExistence(ctx, rg, &armresources.ResourceGroupsClientCheckExistenceOptions{})
+	report(fmt.Sprintf("resource group CheckExistence %s", rg), err)
+
+	_, err = u.galleries.Get(ctx, rg, sigName, &armcomputev6.GalleriesClientGetOptions{})
+	report(fmt.Sprintf("gallery Get %s in %s", sigName, rg), ignoreNotFound(err))
+}
+
+// ignoreNotFound treats a 404 response as success, as missing resources are created during upload.
+func ignoreNotFound(err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
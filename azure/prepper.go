@@ -8,8 +8,17 @@ package azure
 
 import (
 	"context"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
 )
 
+func init() {
+	uploader.RegisterPrepper("azure", func(config.Config) (uploader.Prepper, error) {
+		return &Prepper{}, nil
+	})
+}
+
 type Prepper struct{}
 
 func (p *Prepper) Prepare(_ context.Context, imagePath, _ string) (string, error) {
@@ -9,13 +9,18 @@ package azure
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc64"
 	"io"
 	"log"
+	"path"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	armcomputev6 "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
@@ -23,13 +28,15 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/pageblob"
 	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/internal/retry"
+	"github.com/edgelesssys/uplosi/uploader"
 )
 
 const (
-	pollingFrequency     = 10 * time.Second
-	uploadAccessDuration = 86400   // 24 hours
-	pageSizeMax          = 4194304 // 4MiB
-	pageSizeMin          = 512     // 512 bytes
+	defaultPollingFrequency = 10 * time.Second
+	uploadAccessDuration    = 86400   // 24 hours
+	pageSizeMax             = 4194304 // 4MiB
+	pageSizeMin             = 512     // 512 bytes
 )
 
 // Uploader can upload and remove os images on Azure.
@@ -37,6 +44,8 @@ type Uploader struct {
 	config           config.Config
 	pollingFrequency time.Duration
 	pollOpts         *runtime.PollUntilDoneOptions
+	// operationTimeout limits the polling of each long-running operation. Zero means no limit.
+	operationTimeout time.Duration
 
 	groups            azureGroupsAPI
 	disks             azureDiskAPI
@@ -48,14 +57,18 @@ type Uploader struct {
 	communityVersions azureCommunityGalleryImageVersionAPI
 	gallerySharing    azureGallerySharingProfileAPI
 
-	log *log.Logger
+	log      *log.Logger
+	progress uploader.ProgressReporter
 }
 
 // NewUploader creates a new config.
-func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
+func NewUploader(config config.Config, log *log.Logger, progress uploader.ProgressReporter) (*Uploader, error) {
+	if progress == nil {
+		progress = uploader.NopProgress{}
+	}
 	subscriptionID := config.Azure.SubscriptionID
 
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	cred, err := newCredential(config.Azure)
 	if err != nil {
 		return nil, err
 	}
@@ -92,10 +105,20 @@ func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
 		return nil, err
 	}
 
+	pollingFrequency := defaultPollingFrequency
+	if config.PollIntervalSeconds.IsSome() {
+		pollingFrequency = time.Duration(config.PollIntervalSeconds.Unwrap()) * time.Second
+	}
+	var operationTimeout time.Duration
+	if config.OperationTimeoutSeconds.IsSome() {
+		operationTimeout = time.Duration(config.OperationTimeoutSeconds.Unwrap()) * time.Second
+	}
+
 	return &Uploader{
 		config:           config,
 		pollingFrequency: pollingFrequency,
 		pollOpts:         &runtime.PollUntilDoneOptions{Frequency: pollingFrequency},
+		operationTimeout: operationTimeout,
 		groups:           groupsClient,
 		disks:            diskClient,
 		managedImages:    managedImagesClient,
@@ -108,11 +131,57 @@ func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
 		communityVersions: communityImageVersionClient,
 		gallerySharing:    gallerySharingClient,
 		log:               log,
+		progress:          progress,
 	}, nil
 }
 
+// newCredential creates the credential for the Azure API clients.
+// Without a client ID, the default credential chain is used, restricted to the tenant if configured.
+// With a client ID, only credentials that can be selected by client ID are tried: workload identity,
+// if it's configured in the environment, and managed identity.
+func newCredential(conf config.AzureConfig) (azcore.TokenCredential, error) {
+	if conf.ClientID == "" {
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{TenantID: conf.TenantID})
+	}
+	var creds []azcore.TokenCredential
+	workloadCred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+		ClientID: conf.ClientID,
+		TenantID: conf.TenantID,
+	})
+	if err == nil {
+		creds = append(creds, workloadCred)
+	}
+	managedCred, err := azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+		ID: azidentity.ClientID(conf.ClientID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating managed identity credential: %w", err)
+	}
+	creds = append(creds, managedCred)
+	return azidentity.NewChainedTokenCredential(creds, nil)
+}
+
+// pollUntilDone polls a long-running operation with the configured frequency until it's done.
+// It fails if the operation takes longer than the configured operation timeout.
+func pollUntilDone[T any](ctx context.Context, u *Uploader, poller *runtime.Poller[T]) (T, error) {
+	if u.operationTimeout <= 0 {
+		return poller.PollUntilDone(ctx, u.pollOpts)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, u.operationTimeout)
+	defer cancel()
+	result, err := poller.PollUntilDone(timeoutCtx, u.pollOpts)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("operation timed out after %s: %w", u.operationTimeout, err)
+	}
+	return result, err
+}
+
 // Upload uploads an OS image to Azure.
-func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, size int64) (refs []string, retErr error) {
+func (u *Uploader) Upload(ctx context.Context, req *uploader.Request) (results []uploader.UploadResult, retErr error) {
+	if err := checkOSDiskSize(u.config.Azure.OSDiskSizeGB, req.Size); err != nil {
+		return nil, err
+	}
+
 	// Ensure new image can be uploaded by deleting existing resources using the same name.
 	if err := u.ensureImageVersionDeleted(ctx); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no image version using the same name exists: %w", err)
@@ -120,8 +189,11 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, size int64)
 	if err := u.ensureManagedImageDeleted(ctx); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no managed image using the same name exists: %w", err)
 	}
-	if err := u.ensureDiskDeleted(ctx); err != nil {
-		return nil, fmt.Errorf("pre-cleaning: ensuring no temporary disk using the same name exists: %w", err)
+	// A disk left behind by an interrupted upload is reused by createDisk when resuming.
+	if !u.config.Azure.ResumeUploads.UnwrapOrZero() {
+		if err := u.ensureDiskDeleted(ctx); err != nil {
+			return nil, fmt.Errorf("pre-cleaning: ensuring no temporary disk using the same name exists: %w", err)
+		}
 	}
 
 	// Ensure resource group, SIG and image definition exist.
@@ -136,18 +208,35 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, size int64)
 		return nil, fmt.Errorf("ensuring image definition exists: %w", err)
 	}
 
-	vhdReader := newVHDReader(image, uint64(size), [16]byte{}, time.Time{})
-	diskID, err := u.createDisk(ctx, DiskTypeNormal, vhdReader, nil, int64(vhdReader.ContainerSize()))
+	vhdUUID, vhdTimestamp, err := u.vhdIdentity()
+	if err != nil {
+		return nil, err
+	}
+	vhdReader := newVHDReader(req.Image, uint64(req.Size), vhdUUID, vhdTimestamp, u.config.Azure.VHDCreatorApp)
+	diskType := DiskTypeNormal
+	var vmgs io.ReadSeeker
+	if len(u.config.Azure.VMGS) > 0 {
+		diskType = DiskTypeWithVMGS
+		vmgs = bytes.NewReader(u.config.Azure.VMGS)
+	}
+	diskID, err := u.createDisk(ctx, diskType, vhdReader, vmgs, int64(vhdReader.ContainerSize()))
 	if err != nil {
 		return nil, fmt.Errorf("creating disk: %w", err)
 	}
 	defer func(retErr *error) {
 		// cleanup temp disk
-		if err := u.ensureDiskDeleted(ctx); err != nil {
+		if u.config.KeepTempArtifacts.UnwrapOrZero() {
+			u.log.Printf("Keeping temporary disk %s", diskID)
+			return
+		}
+		cleanupCtx, cancel := uploader.CleanupContext(ctx)
+		defer cancel()
+		if err := u.ensureDiskDeleted(cleanupCtx); err != nil {
 			*retErr = errors.Join(*retErr, fmt.Errorf("post-cleaning: deleting disk image: %v", err))
 		}
 	}(&retErr)
 
+	u.progress.Stage(uploader.StageCreateImage)
 	managedImageID, err := u.createManagedImage(ctx, diskID)
 	if err != nil {
 		return nil, fmt.Errorf("creating managed image: %w", err)
@@ -161,8 +250,17 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, size int64)
 	if err != nil {
 		return nil, fmt.Errorf("getting image reference: %w", err)
 	}
+	resourceType := "image version"
+	if imageReference != unsharedImageVersionID {
+		resourceType = "community image version"
+	}
 
-	return []string{imageReference}, nil
+	return []uploader.UploadResult{{
+		Provider:     "azure",
+		Region:       u.config.Azure.Location,
+		Reference:    imageReference,
+		ResourceType: resourceType,
+	}}, nil
 }
 
 // createDisk creates and initializes (uploads contents of) an azure disk.
@@ -170,7 +268,6 @@ func (u *Uploader) createDisk(ctx context.Context, diskType DiskType, img io.Rea
 	rg := u.config.Azure.ResourceGroup
 	diskName := u.config.Azure.DiskName
 
-	u.log.Printf("Creating disk %s in %s", diskName, rg)
 	if diskType == DiskTypeWithVMGS && vmgs == nil {
 		return "", errors.New("cannot create disk with vmgs: vmgs reader is nil")
 	}
@@ -184,24 +281,42 @@ func (u *Uploader) createDisk(ctx context.Context, diskType DiskType, img io.Rea
 		requestVMGSSAS = true
 	}
 
-	disk := armcomputev6.Disk{
-		Location: &u.config.Azure.Location,
-		Properties: &armcomputev6.DiskProperties{
-			CreationData: &armcomputev6.CreationData{
-				CreateOption:    &createOption,
-				UploadSizeBytes: toPtr(size),
-			},
-			HyperVGeneration: toPtr(armcomputev6.HyperVGenerationV2),
-			OSType:           toPtr(armcomputev6.OperatingSystemTypesLinux),
-		},
-	}
-	createPoller, err := u.disks.BeginCreateOrUpdate(ctx, rg, diskName, disk, &armcomputev6.DisksClientBeginCreateOrUpdateOptions{})
-	if err != nil {
-		return "", fmt.Errorf("creating disk: %w", err)
+	resume := u.config.Azure.ResumeUploads.UnwrapOrZero()
+	var diskID *string
+	if resume {
+		var err error
+		diskID, err = u.resumableDisk(ctx, createOption, size)
+		if err != nil {
+			return "", err
+		}
 	}
-	createdDisk, err := createPoller.PollUntilDone(ctx, u.pollOpts)
-	if err != nil {
-		return "", fmt.Errorf("waiting for disk to be created: %w", err)
+	if diskID == nil {
+		u.log.Printf("Creating disk %s in %s", diskName, rg)
+		disk := armcomputev6.Disk{
+			Location: &u.config.Azure.Location,
+			Properties: &armcomputev6.DiskProperties{
+				CreationData: &armcomputev6.CreationData{
+					CreateOption:    &createOption,
+					UploadSizeBytes: toPtr(size),
+				},
+				HyperVGeneration: toPtr(u.hyperVGeneration()),
+				OSType:           toPtr(u.osType()),
+			},
+		}
+		if err := retry.Transient(ctx, u.log, "Creating disk", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.pollingFrequency, func() error {
+			createPoller, err := u.disks.BeginCreateOrUpdate(ctx, rg, diskName, disk, &armcomputev6.DisksClientBeginCreateOrUpdateOptions{})
+			if err != nil {
+				return fmt.Errorf("creating disk: %w", err)
+			}
+			createdDisk, err := pollUntilDone(ctx, u, createPoller)
+			if err != nil {
+				return fmt.Errorf("waiting for disk to be created: %w", err)
+			}
+			diskID = createdDisk.ID
+			return nil
+		}); err != nil {
+			return "", err
+		}
 	}
 
 	u.log.Printf("Granting temporary upload permissions via SAS token")
@@ -214,7 +329,7 @@ func (u *Uploader) createDisk(ctx context.Context, diskType DiskType, img io.Rea
 	if err != nil {
 		return "", fmt.Errorf("generating disk sas token: %w", err)
 	}
-	accesPollerResp, err := accessPoller.PollUntilDone(ctx, u.pollOpts)
+	accesPollerResp, err := pollUntilDone(ctx, u, accessPoller)
 	if err != nil {
 		return "", fmt.Errorf("waiting for sas token: %w", err)
 	}
@@ -231,16 +346,24 @@ func (u *Uploader) createDisk(ctx context.Context, diskType DiskType, img io.Rea
 		if accesPollerResp.SecurityDataAccessSAS == nil {
 			return "", errors.New("uploading vmgs: grant access returned no vmgs sas")
 		}
-		if err := uploadBlob(ctx, *accesPollerResp.SecurityDataAccessSAS, vmgs, vmgsSize, u.blob); err != nil {
+		vmgsOpts := blobUploadOptions{verify: u.config.VerifyUpload.UnwrapOrZero()}
+		if err := uploadBlob(ctx, *accesPollerResp.SecurityDataAccessSAS, vmgs, vmgsSize, u.blob, vmgsOpts, u.log); err != nil {
 			return "", fmt.Errorf("uploading vmgs: %w", err)
 		}
 	}
 
 	u.log.Printf("Uploading os image")
+	u.progress.Stage(uploader.StageUploadBlob)
 	if accesPollerResp.AccessSAS == nil {
 		return "", errors.New("uploading disk: grant access returned no disk sas")
 	}
-	if err := uploadBlob(ctx, *accesPollerResp.AccessSAS, img, size, u.blob); err != nil {
+	opts := blobUploadOptions{
+		resume:        resume,
+		skipZeroPages: u.config.Azure.SkipZeroPages.UnwrapOr(true),
+		verify:        u.config.VerifyUpload.UnwrapOrZero(),
+	}
+	img = uploader.NewProgressReader(img, size, u.progress)
+	if err := uploadBlob(ctx, *accesPollerResp.AccessSAS, img, size, u.blob, opts, u.log); err != nil {
 		return "", fmt.Errorf("uploading image: %w", err)
 	}
 
@@ -248,15 +371,52 @@ func (u *Uploader) createDisk(ctx context.Context, diskType DiskType, img io.Rea
 	if err != nil {
 		return "", fmt.Errorf("revoking disk sas token: %w", err)
 	}
-	if _, err := revokePoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: u.pollingFrequency}); err != nil {
+	if _, err := pollUntilDone(ctx, u, revokePoller); err != nil {
 		return "", fmt.Errorf("waiting for sas token revocation: %w", err)
 	}
 
-	if createdDisk.ID == nil {
+	if diskID == nil {
 		return "", errors.New("created disk has no id")
 	}
 
-	return *createdDisk.ID, nil
+	return *diskID, nil
+}
+
+// resumableDisk returns the ID of a disk left behind by an interrupted upload of an image with the same size.
+// A disk that can't be resumed is deleted. If no disk can be resumed, nil is returned.
+func (u *Uploader) resumableDisk(ctx context.Context, createOption armcomputev6.DiskCreateOption, size int64) (*string, error) {
+	rg := u.config.Azure.ResourceGroup
+	diskName := u.config.Azure.DiskName
+
+	resp, err := u.disks.Get(ctx, rg, diskName, &armcomputev6.DisksClientGetOptions{})
+	if err != nil {
+		u.log.Printf("Disk %s in %s doesn't exist. Nothing to resume.", diskName, rg)
+		return nil, nil
+	}
+	if isResumableDisk(resp.Disk, createOption, size) {
+		u.log.Printf("Resuming upload to existing disk %s in %s", diskName, rg)
+		return resp.ID, nil
+	}
+	u.log.Printf("Disk %s in %s can't be resumed", diskName, rg)
+	if err := u.ensureDiskDeleted(ctx); err != nil {
+		return nil, fmt.Errorf("deleting disk that can't be resumed: %w", err)
+	}
+	return nil, nil
+}
+
+// isResumableDisk reports whether the disk is still waiting for the upload of an image with the given size.
+func isResumableDisk(disk armcomputev6.Disk, createOption armcomputev6.DiskCreateOption, size int64) bool {
+	props := disk.Properties
+	if disk.ID == nil || props == nil || props.DiskState == nil || props.CreationData == nil ||
+		props.CreationData.CreateOption == nil || props.CreationData.UploadSizeBytes == nil {
+		return false
+	}
+	switch *props.DiskState {
+	case armcomputev6.DiskStateReadyToUpload, armcomputev6.DiskStateActiveUpload:
+	default:
+		return false
+	}
+	return *props.CreationData.CreateOption == createOption && *props.CreationData.UploadSizeBytes == size
 }
 
 func (u *Uploader) ensureDiskDeleted(ctx context.Context) error {
@@ -276,7 +436,7 @@ func (u *Uploader) ensureDiskDeleted(ctx context.Context) error {
 		return fmt.Errorf("deleting disk: %w", err)
 	}
 
-	if _, err = deletePoller.PollUntilDone(ctx, u.pollOpts); err != nil {
+	if _, err = pollUntilDone(ctx, u, deletePoller); err != nil {
 		return fmt.Errorf("waiting for disk to be deleted: %w", err)
 	}
 	return nil
@@ -291,11 +451,11 @@ func (u *Uploader) createManagedImage(ctx context.Context, diskID string) (strin
 	image := armcomputev6.Image{
 		Location: &location,
 		Properties: &armcomputev6.ImageProperties{
-			HyperVGeneration: toPtr(armcomputev6.HyperVGenerationTypesV2),
+			HyperVGeneration: toPtr(armcomputev6.HyperVGenerationTypes(u.hyperVGeneration())),
 			StorageProfile: &armcomputev6.ImageStorageProfile{
 				OSDisk: &armcomputev6.ImageOSDisk{
 					OSState: toPtr(armcomputev6.OperatingSystemStateTypesGeneralized),
-					OSType:  toPtr(armcomputev6.OperatingSystemTypesLinux),
+					OSType:  toPtr(u.osType()),
 					ManagedDisk: &armcomputev6.SubResource{
 						ID: &diskID,
 					},
@@ -303,21 +463,31 @@ func (u *Uploader) createManagedImage(ctx context.Context, diskID string) (strin
 			},
 		},
 	}
-	opts := &armcomputev6.ImagesClientBeginCreateOrUpdateOptions{}
-	createPoller, err := u.managedImages.BeginCreateOrUpdate(ctx, rg, imgName, image, opts)
-	if err != nil {
-		return "", fmt.Errorf("creating managed image: %w", err)
+	if u.config.Azure.OSDiskSizeGB > 0 {
+		image.Properties.StorageProfile.OSDisk.DiskSizeGB = toPtr(int32(u.config.Azure.OSDiskSizeGB))
 	}
-	createdImage, err := createPoller.PollUntilDone(ctx, u.pollOpts)
-	if err != nil {
-		return "", fmt.Errorf("waiting for image to be created: %w", err)
+	opts := &armcomputev6.ImagesClientBeginCreateOrUpdateOptions{}
+	var imageID *string
+	if err := retry.Transient(ctx, u.log, "Creating managed image", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.pollingFrequency, func() error {
+		createPoller, err := u.managedImages.BeginCreateOrUpdate(ctx, rg, imgName, image, opts)
+		if err != nil {
+			return fmt.Errorf("creating managed image: %w", err)
+		}
+		createdImage, err := pollUntilDone(ctx, u, createPoller)
+		if err != nil {
+			return fmt.Errorf("waiting for image to be created: %w", err)
+		}
+		imageID = createdImage.ID
+		return nil
+	}); err != nil {
+		return "", err
 	}
 
-	if createdImage.ID == nil {
+	if imageID == nil {
 		return "", errors.New("created image has no id")
 	}
 
-	return *createdImage.ID, nil
+	return *imageID, nil
 }
 
 func (u *Uploader) ensureManagedImageDeleted(ctx context.Context) error {
@@ -337,7 +507,7 @@ func (u *Uploader) ensureManagedImageDeleted(ctx context.Context) error {
 		return fmt.Errorf("deleting image: %w", err)
 	}
 
-	if _, err = deletePoller.PollUntilDone(ctx, u.pollOpts); err != nil {
+	if _, err = pollUntilDone(ctx, u, deletePoller); err != nil {
 		return fmt.Errorf("waiting for image to be deleted: %w", err)
 	}
 	return nil
@@ -347,6 +517,8 @@ func (u *Uploader) ensureResourceGroup(ctx context.Context) error {
 	rg := u.config.Azure.ResourceGroup
 	location := u.config.Azure.Location
 
+	defer sharedResourceLocks.lock(path.Join(u.config.Azure.SubscriptionID, rg))()
+
 	// Check if resource group exists.
 	resp, err := u.groups.CheckExistence(ctx, rg, &armresources.ResourceGroupsClientCheckExistenceOptions{})
 	if err != nil {
@@ -366,55 +538,65 @@ func (u *Uploader) ensureResourceGroup(ctx context.Context) error {
 	return nil
 }
 
+// vhdIdentity returns the UUID and timestamp of the VHD footer.
+// If SOURCE_DATE_EPOCH is set, the timestamp is fixed to it and the UUID is derived from the image name and version,
+// so the VHD only depends on the inputs. Otherwise, both are zero, unless the current timestamp is configured.
+// A configured UUID always takes precedence.
+func (u *Uploader) vhdIdentity() ([16]byte, time.Time, error) {
+	var uuid [16]byte
+	var timestamp time.Time
+	epoch, ok, err := uploader.SourceDateEpoch()
+	if err != nil {
+		return [16]byte{}, time.Time{}, err
+	}
+	if ok {
+		uuid = deterministicVHDUUID(u.config.Name, u.config.ImageVersion)
+		timestamp = epoch
+	} else if u.config.Azure.VHDUseCurrentTimestamp.UnwrapOrZero() {
+		timestamp = time.Now()
+	}
+	if u.config.Azure.VHDUUID != "" {
+		uuid, err = parseVHDUUID(u.config.Azure.VHDUUID)
+		if err != nil {
+			return [16]byte{}, time.Time{}, err
+		}
+	}
+	return uuid, timestamp, nil
+}
+
 // ensureSIG creates a SIG if it does not exist yet.
 func (u *Uploader) ensureSIG(ctx context.Context) error {
 	rg := u.config.Azure.ResourceGroup
 	sigName := u.config.Azure.SharedImageGallery
-	pubNamePrefix := u.config.Azure.SharingNamePrefix
 	sharingProf := sharingProfilePermissionFromString(u.config.Azure.SharingProfile)
 
+	defer sharedResourceLocks.lock(path.Join(u.config.Azure.SubscriptionID, rg, sigName))()
+
 	resp, err := u.galleries.Get(ctx, rg, sigName, &armcomputev6.GalleriesClientGetOptions{})
 	if err == nil {
 		u.log.Printf("Image gallery %s in %s exists", sigName, rg)
 		switch u.config.Azure.SharingProfile {
-		case "community":
-			if resp.Gallery.Properties == nil {
-				return errors.New("image gallery has no properties")
-			}
-			if resp.Gallery.Properties.SharingProfile == nil {
-				return errors.New("image gallery has no sharing profile")
-			}
-			if resp.Gallery.Properties.SharingProfile.Permissions == nil {
-				return errors.New("image gallery has no sharing profile permissions")
-			}
-			if *resp.Gallery.Properties.SharingProfile.Permissions != *sharingProf {
-				return errors.New("image gallery has different sharing profile permissions, cannot update automatically")
-			}
-			return nil
-		case "private":
-			if resp.Gallery.Properties == nil ||
-				resp.Gallery.Properties.SharingProfile == nil ||
-				resp.Gallery.Properties.SharingProfile.Permissions == nil {
-				return nil // no properties, sharing profile or permission means it's private
-			}
-			if *resp.Gallery.Properties.SharingProfile.Permissions != *sharingProf {
-				return errors.New("image gallery has different sharing profile permissions, cannot update automatically")
-			}
-			return nil
+		case "community", "private", "groups":
 		default:
 			return fmt.Errorf("image gallery has sharing profile %s, which is not supported. Cannot update automatically", u.config.Azure.SharingProfile)
 		}
+		current := gallerySharingPermission(resp.Gallery)
+		if current == *sharingProf {
+			if current == armcomputev6.GallerySharingPermissionTypesGroups {
+				return u.shareWithGroups(ctx)
+			}
+			return nil
+		}
+		if !u.config.Azure.ForceSharingUpdate.UnwrapOrZero() {
+			return fmt.Errorf("image gallery has sharing profile permissions %s instead of %s, cannot update automatically without forceSharingUpdate", current, *sharingProf)
+		}
+		return u.updateSharing(ctx, resp.Gallery, *sharingProf)
 	}
 
 	u.log.Printf("Creating image gallery %s in %s", sigName, rg)
 	var communityGalleryInfo *armcomputev6.CommunityGalleryInfo
 	if u.config.Azure.SharingProfile == "community" {
-		communityGalleryInfo = &armcomputev6.CommunityGalleryInfo{
-			PublicNamePrefix: &pubNamePrefix,
-			Eula:             toPtr("none"),
-			PublisherContact: toPtr("test@foo.bar"),
-			PublisherURI:     toPtr("https://foo.bar"),
-		}
+		communityGalleryInfo = u.communityGalleryInfo()
 	}
 	gallery := armcomputev6.Gallery{
 		Location: &u.config.Azure.Location,
@@ -426,12 +608,17 @@ func (u *Uploader) ensureSIG(ctx context.Context) error {
 		},
 	}
 	opts := &armcomputev6.GalleriesClientBeginCreateOrUpdateOptions{}
-	createPoller, err := u.galleries.BeginCreateOrUpdate(ctx, rg, sigName, gallery, opts)
-	if err != nil {
-		return fmt.Errorf("creating image gallery: %w", err)
-	}
-	if _, err = createPoller.PollUntilDone(ctx, u.pollOpts); err != nil {
-		return fmt.Errorf("waiting for image gallery to be created: %w", err)
+	if err := retry.Transient(ctx, u.log, "Creating image gallery", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.pollingFrequency, func() error {
+		createPoller, err := u.galleries.BeginCreateOrUpdate(ctx, rg, sigName, gallery, opts)
+		if err != nil {
+			return fmt.Errorf("creating image gallery: %w", err)
+		}
+		if _, err = pollUntilDone(ctx, u, createPoller); err != nil {
+			return fmt.Errorf("waiting for image gallery to be created: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 
 	if u.config.Azure.SharingProfile == "community" {
@@ -442,16 +629,155 @@ func (u *Uploader) ensureSIG(ctx context.Context) error {
 			return fmt.Errorf("enabling community sharing: %w", err)
 		}
 	}
+	if u.config.Azure.SharingProfile == "groups" {
+		return u.shareWithGroups(ctx)
+	}
+
+	return nil
+}
+
+// shareWithGroups adds the subscriptions and tenants configured in shareWith as share targets of the gallery.
+// Adding targets that already have access is a no-op, so this is safe to call for existing galleries.
+func (u *Uploader) shareWithGroups(ctx context.Context) error {
+	rg := u.config.Azure.ResourceGroup
+	sigName := u.config.Azure.SharedImageGallery
 
+	groups, err := sharingProfileGroups(u.config.Azure.ShareWith)
+	if err != nil {
+		return err
+	}
+	u.log.Printf("Sharing image gallery %s in %s with %s", sigName, rg, strings.Join(u.config.Azure.ShareWith, ", "))
+	sharingUpdate := armcomputev6.SharingUpdate{
+		OperationType: toPtr(armcomputev6.SharingUpdateOperationTypesAdd),
+		Groups:        groups,
+	}
+	sharingPoller, err := u.gallerySharing.BeginUpdate(ctx, rg, sigName, sharingUpdate, nil)
+	if err != nil {
+		return fmt.Errorf("sharing image gallery: %w", err)
+	}
+	if _, err := pollUntilDone(ctx, u, sharingPoller); err != nil {
+		return fmt.Errorf("waiting for image gallery to be shared: %w", err)
+	}
 	return nil
 }
 
+// sharingProfileGroups converts share targets in the form "subscription:<id>" or "tenant:<id>"
+// to the groups of a sharing update.
+func sharingProfileGroups(shareWith []string) ([]*armcomputev6.SharingProfileGroup, error) {
+	var subscriptions, tenants []*string
+	for _, target := range shareWith {
+		kind, id, _ := strings.Cut(target, ":")
+		switch kind {
+		case "subscription":
+			subscriptions = append(subscriptions, toPtr(id))
+		case "tenant":
+			tenants = append(tenants, toPtr(id))
+		default:
+			return nil, fmt.Errorf("share target %q must be in the form subscription:<id> or tenant:<id>", target)
+		}
+	}
+
+	var groups []*armcomputev6.SharingProfileGroup
+	if len(subscriptions) > 0 {
+		groups = append(groups, &armcomputev6.SharingProfileGroup{
+			Type: toPtr(armcomputev6.SharingProfileGroupTypesSubscriptions),
+			IDs:  subscriptions,
+		})
+	}
+	if len(tenants) > 0 {
+		groups = append(groups, &armcomputev6.SharingProfileGroup{
+			Type: toPtr(armcomputev6.SharingProfileGroupTypesAADTenants),
+			IDs:  tenants,
+		})
+	}
+	return groups, nil
+}
+
+// updateSharing changes the sharing profile of the existing gallery.
+// Only called if forceSharingUpdate is set, as it affects all images in the gallery.
+func (u *Uploader) updateSharing(ctx context.Context, existing armcomputev6.Gallery, to armcomputev6.GallerySharingPermissionTypes) error {
+	rg := u.config.Azure.ResourceGroup
+	sigName := u.config.Azure.SharedImageGallery
+	from := gallerySharingPermission(existing)
+	u.log.Printf("WARNING: Changing sharing profile of existing image gallery %s in %s from %s to %s. This affects all images in the gallery.", sigName, rg, from, to)
+
+	if to == armcomputev6.GallerySharingPermissionTypesGroups {
+		gallery := withSharingProfile(existing, &armcomputev6.SharingProfile{Permissions: &to})
+		updatePoller, err := u.galleries.BeginCreateOrUpdate(ctx, rg, sigName, gallery, &armcomputev6.GalleriesClientBeginCreateOrUpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("updating image gallery sharing profile: %w", err)
+		}
+		if _, err := pollUntilDone(ctx, u, updatePoller); err != nil {
+			return fmt.Errorf("waiting for image gallery sharing profile to be updated: %w", err)
+		}
+		return u.shareWithGroups(ctx)
+	}
+
+	operation := armcomputev6.SharingUpdateOperationTypesReset
+	if to == armcomputev6.GallerySharingPermissionTypesCommunity {
+		gallery := withSharingProfile(existing, &armcomputev6.SharingProfile{
+			CommunityGalleryInfo: u.communityGalleryInfo(),
+			Permissions:          &to,
+		})
+		updatePoller, err := u.galleries.BeginCreateOrUpdate(ctx, rg, sigName, gallery, &armcomputev6.GalleriesClientBeginCreateOrUpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("updating image gallery sharing profile: %w", err)
+		}
+		if _, err := pollUntilDone(ctx, u, updatePoller); err != nil {
+			return fmt.Errorf("waiting for image gallery sharing profile to be updated: %w", err)
+		}
+		operation = armcomputev6.SharingUpdateOperationTypesEnableCommunity
+	}
+
+	sharingUpdate := armcomputev6.SharingUpdate{OperationType: &operation}
+	sharingPoller, err := u.gallerySharing.BeginUpdate(ctx, rg, sigName, sharingUpdate, nil)
+	if err != nil {
+		return fmt.Errorf("updating gallery sharing with operation %s: %w", operation, err)
+	}
+	if _, err := pollUntilDone(ctx, u, sharingPoller); err != nil {
+		return fmt.Errorf("waiting for gallery sharing operation %s: %w", operation, err)
+	}
+	return nil
+}
+
+func (u *Uploader) communityGalleryInfo() *armcomputev6.CommunityGalleryInfo {
+	return &armcomputev6.CommunityGalleryInfo{
+		PublicNamePrefix: &u.config.Azure.SharingNamePrefix,
+		Eula:             toPtr("none"),
+		PublisherContact: toPtr("test@foo.bar"),
+		PublisherURI:     toPtr("https://foo.bar"),
+	}
+}
+
+// gallerySharingPermission returns the sharing permissions of a gallery.
+// No properties, sharing profile or permission means it's private.
+func gallerySharingPermission(gallery armcomputev6.Gallery) armcomputev6.GallerySharingPermissionTypes {
+	if gallery.Properties == nil ||
+		gallery.Properties.SharingProfile == nil ||
+		gallery.Properties.SharingProfile.Permissions == nil {
+		return armcomputev6.GallerySharingPermissionTypesPrivate
+	}
+	return *gallery.Properties.SharingProfile.Permissions
+}
+
+// withSharingProfile returns a copy of gallery with the given sharing profile.
+// Everything else, like the description and tags, is kept, since an update replaces the whole gallery.
+func withSharingProfile(gallery armcomputev6.Gallery, profile *armcomputev6.SharingProfile) armcomputev6.Gallery {
+	var properties armcomputev6.GalleryProperties
+	if gallery.Properties != nil {
+		properties = *gallery.Properties
+	}
+	properties.SharingProfile = profile
+	gallery.Properties = &properties
+	return gallery
+}
+
 func sharingProfilePermissionFromString(s string) *armcomputev6.GallerySharingPermissionTypes {
 	switch strings.ToLower(s) {
 	case "community":
 		return toPtr(armcomputev6.GallerySharingPermissionTypesCommunity)
-	// case "groups":
-	// 	return armcomputev5.GallerySharingPermissionTypesGroups
+	case "groups":
+		return toPtr(armcomputev6.GallerySharingPermissionTypesGroups)
 	default:
 		return toPtr(armcomputev6.GallerySharingPermissionTypesPrivate)
 	}
@@ -464,6 +790,8 @@ func (u *Uploader) ensureImageDefinition(ctx context.Context) error {
 	attestVariant := u.config.Azure.AttestationVariant
 	defName := u.config.Azure.ImageDefinitionName
 
+	defer sharedResourceLocks.lock(path.Join(u.config.Azure.SubscriptionID, rg, sigName, defName))()
+
 	_, err := u.image.Get(ctx, rg, sigName, defName, &armcomputev6.GalleryImagesClientGetOptions{})
 	if err == nil {
 		u.log.Printf("Image definition %s/%s in %s exists", sigName, defName, rg)
@@ -471,13 +799,16 @@ func (u *Uploader) ensureImageDefinition(ctx context.Context) error {
 	}
 	u.log.Printf("Creating image definition  %s/%s in %s", sigName, defName, rg)
 	var securityType string
-	// TODO(malt3): This needs to allow the *Supported or the normal variant
-	// based on wether a VMGS was provided or not.
-	// VMGS provided: ConfidentialVM
-	// No VMGS provided: ConfidentialVMSupported
 	switch strings.ToLower(attestVariant) {
 	case "azure-sev-snp", "azure-tdx":
-		securityType = string("ConfidentialVMSupported")
+		// Images with a VM guest state can only be used for confidential VMs.
+		// Without one, the guest state is created on deployment and the image
+		// also supports VMs without confidential computing.
+		if len(u.config.Azure.VMGS) > 0 {
+			securityType = string(armcomputev6.SecurityTypesConfidentialVM)
+		} else {
+			securityType = string("ConfidentialVMSupported")
+		}
 	case "azure-trustedlaunch":
 		securityType = string(armcomputev6.SecurityTypesTrustedLaunch)
 	}
@@ -490,25 +821,43 @@ func (u *Uploader) ensureImageDefinition(ctx context.Context) error {
 				Publisher: &u.config.Azure.Publisher,
 				SKU:       &u.config.Azure.SKU,
 			},
-			OSState:      toPtr(armcomputev6.OperatingSystemStateTypesGeneralized),
-			OSType:       toPtr(armcomputev6.OperatingSystemTypesLinux),
-			Architecture: toPtr(armcomputev6.ArchitectureX64),
-			Features: []*armcomputev6.GalleryImageFeature{
-				{Name: toPtr("SecurityType"), Value: &securityType},
-			},
-			HyperVGeneration: toPtr(armcomputev6.HyperVGenerationV2),
+			OSState:          toPtr(armcomputev6.OperatingSystemStateTypesGeneralized),
+			OSType:           toPtr(u.osType()),
+			Architecture:     toPtr(armcomputev6.ArchitectureX64),
+			HyperVGeneration: toPtr(u.hyperVGeneration()),
 		},
 	}
-	opts := &armcomputev6.GalleryImagesClientBeginCreateOrUpdateOptions{}
-	createPoller, err := u.image.BeginCreateOrUpdate(ctx, rg, sigName, defName, galleryImage, opts)
-	if err != nil {
-		return fmt.Errorf("creating image definition: %w", err)
+	// Trusted launch and confidential VMs are only available for generation 2 images.
+	if u.hyperVGeneration() == armcomputev6.HyperVGenerationV2 {
+		galleryImage.Properties.Features = []*armcomputev6.GalleryImageFeature{
+			{Name: toPtr("SecurityType"), Value: &securityType},
+		}
 	}
-	if _, err = createPoller.PollUntilDone(ctx, u.pollOpts); err != nil {
-		return fmt.Errorf("waiting for image definition to be created: %w", err)
+	if len(u.config.Azure.DisallowedDiskTypes) > 0 {
+		diskTypes := make([]*string, 0, len(u.config.Azure.DisallowedDiskTypes))
+		for _, diskType := range u.config.Azure.DisallowedDiskTypes {
+			diskTypes = append(diskTypes, toPtr(diskType))
+		}
+		galleryImage.Properties.Disallowed = &armcomputev6.Disallowed{DiskTypes: diskTypes}
 	}
-
-	return nil
+	if u.config.Azure.DefinitionEndOfLifeDate != "" {
+		endOfLife, err := parseEndOfLifeDate(u.config.Azure.DefinitionEndOfLifeDate)
+		if err != nil {
+			return err
+		}
+		galleryImage.Properties.EndOfLifeDate = &endOfLife
+	}
+	opts := &armcomputev6.GalleryImagesClientBeginCreateOrUpdateOptions{}
+	return retry.Transient(ctx, u.log, "Creating image definition", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.pollingFrequency, func() error {
+		createPoller, err := u.image.BeginCreateOrUpdate(ctx, rg, sigName, defName, galleryImage, opts)
+		if err != nil {
+			return fmt.Errorf("creating image definition: %w", err)
+		}
+		if _, err = pollUntilDone(ctx, u, createPoller); err != nil {
+			return fmt.Errorf("waiting for image definition to be created: %w", err)
+		}
+		return nil
+	})
 }
 
 func (u *Uploader) createImageVersion(ctx context.Context, imageID string) (string, error) {
@@ -530,13 +879,18 @@ func (u *Uploader) createImageVersion(ctx context.Context, imageID string) (stri
 				},
 			},
 			PublishingProfile: &armcomputev6.GalleryImageVersionPublishingProfile{
-				ReplicaCount:    toPtr[int32](1),
+				ReplicaCount:    toPtr(u.replicaCount()),
 				ReplicationMode: toPtr(armcomputev6.ReplicationModeFull),
-				TargetRegions:   replication(u.config.Azure.Location, u.config.Azure.ReplicationRegions, 1),
+				TargetRegions: replication(u.config.Azure.Location, u.config.Azure.ReplicationRegions,
+					u.replicaCount(), u.config.Azure.ReplicaCounts),
 			},
 		},
 	}
 
+	if u.config.Azure.OSDiskSizeGB > 0 {
+		imageVersion.Properties.StorageProfile.OSDiskImage.SizeInGB = toPtr(int32(u.config.Azure.OSDiskSizeGB))
+	}
+
 	if u.config.Azure.AdditionalSignatures != nil {
 		var value []*string
 		for _, sig := range u.config.Azure.AdditionalSignatures {
@@ -559,15 +913,21 @@ func (u *Uploader) createImageVersion(ctx context.Context, imageID string) (stri
 		}
 	}
 
-	createPoller, err := u.imageVersions.BeginCreateOrUpdate(ctx, rg, sigName, defName, verName, imageVersion,
-		&armcomputev6.GalleryImageVersionsClientBeginCreateOrUpdateOptions{},
-	)
-	if err != nil {
-		return "", fmt.Errorf("creating image version: %w", err)
-	}
-	createdImage, err := createPoller.PollUntilDone(ctx, u.pollOpts)
-	if err != nil {
-		return "", fmt.Errorf("waiting for image version to be created: %w", err)
+	var createdImage armcomputev6.GalleryImageVersionsClientCreateOrUpdateResponse
+	if err := retry.Transient(ctx, u.log, "Creating image version", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.pollingFrequency, func() error {
+		createPoller, err := u.imageVersions.BeginCreateOrUpdate(ctx, rg, sigName, defName, verName, imageVersion,
+			&armcomputev6.GalleryImageVersionsClientBeginCreateOrUpdateOptions{},
+		)
+		if err != nil {
+			return fmt.Errorf("creating image version: %w", err)
+		}
+		createdImage, err = pollUntilDone(ctx, u, createPoller)
+		if err != nil {
+			return fmt.Errorf("waiting for image version to be created: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", err
 	}
 	if createdImage.ID == nil {
 		return "", errors.New("created image has no id")
@@ -594,7 +954,7 @@ func (u *Uploader) ensureImageVersionDeleted(ctx context.Context) error {
 		return fmt.Errorf("deleting image version: %w", err)
 	}
 
-	if _, err = deletePoller.PollUntilDone(ctx, u.pollOpts); err != nil {
+	if _, err = pollUntilDone(ctx, u, deletePoller); err != nil {
 		return fmt.Errorf("waiting for image version to be deleted: %w", err)
 	}
 	return nil
@@ -622,16 +982,13 @@ func (u *Uploader) getImageReference(ctx context.Context, unsharedID string) (st
 		u.log.Printf("Image gallery %s in %s is not shared. Using private identifier", sigName, rg)
 		return unsharedID, nil
 	}
-	if galleryResp.Properties == nil ||
-		galleryResp.Properties.SharingProfile == nil ||
-		galleryResp.Properties.SharingProfile.CommunityGalleryInfo == nil ||
-		galleryResp.Properties.SharingProfile.CommunityGalleryInfo.PublicNames == nil ||
-		len(galleryResp.Properties.SharingProfile.CommunityGalleryInfo.PublicNames) < 1 ||
-		galleryResp.Properties.SharingProfile.CommunityGalleryInfo.PublicNames[0] == nil {
+	publicNames := galleryResp.Properties.SharingProfile.CommunityGalleryInfo.PublicNames
+	prefixes := append(slices.Clone(u.config.Azure.PublicNamePrefixes), u.config.Azure.SharingNamePrefix)
+	communityGalleryName, ok := selectPublicName(publicNames, prefixes)
+	if !ok {
 		return "", fmt.Errorf("image gallery %s in %s is a community gallery but has no public names", sigName, rg)
 	}
-	communityGalleryName := *galleryResp.Properties.SharingProfile.CommunityGalleryInfo.PublicNames[0]
-	u.log.Printf("Image gallery %s in %s is shared. Using community identifier in %s", sigName, rg, communityGalleryName)
+	u.log.Printf("Image gallery %s in %s is shared under %v. Using community identifier in %s", sigName, rg, derefAll(publicNames), communityGalleryName)
 	opts := &armcomputev6.CommunityGalleryImageVersionsClientGetOptions{}
 	communityVersionResp, err := u.communityVersions.Get(ctx, location, communityGalleryName, defName, verName, opts)
 	if err != nil {
@@ -643,34 +1000,158 @@ func (u *Uploader) getImageReference(ctx context.Context, unsharedID string) (st
 	return *communityVersionResp.Identifier.UniqueID, nil
 }
 
-func uploadBlob(ctx context.Context, sasURL string, disk io.Reader, size int64, uploader sasBlobUploader) error {
+// blobUploadOptions control which chunks uploadBlob writes.
+type blobUploadOptions struct {
+	// resume skips chunks that an earlier, interrupted upload already wrote with the same content.
+	resume bool
+	// skipZeroPages skips all-zero chunks that weren't written before, as unwritten pages read as zero.
+	skipZeroPages bool
+	// verify sends the CRC64 of every uploaded chunk and compares it with the CRC64 Azure computed.
+	verify bool
+}
+
+// zeroChunk is compared against chunks to detect all-zero chunks.
+var zeroChunk = make([]byte, pageSizeMax)
+
+// uploadBlob writes disk to the page blob behind sasURL, which must be zero initialized.
+func uploadBlob(ctx context.Context, sasURL string, disk io.Reader, size int64, uploader sasBlobUploader, opts blobUploadOptions, log *log.Logger) error {
 	uploadClient, err := uploader(sasURL)
 	if err != nil {
 		return fmt.Errorf("uploading blob: %w", err)
 	}
-	var offset int64
+	var written []blob.HTTPRange
+	if opts.resume {
+		written, err = writtenPageRanges(ctx, uploadClient)
+		if err != nil {
+			return fmt.Errorf("getting written page ranges: %w", err)
+		}
+	}
+	var offset, skipped, zeroSkipped int64
 	var chunksize int
 	chunk := make([]byte, pageSizeMax)
+	existing := make([]byte, pageSizeMax)
 	var readErr error
 	for offset < size {
 		chunksize, readErr = io.ReadAtLeast(disk, chunk, 1)
 		if readErr != nil {
-			return fmt.Errorf("reading from disk: %w", err)
+			return fmt.Errorf("reading from disk: %w", readErr)
+		}
+		if opts.skipZeroPages && !rangesOverlap(written, offset, int64(chunksize)) && bytes.Equal(chunk[:chunksize], zeroChunk[:chunksize]) {
+			zeroSkipped += int64(chunksize)
+			offset += int64(chunksize)
+			continue
+		}
+		if rangesCover(written, offset, int64(chunksize)) {
+			same, err := chunkWritten(ctx, uploadClient, chunk[:chunksize], existing[:chunksize], offset)
+			if err != nil {
+				return fmt.Errorf("comparing chunk at offset %d: %w", offset, err)
+			}
+			if same {
+				skipped += int64(chunksize)
+				offset += int64(chunksize)
+				continue
+			}
 		}
-		if err := uploadChunk(ctx, uploadClient, bytes.NewReader(chunk[:chunksize]), offset, int64(chunksize)); err != nil {
+		if err := uploadChunk(ctx, uploadClient, chunk[:chunksize], offset, opts.verify); err != nil {
 			return fmt.Errorf("uploading chunk: %w", err)
 		}
 		offset += int64(chunksize)
 	}
+	if opts.resume {
+		log.Printf("Resumed upload, skipped %d of %d bytes that were already written", skipped, size)
+	}
+	if opts.skipZeroPages {
+		log.Printf("Skipped %d of %d bytes that are zero", zeroSkipped, size)
+	}
 	return nil
 }
 
-func uploadChunk(ctx context.Context, uploader azurePageblobAPI, chunk io.ReadSeeker, offset, chunksize int64) error {
-	_, err := uploader.UploadPages(ctx, &readSeekNopCloser{chunk}, blob.HTTPRange{
+// writtenPageRanges returns the page ranges of the blob that contain data.
+func writtenPageRanges(ctx context.Context, client azurePageblobAPI) ([]blob.HTTPRange, error) {
+	var ranges []blob.HTTPRange
+	pager := client.NewGetPageRangesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, pageRange := range page.PageRange {
+			if pageRange == nil || pageRange.Start == nil || pageRange.End == nil {
+				continue
+			}
+			// The end of a page range is inclusive.
+			ranges = append(ranges, blob.HTTPRange{Offset: *pageRange.Start, Count: *pageRange.End - *pageRange.Start + 1})
+		}
+	}
+	return ranges, nil
+}
+
+// rangesCover reports whether [offset, offset+count) is fully contained in one of the ranges.
+// Page ranges returned by Azure are merged, so a chunk written earlier never spans two ranges.
+func rangesCover(ranges []blob.HTTPRange, offset, count int64) bool {
+	for _, r := range ranges {
+		if r.Offset <= offset && offset+count <= r.Offset+r.Count {
+			return true
+		}
+	}
+	return false
+}
+
+// rangesOverlap reports whether [offset, offset+count) overlaps any of the ranges.
+func rangesOverlap(ranges []blob.HTTPRange, offset, count int64) bool {
+	for _, r := range ranges {
+		if r.Offset < offset+count && offset < r.Offset+r.Count {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkWritten reports whether the blob already contains chunk at offset.
+// buf is used to download the existing content and must have the length of chunk.
+func chunkWritten(ctx context.Context, client azurePageblobAPI, chunk, buf []byte, offset int64) (bool, error) {
+	n, err := client.DownloadBuffer(ctx, buf, &blob.DownloadBufferOptions{
+		Range:     blob.HTTPRange{Offset: offset, Count: int64(len(chunk))},
+		BlockSize: int64(len(chunk)),
+	})
+	if err != nil {
+		return false, err
+	}
+	return n == int64(len(chunk)) && bytes.Equal(chunk, buf), nil
+}
+
+// uploadChunk writes chunk to the page blob at offset. If verify is set, the chunk is
+// uploaded with its CRC64, so Azure rejects corrupted chunks, and the CRC64 Azure
+// computed over the written range is compared with the local one.
+func uploadChunk(ctx context.Context, uploader azurePageblobAPI, chunk []byte, offset int64, verify bool) error {
+	var opts *pageblob.UploadPagesOptions
+	var checksum uint64
+	if verify {
+		checksum = crc64.Checksum(chunk, crc64Table)
+		opts = &pageblob.UploadPagesOptions{TransactionalValidation: blob.TransferValidationTypeCRC64(checksum)}
+	}
+	resp, err := uploader.UploadPages(ctx, &readSeekNopCloser{bytes.NewReader(chunk)}, blob.HTTPRange{
 		Offset: offset,
-		Count:  chunksize,
-	}, nil)
-	return err
+		Count:  int64(len(chunk)),
+	}, opts)
+	if err != nil || !verify {
+		return err
+	}
+	return verifyChecksum(offset, checksum, resp.ContentCRC64)
+}
+
+// crc64Table is the CRC64 table Azure Storage uses for transactional checksums.
+var crc64Table = crc64.MakeTable(0x9A6C9329AC4BC9B5)
+
+// verifyChecksum compares the little-endian CRC64 Azure returned for the chunk at offset with the local checksum.
+func verifyChecksum(offset int64, local uint64, remote []byte) error {
+	if len(remote) != 8 {
+		return fmt.Errorf("chunk at offset %d: Azure returned no CRC64", offset)
+	}
+	if got := binary.LittleEndian.Uint64(remote); got != local {
+		return fmt.Errorf("checksum mismatch for chunk at offset %d: Azure computed CRC64 %016x, uploaded chunk has %016x", offset, got, local)
+	}
+	return nil
 }
 
 type readSeekNopCloser struct {
@@ -681,15 +1162,115 @@ func (n *readSeekNopCloser) Close() error {
 	return nil
 }
 
+// selectPublicName returns the first public name of a community gallery that begins with
+// one of the prefixes, trying the prefixes in order. If no name matches, the first name is returned.
+func selectPublicName(publicNames []*string, prefixes []string) (string, bool) {
+	names := derefAll(publicNames)
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			continue
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix+"-") {
+				return name, true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	return names[0], true
+}
+
+func derefAll(ptrs []*string) []string {
+	vals := make([]string, 0, len(ptrs))
+	for _, p := range ptrs {
+		if p != nil {
+			vals = append(vals, *p)
+		}
+	}
+	return vals
+}
+
+// parseEndOfLifeDate parses a date (YYYY-MM-DD) or an RFC 3339 timestamp.
+func parseEndOfLifeDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing end of life date %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// checkOSDiskSize ensures a configured OS disk size can hold the image.
+func checkOSDiskSize(sizeGB int, imageSize int64) error {
+	if sizeGB <= 0 {
+		return nil
+	}
+	const gib = 1 << 30
+	minSizeGB := (imageSize + gib - 1) / gib
+	if int64(sizeGB) < minSizeGB {
+		return fmt.Errorf("osDiskSizeGB %d is smaller than the image, which needs at least %d GB", sizeGB, minSizeGB)
+	}
+	return nil
+}
+
+// hyperVGeneration returns the configured Hyper-V generation of the disk and images.
+// Generation 2 is used if it is unset.
+func (u *Uploader) hyperVGeneration() armcomputev6.HyperVGeneration {
+	if u.config.Azure.HyperVGeneration == "" {
+		return armcomputev6.HyperVGenerationV2
+	}
+	return armcomputev6.HyperVGeneration(u.config.Azure.HyperVGeneration)
+}
+
+// osType returns the configured operating system type of the disk and images.
+// Linux is used if it is unset.
+func (u *Uploader) osType() armcomputev6.OperatingSystemTypes {
+	if u.config.Azure.OSType == "" {
+		return armcomputev6.OperatingSystemTypesLinux
+	}
+	return armcomputev6.OperatingSystemTypes(u.config.Azure.OSType)
+}
+
 func toPtr[T any](t T) *T {
 	return &t
 }
 
-func replication(location string, regions []string, count int32) []*armcomputev6.TargetRegion {
+// deref returns the value p points to or the zero value if p is nil.
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+// replicaCount returns the configured default number of replicas per region.
+// A single replica is used if it is unset.
+func (u *Uploader) replicaCount() int32 {
+	if u.config.Azure.ReplicaCount <= 0 {
+		return 1
+	}
+	return int32(u.config.Azure.ReplicaCount)
+}
+
+// replication returns the target regions of an image version. Each region gets the
+// replica count from counts, falling back to defaultCount.
+func replication(location string, regions []string, defaultCount int32, counts map[string]int) []*armcomputev6.TargetRegion {
+	regionalCount := func(region string) *int32 {
+		if count, ok := counts[region]; ok && count > 0 {
+			return toPtr(int32(count))
+		}
+		return toPtr(defaultCount)
+	}
+
 	targetRegions := []*armcomputev6.TargetRegion{
 		{
 			Name:                 toPtr(location),
-			RegionalReplicaCount: toPtr[int32](count),
+			RegionalReplicaCount: regionalCount(location),
 		},
 	}
 	for _, region := range regions {
@@ -698,7 +1279,7 @@ func replication(location string, regions []string, count int32) []*armcomputev6
 		}
 		targetRegions = append(targetRegions, &armcomputev6.TargetRegion{
 			Name:                 toPtr(region),
-			RegionalReplicaCount: toPtr[int32](count),
+			RegionalReplicaCount: regionalCount(region),
 		})
 	}
 
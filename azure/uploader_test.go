@@ -0,0 +1,1136 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	armcomputev6 "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/pageblob"
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/internal/retry"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureSIGConcurrent(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	galleries := &stubGalleriesAPI{}
+	conf := config.Config{
+		Azure: config.AzureConfig{
+			SubscriptionID:     "00000000-0000-0000-0000-000000000000",
+			ResourceGroup:      "rg",
+			SharedImageGallery: "gallery",
+			SharingProfile:     "private",
+		},
+	}
+
+	const parallel = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, parallel)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u := &Uploader{config: conf, galleries: galleries, log: log.New(io.Discard, "", 0)}
+			errs <- u.ensureSIG(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(err)
+	}
+	assert.Equal(1, galleries.createCalls)
+}
+
+func TestEnsureSIGSharingUpdate(t *testing.T) {
+	testCases := map[string]struct {
+		current        armcomputev6.GallerySharingPermissionTypes
+		sharingProfile string
+		force          bool
+		wantErr        bool
+		wantOps        []armcomputev6.SharingUpdateOperationTypes
+		wantPermission armcomputev6.GallerySharingPermissionTypes
+	}{
+		"unchanged": {
+			current:        armcomputev6.GallerySharingPermissionTypesCommunity,
+			sharingProfile: "community",
+			wantPermission: armcomputev6.GallerySharingPermissionTypesCommunity,
+		},
+		"changed without force": {
+			current:        armcomputev6.GallerySharingPermissionTypesCommunity,
+			sharingProfile: "private",
+			wantErr:        true,
+			wantPermission: armcomputev6.GallerySharingPermissionTypesCommunity,
+		},
+		"forced to private": {
+			current:        armcomputev6.GallerySharingPermissionTypesCommunity,
+			sharingProfile: "private",
+			force:          true,
+			wantOps:        []armcomputev6.SharingUpdateOperationTypes{armcomputev6.SharingUpdateOperationTypesReset},
+			wantPermission: armcomputev6.GallerySharingPermissionTypesCommunity,
+		},
+		"forced to community": {
+			current:        armcomputev6.GallerySharingPermissionTypesPrivate,
+			sharingProfile: "community",
+			force:          true,
+			wantOps:        []armcomputev6.SharingUpdateOperationTypes{armcomputev6.SharingUpdateOperationTypesEnableCommunity},
+			wantPermission: armcomputev6.GallerySharingPermissionTypesCommunity,
+		},
+		"groups unchanged": {
+			current:        armcomputev6.GallerySharingPermissionTypesGroups,
+			sharingProfile: "groups",
+			wantOps:        []armcomputev6.SharingUpdateOperationTypes{armcomputev6.SharingUpdateOperationTypesAdd},
+			wantPermission: armcomputev6.GallerySharingPermissionTypesGroups,
+		},
+		"forced to groups": {
+			current:        armcomputev6.GallerySharingPermissionTypesPrivate,
+			sharingProfile: "groups",
+			force:          true,
+			wantOps:        []armcomputev6.SharingUpdateOperationTypes{armcomputev6.SharingUpdateOperationTypesAdd},
+			wantPermission: armcomputev6.GallerySharingPermissionTypesGroups,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			galleries := &stubGalleriesAPI{
+				created: true,
+				gallery: armcomputev6.Gallery{
+					Location: toPtr("westeurope"),
+					Tags:     map[string]*string{"team": toPtr("os")},
+					Properties: &armcomputev6.GalleryProperties{
+						Description:    toPtr("gallery description"),
+						SharingProfile: &armcomputev6.SharingProfile{Permissions: toPtr(tc.current)},
+					},
+				},
+			}
+			sharing := &stubGallerySharingAPI{}
+			u := &Uploader{
+				config: config.Config{
+					Azure: config.AzureConfig{
+						ResourceGroup:      "rg",
+						SharedImageGallery: "gallery",
+						SharingProfile:     tc.sharingProfile,
+						SharingNamePrefix:  "prefix",
+						ShareWith:          []string{"subscription:00000000-0000-0000-0000-000000000000"},
+						ForceSharingUpdate: config.Some(tc.force),
+					},
+				},
+				galleries:      galleries,
+				gallerySharing: sharing,
+				log:            log.New(io.Discard, "", 0),
+			}
+
+			err := u.ensureSIG(context.Background())
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(tc.wantOps, sharing.operations)
+			assert.Equal(tc.wantPermission, gallerySharingPermission(galleries.gallery))
+			// Updating the sharing profile keeps the rest of the gallery.
+			assert.Equal("westeurope", *galleries.gallery.Location)
+			assert.Equal(map[string]*string{"team": toPtr("os")}, galleries.gallery.Tags)
+			assert.Equal("gallery description", *galleries.gallery.Properties.Description)
+		})
+	}
+}
+
+func TestEnsureSIGGroupsSharing(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	galleries := &stubGalleriesAPI{}
+	sharing := &stubGallerySharingAPI{}
+	u := &Uploader{
+		config: config.Config{
+			Azure: config.AzureConfig{
+				ResourceGroup:      "rg",
+				SharedImageGallery: "gallery",
+				SharingProfile:     "groups",
+				ShareWith: []string{
+					"subscription:00000000-0000-0000-0000-000000000000",
+					"tenant:11111111-1111-1111-1111-111111111111",
+					"subscription:22222222-2222-2222-2222-222222222222",
+				},
+			},
+		},
+		galleries:      galleries,
+		gallerySharing: sharing,
+		log:            log.New(io.Discard, "", 0),
+	}
+
+	require.NoError(u.ensureSIG(context.Background()))
+	assert.Equal(armcomputev6.GallerySharingPermissionTypesGroups, gallerySharingPermission(galleries.gallery))
+	assert.Equal([]armcomputev6.SharingUpdateOperationTypes{armcomputev6.SharingUpdateOperationTypesAdd}, sharing.operations)
+	assert.Equal([]*armcomputev6.SharingProfileGroup{
+		{
+			Type: toPtr(armcomputev6.SharingProfileGroupTypesSubscriptions),
+			IDs:  []*string{toPtr("00000000-0000-0000-0000-000000000000"), toPtr("22222222-2222-2222-2222-222222222222")},
+		},
+		{
+			Type: toPtr(armcomputev6.SharingProfileGroupTypesAADTenants),
+			IDs:  []*string{toPtr("11111111-1111-1111-1111-111111111111")},
+		},
+	}, sharing.groups)
+}
+
+func TestSharingProfileGroupsInvalidTarget(t *testing.T) {
+	_, err := sharingProfileGroups([]string{"group:00000000-0000-0000-0000-000000000000"})
+	assert.Error(t, err)
+}
+
+func TestCreateImageVersionOSDiskSize(t *testing.T) {
+	testCases := map[string]struct {
+		osDiskSizeGB int
+		wantSizeInGB *int32
+	}{
+		"unset": {},
+		"set": {
+			osDiskSizeGB: 64,
+			wantSizeInGB: toPtr[int32](64),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			imageVersions := &stubImageVersionsAPI{}
+			u := &Uploader{
+				config: config.Config{
+					ImageVersion: "1.0.0",
+					Azure: config.AzureConfig{
+						Location:            "westeurope",
+						ResourceGroup:       "rg",
+						SharedImageGallery:  "gallery",
+						ImageDefinitionName: "definition",
+						OSDiskSizeGB:        tc.osDiskSizeGB,
+					},
+				},
+				imageVersions: imageVersions,
+				log:           log.New(io.Discard, "", 0),
+			}
+
+			_, err := u.createImageVersion(context.Background(), "image-id")
+			require.NoError(err)
+			require.NotNil(imageVersions.created.Properties)
+			assert.Equal(tc.wantSizeInGB, imageVersions.created.Properties.StorageProfile.OSDiskImage.SizeInGB)
+		})
+	}
+}
+
+func TestReplication(t *testing.T) {
+	testCases := map[string]struct {
+		regions      []string
+		defaultCount int32
+		counts       map[string]int
+		want         map[string]int32
+	}{
+		"location only": {
+			defaultCount: 1,
+			want:         map[string]int32{"westeurope": 1},
+		},
+		"default count": {
+			regions:      []string{"eastus2", "westeurope"},
+			defaultCount: 3,
+			want:         map[string]int32{"westeurope": 3, "eastus2": 3},
+		},
+		"per region counts": {
+			regions:      []string{"eastus2", "northeurope"},
+			defaultCount: 2,
+			counts:       map[string]int{"westeurope": 5, "eastus2": 10},
+			want:         map[string]int32{"westeurope": 5, "eastus2": 10, "northeurope": 2},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			targetRegions := replication("westeurope", tc.regions, tc.defaultCount, tc.counts)
+			assert.Equal("westeurope", *targetRegions[0].Name)
+			got := map[string]int32{}
+			for _, region := range targetRegions {
+				got[*region.Name] = *region.RegionalReplicaCount
+			}
+			assert.Equal(tc.want, got)
+		})
+	}
+}
+
+func TestCheckOSDiskSize(t *testing.T) {
+	const gib = 1 << 30
+
+	testCases := map[string]struct {
+		sizeGB    int
+		imageSize int64
+		wantErr   bool
+	}{
+		"unset":      {imageSize: 10 * gib},
+		"larger":     {sizeGB: 30, imageSize: 10 * gib},
+		"exact":      {sizeGB: 10, imageSize: 10 * gib},
+		"rounded up": {sizeGB: 10, imageSize: 10*gib + 1, wantErr: true},
+		"smaller":    {sizeGB: 5, imageSize: 10 * gib, wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := checkOSDiskSize(tc.sizeGB, tc.imageSize)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEnsureImageDefinitionRetry(t *testing.T) {
+	throttled := &azcore.ResponseError{StatusCode: http.StatusTooManyRequests}
+	parentNotFound := &azcore.ResponseError{StatusCode: http.StatusNotFound}
+	forbidden := &azcore.ResponseError{StatusCode: http.StatusForbidden}
+
+	testCases := map[string]struct {
+		createErrs []error
+		pollErrs   []error
+		wantCalls  int
+		wantErr    bool
+	}{
+		"success": {
+			wantCalls: 1,
+		},
+		"throttled then success": {
+			createErrs: []error{throttled},
+			wantCalls:  2,
+		},
+		"parent not found while polling then success": {
+			pollErrs:  []error{parentNotFound, parentNotFound},
+			wantCalls: 3,
+		},
+		"non-transient error": {
+			createErrs: []error{forbidden},
+			wantCalls:  1,
+			wantErr:    true,
+		},
+		"attempts exhausted": {
+			createErrs: []error{throttled, throttled, throttled, throttled, throttled, throttled},
+			wantCalls:  retry.DefaultMaxAttempts,
+			wantErr:    true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			images := &stubGalleriesImageAPI{createErrs: tc.createErrs, pollErrs: tc.pollErrs}
+			u := &Uploader{
+				config: config.Config{
+					Azure: config.AzureConfig{
+						SubscriptionID:      "00000000-0000-0000-0000-000000000000",
+						ResourceGroup:       "rg",
+						SharedImageGallery:  "gallery",
+						ImageDefinitionName: "definition",
+					},
+				},
+				image: images,
+				log:   log.New(io.Discard, "", 0),
+			}
+
+			err := u.ensureImageDefinition(context.Background())
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(tc.wantCalls, images.createCalls)
+		})
+	}
+}
+
+func TestEnsureImageDefinitionLifecycle(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	images := &stubGalleriesImageAPI{}
+	u := &Uploader{
+		config: config.Config{
+			Azure: config.AzureConfig{
+				ResourceGroup:           "rg",
+				SharedImageGallery:      "gallery",
+				ImageDefinitionName:     "definition",
+				DisallowedDiskTypes:     []string{"Standard_LRS"},
+				DefinitionEndOfLifeDate: "2030-12-31",
+			},
+		},
+		image: images,
+		log:   log.New(io.Discard, "", 0),
+	}
+
+	require.NoError(u.ensureImageDefinition(context.Background()))
+	props := images.created.Properties
+	require.NotNil(props.Disallowed)
+	assert.Equal([]*string{toPtr("Standard_LRS")}, props.Disallowed.DiskTypes)
+	require.NotNil(props.EndOfLifeDate)
+	assert.Equal(time.Date(2030, 12, 31, 0, 0, 0, 0, time.UTC), *props.EndOfLifeDate)
+}
+
+func TestEnsureImageDefinitionGeneration(t *testing.T) {
+	testCases := map[string]struct {
+		hyperVGeneration string
+		osType           string
+		wantGeneration   armcomputev6.HyperVGeneration
+		wantOSType       armcomputev6.OperatingSystemTypes
+		wantFeatures     bool
+	}{
+		"defaults": {
+			wantGeneration: armcomputev6.HyperVGenerationV2,
+			wantOSType:     armcomputev6.OperatingSystemTypesLinux,
+			wantFeatures:   true,
+		},
+		"generation 2 Linux": {
+			hyperVGeneration: "V2",
+			osType:           "Linux",
+			wantGeneration:   armcomputev6.HyperVGenerationV2,
+			wantOSType:       armcomputev6.OperatingSystemTypesLinux,
+			wantFeatures:     true,
+		},
+		"generation 1 Windows": {
+			hyperVGeneration: "V1",
+			osType:           "Windows",
+			wantGeneration:   armcomputev6.HyperVGenerationV1,
+			wantOSType:       armcomputev6.OperatingSystemTypesWindows,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			images := &stubGalleriesImageAPI{}
+			u := &Uploader{
+				config: config.Config{
+					Azure: config.AzureConfig{
+						ResourceGroup:       "rg",
+						SharedImageGallery:  "gallery",
+						ImageDefinitionName: "definition",
+						AttestationVariant:  "azure-sev-snp",
+						HyperVGeneration:    tc.hyperVGeneration,
+						OSType:              tc.osType,
+					},
+				},
+				image: images,
+				log:   log.New(io.Discard, "", 0),
+			}
+
+			require.NoError(u.ensureImageDefinition(context.Background()))
+			props := images.created.Properties
+			assert.Equal(tc.wantGeneration, *props.HyperVGeneration)
+			assert.Equal(tc.wantOSType, *props.OSType)
+			assert.Equal(tc.wantFeatures, len(props.Features) > 0)
+		})
+	}
+}
+
+func TestEnsureImageDefinitionSecurityType(t *testing.T) {
+	testCases := map[string]struct {
+		attestationVariant string
+		vmgs               []byte
+		want               string
+	}{
+		"confidential VM supported": {
+			attestationVariant: "azure-sev-snp",
+			want:               "ConfidentialVMSupported",
+		},
+		"confidential VM with VMGS": {
+			attestationVariant: "azure-tdx",
+			vmgs:               []byte{0x01},
+			want:               "ConfidentialVM",
+		},
+		"trusted launch": {
+			attestationVariant: "azure-trustedlaunch",
+			want:               "TrustedLaunch",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			images := &stubGalleriesImageAPI{}
+			u := &Uploader{
+				config: config.Config{
+					Azure: config.AzureConfig{
+						ResourceGroup:       "rg",
+						SharedImageGallery:  "gallery",
+						ImageDefinitionName: "definition",
+						AttestationVariant:  tc.attestationVariant,
+						VMGS:                tc.vmgs,
+					},
+				},
+				image: images,
+				log:   log.New(io.Discard, "", 0),
+			}
+
+			require.NoError(u.ensureImageDefinition(context.Background()))
+			features := images.created.Properties.Features
+			require.Len(features, 1)
+			assert.Equal("SecurityType", *features[0].Name)
+			assert.Equal(tc.want, *features[0].Value)
+		})
+	}
+}
+
+func TestUploadBlobResume(t *testing.T) {
+	size := 2*pageSizeMax + 1024
+	image := make([]byte, size)
+	for i := range image {
+		image[i] = byte(i % 251)
+	}
+	changed := bytes.Clone(image)
+	changed[pageSizeMax+1] ^= 0xff
+
+	testCases := map[string]struct {
+		blob        []byte
+		ranges      []*pageblob.PageRange
+		resume      bool
+		wantOffsets []int64
+	}{
+		"without resume every chunk is written": {
+			blob:        image,
+			ranges:      []*pageblob.PageRange{pageRange(0, int64(size))},
+			wantOffsets: []int64{0, pageSizeMax, 2 * pageSizeMax},
+		},
+		"resume of empty blob writes every chunk": {
+			blob:        make([]byte, size),
+			resume:      true,
+			wantOffsets: []int64{0, pageSizeMax, 2 * pageSizeMax},
+		},
+		"resume skips written chunks": {
+			blob:        image,
+			ranges:      []*pageblob.PageRange{pageRange(0, 2*pageSizeMax)},
+			resume:      true,
+			wantOffsets: []int64{2 * pageSizeMax},
+		},
+		"resume rewrites changed chunks": {
+			blob:        changed,
+			ranges:      []*pageblob.PageRange{pageRange(0, int64(size))},
+			resume:      true,
+			wantOffsets: []int64{pageSizeMax},
+		},
+		"resume of complete blob writes nothing": {
+			blob:   image,
+			ranges: []*pageblob.PageRange{pageRange(0, int64(size))},
+			resume: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			pageBlob := &stubPageblobAPI{blob: bytes.Clone(tc.blob), ranges: tc.ranges}
+			uploader := func(string) (azurePageblobAPI, error) { return pageBlob, nil }
+
+			opts := blobUploadOptions{resume: tc.resume}
+			err := uploadBlob(context.Background(), "sas", bytes.NewReader(image), int64(size), uploader, opts, log.New(io.Discard, "", 0))
+			require.NoError(err)
+			assert.Equal(tc.wantOffsets, pageBlob.writtenOffsets)
+			assert.Equal(image, pageBlob.blob)
+		})
+	}
+}
+
+func TestUploadBlobSkipZeroPages(t *testing.T) {
+	size := 3*pageSizeMax + 1024
+	image := make([]byte, size)
+	image[pageSizeMax+7] = 1
+	image[size-1] = 1
+
+	testCases := map[string]struct {
+		blob        []byte
+		ranges      []*pageblob.PageRange
+		opts        blobUploadOptions
+		wantOffsets []int64
+	}{
+		"zero pages are written without skipping": {
+			blob:        make([]byte, size),
+			wantOffsets: []int64{0, pageSizeMax, 2 * pageSizeMax, 3 * pageSizeMax},
+		},
+		"zero pages are skipped": {
+			blob:        make([]byte, size),
+			opts:        blobUploadOptions{skipZeroPages: true},
+			wantOffsets: []int64{pageSizeMax, 3 * pageSizeMax},
+		},
+		"written zero pages are compared when resuming": {
+			blob:        bytes.Repeat([]byte{0xff}, size),
+			ranges:      []*pageblob.PageRange{pageRange(pageSizeMax, pageSizeMax+512)},
+			opts:        blobUploadOptions{skipZeroPages: true, resume: true},
+			wantOffsets: []int64{pageSizeMax, 2 * pageSizeMax, 3 * pageSizeMax},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			pageBlob := &stubPageblobAPI{blob: bytes.Clone(tc.blob), ranges: tc.ranges}
+			uploader := func(string) (azurePageblobAPI, error) { return pageBlob, nil }
+
+			err := uploadBlob(context.Background(), "sas", bytes.NewReader(image), int64(size), uploader, tc.opts, log.New(io.Discard, "", 0))
+			require.NoError(err)
+			assert.Equal(tc.wantOffsets, pageBlob.writtenOffsets)
+		})
+	}
+}
+
+func TestUploadBlobVerify(t *testing.T) {
+	size := 2*pageSizeMax + 1024
+	image := bytes.Repeat([]byte{0xab}, size)
+
+	testCases := map[string]struct {
+		verify  bool
+		corrupt bool
+		wantErr bool
+	}{
+		"verified": {
+			verify: true,
+		},
+		"corrupted chunk detected": {
+			verify:  true,
+			corrupt: true,
+			wantErr: true,
+		},
+		"corrupted chunk without verification": {
+			corrupt: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			pageBlob := &stubPageblobAPI{blob: make([]byte, size), corrupt: tc.corrupt}
+			uploader := func(string) (azurePageblobAPI, error) { return pageBlob, nil }
+
+			err := uploadBlob(context.Background(), "sas", bytes.NewReader(image), int64(size), uploader,
+				blobUploadOptions{verify: tc.verify}, log.New(io.Discard, "", 0))
+			if tc.wantErr {
+				assert.ErrorContains(err, "checksum mismatch for chunk at offset 0")
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+// BenchmarkUploadBlobSparse uploads a 10GiB image with 16 non-zero chunks and reports the
+// number of UploadPages calls with and without skipping zero pages.
+func BenchmarkUploadBlobSparse(b *testing.B) {
+	const size = 10 << 30
+	for name, opts := range map[string]blobUploadOptions{
+		"all pages":  {},
+		"skip zeros": {skipZeroPages: true},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				pageBlob := &countingPageblobAPI{}
+				uploader := func(string) (azurePageblobAPI, error) { return pageBlob, nil }
+				err := uploadBlob(context.Background(), "sas", &sparseReader{size: size, dataEvery: size / 16}, size, uploader, opts, log.New(io.Discard, "", 0))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(pageBlob.uploads), "uploads/op")
+			}
+		})
+	}
+}
+
+func TestNewCredential(t *testing.T) {
+	testCases := map[string]struct {
+		conf     config.AzureConfig
+		wantType azcore.TokenCredential
+	}{
+		"default": {
+			wantType: &azidentity.DefaultAzureCredential{},
+		},
+		"tenant": {
+			conf:     config.AzureConfig{TenantID: "11111111-1111-1111-1111-111111111111"},
+			wantType: &azidentity.DefaultAzureCredential{},
+		},
+		"client": {
+			conf: config.AzureConfig{
+				TenantID: "11111111-1111-1111-1111-111111111111",
+				ClientID: "22222222-2222-2222-2222-222222222222",
+			},
+			wantType: &azidentity.ChainedTokenCredential{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			cred, err := newCredential(tc.conf)
+			require.NoError(err)
+			require.IsType(tc.wantType, cred)
+		})
+	}
+}
+
+func TestPollUntilDone(t *testing.T) {
+	testCases := map[string]struct {
+		handler     runtime.PollingHandler[string]
+		timeout     time.Duration
+		wantResult  string
+		wantTimeout bool
+	}{
+		"done": {
+			handler:    &stubPollingHandler[string]{result: "result"},
+			wantResult: "result",
+		},
+		"done with timeout": {
+			handler:    &stubPollingHandler[string]{result: "result"},
+			timeout:    time.Minute,
+			wantResult: "result",
+		},
+		"timeout": {
+			handler:     &stubPendingHandler[string]{},
+			timeout:     50 * time.Millisecond,
+			wantTimeout: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			poller, err := runtime.NewPoller(nil, runtime.Pipeline{}, &runtime.NewPollerOptions[string]{Handler: tc.handler})
+			require.NoError(err)
+			u := &Uploader{
+				pollOpts:         &runtime.PollUntilDoneOptions{Frequency: 10 * time.Millisecond},
+				operationTimeout: tc.timeout,
+			}
+
+			result, err := pollUntilDone(context.Background(), u, poller)
+			if tc.wantTimeout {
+				assert.ErrorIs(err, context.DeadlineExceeded)
+				assert.ErrorContains(err, "timed out after 50ms")
+				assert.Greater(tc.handler.(*stubPendingHandler[string]).polls, 1)
+				return
+			}
+			require.NoError(err)
+			assert.Equal(tc.wantResult, result)
+		})
+	}
+}
+
+func TestIsResumableDisk(t *testing.T) {
+	uploadDisk := func(state armcomputev6.DiskState, createOption armcomputev6.DiskCreateOption, size int64) armcomputev6.Disk {
+		return armcomputev6.Disk{
+			ID: toPtr("disk-id"),
+			Properties: &armcomputev6.DiskProperties{
+				DiskState: toPtr(state),
+				CreationData: &armcomputev6.CreationData{
+					CreateOption:    toPtr(createOption),
+					UploadSizeBytes: toPtr(size),
+				},
+			},
+		}
+	}
+
+	testCases := map[string]struct {
+		disk armcomputev6.Disk
+		want bool
+	}{
+		"ready to upload": {
+			disk: uploadDisk(armcomputev6.DiskStateReadyToUpload, armcomputev6.DiskCreateOptionUpload, 1024),
+			want: true,
+		},
+		"active upload": {
+			disk: uploadDisk(armcomputev6.DiskStateActiveUpload, armcomputev6.DiskCreateOptionUpload, 1024),
+			want: true,
+		},
+		"upload finished": {
+			disk: uploadDisk(armcomputev6.DiskStateUnattached, armcomputev6.DiskCreateOptionUpload, 1024),
+		},
+		"different size": {
+			disk: uploadDisk(armcomputev6.DiskStateActiveUpload, armcomputev6.DiskCreateOptionUpload, 2048),
+		},
+		"different create option": {
+			disk: uploadDisk(armcomputev6.DiskStateActiveUpload, armcomputev6.DiskCreateOptionUploadPreparedSecure, 1024),
+		},
+		"no properties": {
+			disk: armcomputev6.Disk{ID: toPtr("disk-id")},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isResumableDisk(tc.disk, armcomputev6.DiskCreateOptionUpload, 1024))
+		})
+	}
+}
+
+func TestSelectPublicName(t *testing.T) {
+	publicNames := []*string{toPtr("first-0000"), nil, toPtr("second-1111"), toPtr("third-2222")}
+
+	testCases := map[string]struct {
+		publicNames []*string
+		prefixes    []string
+		want        string
+		wantOK      bool
+	}{
+		"no prefixes": {
+			publicNames: publicNames,
+			want:        "first-0000",
+			wantOK:      true,
+		},
+		"matching prefix": {
+			publicNames: publicNames,
+			prefixes:    []string{"third"},
+			want:        "third-2222",
+			wantOK:      true,
+		},
+		"prefixes in order of preference": {
+			publicNames: publicNames,
+			prefixes:    []string{"unknown", "second", "third"},
+			want:        "second-1111",
+			wantOK:      true,
+		},
+		"no matching prefix": {
+			publicNames: publicNames,
+			prefixes:    []string{"fourth", "sec"},
+			want:        "first-0000",
+			wantOK:      true,
+		},
+		"no public names": {
+			publicNames: []*string{nil},
+			prefixes:    []string{"first"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			got, ok := selectPublicName(tc.publicNames, tc.prefixes)
+			assert.Equal(tc.wantOK, ok)
+			assert.Equal(tc.want, got)
+		})
+	}
+}
+
+type stubGalleriesImageAPI struct {
+	azureGalleriesImageAPI
+	createErrs  []error
+	pollErrs    []error
+	createCalls int
+	created     armcomputev6.GalleryImage
+}
+
+func (s *stubGalleriesImageAPI) Get(_ context.Context, _ string, _ string, _ string,
+	_ *armcomputev6.GalleryImagesClientGetOptions,
+) (armcomputev6.GalleryImagesClientGetResponse, error) {
+	return armcomputev6.GalleryImagesClientGetResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound}
+}
+
+func (s *stubGalleriesImageAPI) BeginCreateOrUpdate(_ context.Context, _ string, _ string, _ string,
+	galleryImage armcomputev6.GalleryImage, _ *armcomputev6.GalleryImagesClientBeginCreateOrUpdateOptions,
+) (*runtime.Poller[armcomputev6.GalleryImagesClientCreateOrUpdateResponse], error) {
+	s.createCalls++
+	s.created = galleryImage
+	if len(s.createErrs) > 0 {
+		err := s.createErrs[0]
+		s.createErrs = s.createErrs[1:]
+		return nil, err
+	}
+	var pollErr error
+	if len(s.pollErrs) > 0 {
+		pollErr = s.pollErrs[0]
+		s.pollErrs = s.pollErrs[1:]
+	}
+	return newStubPoller(armcomputev6.GalleryImagesClientCreateOrUpdateResponse{}, pollErr)
+}
+
+func TestList(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	published := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	versions := &stubImageVersionsAPI{pages: [][]*armcomputev6.GalleryImageVersion{
+		{
+			{
+				Name:     toPtr("1.0.0"),
+				ID:       toPtr("/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/galleries/g/images/d/versions/1.0.0"),
+				Location: toPtr("westeurope"),
+				Properties: &armcomputev6.GalleryImageVersionProperties{
+					PublishingProfile: &armcomputev6.GalleryImageVersionPublishingProfile{PublishedDate: &published},
+				},
+			},
+		},
+		{
+			{Name: toPtr("1.0.1"), ID: toPtr("version-id"), Location: toPtr("westeurope")},
+			nil,
+		},
+	}}
+	u := &Uploader{
+		config: config.Config{Azure: config.AzureConfig{
+			ResourceGroup:       "rg",
+			SharedImageGallery:  "g",
+			ImageDefinitionName: "d",
+		}},
+		imageVersions: versions,
+	}
+
+	images, err := u.List(context.Background())
+	require.NoError(err)
+	assert.Equal([]uploader.ListedImage{
+		{
+			Provider:  "azure",
+			Region:    "westeurope",
+			Name:      "1.0.0",
+			Reference: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/galleries/g/images/d/versions/1.0.0",
+			CreatedAt: published,
+		},
+		{Provider: "azure", Region: "westeurope", Name: "1.0.1", Reference: "version-id"},
+	}, images)
+}
+
+type stubImageVersionsAPI struct {
+	azureGalleriesImageVersionAPI
+	created armcomputev6.GalleryImageVersion
+	pages   [][]*armcomputev6.GalleryImageVersion
+}
+
+func (s *stubImageVersionsAPI) NewListByGalleryImagePager(_ string, _ string, _ string,
+	_ *armcomputev6.GalleryImageVersionsClientListByGalleryImageOptions,
+) *runtime.Pager[armcomputev6.GalleryImageVersionsClientListByGalleryImageResponse] {
+	pages := s.pages
+	return runtime.NewPager(runtime.PagingHandler[armcomputev6.GalleryImageVersionsClientListByGalleryImageResponse]{
+		More: func(armcomputev6.GalleryImageVersionsClientListByGalleryImageResponse) bool { return len(pages) > 0 },
+		Fetcher: func(context.Context, *armcomputev6.GalleryImageVersionsClientListByGalleryImageResponse,
+		) (armcomputev6.GalleryImageVersionsClientListByGalleryImageResponse, error) {
+			var resp armcomputev6.GalleryImageVersionsClientListByGalleryImageResponse
+			resp.Value, pages = pages[0], pages[1:]
+			return resp, nil
+		},
+	})
+}
+
+func (s *stubImageVersionsAPI) BeginCreateOrUpdate(_ context.Context, _ string, _ string, _ string, _ string,
+	galleryImageVersion armcomputev6.GalleryImageVersion,
+	_ *armcomputev6.GalleryImageVersionsClientBeginCreateOrUpdateOptions,
+) (*runtime.Poller[armcomputev6.GalleryImageVersionsClientCreateOrUpdateResponse], error) {
+	s.created = galleryImageVersion
+	return newStubPoller(armcomputev6.GalleryImageVersionsClientCreateOrUpdateResponse{
+		GalleryImageVersion: armcomputev6.GalleryImageVersion{ID: toPtr("image-version-id")},
+	}, nil)
+}
+
+type stubGalleriesAPI struct {
+	mu          sync.Mutex
+	created     bool
+	createCalls int
+	gallery     armcomputev6.Gallery
+}
+
+func (s *stubGalleriesAPI) Get(_ context.Context, _ string, _ string,
+	_ *armcomputev6.GalleriesClientGetOptions,
+) (armcomputev6.GalleriesClientGetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.created {
+		return armcomputev6.GalleriesClientGetResponse{}, errors.New("not found")
+	}
+	return armcomputev6.GalleriesClientGetResponse{Gallery: s.gallery}, nil
+}
+
+func (s *stubGalleriesAPI) NewListPager(_ *armcomputev6.GalleriesClientListOptions,
+) *runtime.Pager[armcomputev6.GalleriesClientListResponse] {
+	return nil
+}
+
+func (s *stubGalleriesAPI) BeginCreateOrUpdate(_ context.Context, _ string, _ string, gallery armcomputev6.Gallery,
+	_ *armcomputev6.GalleriesClientBeginCreateOrUpdateOptions,
+) (*runtime.Poller[armcomputev6.GalleriesClientCreateOrUpdateResponse], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createCalls++
+	s.created = true
+	s.gallery = gallery
+	return newStubPoller(armcomputev6.GalleriesClientCreateOrUpdateResponse{}, nil)
+}
+
+type stubGallerySharingAPI struct {
+	operations []armcomputev6.SharingUpdateOperationTypes
+	groups     []*armcomputev6.SharingProfileGroup
+}
+
+func (s *stubGallerySharingAPI) BeginUpdate(_ context.Context, _ string, _ string,
+	sharingUpdate armcomputev6.SharingUpdate, _ *armcomputev6.GallerySharingProfileClientBeginUpdateOptions,
+) (*runtime.Poller[armcomputev6.GallerySharingProfileClientUpdateResponse], error) {
+	s.operations = append(s.operations, *sharingUpdate.OperationType)
+	s.groups = append(s.groups, sharingUpdate.Groups...)
+	return newStubPoller(armcomputev6.GallerySharingProfileClientUpdateResponse{}, nil)
+}
+
+// stubPollingHandler is a polling handler for long-running operations that are already done.
+type stubPollingHandler[T any] struct {
+	result T
+	err    error
+}
+
+func (h *stubPollingHandler[T]) Done() bool {
+	return true
+}
+
+func (h *stubPollingHandler[T]) Poll(context.Context) (*http.Response, error) {
+	return nil, nil
+}
+
+func (h *stubPollingHandler[T]) Result(_ context.Context, out *T) error {
+	*out = h.result
+	return h.err
+}
+
+// stubPendingHandler is a polling handler of an operation that never finishes.
+type stubPendingHandler[T any] struct {
+	polls int
+}
+
+func (h *stubPendingHandler[T]) Done() bool {
+	return false
+}
+
+func (h *stubPendingHandler[T]) Poll(context.Context) (*http.Response, error) {
+	h.polls++
+	return nil, nil
+}
+
+func (h *stubPendingHandler[T]) Result(context.Context, *T) error {
+	return errors.New("operation is pending")
+}
+
+func newStubPoller[T any](result T, err error) (*runtime.Poller[T], error) {
+	return runtime.NewPoller(nil, runtime.Pipeline{}, &runtime.NewPollerOptions[T]{
+		Handler: &stubPollingHandler[T]{result: result, err: err},
+	})
+}
+
+// stubPageblobAPI is an in-memory page blob that records the offsets of written pages.
+type stubPageblobAPI struct {
+	blob           []byte
+	ranges         []*pageblob.PageRange
+	writtenOffsets []int64
+	// corrupt flips the first bit of every written chunk, as if it was corrupted in transit.
+	corrupt bool
+}
+
+func (s *stubPageblobAPI) UploadPages(_ context.Context, body io.ReadSeekCloser, contentRange blob.HTTPRange,
+	opts *pageblob.UploadPagesOptions,
+) (pageblob.UploadPagesResponse, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return pageblob.UploadPagesResponse{}, err
+	}
+	if s.corrupt {
+		data[0] ^= 1
+	}
+	copy(s.blob[contentRange.Offset:contentRange.Offset+contentRange.Count], data)
+	s.writtenOffsets = append(s.writtenOffsets, contentRange.Offset)
+	var resp pageblob.UploadPagesResponse
+	if opts != nil && opts.TransactionalValidation != nil {
+		resp.ContentCRC64 = binary.LittleEndian.AppendUint64(nil, crc64.Checksum(data, crc64Table))
+	}
+	return resp, nil
+}
+
+func (s *stubPageblobAPI) NewGetPageRangesPager(_ *pageblob.GetPageRangesOptions) *runtime.Pager[pageblob.GetPageRangesResponse] {
+	return runtime.NewPager(runtime.PagingHandler[pageblob.GetPageRangesResponse]{
+		More: func(pageblob.GetPageRangesResponse) bool { return false },
+		Fetcher: func(context.Context, *pageblob.GetPageRangesResponse) (pageblob.GetPageRangesResponse, error) {
+			var resp pageblob.GetPageRangesResponse
+			resp.PageRange = s.ranges
+			return resp, nil
+		},
+	})
+}
+
+func (s *stubPageblobAPI) DownloadBuffer(_ context.Context, buffer []byte, options *blob.DownloadBufferOptions) (int64, error) {
+	return int64(copy(buffer, s.blob[options.Range.Offset:options.Range.Offset+options.Range.Count])), nil
+}
+
+// pageRange returns the page range of count bytes starting at offset.
+func pageRange(offset, count int64) *pageblob.PageRange {
+	return &pageblob.PageRange{Start: toPtr(offset), End: toPtr(offset + count - 1)}
+}
+
+// countingPageblobAPI discards written pages and counts the UploadPages calls.
+type countingPageblobAPI struct {
+	stubPageblobAPI
+	uploads int
+}
+
+func (c *countingPageblobAPI) UploadPages(_ context.Context, _ io.ReadSeekCloser, _ blob.HTTPRange,
+	_ *pageblob.UploadPagesOptions,
+) (pageblob.UploadPagesResponse, error) {
+	c.uploads++
+	return pageblob.UploadPagesResponse{}, nil
+}
+
+// sparseReader reads size bytes that are zero except for one byte every dataEvery bytes.
+type sparseReader struct {
+	size, dataEvery, offset int64
+}
+
+func (r *sparseReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if remaining := r.size - r.offset; n > remaining {
+		n = remaining
+	}
+	clear(p[:n])
+	first := (r.offset + r.dataEvery - 1) / r.dataEvery * r.dataEvery
+	for i := first - r.offset; i < n; i += r.dataEvery {
+		p[i] = 1
+	}
+	r.offset += n
+	return int(n), nil
+}
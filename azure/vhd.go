@@ -8,8 +8,13 @@ package azure
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"math"
+	"strings"
 	"time"
 )
 
@@ -17,8 +22,27 @@ const (
 	sectorSize         = 512
 	vhdFixedHeaderSize = 512
 	dataAlignmentBytes = 1048576 // 1 MiB
+	// vhdEpoch is the Unix time of 2000-01-01 UTC, the reference of VHD timestamps.
+	vhdEpoch = 946684800
+	// defaultVHDCreatorApp is the creator application written by uplosi if none is configured.
+	defaultVHDCreatorApp = "uplo"
 )
 
+// WriteVHD writes the raw image data of the given size to w as fixed VHD,
+// exactly as it is uploaded to Azure with the default config.
+// It can be used to inspect the uploaded VHD locally.
+func WriteVHD(w io.Writer, data io.Reader, size uint64) error {
+	reader := newVHDReader(data, size, [16]byte{}, time.Time{}, defaultVHDCreatorApp)
+	written, err := io.Copy(w, reader)
+	if err != nil {
+		return fmt.Errorf("writing vhd: %w", err)
+	}
+	if uint64(written) != reader.ContainerSize() {
+		return fmt.Errorf("writing vhd: wrote %d bytes, expected %d", written, reader.ContainerSize())
+	}
+	return nil
+}
+
 // vhdReader is a reader for raw image files
 // it appends the VHD footer to the end of the image
 // and pads the image to a multiple of 1 MiB
@@ -30,8 +54,8 @@ type vhdReader struct {
 	pos uint64
 }
 
-func newVHDReader(data io.Reader, size uint64, uuid [16]byte, timestamp time.Time) *vhdReader {
-	footer := newVHDFixedHeader(size, uuid, timestamp)
+func newVHDReader(data io.Reader, size uint64, uuid [16]byte, timestamp time.Time, creatorApp string) *vhdReader {
+	footer := newVHDFixedHeader(size, uuid, timestamp, creatorApp)
 
 	reader := &vhdReader{
 		data:        data,
@@ -59,18 +83,21 @@ func (r *vhdReader) ContainerSize() uint64 {
 }
 
 func (r *vhdReader) Read(p []byte) (int, error) {
-	// first, read the data unmodified
+	// first, read the data unmodified, but never more than the payload size
 	if r.pos < r.payloadSize {
+		remaining := r.payloadSize - r.pos
+		if uint64(len(p)) > remaining {
+			p = p[:remaining]
+		}
 		read, err := r.data.Read(p)
+		r.pos += uint64(read)
 		if err == io.EOF {
-			r.pos = r.payloadSize
+			if r.pos < r.payloadSize {
+				return read, io.ErrUnexpectedEOF
+			}
 			return read, nil
 		}
-		if err != nil {
-			return read, err
-		}
-		r.pos += uint64(read)
-		return read, nil
+		return read, err
 	}
 
 	// then, pad the data to a multiple of 1 MiB
@@ -86,12 +113,10 @@ func (r *vhdReader) Read(p []byte) (int, error) {
 	}
 
 	// finally, append the VHD footer
-	footerPos := r.pos - padEnd
-	footerRange := min(len(p), vhdFixedHeaderSize)
 	if r.pos < padEnd+vhdFixedHeaderSize {
-		copy(p, r.footer[footerPos:footerRange])
-		r.pos += uint64(footerRange)
-		return footerRange, nil
+		copied := copy(p, r.footer[r.pos-padEnd:])
+		r.pos += uint64(copied)
+		return copied, nil
 	}
 
 	return 0, io.EOF
@@ -117,7 +142,9 @@ type VHDFixedHeader struct {
 	Reserved           [427]byte // offset 85
 }
 
-func newVHDFixedHeader(size uint64, uuid [16]byte, timestamp time.Time) VHDFixedHeader {
+// newVHDFixedHeader creates the footer of a fixed VHD.
+// creatorApp is truncated to the 4 bytes of the creator application field.
+func newVHDFixedHeader(size uint64, uuid [16]byte, timestamp time.Time, creatorApp string) VHDFixedHeader {
 	sizeWithPadding := size + (dataAlignmentBytes - (size % dataAlignmentBytes))
 
 	var header VHDFixedHeader
@@ -126,9 +153,8 @@ func newVHDFixedHeader(size uint64, uuid [16]byte, timestamp time.Time) VHDFixed
 	copy(header.FileFormatVersion[:], "\x00\x01\x00\x00")
 	copy(header.DataOffset[:], "\xff\xff\xff\xff\xff\xff\xff\xff")
 
-	formattedTimestamp := uint32(timestamp.Unix()) - 946684800
-	binary.BigEndian.PutUint32(header.Timestamp[:], formattedTimestamp)
-	copy(header.CreatorApplication[:], "uplo")
+	binary.BigEndian.PutUint32(header.Timestamp[:], vhdTimestamp(timestamp))
+	copy(header.CreatorApplication[:], creatorApp)
 	copy(header.CreatorHostOS[:], "Win2k")
 	binary.BigEndian.PutUint64(header.OriginalSize[:], sizeWithPadding)
 	binary.BigEndian.PutUint64(header.CurrentSize[:], sizeWithPadding)
@@ -146,6 +172,20 @@ func newVHDFixedHeader(size uint64, uuid [16]byte, timestamp time.Time) VHDFixed
 	return header
 }
 
+// vhdTimestamp returns the seconds since the VHD epoch (2000-01-01 UTC) stored in the footer.
+// Earlier timestamps, like the zero time or a SOURCE_DATE_EPOCH of 0, are clamped to the VHD epoch
+// instead of wrapping around.
+func vhdTimestamp(timestamp time.Time) uint32 {
+	seconds := timestamp.Unix() - vhdEpoch
+	switch {
+	case seconds < 0:
+		return 0
+	case seconds > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(seconds)
+}
+
 func (h *VHDFixedHeader) recalculateChecksum() {
 	copy(h.Checksum[:], "\x00\x00\x00\x00")
 	var hdr [vhdFixedHeaderSize]byte
@@ -213,3 +253,28 @@ func calculateCHS(totalSectors uint64) (uint16, uint8, uint8) {
 	}
 	return uint16(cylinders), uint8(heads), uint8(sectorsPerTrack)
 }
+
+// parseVHDUUID parses a UUID of 32 hex digits, optionally separated by dashes.
+func parseVHDUUID(s string) ([16]byte, error) {
+	var uuid [16]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return uuid, fmt.Errorf("parsing vhd uuid %q: %w", s, err)
+	}
+	if len(b) != len(uuid) {
+		return uuid, fmt.Errorf("parsing vhd uuid %q: expected %d bytes, got %d", s, len(uuid), len(b))
+	}
+	copy(uuid[:], b)
+	return uuid, nil
+}
+
+// deterministicVHDUUID derives a UUID for the VHD footer from the image name and version.
+// The UUID has version 8 (custom) and the RFC 9562 variant set.
+func deterministicVHDUUID(name, version string) [16]byte {
+	sum := sha256.Sum256([]byte(name + "\x00" + version))
+	var uuid [16]byte
+	copy(uuid[:], sum[:16])
+	uuid[6] = (uuid[6] & 0x0f) | 0x80
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return uuid
+}
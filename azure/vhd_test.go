@@ -0,0 +1,378 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package azure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVHDFixedHeaderCreatorApp(t *testing.T) {
+	testCases := map[string]struct {
+		creatorApp string
+		want       [4]byte
+	}{
+		"default": {
+			creatorApp: "uplo",
+			want:       [4]byte{'u', 'p', 'l', 'o'},
+		},
+		"custom": {
+			creatorApp: "myos",
+			want:       [4]byte{'m', 'y', 'o', 's'},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			header := newVHDFixedHeader(dataAlignmentBytes, [16]byte{}, time.Unix(946684800, 0), tc.creatorApp)
+			assert.Equal(tc.want, header.CreatorApplication)
+
+			// The checksum is the one's complement of the sum of all bytes, excluding the checksum itself.
+			buf := new(bytes.Buffer)
+			require.NoError(binary.Write(buf, binary.BigEndian, header))
+			raw := buf.Bytes()
+			require.Len(raw, vhdFixedHeaderSize)
+			var sum uint32
+			for i, b := range raw {
+				if i >= 64 && i < 68 {
+					continue
+				}
+				sum += uint32(b)
+			}
+			assert.Equal(^sum, binary.BigEndian.Uint32(header.Checksum[:]))
+		})
+	}
+}
+
+func TestVHDReaderReproducible(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	t.Setenv(uploader.SourceDateEpochEnv, "1700000000")
+
+	u := &Uploader{config: config.Config{Name: "image", ImageVersion: "1.2.3"}}
+	rawImage := bytes.Repeat([]byte("image"), 1024)
+
+	read := func() []byte {
+		uuid, timestamp, err := u.vhdIdentity()
+		require.NoError(err)
+		out, err := io.ReadAll(newVHDReader(bytes.NewReader(rawImage), uint64(len(rawImage)), uuid, timestamp, "uplo"))
+		require.NoError(err)
+		return out
+	}
+	first := read()
+	assert.Equal(first, read())
+
+	footer := first[len(first)-vhdFixedHeaderSize:]
+	assert.Equal(uint32(1700000000-946684800), binary.BigEndian.Uint32(footer[24:28]))
+	wantUUID := deterministicVHDUUID("image", "1.2.3")
+	assert.Equal(wantUUID[:], footer[68:84])
+}
+
+func TestVHDTimestamp(t *testing.T) {
+	testCases := map[string]struct {
+		timestamp time.Time
+		want      uint32
+	}{
+		"vhd epoch": {
+			timestamp: time.Unix(946684800, 0),
+			want:      0,
+		},
+		"after vhd epoch": {
+			timestamp: time.Unix(1700000000, 0),
+			want:      1700000000 - 946684800,
+		},
+		"unix epoch": {
+			timestamp: time.Unix(0, 0),
+			want:      0,
+		},
+		"zero time": {
+			timestamp: time.Time{},
+			want:      0,
+		},
+		"after uint32 range": {
+			timestamp: time.Unix(946684800+math.MaxUint32+1, 0),
+			want:      math.MaxUint32,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, vhdTimestamp(tc.timestamp))
+		})
+	}
+}
+
+func TestVHDReaderSourceDateEpochZero(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	t.Setenv(uploader.SourceDateEpochEnv, "0")
+
+	u := &Uploader{config: config.Config{Name: "image", ImageVersion: "1.2.3"}}
+	rawImage := bytes.Repeat([]byte("image"), 1024)
+	uuid, timestamp, err := u.vhdIdentity()
+	require.NoError(err)
+	out, err := io.ReadAll(newVHDReader(bytes.NewReader(rawImage), uint64(len(rawImage)), uuid, timestamp, "uplo"))
+	require.NoError(err)
+
+	footer := out[len(out)-vhdFixedHeaderSize:]
+	assert.Equal(uint32(0), binary.BigEndian.Uint32(footer[24:28]))
+}
+
+func TestDeterministicVHDUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	uuid := deterministicVHDUUID("image", "1.2.3")
+	assert.Equal(uuid, deterministicVHDUUID("image", "1.2.3"))
+	assert.NotEqual(uuid, deterministicVHDUUID("image", "1.2.4"))
+	assert.NotEqual(uuid, deterministicVHDUUID("image-1.2", ".3"))
+	assert.Equal(byte(0x80), uuid[6]&0xf0, "version 8")
+	assert.Equal(byte(0x80), uuid[8]&0xc0, "RFC 9562 variant")
+}
+
+func TestCalculateCHS(t *testing.T) {
+	testCases := map[string]struct {
+		size          uint64
+		wantCylinders uint16
+		wantHeads     uint8
+		wantSectors   uint8
+	}{
+		"1 MiB": {
+			size:          1 << 20,
+			wantCylinders: 30, wantHeads: 4, wantSectors: 17,
+		},
+		"10 MiB": {
+			size:          10 << 20,
+			wantCylinders: 301, wantHeads: 4, wantSectors: 17,
+		},
+		"100 MiB": {
+			size:          100 << 20,
+			wantCylinders: 1003, wantHeads: 12, wantSectors: 17,
+		},
+		"4 GiB": {
+			size:          4 << 30,
+			wantCylinders: 8322, wantHeads: 16, wantSectors: 63,
+		},
+		"30 GiB": {
+			size:          30 << 30,
+			wantCylinders: 62415, wantHeads: 16, wantSectors: 63,
+		},
+		"40 GiB": {
+			size:          40 << 30,
+			wantCylinders: 20560, wantHeads: 16, wantSectors: 255,
+		},
+		"clamped at 65535*16*255 sectors": {
+			size:          65535 * 16 * 255 * sectorSize,
+			wantCylinders: 65535, wantHeads: 16, wantSectors: 255,
+		},
+		"larger than the clamp": {
+			size:          2 << 40,
+			wantCylinders: 65535, wantHeads: 16, wantSectors: 255,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			cylinders, heads, sectors := calculateCHS(tc.size / sectorSize)
+			assert.Equal(tc.wantCylinders, cylinders)
+			assert.Equal(tc.wantHeads, heads)
+			assert.Equal(tc.wantSectors, sectors)
+		})
+	}
+}
+
+func TestVHDReaderContainerSize(t *testing.T) {
+	sizes := map[string]uint64{
+		"empty":                     0,
+		"single byte":               1,
+		"single sector":             sectorSize,
+		"aligned":                   dataAlignmentBytes,
+		"one byte more than 1 MiB":  dataAlignmentBytes + 1,
+		"unaligned multiple chunks": 3*dataAlignmentBytes - 5,
+	}
+	bufSizes := []int{1, 7, vhdFixedHeaderSize - 1, vhdFixedHeaderSize, 32 * 1024}
+
+	for name, size := range sizes {
+		for _, bufSize := range bufSizes {
+			t.Run(fmt.Sprintf("%s with %d byte reads", name, bufSize), func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				data := bytes.Repeat([]byte{0xab}, int(size))
+				reader := newVHDReader(bytes.NewReader(data), size, [16]byte{1}, time.Unix(1700000000, 0), "uplo")
+				out := readWithBuffer(t, reader, bufSize)
+
+				require.Equal(reader.ContainerSize(), uint64(len(out)))
+				assert.EqualValues(vhdFixedHeaderSize, reader.ContainerSize()%dataAlignmentBytes)
+				assert.Equal(data, out[:size])
+				padding := out[size : len(out)-vhdFixedHeaderSize]
+				assert.Equal(make([]byte, len(padding)), padding)
+
+				footer := out[len(out)-vhdFixedHeaderSize:]
+				assert.Equal(reader.footer[:], footer)
+				assert.Equal("conectix", string(footer[:8]))
+				assert.Equal(reader.ContainerSize()-vhdFixedHeaderSize, binary.BigEndian.Uint64(footer[48:56]))
+				assertFooterChecksum(t, footer)
+			})
+		}
+	}
+}
+
+func TestVHDReaderPayloadSizeMismatch(t *testing.T) {
+	t.Run("short data", func(t *testing.T) {
+		reader := newVHDReader(bytes.NewReader(make([]byte, 100)), 200, [16]byte{}, time.Time{}, "uplo")
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+	t.Run("long data", func(t *testing.T) {
+		assert := assert.New(t)
+
+		data := bytes.Repeat([]byte{0xab}, 300)
+		reader := newVHDReader(bytes.NewReader(data), 200, [16]byte{}, time.Time{}, "uplo")
+		out, err := io.ReadAll(reader)
+		assert.NoError(err)
+		assert.Equal(reader.ContainerSize(), uint64(len(out)))
+		assert.Equal(data[:200], out[:200])
+		assert.Zero(out[200])
+	})
+}
+
+func TestWriteVHD(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := bytes.Repeat([]byte("image"), 1000)
+	var out bytes.Buffer
+	require.NoError(WriteVHD(&out, bytes.NewReader(data), uint64(len(data))))
+
+	require.Equal(dataAlignmentBytes+vhdFixedHeaderSize, out.Len())
+	assert.Equal(data, out.Bytes()[:len(data)])
+	footer := out.Bytes()[dataAlignmentBytes:]
+	assert.Equal("conectix", string(footer[:8]))
+	assert.Equal(defaultVHDCreatorApp, string(footer[28:32]))
+	assert.Equal(make([]byte, 16), footer[68:84])
+	assertFooterChecksum(t, footer)
+
+	assert.ErrorIs(WriteVHD(io.Discard, bytes.NewReader(data), uint64(len(data)+1)), io.ErrUnexpectedEOF)
+}
+
+// readWithBuffer reads r to the end, using a buffer of bufSize bytes for every read.
+func readWithBuffer(t *testing.T, r io.Reader, bufSize int) []byte {
+	t.Helper()
+	var out []byte
+	buf := make([]byte, bufSize)
+	for {
+		n, err := r.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out
+		}
+		require.NoError(t, err)
+	}
+}
+
+// assertFooterChecksum checks that the checksum of the footer is the one's complement
+// of the sum of all its bytes, excluding the checksum itself.
+func assertFooterChecksum(t *testing.T, footer []byte) {
+	t.Helper()
+	var sum uint32
+	for i, b := range footer {
+		if i >= 64 && i < 68 {
+			continue
+		}
+		sum += uint32(b)
+	}
+	assert.Equal(t, ^sum, binary.BigEndian.Uint32(footer[64:68]))
+}
+
+func TestVHDIdentity(t *testing.T) {
+	configuredUUID := [16]byte{0x01, 0x23, 0xab, 0xcd, 0x45, 0x67, 0x89, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+	testCases := map[string]struct {
+		sourceDateEpoch     string
+		uuid                string
+		useCurrentTimestamp bool
+		wantUUID            [16]byte
+		wantTimestamp       time.Time
+		wantCurrentTime     bool
+		wantErr             bool
+	}{
+		"default": {},
+		"source date epoch": {
+			sourceDateEpoch: "1700000000",
+			wantUUID:        deterministicVHDUUID("image", "1.2.3"),
+			wantTimestamp:   time.Unix(1700000000, 0),
+		},
+		"configured uuid": {
+			uuid:     "0123abcd-4567-89ef-0123-456789abcdef",
+			wantUUID: configuredUUID,
+		},
+		"configured uuid overrides source date epoch": {
+			sourceDateEpoch: "1700000000",
+			uuid:            "0123abcd456789ef0123456789ABCDEF",
+			wantUUID:        configuredUUID,
+			wantTimestamp:   time.Unix(1700000000, 0),
+		},
+		"current timestamp": {
+			useCurrentTimestamp: true,
+			wantCurrentTime:     true,
+		},
+		"source date epoch overrides current timestamp": {
+			sourceDateEpoch:     "1700000000",
+			useCurrentTimestamp: true,
+			wantUUID:            deterministicVHDUUID("image", "1.2.3"),
+			wantTimestamp:       time.Unix(1700000000, 0),
+		},
+		"invalid uuid": {
+			uuid:    "0123abcd-4567",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			t.Setenv(uploader.SourceDateEpochEnv, tc.sourceDateEpoch)
+
+			u := &Uploader{config: config.Config{
+				Name:         "image",
+				ImageVersion: "1.2.3",
+				Azure: config.AzureConfig{
+					VHDUUID:                tc.uuid,
+					VHDUseCurrentTimestamp: config.Some(tc.useCurrentTimestamp),
+				},
+			}}
+			before := time.Now()
+			uuid, timestamp, err := u.vhdIdentity()
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantUUID, uuid)
+			if tc.wantCurrentTime {
+				assert.WithinRange(timestamp, before, time.Now())
+			} else {
+				assert.True(tc.wantTimestamp.Equal(timestamp), "timestamp %v", timestamp)
+			}
+		})
+	}
+}
@@ -23,8 +23,14 @@ func newRootCmd() *cobra.Command {
 	}
 	cmd.SetOut(os.Stdout)
 	cmd.InitDefaultVersionFlag()
+	cmd.AddCommand(newInitCmd())
 	cmd.AddCommand(newUploadCmd())
+	cmd.AddCommand(newDeleteCmd())
+	cmd.AddCommand(newListCmd())
 	cmd.AddCommand(newMeasurementsCmd())
+	cmd.AddCommand(newPreflightCmd())
+	cmd.AddCommand(newValidateCmd())
+	cmd.AddCommand(newPruneCmd())
 
 	return cmd
 }
@@ -8,26 +8,41 @@ package config
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"html/template"
+	"os"
 	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"text/template"
+	"unicode/utf8"
 
 	uplositemplate "github.com/edgelesssys/uplosi/template"
 
 	"dario.cat/mergo"
+	"golang.org/x/sync/errgroup"
 )
 
 var defaultConfig = Config{
-	ImageVersion: "0.0.0",
+	ImageVersion:   "0.0.0",
+	APIMaxAttempts: Some(5),
 	AWS: AWSConfig{
 		ReplicationRegions: []string{},
 		AMIName:            "{{.Name}}-{{.Version}}",
 		AMIDescription:     "{{.Name}}-{{.Version}}",
 		BlobName:           "{{.Name}}-{{.Version}}.raw",
 		SnapshotName:       "{{.Name}}-{{.Version}}",
+		Architecture:       "x86_64",
+		RootDeviceName:     "/dev/xvda",
+		DataDeviceName:     "/dev/xvdb",
+		DataBlobName:       "{{.Name}}-{{.Version}}-data.raw",
+		DataSnapshotName:   "{{.Name}}-{{.Version}}-data",
+		VirtualizationType: "hvm",
+		SriovNetSupport:    Some(false),
 		Publish:            Some(false),
 	},
 	Azure: AzureConfig{
@@ -38,57 +53,112 @@ var defaultConfig = Config{
 		Offer:               "Linux",
 		SKU:                 "{{.Name}}-{{.VersionMajor}}",
 		Publisher:           "Contoso",
+		VHDCreatorApp:       "uplo",
+		SkipZeroPages:       Some(true),
+		ReplicaCount:        1,
+		HyperVGeneration:    "V2",
+		OSType:              "Linux",
 	},
 	GCP: GCPConfig{
-		ImageName:   "{{.Name}}-{{replaceAll .Version \".\" \"-\"}}",
-		ImageFamily: "{{.Name}}",
-		BlobName:    "{{.Name}}-{{replaceAll .Version \".\" \"-\"}}.tar.gz",
+		ImageName:              "{{.Name}}-{{replaceAll .Version \".\" \"-\"}}",
+		ImageFamily:            "{{.Name}}",
+		BlobName:               "{{.Name}}-{{replaceAll .Version \".\" \"-\"}}.tar.gz",
+		DeprecateImagesState:   "DEPRECATED",
+		PublicAccessPrevention: "enforced",
+		Architecture:           "x86_64",
 	},
 	OpenStack: OpenStackConfig{
-		ImageName:  "{{.Name}}-{{.Version}}",
-		Visibility: "public",
-		Protected:  Some(false),
+		ImageName:       "{{.Name}}-{{.Version}}",
+		Visibility:      "public",
+		Protected:       Some(false),
+		UploadRetries:   Some(3),
+		ImportMethod:    "direct",
+		ContainerFormat: "bare",
 	},
 }
 
 type Config struct {
-	Provider         string          `toml:"provider"`
-	ImageVersion     string          `toml:"imageVersion"`
-	ImageVersionFile string          `toml:"imageVersionFile"`
-	Name             string          `toml:"name"`
-	AWS              AWSConfig       `toml:"aws,omitempty"`
-	Azure            AzureConfig     `toml:"azure,omitempty"`
-	GCP              GCPConfig       `toml:"gcp,omitempty"`
-	OpenStack        OpenStackConfig `toml:"openstack,omitempty"`
+	Provider                string          `toml:"provider"`
+	ImageVersion            string          `toml:"imageVersion"`
+	ImageVersionFile        string          `toml:"imageVersionFile"`
+	AllowPrerelease         Option[bool]    `toml:"allowPrerelease,omitempty"`
+	Name                    string          `toml:"name"`
+	CommitHash              string          `toml:"commitHash,omitempty"`
+	MaxImageSizeGiB         int             `toml:"maxImageSizeGiB,omitempty"`
+	APIMaxAttempts          Option[int]     `toml:"apiMaxAttempts,omitempty"`
+	PollIntervalSeconds     Option[int]     `toml:"pollIntervalSeconds,omitempty"`
+	OperationTimeoutSeconds Option[int]     `toml:"operationTimeoutSeconds,omitempty"`
+	VerifyUpload            Option[bool]    `toml:"verifyUpload,omitempty"`
+	KeepTempArtifacts       Option[bool]    `toml:"keepTempArtifacts,omitempty"`
+	NamePrefix              string          `toml:"namePrefix,omitempty"`
+	NameSuffix              string          `toml:"nameSuffix,omitempty"`
+	AWS                     AWSConfig       `toml:"aws,omitempty"`
+	Azure                   AzureConfig     `toml:"azure,omitempty"`
+	GCP                     GCPConfig       `toml:"gcp,omitempty"`
+	OpenStack               OpenStackConfig `toml:"openstack,omitempty"`
 }
 
 func (c *Config) Merge(other Config) error {
 	return mergo.Merge(c, other, mergo.WithOverride, mergo.WithTransformers(&OptionTransformer{}))
 }
 
+// overrideProvider sets the provider and ensures the config block for it was populated.
+func (c *Config) overrideProvider(provider string) error {
+	c.Provider = provider
+	var providerConfig any
+	switch strings.ToLower(provider) {
+	case "aws":
+		providerConfig = c.AWS
+	case "azure":
+		providerConfig = c.Azure
+	case "gcp":
+		providerConfig = c.GCP
+	case "openstack":
+		providerConfig = c.OpenStack
+	default:
+		// unknown providers are rejected by validation
+		return nil
+	}
+	if reflect.ValueOf(providerConfig).IsZero() {
+		return fmt.Errorf("provider overridden to %s, but config for %s is empty", provider, provider)
+	}
+	return nil
+}
+
 func (c *Config) SetDefaults() error {
 	return mergo.Merge(c, defaultConfig, mergo.WithTransformers(&OptionTransformer{}))
 }
 
 // Render renders the config by evaluating the version file and all template strings.
-func (c *Config) Render(fileLookup func(name string) ([]byte, error)) error {
-	if err := c.renderVersion(fileLookup); err != nil {
+// The image version file is read with versionFileLookup, data files like the UEFI
+// var store are read with readFile.
+func (c *Config) Render(versionFileLookup, readFile func(name string) ([]byte, error)) error {
+	if err := c.renderVersion(versionFileLookup); err != nil {
+		return err
+	}
+	if err := c.renderUEFIVarStore(readFile); err != nil {
+		return err
+	}
+	if err := c.renderVMGS(readFile); err != nil {
+		return err
+	}
+	if err := c.renderSecureBootKeys(readFile); err != nil {
 		return err
 	}
 
-	if err := c.renderTemplates(c); err != nil {
+	if err := c.renderTemplates("", c); err != nil {
 		return err
 	}
-	if err := c.renderTemplates(&c.AWS); err != nil {
+	if err := c.renderTemplates("aws", &c.AWS); err != nil {
 		return err
 	}
-	if err := c.renderTemplates(&c.Azure); err != nil {
+	if err := c.renderTemplates("azure", &c.Azure); err != nil {
 		return err
 	}
-	if err := c.renderTemplates(&c.GCP); err != nil {
+	if err := c.renderTemplates("gcp", &c.GCP); err != nil {
 		return err
 	}
-	if err := c.renderTemplates(&c.OpenStack); err != nil {
+	if err := c.renderTemplates("openstack", &c.OpenStack); err != nil {
 		return err
 	}
 
@@ -113,117 +183,452 @@ func (c *Config) renderVersion(fileLookup func(name string) ([]byte, error)) err
 	return nil
 }
 
-func (c *Config) renderTemplates(configStruct any) error {
+func (c *Config) renderUEFIVarStore(readFile func(name string) ([]byte, error)) error {
+	if len(c.AWS.UEFIVarStoreFile) == 0 {
+		return nil
+	}
+	data, err := readFile(c.AWS.UEFIVarStoreFile)
+	if err != nil {
+		return err
+	}
+	uefiData := strings.TrimSpace(string(data))
+	if _, err := base64.StdEncoding.DecodeString(uefiData); err != nil {
+		return fmt.Errorf("UEFI var store file %q must contain base64 encoded data: %w", c.AWS.UEFIVarStoreFile, err)
+	}
+	c.AWS.UEFIData = uefiData
+	return nil
+}
+
+func (c *Config) renderVMGS(readFile func(name string) ([]byte, error)) error {
+	if len(c.Azure.VMGSFile) == 0 {
+		return nil
+	}
+	vmgs, err := readFile(c.Azure.VMGSFile)
+	if err != nil {
+		return err
+	}
+	if len(vmgs) == 0 {
+		return fmt.Errorf("VMGS file %q must not be empty", c.Azure.VMGSFile)
+	}
+	c.Azure.VMGS = vmgs
+	return nil
+}
+
+func (c *Config) renderSecureBootKeys(readFile func(name string) ([]byte, error)) error {
+	secureBoot := &c.GCP.SecureBoot
+	readKey := func(name string) ([]byte, error) {
+		key, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("secure boot key file %q must not be empty", name)
+		}
+		return key, nil
+	}
+	readKeys := func(names []string) ([][]byte, error) {
+		var keys [][]byte
+		for _, name := range names {
+			key, err := readKey(name)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	if len(secureBoot.PKFile) > 0 {
+		pk, err := readKey(secureBoot.PKFile)
+		if err != nil {
+			return err
+		}
+		secureBoot.PK = pk
+	}
+	var err error
+	if secureBoot.KEKs, err = readKeys(secureBoot.KEKFiles); err != nil {
+		return err
+	}
+	if secureBoot.DBs, err = readKeys(secureBoot.DBFiles); err != nil {
+		return err
+	}
+	if secureBoot.DBXs, err = readKeys(secureBoot.DBXFiles); err != nil {
+		return err
+	}
+	return nil
+}
+
+// renderTemplates renders the template fields of configStruct, which is the config of provider
+// or, if provider is empty, the provider independent config.
+func (c *Config) renderTemplates(provider string, configStruct any) error {
 	numFields := reflect.TypeOf(configStruct).Elem().NumField()
 	for i := 0; i < numFields; i++ {
 		typeField := reflect.TypeOf(configStruct).Elem().Field(i)
 		name := typeField.Name
 		tag := typeField.Tag
 		field := reflect.ValueOf(configStruct).Elem().Field(i)
+		text := field.String()
 		if err := c.renderFieldTemplate(name, field, tag); err != nil {
 			return err
 		}
+		if rule, ok := renderedNameRules[provider][name]; ok && provider == c.Provider {
+			if err := rule.check(provider, tag, text, field.String()); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (c *Config) fieldTemplateData() fieldTemplateData {
-	var VersionMajor, VersionMinor, VersionPatch string
-	versionParts := strings.Split(c.ImageVersion, ".")
-	if len(versionParts) == 3 {
-		VersionMajor = versionParts[0]
-		VersionMinor = versionParts[1]
-		VersionPatch = versionParts[2]
+// renderedNameRule limits the length and characters of a name of a provider.
+// The validator enforces the same rules, checking them right after rendering
+// points to the template that produced the invalid name.
+type renderedNameRule struct {
+	maxLength int
+	// charset matches the allowed names. If nil, all characters are allowed.
+	charset *regexp.Regexp
+	// allowed describes the characters matched by charset.
+	allowed string
+}
+
+// renderedNameRules are the rules of all names that get the name prefix and suffix,
+// keyed by provider and field name.
+var renderedNameRules = map[string]map[string]renderedNameRule{
+	"aws": {
+		"AMIName": {
+			maxLength: 128,
+			charset:   regexp.MustCompile(`^[a-zA-Z0-9().\-/_]*$`),
+			allowed:   "letters, numbers, '(', ')', '.', '-', '/' and '_'",
+		},
+		"Bucket": {
+			maxLength: 63,
+			charset:   regexp.MustCompile(`^[a-z0-9.\-]*$`),
+			allowed:   "lowercase letters, numbers, dots and hyphens",
+		},
+		// Snapshot names are Name tags, which allow any characters.
+		"SnapshotName":     {maxLength: 256},
+		"DataSnapshotName": {maxLength: 256},
+	},
+	"azure": {
+		"ResourceGroup": {
+			maxLength: 90,
+			charset:   regexp.MustCompile(`^[\p{L}\p{N}_().\-]*$`),
+			allowed:   "letters, numbers, underscores, parentheses, hyphens and periods",
+		},
+		"SharedImageGallery": {
+			maxLength: 80,
+			charset:   regexp.MustCompile(`^[a-zA-Z0-9_.]*$`),
+			allowed:   "alphanumerics, underscores and periods",
+		},
+		"ImageDefinitionName": {
+			maxLength: 80,
+			charset:   regexp.MustCompile(`^[a-zA-Z0-9_\-.]*$`),
+			allowed:   "alphanumerics, underscores, hyphens and periods",
+		},
+		"DiskName": {
+			maxLength: 80,
+			charset:   regexp.MustCompile(`^[a-zA-Z0-9_\-.]*$`),
+			allowed:   "alphanumerics, underscores, hyphens and periods",
+		},
+	},
+	"gcp": {
+		"ImageName": {
+			maxLength: 63,
+			charset:   regexp.MustCompile(`^[a-z0-9\-]*$`),
+			allowed:   "lowercase letters, numbers and hyphens",
+		},
+		"ImageFamily": {
+			maxLength: 63,
+			charset:   regexp.MustCompile(`^[a-z0-9\-]*$`),
+			allowed:   "lowercase letters, numbers and hyphens",
+		},
+		"Bucket": {
+			maxLength: 63,
+			charset:   regexp.MustCompile(`^[a-z0-9\-_.]*$`),
+			allowed:   "lowercase letters, numbers, hyphens, underscores and periods",
+		},
+	},
+	"openstack": {
+		"ImageName": {maxLength: 255},
+	},
+}
+
+// check returns an error if the name rendered from text violates the rule.
+func (r renderedNameRule) check(provider string, tag reflect.StructTag, text, rendered string) error {
+	key, _, _ := strings.Cut(tag.Get("toml"), ",")
+	if length := utf8.RuneCountInString(rendered); length > r.maxLength {
+		return fmt.Errorf("field %s rendered from template %q to %q, which has %d characters, but at most %d are allowed for provider %s; shorten it, e.g. with trunc",
+			key, text, rendered, length, r.maxLength, provider)
 	}
-	return fieldTemplateData{
-		Name:         c.Name,
-		Version:      c.ImageVersion,
-		VersionMajor: VersionMajor,
-		VersionMinor: VersionMinor,
-		VersionPatch: VersionPatch,
+	if r.charset != nil && !r.charset.MatchString(rendered) {
+		return fmt.Errorf("field %s rendered from template %q to %q, but only %s are allowed for provider %s; replace other characters, e.g. with regexReplaceAll",
+			key, text, rendered, r.allowed, provider)
 	}
+	return nil
+}
+
+// shortCommitLength is the length of the abbreviated commit hash, like git's default.
+const shortCommitLength = 7
+
+func (c *Config) fieldTemplateData() fieldTemplateData {
+	data := fieldTemplateData{
+		Name:    c.Name,
+		Version: c.ImageVersion,
+		Commit:  c.CommitHash,
+	}
+	if data.Commit == "" {
+		data.Commit = os.Getenv("GIT_COMMIT")
+	}
+	data.ShortCommit = data.Commit
+	if len(data.ShortCommit) > shortCommitLength {
+		data.ShortCommit = data.ShortCommit[:shortCommitLength]
+	}
+	switch c.Provider {
+	case "aws":
+		data.Architecture = c.AWS.Architecture
+	case "gcp":
+		data.Architecture = c.GCP.Architecture
+	case "openstack":
+		data.Architecture = c.OpenStack.Architecture
+	}
+	if ver, err := ParseVersion(c.ImageVersion); err == nil {
+		data.VersionMajor = strconv.Itoa(ver.Major)
+		data.VersionMinor = strconv.Itoa(ver.Minor)
+		data.VersionPatch = strconv.Itoa(ver.Patch)
+		data.VersionPrerelease = ver.Prerelease
+		data.VersionBuild = ver.Build
+	}
+	return data
 }
 
 func (c *Config) renderFieldTemplate(name string, field reflect.Value, tag reflect.StructTag) error {
 	if tag.Get("template") != "true" {
 		return nil
 	}
-	if field.Kind() != reflect.String || !field.CanSet() {
-		return fmt.Errorf("field %s must be settable a string", name)
+	if !field.CanSet() {
+		return fmt.Errorf("field %s must be settable", name)
 	}
-	tmpl, err := template.New(name).Funcs(uplositemplate.DefaultFuncMap()).Parse(field.String())
+
+	switch {
+	case field.Kind() == reflect.String:
+		rendered, err := c.renderTemplate(name, field.String())
+		if err != nil {
+			return err
+		}
+		if tag.Get("name") == "true" && rendered != "" {
+			rendered = c.NamePrefix + rendered + c.NameSuffix
+		}
+		field.SetString(rendered)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		if field.IsNil() {
+			return nil
+		}
+		// Render into a new slice, the original may be shared with other variants.
+		rendered := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+		for i := 0; i < field.Len(); i++ {
+			value, err := c.renderTemplate(fmt.Sprintf("%s[%d]", name, i), field.Index(i).String())
+			if err != nil {
+				return err
+			}
+			rendered.Index(i).SetString(value)
+		}
+		field.Set(rendered)
+	case field.Kind() == reflect.Map && field.Type().Key().Kind() == reflect.String && field.Type().Elem().Kind() == reflect.String:
+		if field.IsNil() {
+			return nil
+		}
+		// Render into a new map, the original may be shared with other variants.
+		rendered := reflect.MakeMapWithSize(field.Type(), field.Len())
+		iter := field.MapRange()
+		for iter.Next() {
+			value, err := c.renderTemplate(fmt.Sprintf("%s[%s]", name, iter.Key().String()), iter.Value().String())
+			if err != nil {
+				return err
+			}
+			rendered.SetMapIndex(iter.Key(), reflect.ValueOf(value).Convert(field.Type().Elem()))
+		}
+		field.Set(rendered)
+	default:
+		return fmt.Errorf("field %s must be a string, a string slice or a string map", name)
+	}
+	return nil
+}
+
+func (c *Config) renderTemplate(name, text string) (string, error) {
+	tmpl, err := template.New(name).Funcs(uplositemplate.DefaultFuncMap()).Parse(text)
 	if err != nil {
-		return err
+		return "", err
 	}
-	renderedField := new(strings.Builder)
-	if err := tmpl.Execute(renderedField, c.fieldTemplateData()); err != nil {
-		return err
+	rendered := new(strings.Builder)
+	if err := tmpl.Execute(rendered, c.fieldTemplateData()); err != nil {
+		return "", err
 	}
-	field.SetString(renderedField.String())
-	return nil
+	return rendered.String(), nil
 }
 
 type fieldTemplateData struct {
-	Name         string
-	Version      string
-	VersionMajor string
-	VersionMinor string
-	VersionPatch string
+	Name              string
+	Version           string
+	VersionMajor      string
+	VersionMinor      string
+	VersionPatch      string
+	VersionPrerelease string
+	VersionBuild      string
+	Architecture      string
+	Commit            string
+	ShortCommit       string
 }
 
 type AWSConfig struct {
-	Region                   string       `toml:"region,omitempty"`
-	ReplicationRegions       []string     `toml:"replicationRegions,omitempty"`
-	AMIName                  string       `toml:"amiName,omitempty" template:"true"`
-	AMIDescription           string       `toml:"amiDescription,omitempty" template:"true"`
-	Bucket                   string       `toml:"bucket,omitempty" template:"true"`
-	BucketLocationConstraint string       `toml:"bucketLocationConstraint,omitempty" template:"false"`
-	BlobName                 string       `toml:"blobName,omitempty" template:"true"`
-	SnapshotName             string       `toml:"snapshotName,omitempty" template:"true"`
-	Publish                  Option[bool] `toml:"publish,omitempty"`
+	Region                    string            `toml:"region,omitempty" template:"true"`
+	ReplicationRegions        []string          `toml:"replicationRegions,omitempty" template:"true"`
+	MaxConcurrentReplications int               `toml:"maxConcurrentReplications,omitempty"`
+	AMIName                   string            `toml:"amiName,omitempty" template:"true" name:"true"`
+	AMIDescription            string            `toml:"amiDescription,omitempty" template:"true"`
+	Bucket                    string            `toml:"bucket,omitempty" template:"true" name:"true"`
+	BucketLocationConstraint  string            `toml:"bucketLocationConstraint,omitempty" template:"false"`
+	BucketTags                map[string]string `toml:"bucketTags,omitempty"`
+	BucketBlockPublicAccess   Option[bool]      `toml:"bucketBlockPublicAccess,omitempty"`
+	BucketEncryptionKMSKeyID  string            `toml:"bucketEncryptionKMSKeyID,omitempty"`
+	Tags                      map[string]string `toml:"tags,omitempty"`
+	BlobName                  string            `toml:"blobName,omitempty" template:"true"`
+	SnapshotName              string            `toml:"snapshotName,omitempty" template:"true" name:"true"`
+	Architecture              string            `toml:"architecture,omitempty"`
+	RootDeviceName            string            `toml:"rootDeviceName,omitempty"`
+	DataImage                 string            `toml:"dataImage,omitempty"`
+	DataDeviceName            string            `toml:"dataDeviceName,omitempty"`
+	DataBlobName              string            `toml:"dataBlobName,omitempty" template:"true"`
+	DataSnapshotName          string            `toml:"dataSnapshotName,omitempty" template:"true" name:"true"`
+	VirtualizationType        string            `toml:"virtualizationType,omitempty"`
+	// EnaSupport and TPMSupport have no default, as it depends on VirtualizationType:
+	// unless set, both are enabled for hvm and disabled for paravirtual AMIs.
+	EnaSupport       Option[bool] `toml:"enaSupport,omitempty"`
+	SriovNetSupport  Option[bool] `toml:"sriovNetSupport,omitempty"`
+	TPMSupport       Option[bool] `toml:"tpmSupport,omitempty"`
+	UEFIVarStoreFile string       `toml:"uefiVarStoreFile,omitempty"`
+	// UEFIData is the base64 encoded UEFI variable store read from UEFIVarStoreFile during rendering.
+	UEFIData            string       `toml:"-"`
+	Publish             Option[bool] `toml:"publish,omitempty"`
+	ShareWithAccountIDs []string     `toml:"shareWithAccountIDs,omitempty"`
+	EBSVolumeType       string       `toml:"ebsVolumeType,omitempty"`
+	Encrypted           Option[bool] `toml:"encrypted,omitempty"`
+	KMSKeyID            string       `toml:"kmsKeyID,omitempty"`
+	// SnapshotImportTimeoutMinutes overrides the operation timeout for snapshot imports.
+	SnapshotImportTimeoutMinutes Option[int] `toml:"snapshotImportTimeoutMinutes,omitempty"`
+	EndpointURL                  string      `toml:"endpointURL,omitempty"`
+	Profile                      string      `toml:"profile,omitempty"`
 }
 
 type AzureConfig struct {
-	SubscriptionID       string   `toml:"subscriptionID,omitempty"`
-	Location             string   `toml:"location,omitempty"`
-	ReplicationRegions   []string `toml:"replicationRegions,omitempty"`
-	ResourceGroup        string   `toml:"resourceGroup,omitempty" template:"true"`
-	AttestationVariant   string   `toml:"attestationVariant,omitempty" template:"true"`
-	SharedImageGallery   string   `toml:"sharedImageGallery,omitempty" template:"true"`
-	SharingProfile       string   `toml:"sharingProfile,omitempty" template:"true"`
-	SharingNamePrefix    string   `toml:"sharingNamePrefix,omitempty" template:"true"`
-	ImageDefinitionName  string   `toml:"imageDefinitionName,omitempty" template:"true"`
-	Offer                string   `toml:"offer,omitempty" template:"true"`
-	SKU                  string   `toml:"sku,omitempty" template:"true"`
-	Publisher            string   `toml:"publisher,omitempty" template:"true"`
-	DiskName             string   `toml:"diskName,omitempty" template:"true"`
-	AdditionalSignatures []string `toml:"additionalSignatures,omitempty"`
+	SubscriptionID          string         `toml:"subscriptionID,omitempty"`
+	TenantID                string         `toml:"tenantID,omitempty"`
+	ClientID                string         `toml:"clientID,omitempty"`
+	Location                string         `toml:"location,omitempty" template:"true"`
+	ReplicationRegions      []string       `toml:"replicationRegions,omitempty" template:"true"`
+	ReplicaCount            int            `toml:"replicaCount,omitempty"`
+	ReplicaCounts           map[string]int `toml:"replicaCounts,omitempty"`
+	ResourceGroup           string         `toml:"resourceGroup,omitempty" template:"true" name:"true"`
+	AttestationVariant      string         `toml:"attestationVariant,omitempty" template:"true"`
+	SharedImageGallery      string         `toml:"sharedImageGallery,omitempty" template:"true" name:"true"`
+	SharingProfile          string         `toml:"sharingProfile,omitempty" template:"true"`
+	SharingNamePrefix       string         `toml:"sharingNamePrefix,omitempty" template:"true"`
+	PublicNamePrefixes      []string       `toml:"publicNamePrefixes,omitempty"`
+	ForceSharingUpdate      Option[bool]   `toml:"forceSharingUpdate,omitempty"`
+	ShareWith               []string       `toml:"shareWith,omitempty"`
+	ImageDefinitionName     string         `toml:"imageDefinitionName,omitempty" template:"true" name:"true"`
+	Offer                   string         `toml:"offer,omitempty" template:"true"`
+	SKU                     string         `toml:"sku,omitempty" template:"true"`
+	Publisher               string         `toml:"publisher,omitempty" template:"true"`
+	DiskName                string         `toml:"diskName,omitempty" template:"true" name:"true"`
+	AdditionalSignatures    []string       `toml:"additionalSignatures,omitempty"`
+	VHDCreatorApp           string         `toml:"vhdCreatorApp,omitempty"`
+	VHDUUID                 string         `toml:"vhdUUID,omitempty"`
+	VHDUseCurrentTimestamp  Option[bool]   `toml:"vhdUseCurrentTimestamp,omitempty"`
+	OSDiskSizeGB            int            `toml:"osDiskSizeGB,omitempty"`
+	ResumeUploads           Option[bool]   `toml:"resumeUploads,omitempty"`
+	SkipZeroPages           Option[bool]   `toml:"skipZeroPages,omitempty"`
+	DisallowedDiskTypes     []string       `toml:"disallowedDiskTypes,omitempty"`
+	DefinitionEndOfLifeDate string         `toml:"definitionEndOfLifeDate,omitempty"`
+	HyperVGeneration        string         `toml:"hyperVGeneration,omitempty"`
+	OSType                  string         `toml:"osType,omitempty"`
+	VMGSFile                string         `toml:"vmgsFile,omitempty"`
+	// VMGS is the VM guest state read from VMGSFile during rendering.
+	VMGS []byte `toml:"-" json:"-"`
 }
 
 type GCPConfig struct {
-	Project     string `toml:"project,omitempty"`
-	Location    string `toml:"location,omitempty"`
-	ImageName   string `toml:"imageName,omitempty" template:"true"`
-	ImageFamily string `toml:"imageFamily,omitempty" template:"true"`
-	Bucket      string `toml:"bucket,omitempty" template:"true"`
-	BlobName    string `toml:"blobName,omitempty" template:"true"`
+	Project                string              `toml:"project,omitempty"`
+	CredentialsFile        string              `toml:"credentialsFile,omitempty"`
+	Location               string              `toml:"location,omitempty"`
+	ImageName              string              `toml:"imageName,omitempty" template:"true" name:"true"`
+	ImageFamily            string              `toml:"imageFamily,omitempty" template:"true" name:"true"`
+	Architecture           string              `toml:"architecture,omitempty"`
+	StorageLocations       []string            `toml:"storageLocations,omitempty"`
+	Labels                 map[string]string   `toml:"labels,omitempty"`
+	Bucket                 string              `toml:"bucket,omitempty" template:"true" name:"true"`
+	BucketLabels           map[string]string   `toml:"bucketLabels,omitempty"`
+	PublicAccessPrevention string              `toml:"publicAccessPrevention,omitempty"`
+	BlobName               string              `toml:"blobName,omitempty" template:"true"`
+	SourceImage            string              `toml:"sourceImage,omitempty" template:"true"`
+	SourceDisk             string              `toml:"sourceDisk,omitempty" template:"true"`
+	State                  string              `toml:"state,omitempty"`
+	Replacement            string              `toml:"replacement,omitempty" template:"true"`
+	DeprecateImages        []string            `toml:"deprecateImages,omitempty"`
+	DeprecateImagesState   string              `toml:"deprecateImagesState,omitempty"`
+	GuestOSFeatures        []string            `toml:"guestOSFeatures,omitempty"`
+	ImageUsers             []string            `toml:"imageUsers,omitempty"`
+	Public                 Option[bool]        `toml:"public,omitempty"`
+	SecureBoot             GCPSecureBootConfig `toml:"secureBoot,omitempty"`
+}
+
+// GCPSecureBootConfig configures Shielded VM secure boot of a GCP image.
+type GCPSecureBootConfig struct {
+	Enabled  Option[bool] `toml:"enabled,omitempty"`
+	PKFile   string       `toml:"pkFile,omitempty"`
+	KEKFiles []string     `toml:"kekFiles,omitempty"`
+	DBFiles  []string     `toml:"dbFiles,omitempty"`
+	DBXFiles []string     `toml:"dbxFiles,omitempty"`
+	// PK, KEKs, DBs and DBXs are the contents of the key files read during rendering.
+	PK   []byte   `toml:"-" json:"-"`
+	KEKs [][]byte `toml:"-" json:"-"`
+	DBs  [][]byte `toml:"-" json:"-"`
+	DBXs [][]byte `toml:"-" json:"-"`
 }
 
 type OpenStackConfig struct {
-	Cloud      string            `toml:"cloud"`
-	ImageName  string            `toml:"imageName,omitempty" template:"true"`
-	Visibility string            `toml:"visibility,omitempty"`
-	Hidden     Option[bool]      `toml:"hidden,omitempty"`
-	Tags       []string          `toml:"tags,omitempty"`
-	MinDiskGB  int               `toml:"minDiskGB,omitempty"`
-	MinRamMB   int               `toml:"minRamMB,omitempty"`
-	Protected  Option[bool]      `toml:"protected,omitempty"`
-	Properties map[string]string `toml:"properties"`
+	Cloud            string            `toml:"cloud"`
+	ImageName        string            `toml:"imageName,omitempty" template:"true" name:"true"`
+	Visibility       string            `toml:"visibility,omitempty"`
+	Hidden           Option[bool]      `toml:"hidden,omitempty"`
+	Tags             []string          `toml:"tags,omitempty" template:"true"`
+	MinDiskGB        int               `toml:"minDiskGB,omitempty"`
+	MinRamMB         int               `toml:"minRamMB,omitempty"`
+	Protected        Option[bool]      `toml:"protected,omitempty"`
+	DiskFormat       string            `toml:"diskFormat,omitempty"`
+	ContainerFormat  string            `toml:"containerFormat,omitempty"`
+	ConvertToQCOW2   Option[bool]      `toml:"convertToQCOW2,omitempty"`
+	Architecture     string            `toml:"architecture,omitempty"`
+	FirmwareType     string            `toml:"firmwareType,omitempty"`
+	HypervisorType   string            `toml:"hypervisorType,omitempty"`
+	HashAlgorithm    string            `toml:"hashAlgorithm,omitempty"`
+	DeleteDuplicates Option[bool]      `toml:"deleteDuplicates,omitempty"`
+	UploadRetries    Option[int]       `toml:"uploadRetries,omitempty"`
+	ImportMethod     string            `toml:"importMethod,omitempty"`
+	SourceURL        string            `toml:"sourceURL,omitempty" template:"true"`
+	Properties       map[string]string `toml:"properties" template:"true"`
 }
 
 type ConfigFile struct {
 	Base     Config            `toml:"base"`
 	Variants map[string]Config `toml:"variant"`
+
+	providerOverride string
+}
+
+// SetProviderOverride replaces the provider of every rendered variant.
+// The override is applied after merging and before defaults and validation.
+func (c *ConfigFile) SetProviderOverride(provider string) {
+	c.providerOverride = provider
 }
 
 func (c *ConfigFile) Merge(other ConfigFile) error {
@@ -242,11 +647,23 @@ func (c *ConfigFile) Merge(other ConfigFile) error {
 		if err := dst.Merge(v); err != nil {
 			return err
 		}
+		c.Variants[k] = dst
 	}
 	return nil
 }
 
+// RenderedVariant returns the config of the named variant merged onto the base config,
+// with defaults set and templates rendered. Errors name the variant, validation
+// errors are returned as *FieldError with the Variant set.
 func (c *ConfigFile) RenderedVariant(fileLookup fileLookupFn, name string) (Config, error) {
+	out, err := c.renderVariant(fileLookup, name)
+	if err != nil {
+		return Config{}, variantError(name, err)
+	}
+	return out, nil
+}
+
+func (c *ConfigFile) renderVariant(fileLookup fileLookupFn, name string) (Config, error) {
 	var out Config
 	var vari Config
 	if len(c.Variants) > 0 || len(name) > 0 {
@@ -262,52 +679,75 @@ func (c *ConfigFile) RenderedVariant(fileLookup fileLookupFn, name string) (Conf
 	if err := out.Merge(vari); err != nil {
 		return Config{}, err
 	}
+	if len(c.providerOverride) > 0 {
+		if err := out.overrideProvider(c.providerOverride); err != nil {
+			return Config{}, err
+		}
+	}
 	if err := out.SetDefaults(); err != nil {
 		return Config{}, err
 	}
-	if err := out.Render(fileLookup); err != nil {
+	if err := out.Render(fileLookup, os.ReadFile); err != nil {
 		return Config{}, err
 	}
 
 	return out, nil
 }
 
+// variantError adds the variant name to err. Field errors carry the variant themselves,
+// so each of multiple joined field errors names the variant.
+func variantError(variant string, err error) error {
+	if variant == "" {
+		return err
+	}
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		setErrorVariant(err, variant)
+		return err
+	}
+	return fmt.Errorf("variant %q: %w", variant, err)
+}
+
 func (c *ConfigFile) validateAll(fileLookup fileLookupFn, filters ...variantFilter) error {
-	var errs error
+	results, err := c.ValidateVariants(fileLookup, filters...)
+	if err != nil {
+		return err
+	}
 	if len(c.Variants) == 0 {
-		_, err := c.RenderedVariant(fileLookup, "")
-		if err != nil {
+		if err := results[""]; err != nil {
 			return fmt.Errorf("validating config: %w", err)
 		}
+		return nil
 	}
 
-	variantNames := make([]string, 0, len(c.Variants))
-	for name := range c.Variants {
-		var filtered bool
-		for _, filter := range filters {
-			if !filter(name) {
-				filtered = true
-				break
-			}
-		}
-		if filtered {
-			continue
+	var errs error
+	for _, name := range c.filteredVariantNames(filters...) {
+		if err := results[name]; err != nil {
+			errs = errors.Join(errs, variantError(name, err))
 		}
-		variantNames = append(variantNames, name)
 	}
+	return errs
+}
 
-	if len(c.Variants) != 0 && len(variantNames) == 0 {
-		return errors.New("all variants were filtered out")
+// ValidateVariants renders and validates every variant that passes all filters.
+// It returns the validation error of every variant by name, nil for valid variants.
+// A config without variants is returned with the empty name.
+// The errors don't name the variant, validation errors are returned as *FieldError.
+func (c *ConfigFile) ValidateVariants(fileLookup fileLookupFn, filters ...variantFilter) (map[string]error, error) {
+	if len(c.Variants) == 0 {
+		_, err := c.renderVariant(fileLookup, "")
+		return map[string]error{"": err}, nil
 	}
 
-	slices.Sort(variantNames)
+	variantNames := c.filteredVariantNames(filters...)
+	if len(variantNames) == 0 {
+		return nil, errors.New("all variants were filtered out")
+	}
+	results := make(map[string]error, len(variantNames))
 	for _, name := range variantNames {
-		_, err := c.RenderedVariant(fileLookup, name)
-		if err != nil {
-			errs = errors.Join(errs, fmt.Errorf("config for variant %s: %w", name, err))
-		}
+		_, results[name] = c.renderVariant(fileLookup, name)
 	}
-	return errs
+	return results, nil
 }
 
 func (c *ConfigFile) ForEach(fn func(name string, cfg Config) error, fileLookup fileLookupFn, filters ...variantFilter) error {
@@ -322,6 +762,64 @@ func (c *ConfigFile) ForEach(fn func(name string, cfg Config) error, fileLookup
 		}
 		return fn("", cfg)
 	}
+	for _, name := range c.filteredVariantNames(filters...) {
+		cfg, err := c.RenderedVariant(fileLookup, name)
+		if err != nil {
+			return err
+		}
+		if err := fn(name, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachParallel is like ForEach, but calls fn for up to limit variants concurrently.
+// All variants are validated and rendered before fn is called for the first one.
+// After fn failed for a variant, no further variants are started. The errors of
+// all variants are joined in the order of the variant names.
+func (c *ConfigFile) ForEachParallel(fn func(name string, cfg Config) error, limit int, fileLookup fileLookupFn, filters ...variantFilter) error {
+	if err := c.validateAll(fileLookup, filters...); err != nil {
+		return err
+	}
+
+	names := []string{""}
+	if len(c.Variants) > 0 {
+		names = c.filteredVariantNames(filters...)
+	}
+	cfgs := make([]Config, len(names))
+	for i, name := range names {
+		cfg, err := c.RenderedVariant(fileLookup, name)
+		if err != nil {
+			return err
+		}
+		cfgs[i] = cfg
+	}
+
+	errs := make([]error, len(names))
+	var failed atomic.Bool
+	var group errgroup.Group
+	if limit > 0 {
+		group.SetLimit(limit)
+	}
+	for i, name := range names {
+		group.Go(func() error {
+			if failed.Load() {
+				return nil
+			}
+			if err := fn(name, cfgs[i]); err != nil {
+				failed.Store(true)
+				errs[i] = err
+			}
+			return nil
+		})
+	}
+	_ = group.Wait()
+	return errors.Join(errs...)
+}
+
+// filteredVariantNames returns the sorted names of the variants that pass all filters.
+func (c *ConfigFile) filteredVariantNames(filters ...variantFilter) []string {
 	variantNames := make([]string, 0, len(c.Variants))
 	for name := range c.Variants {
 		var filtered bool
@@ -337,18 +835,11 @@ func (c *ConfigFile) ForEach(fn func(name string, cfg Config) error, fileLookup
 		variantNames = append(variantNames, name)
 	}
 	slices.Sort(variantNames)
-	for _, name := range variantNames {
-		cfg, err := c.RenderedVariant(fileLookup, name)
-		if err != nil {
-			return err
-		}
-		if err := fn(name, cfg); err != nil {
-			return err
-		}
-	}
-	return nil
+	return variantNames
 }
 
+// fileLookupFn reads the image version file name. Other files referenced by the config
+// are read from disk directly, so the lookup can return an incremented version.
 type fileLookupFn func(name string) ([]byte, error)
 
 type variantFilter func(name string) bool
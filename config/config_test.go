@@ -8,9 +8,13 @@ package config
 
 import (
 	"errors"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfigRenderVersionFromFile(t *testing.T) {
@@ -24,10 +28,160 @@ func TestConfigRenderVersionFromFile(t *testing.T) {
 		ImageVersion:     "0.0.1", // this will be overwritten by the file
 		ImageVersionFile: "image-version.txt",
 	}))
-	assert.NoError(config.Render(lookup.Lookup))
+	assert.NoError(config.Render(lookup.Lookup, lookup.Lookup))
 	assert.Equal("0.0.2", config.ImageVersion)
 }
 
+func TestConfigRenderUEFIVarStoreFromFile(t *testing.T) {
+	testCases := map[string]struct {
+		lookup   stubFileLookup
+		wantData string
+		wantErr  bool
+	}{
+		"base64 data": {
+			lookup:   stubFileLookup{"uefi.b64": []byte("dGVzdA==\n")},
+			wantData: "dGVzdA==",
+		},
+		"invalid base64": {
+			lookup:  stubFileLookup{"uefi.b64": []byte("not base64!")},
+			wantErr: true,
+		},
+		"missing file": {
+			lookup:  stubFileLookup{},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := fullConfig()
+			assert.NoError(config.Merge(Config{
+				Name:         "test",
+				ImageVersion: "0.0.1",
+				AWS:          AWSConfig{UEFIVarStoreFile: "uefi.b64"},
+			}))
+			// The var store isn't read with the version file lookup.
+			err := config.Render(stubFileLookup{}.Lookup, tc.lookup.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantData, config.AWS.UEFIData)
+		})
+	}
+}
+
+func TestConfigRenderVMGSFromFile(t *testing.T) {
+	testCases := map[string]struct {
+		lookup   stubFileLookup
+		wantVMGS []byte
+		wantErr  bool
+	}{
+		"vmgs file": {
+			lookup:   stubFileLookup{"image.vmgs": []byte{0x01, 0x02}},
+			wantVMGS: []byte{0x01, 0x02},
+		},
+		"empty file": {
+			lookup:  stubFileLookup{"image.vmgs": {}},
+			wantErr: true,
+		},
+		"missing file": {
+			lookup:  stubFileLookup{},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := fullConfig()
+			assert.NoError(config.Merge(Config{
+				Name:         "test",
+				ImageVersion: "0.0.1",
+				Azure:        AzureConfig{VMGSFile: "image.vmgs"},
+			}))
+			// The VMGS isn't read with the version file lookup.
+			err := config.Render(stubFileLookup{}.Lookup, tc.lookup.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantVMGS, config.Azure.VMGS)
+		})
+	}
+}
+
+func TestConfigRenderSecureBootKeysFromFiles(t *testing.T) {
+	testCases := map[string]struct {
+		lookup   stubFileLookup
+		wantPK   []byte
+		wantKEKs [][]byte
+		wantDBs  [][]byte
+		wantErr  bool
+	}{
+		"key files": {
+			lookup: stubFileLookup{
+				"pk.pem":  []byte("pk"),
+				"kek.pem": []byte("kek"),
+				"db.pem":  []byte("db"),
+				"db2.pem": []byte("db2"),
+			},
+			wantPK:   []byte("pk"),
+			wantKEKs: [][]byte{[]byte("kek")},
+			wantDBs:  [][]byte{[]byte("db"), []byte("db2")},
+		},
+		"empty file": {
+			lookup: stubFileLookup{
+				"pk.pem":  []byte("pk"),
+				"kek.pem": {},
+				"db.pem":  []byte("db"),
+				"db2.pem": []byte("db2"),
+			},
+			wantErr: true,
+		},
+		"missing file": {
+			lookup:  stubFileLookup{"pk.pem": []byte("pk")},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := fullConfig()
+			assert.NoError(config.Merge(Config{
+				Name:         "test",
+				ImageVersion: "0.0.1",
+				GCP: GCPConfig{
+					SecureBoot: GCPSecureBootConfig{
+						Enabled:  Some(true),
+						PKFile:   "pk.pem",
+						KEKFiles: []string{"kek.pem"},
+						DBFiles:  []string{"db.pem", "db2.pem"},
+					},
+				},
+			}))
+			// The keys aren't read with the version file lookup.
+			err := config.Render(stubFileLookup{}.Lookup, tc.lookup.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantPK, config.GCP.SecureBoot.PK)
+			assert.Equal(tc.wantKEKs, config.GCP.SecureBoot.KEKs)
+			assert.Equal(tc.wantDBs, config.GCP.SecureBoot.DBs)
+			assert.Nil(config.GCP.SecureBoot.DBXs)
+		})
+	}
+}
+
 func TestConfigRenderTemplate(t *testing.T) {
 	assert := assert.New(t)
 	lookup := stubFileLookup{}
@@ -39,10 +193,84 @@ func TestConfigRenderTemplate(t *testing.T) {
 			ImageName: "prefix-{{.Name}}-{{replaceAll .Version \".\" \"-\"}}-suffix",
 		},
 	}))
-	assert.NoError(config.Render(lookup.Lookup))
+	assert.NoError(config.Render(lookup.Lookup, lookup.Lookup))
 	assert.Equal("prefix-name-0-0-1-suffix", config.GCP.ImageName)
 }
 
+func TestConfigRenderArchitectureTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		provider     string
+		architecture string
+		want         string
+	}{
+		"gcp default": {
+			provider: "gcp",
+			want:     "name-x86-64",
+		},
+		"gcp arm64": {
+			provider:     "gcp",
+			architecture: "arm64",
+			want:         "name-arm64",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := Config{}
+			assert.NoError(config.SetDefaults())
+			assert.NoError(config.Merge(fullConfig()))
+			assert.NoError(config.Merge(Config{
+				Provider: tc.provider,
+				Name:     "name",
+				GCP: GCPConfig{
+					ImageName:    `{{.Name}}-{{replaceAll .Architecture "_" "-"}}`,
+					Architecture: tc.architecture,
+				},
+			}))
+			assert.NoError(config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup))
+			assert.Equal(tc.want, config.GCP.ImageName)
+		})
+	}
+}
+
+func TestConfigRenderAMINameTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		amiName string
+		want    string
+	}{
+		"default": {
+			amiName: defaultConfig.AWS.AMIName,
+			want:    "name-1.2.3",
+		},
+		"split version fields": {
+			amiName: "{{.Name}}-{{.VersionMajor}}.{{.VersionMinor}}",
+			want:    "name-1.2",
+		},
+		"patch with function": {
+			amiName: "{{.Name}}-{{replaceAll .Version \".\" \"_\"}}-p{{.VersionPatch}}",
+			want:    "name-1_2_3-p3",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := fullConfig()
+			config.AWS.AMIName = ""
+			assert.NoError(config.Merge(Config{
+				Name:         "name",
+				ImageVersion: "1.2.3",
+				AWS:          AWSConfig{AMIName: tc.amiName},
+			}))
+			assert.NoError(config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup))
+			assert.Equal(tc.want, config.AWS.AMIName)
+		})
+	}
+}
+
 func TestConfigSetDefaults(t *testing.T) {
 	assert := assert.New(t)
 	config := Config{
@@ -130,9 +358,139 @@ func TestConfigFileMerge(t *testing.T) {
 	src = fullConfigFile()
 	srcVariant := src.Variants["a"]
 	srcVariant.Name = ""
+	src.Variants["a"] = srcVariant
 	assert.NoError(dst.Merge(src))
 	assert.Equal("a", dst.Variants["a"].Name)
 	assert.Equal("test", dst.Variants["b"].Name)
+
+	dst = ConfigFile{
+		Variants: map[string]Config{
+			"v": {
+				Name:         "a",
+				ImageVersion: "1.0.0",
+			},
+		},
+	}
+	src = ConfigFile{
+		Variants: map[string]Config{
+			"v": {
+				Name: "b",
+			},
+		},
+	}
+	assert.NoError(dst.Merge(src))
+	assert.Equal("b", dst.Variants["v"].Name)
+	assert.Equal("1.0.0", dst.Variants["v"].ImageVersion)
+}
+
+func TestConfigFileProviderOverride(t *testing.T) {
+	testCases := map[string]struct {
+		provider     string
+		mutation     func(*ConfigFile)
+		wantProvider string
+		wantErr      bool
+	}{
+		"no override": {
+			wantProvider: "aws",
+		},
+		"override to populated provider": {
+			provider:     "gcp",
+			wantProvider: "gcp",
+		},
+		"override to empty provider": {
+			provider: "openstack",
+			wantErr:  true,
+		},
+		"override to provider populated in variant": {
+			provider: "openstack",
+			mutation: func(c *ConfigFile) {
+				variant := c.Variants["a"]
+				variant.OpenStack = OpenStackConfig{Cloud: "cloud", ImageName: "image"}
+				c.Variants["a"] = variant
+			},
+			wantProvider: "openstack",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			conf := fullConfigFile()
+			conf.Base.GCP.Project = "my-project"
+			conf.Base.GCP.Location = "us-central1"
+			if tc.mutation != nil {
+				tc.mutation(&conf)
+			}
+			conf.SetProviderOverride(tc.provider)
+
+			cfg, err := conf.RenderedVariant(stubFileLookup{}.Lookup, "a")
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantProvider, cfg.Provider)
+		})
+	}
+}
+
+func TestConfigFileRenderRegionTemplates(t *testing.T) {
+	testCases := map[string]struct {
+		provider             string
+		wantAWSRegion        string
+		wantAWSReplication   []string
+		wantAzureLocation    string
+		wantAzureReplication []string
+	}{
+		"aws": {
+			provider:           "aws",
+			wantAWSRegion:      "a-region",
+			wantAWSReplication: []string{"a-replica-1", "a-replica-2"},
+		},
+		"azure": {
+			provider:             "azure",
+			wantAzureLocation:    "a-location",
+			wantAzureReplication: []string{"a-replica"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			conf := ConfigFile{
+				Base: validConfig(),
+				Variants: map[string]Config{
+					"a": {
+						Provider: tc.provider,
+						Name:     "a",
+						AWS: AWSConfig{
+							Region:             "{{.Name}}-region",
+							ReplicationRegions: []string{"{{.Name}}-replica-1", "{{.Name}}-replica-2"},
+						},
+						Azure: AzureConfig{
+							Location:           "{{.Name}}-location",
+							ReplicationRegions: []string{"{{.Name}}-replica"},
+						},
+					},
+				},
+			}
+
+			cfg, err := conf.RenderedVariant(stubFileLookup{}.Lookup, "a")
+			require.NoError(err)
+			if tc.provider == "aws" {
+				assert.Equal(tc.wantAWSRegion, cfg.AWS.Region)
+				assert.Equal(tc.wantAWSReplication, cfg.AWS.ReplicationRegions)
+			} else {
+				assert.Equal(tc.wantAzureLocation, cfg.Azure.Location)
+				assert.Equal(tc.wantAzureReplication, cfg.Azure.ReplicationRegions)
+			}
+			// The variant config must not be changed by rendering it.
+			assert.Equal([]string{"{{.Name}}-replica-1", "{{.Name}}-replica-2"}, conf.Variants["a"].AWS.ReplicationRegions)
+		})
+	}
 }
 
 type stubFileLookup map[string][]byte
@@ -145,6 +503,458 @@ func (s stubFileLookup) Lookup(name string) ([]byte, error) {
 	return val, nil
 }
 
+func TestConfigRenderOpenStackPropertiesAndTags(t *testing.T) {
+	assert := assert.New(t)
+	lookup := stubFileLookup{}
+	properties := map[string]string{
+		"build":   "{{.Version}}",
+		"os_type": "linux",
+	}
+	config := fullConfig()
+	config.Provider = "openstack"
+	assert.NoError(config.Merge(Config{
+		Name:         "name",
+		ImageVersion: "1.2.3",
+		OpenStack: OpenStackConfig{
+			Cloud:      "openstack",
+			ImageName:  "{{.Name}}",
+			Tags:       []string{"{{.Name}}", "v{{.VersionMajor}}"},
+			Properties: properties,
+		},
+	}))
+	assert.NoError(config.Render(lookup.Lookup, lookup.Lookup))
+	assert.Equal([]string{"name", "v1"}, config.OpenStack.Tags)
+	assert.Equal(map[string]string{"build": "1.2.3", "os_type": "linux"}, config.OpenStack.Properties)
+	assert.Equal("{{.Version}}", properties["build"], "shared map must not be modified")
+}
+
+func TestConfigRenderPrereleaseVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version        string
+		gcpImageName   string
+		wantPrerelease string
+		wantBuild      string
+		wantGCPImage   string
+		wantErr        bool
+	}{
+		"prerelease": {
+			version:        "1.2.3-rc.1",
+			gcpImageName:   defaultConfig.GCP.ImageName,
+			wantPrerelease: "rc.1",
+			wantGCPImage:   "name-1-2-3-rc-1",
+		},
+		"build metadata violates GCP name rules": {
+			version:        "1.2.3-rc.1+build.5",
+			gcpImageName:   defaultConfig.GCP.ImageName,
+			wantPrerelease: "rc.1",
+			wantBuild:      "build.5",
+			wantErr:        true,
+		},
+		"build metadata sanitized": {
+			version:        "1.2.3-rc.1+build.5",
+			gcpImageName:   `{{.Name}}-{{replaceAll (replaceAll .Version "." "-") "+" "-"}}`,
+			wantPrerelease: "rc.1",
+			wantBuild:      "build.5",
+			wantGCPImage:   "name-1-2-3-rc-1-build-5",
+		},
+		"build metadata only": {
+			version:      "1.2.3+build.5",
+			gcpImageName: `{{.Name}}-{{.VersionMajor}}-{{replaceAll .VersionBuild "." "-"}}`,
+			wantBuild:    "build.5",
+			wantGCPImage: "name-1-build-5",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := Config{}
+			assert.NoError(config.SetDefaults())
+			assert.NoError(config.Merge(fullConfig()))
+			assert.NoError(config.Merge(Config{
+				Provider:        "gcp",
+				Name:            "name",
+				ImageVersion:    tc.version,
+				AllowPrerelease: Some(true),
+				GCP: GCPConfig{
+					ImageName: tc.gcpImageName,
+					BlobName:  "{{.Name}}.tar.gz",
+				},
+			}))
+			data := config.fieldTemplateData()
+			assert.Equal(tc.wantPrerelease, data.VersionPrerelease)
+			assert.Equal(tc.wantBuild, data.VersionBuild)
+
+			err := config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantGCPImage, config.GCP.ImageName)
+		})
+	}
+}
+
+func TestConfigRenderAWSParavirtual(t *testing.T) {
+	testCases := map[string]struct {
+		awsConfig AWSConfig
+		wantErr   bool
+	}{
+		"plain paravirtual": {
+			awsConfig: AWSConfig{VirtualizationType: "paravirtual"},
+		},
+		"paravirtual with tpmSupport disabled": {
+			awsConfig: AWSConfig{VirtualizationType: "paravirtual", TPMSupport: Some(false)},
+		},
+		"paravirtual with tpmSupport enabled": {
+			awsConfig: AWSConfig{VirtualizationType: "paravirtual", TPMSupport: Some(true)},
+			wantErr:   true,
+		},
+		"paravirtual with enaSupport enabled": {
+			awsConfig: AWSConfig{VirtualizationType: "paravirtual", EnaSupport: Some(true)},
+			wantErr:   true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := Config{}
+			assert.NoError(config.SetDefaults())
+			assert.NoError(config.Merge(fullConfig()))
+			assert.NoError(config.Merge(Config{AWS: tc.awsConfig}))
+
+			err := config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestConfigRenderCommit(t *testing.T) {
+	testCases := map[string]struct {
+		commitHash    string
+		envCommit     string
+		wantImageName string
+	}{
+		"commitHash": {
+			commitHash:    "0123456789abcdef0123456789abcdef01234567",
+			wantImageName: "name-0123456",
+		},
+		"GIT_COMMIT": {
+			envCommit:     "fedcba9876543210fedcba9876543210fedcba98",
+			wantImageName: "name-fedcba9",
+		},
+		"commitHash overrides GIT_COMMIT": {
+			commitHash:    "0123456789abcdef0123456789abcdef01234567",
+			envCommit:     "fedcba9876543210fedcba9876543210fedcba98",
+			wantImageName: "name-0123456",
+		},
+		"short commit hash": {
+			commitHash:    "abcd",
+			wantImageName: "name-abcd",
+		},
+		"no commit": {
+			wantImageName: "name-",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			t.Setenv("GIT_COMMIT", tc.envCommit)
+
+			config := Config{}
+			assert.NoError(config.SetDefaults())
+			assert.NoError(config.Merge(fullConfig()))
+			assert.NoError(config.Merge(Config{
+				Provider:   "gcp",
+				Name:       "name",
+				CommitHash: tc.commitHash,
+				GCP: GCPConfig{
+					ImageName: "{{.Name}}-{{.ShortCommit}}",
+				},
+			}))
+
+			assert.NoError(config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup))
+			assert.Equal(tc.wantImageName, config.GCP.ImageName)
+		})
+	}
+}
+
+func TestConfigRenderTemplateFuncs(t *testing.T) {
+	t.Setenv("UPLOSI_TEST_BUILD_ID", "42")
+
+	testCases := map[string]struct {
+		name         string
+		gcpImageName string
+		gcpBlobName  string
+		wantGCPImage string
+		wantGCPBlob  string
+		wantErr      bool
+	}{
+		"toLower": {
+			name:         "MyImage",
+			gcpImageName: `{{.Name | toLower}}-{{replaceAll .Version "." "-"}}`,
+			wantGCPImage: "myimage-1-2-3",
+		},
+		"uppercase name violates GCP name rules": {
+			name:         "MyImage",
+			gcpImageName: `{{.Name}}-{{replaceAll .Version "." "-"}}`,
+			wantErr:      true,
+		},
+		"toUpper": {
+			name:         "image",
+			gcpBlobName:  `{{.Name | toUpper}}.tar.gz`,
+			wantGCPImage: "image",
+			wantGCPBlob:  "IMAGE.tar.gz",
+		},
+		"trimPrefix and trimSuffix": {
+			name:         "os-image-dev",
+			gcpImageName: `{{.Name | trimPrefix "os-" | trimSuffix "-dev"}}`,
+			wantGCPImage: "image",
+		},
+		"env": {
+			name:         "image",
+			gcpImageName: `{{.Name}}-{{env "UPLOSI_TEST_BUILD_ID"}}`,
+			wantGCPImage: "image-42",
+		},
+		"replaceAll": {
+			name:         "image",
+			gcpImageName: `{{.Name}}-{{replaceAll .Version "." "-"}}`,
+			wantGCPImage: "image-1-2-3",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			if tc.gcpImageName == "" {
+				tc.gcpImageName = "{{.Name}}"
+			}
+			if tc.gcpBlobName == "" {
+				tc.gcpBlobName = "{{.Name}}.tar.gz"
+			}
+			config := Config{}
+			assert.NoError(config.SetDefaults())
+			assert.NoError(config.Merge(fullConfig()))
+			assert.NoError(config.Merge(Config{
+				Provider:     "gcp",
+				Name:         tc.name,
+				ImageVersion: "1.2.3",
+				GCP: GCPConfig{
+					ImageName: tc.gcpImageName,
+					BlobName:  tc.gcpBlobName,
+				},
+			}))
+
+			err := config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantGCPImage, config.GCP.ImageName)
+			if tc.wantGCPBlob != "" {
+				assert.Equal(tc.wantGCPBlob, config.GCP.BlobName)
+			}
+		})
+	}
+}
+
+func TestConfigRenderNamePrefixSuffix(t *testing.T) {
+	testCases := map[string]struct {
+		prefix, suffix string
+		wantAMIName    string
+		wantGCPImage   string
+		wantErr        bool
+	}{
+		"none": {
+			wantAMIName:  "name-1.2.3",
+			wantGCPImage: "name-1-2-3",
+		},
+		"prefix": {
+			prefix:       "dev-",
+			wantAMIName:  "dev-name-1.2.3",
+			wantGCPImage: "dev-name-1-2-3",
+		},
+		"suffix": {
+			suffix:       "-staging",
+			wantAMIName:  "name-1.2.3-staging",
+			wantGCPImage: "name-1-2-3-staging",
+		},
+		"prefix and suffix": {
+			prefix:       "team-",
+			suffix:       "-dev",
+			wantAMIName:  "team-name-1.2.3-dev",
+			wantGCPImage: "team-name-1-2-3-dev",
+		},
+		"combined name violates provider rules": {
+			prefix:  "Dev_",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := fullConfig()
+			config.AWS.AMIName = ""
+			config.GCP.ImageName = ""
+			assert.NoError(config.Merge(Config{
+				Name:         "name",
+				ImageVersion: "1.2.3",
+				NamePrefix:   tc.prefix,
+				NameSuffix:   tc.suffix,
+				AWS:          AWSConfig{AMIName: defaultConfig.AWS.AMIName},
+				GCP:          GCPConfig{ImageName: defaultConfig.GCP.ImageName},
+			}))
+			err := config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantAMIName, config.AWS.AMIName)
+			assert.Equal(tc.wantGCPImage, config.GCP.ImageName)
+		})
+	}
+}
+
+func TestConfigRenderNameRules(t *testing.T) {
+	longVersion := "1.2.3-" + strings.Repeat("rc", 70)
+
+	testCases := map[string]struct {
+		provider           string
+		namePrefix         string
+		nameSuffix         string
+		amiName            string
+		azureResourceGroup string
+		gcpImageName       string
+		openstackImageName string
+		wantAMIName        string
+		wantGCPImage       string
+		wantErr            string
+	}{
+		"AMI name too long": {
+			provider: "aws",
+			amiName:  "{{.Name}}-{{.Version}}",
+			wantErr:  "field amiName rendered from template \"{{.Name}}-{{.Version}}\"",
+		},
+		"AMI name truncated": {
+			provider:    "aws",
+			amiName:     "{{.Name}}-{{.Version | trunc 10}}",
+			wantAMIName: "name-1.2.3-rcrc",
+		},
+		"AMI name with invalid characters": {
+			provider: "aws",
+			amiName:  "{{.Name}}+{{.VersionMajor}}",
+			wantErr:  "replace other characters, e.g. with regexReplaceAll",
+		},
+		"GCP image name too long": {
+			provider:     "gcp",
+			gcpImageName: "{{.Name}}-{{.VersionPrerelease}}",
+			wantErr:      "at most 63 are allowed for provider gcp; shorten it, e.g. with trunc",
+		},
+		"GCP image name sanitized and truncated": {
+			provider:     "gcp",
+			gcpImageName: `{{.Name}}-{{regexReplaceAll "[^a-z0-9]+" .Version "-" | trunc 12}}`,
+			wantGCPImage: "name-1-2-3-rcrcrc",
+		},
+		"OpenStack image name too long with prefix": {
+			provider:           "openstack",
+			namePrefix:         strings.Repeat("p", 105) + "-",
+			openstackImageName: "{{.Name}}-{{.Version}}",
+			wantErr:            "which has 257 characters, but at most 255 are allowed for provider openstack",
+		},
+		"Azure resource group with invalid characters in suffix": {
+			provider:           "azure",
+			nameSuffix:         "+dev",
+			azureResourceGroup: "{{.Name}}",
+			wantErr:            "field resourceGroup rendered from template \"{{.Name}}\" to \"name+dev\", but only letters, numbers, underscores, parentheses, hyphens and periods are allowed",
+		},
+		"AWS bucket too long with suffix": {
+			provider:   "aws",
+			nameSuffix: "-" + strings.Repeat("s", 60),
+			wantErr:    "field bucket rendered from template \"bucket\"",
+		},
+		"rules of other providers are ignored": {
+			provider:     "gcp",
+			amiName:      "{{.Name}}+{{.Version}}",
+			gcpImageName: "{{.Name}}",
+			wantGCPImage: "name",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			config := fullConfig()
+			config.AWS.AMIName = ""
+			config.GCP.ImageName = ""
+			if tc.amiName == "" {
+				tc.amiName = "{{.Name}}"
+			}
+			if tc.gcpImageName == "" {
+				tc.gcpImageName = "{{.Name}}"
+			}
+			assert.NoError(config.Merge(Config{
+				Provider:        tc.provider,
+				Name:            "name",
+				ImageVersion:    longVersion,
+				AllowPrerelease: Some(true),
+				NamePrefix:      tc.namePrefix,
+				NameSuffix:      tc.nameSuffix,
+				AWS:             AWSConfig{AMIName: tc.amiName},
+				Azure:           AzureConfig{ResourceGroup: tc.azureResourceGroup},
+				GCP:             GCPConfig{ImageName: tc.gcpImageName},
+				OpenStack:       OpenStackConfig{Cloud: "cloud", ImageName: tc.openstackImageName},
+			}))
+			err := config.Render(stubFileLookup{}.Lookup, stubFileLookup{}.Lookup)
+			if tc.wantErr != "" {
+				assert.ErrorContains(err, tc.wantErr)
+				return
+			}
+			assert.NoError(err)
+			if tc.wantAMIName != "" {
+				assert.Equal(tc.wantAMIName, config.AWS.AMIName)
+			}
+			if tc.wantGCPImage != "" {
+				assert.Equal(tc.wantGCPImage, config.GCP.ImageName)
+			}
+		})
+	}
+}
+
+func TestRenderedNameRulesCoverPrefixedFields(t *testing.T) {
+	providerConfigs := map[string]any{
+		"aws":       AWSConfig{},
+		"azure":     AzureConfig{},
+		"gcp":       GCPConfig{},
+		"openstack": OpenStackConfig{},
+	}
+	for provider, providerConfig := range providerConfigs {
+		typ := reflect.TypeOf(providerConfig)
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Tag.Get("name") != "true" {
+				continue
+			}
+			_, ok := renderedNameRules[provider][field.Name]
+			assert.True(t, ok, "missing rendered name rule for %s.%s", provider, field.Name)
+		}
+	}
+}
+
 func fullConfig() Config {
 	return Config{
 		Provider:     "aws",
@@ -194,3 +1004,72 @@ func fullConfigFile() ConfigFile {
 		},
 	}
 }
+
+func TestConfigFileForEachParallel(t *testing.T) {
+	newConfigFile := func(names ...string) ConfigFile {
+		conf := ConfigFile{Base: validConfig(), Variants: map[string]Config{}}
+		for _, name := range names {
+			conf.Variants[name] = Config{Name: name}
+		}
+		return conf
+	}
+
+	testCases := map[string]struct {
+		conf      ConfigFile
+		limit     int
+		failing   map[string]bool
+		wantCalls []string
+		wantErr   bool
+	}{
+		"all variants": {
+			conf:      newConfigFile("c", "a", "b"),
+			limit:     2,
+			wantCalls: []string{"a", "b", "c"},
+		},
+		"without variants": {
+			conf:      ConfigFile{Base: validConfig()},
+			limit:     2,
+			wantCalls: []string{""},
+		},
+		"no further variants after failure": {
+			conf:      newConfigFile("a", "b", "c"),
+			limit:     1,
+			failing:   map[string]bool{"a": true},
+			wantCalls: []string{"a"},
+			wantErr:   true,
+		},
+		"invalid variant is not uploaded": {
+			conf: func() ConfigFile {
+				conf := newConfigFile("a", "b")
+				conf.Variants["b"] = Config{Name: "b", Provider: "invalid"}
+				return conf
+			}(),
+			limit:   2,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var mu sync.Mutex
+			calls := []string{}
+			err := tc.conf.ForEachParallel(func(name string, cfg Config) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, name)
+				if tc.failing[name] {
+					return errors.New("failed")
+				}
+				return nil
+			}, tc.limit, stubFileLookup{}.Lookup)
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.ElementsMatch(tc.wantCalls, calls)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// envVarRegexp matches a ${NAME} reference to an environment variable
+// or its escaped form $${NAME}.
+var envVarRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces every ${NAME} reference in the string values of the base and variant configs
+// with the value of the environment variable NAME, as returned by lookupEnv.
+// $${NAME} is replaced with a literal ${NAME}.
+// It is meant to run right after loading, before templates are rendered.
+// Every reference to a variable that isn't set is returned as error, naming the field.
+func (c *ConfigFile) ExpandEnv(lookupEnv func(key string) (string, bool)) error {
+	errs := []error{expandEnv(reflect.ValueOf(&c.Base).Elem(), "base", lookupEnv)}
+
+	names := make([]string, 0, len(c.Variants))
+	for name := range c.Variants {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		vari := c.Variants[name]
+		errs = append(errs, expandEnv(reflect.ValueOf(&vari).Elem(), "variant."+name, lookupEnv))
+		c.Variants[name] = vari
+	}
+	return errors.Join(errs...)
+}
+
+// expandEnv expands the references in value, recursing into structs.
+// fieldPath is the TOML path of value, used in errors.
+func expandEnv(value reflect.Value, fieldPath string, lookupEnv func(key string) (string, bool)) error {
+	switch {
+	case value.Kind() == reflect.Struct:
+		var errs []error
+		for i := 0; i < value.NumField(); i++ {
+			typeField := value.Type().Field(i)
+			if !typeField.IsExported() {
+				continue
+			}
+			key, _, _ := strings.Cut(typeField.Tag.Get("toml"), ",")
+			if key == "" {
+				key = typeField.Name
+			}
+			errs = append(errs, expandEnv(value.Field(i), fieldPath+"."+key, lookupEnv))
+		}
+		return errors.Join(errs...)
+	case value.Kind() == reflect.String:
+		expanded, err := expandEnvString(value.String(), lookupEnv)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fieldPath, err)
+		}
+		value.SetString(expanded)
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.String:
+		var errs []error
+		for i := 0; i < value.Len(); i++ {
+			expanded, err := expandEnvString(value.Index(i).String(), lookupEnv)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s[%d]: %w", fieldPath, i, err))
+				continue
+			}
+			value.Index(i).SetString(expanded)
+		}
+		return errors.Join(errs...)
+	case value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String && value.Type().Elem().Kind() == reflect.String:
+		var errs []error
+		iter := value.MapRange()
+		expanded := make(map[string]string, value.Len())
+		for iter.Next() {
+			val, err := expandEnvString(iter.Value().String(), lookupEnv)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s[%s]: %w", fieldPath, iter.Key().String(), err))
+				continue
+			}
+			expanded[iter.Key().String()] = val
+		}
+		for key, val := range expanded {
+			value.SetMapIndex(reflect.ValueOf(key).Convert(value.Type().Key()), reflect.ValueOf(val).Convert(value.Type().Elem()))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// expandEnvString replaces the ${NAME} references in text.
+func expandEnvString(text string, lookupEnv func(key string) (string, bool)) (string, error) {
+	var missing []string
+	expanded := envVarRegexp.ReplaceAllStringFunc(text, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		key := envVarRegexp.FindStringSubmatch(ref)[1]
+		val, ok := lookupEnv(key)
+		if !ok {
+			missing = append(missing, key)
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFileExpandEnv(t *testing.T) {
+	env := map[string]string{
+		"AZ_SUB": "00000000-0000-0000-0000-000000000000",
+		"BUCKET": "my-bucket",
+		"REGION": "eu-central-1",
+		"EMPTY":  "",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+
+	testCases := map[string]struct {
+		config    string
+		wantErr   []string
+		checkFunc func(assert *assert.Assertions, conf ConfigFile)
+	}{
+		"subscription id": {
+			config: `
+[base.azure]
+subscriptionID = "${AZ_SUB}"
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal("00000000-0000-0000-0000-000000000000", conf.Base.Azure.SubscriptionID)
+			},
+		},
+		"references within a value": {
+			config: `
+[base.aws]
+bucket = "${BUCKET}-${REGION}"
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal("my-bucket-eu-central-1", conf.Base.AWS.Bucket)
+			},
+		},
+		"variant slices and maps": {
+			config: `
+[variant.a.aws]
+replicationRegions = ["${REGION}", "us-east-2"]
+tags = { bucket = "${BUCKET}" }
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal([]string{"eu-central-1", "us-east-2"}, conf.Variants["a"].AWS.ReplicationRegions)
+				assert.Equal(map[string]string{"bucket": "my-bucket"}, conf.Variants["a"].AWS.Tags)
+			},
+		},
+		"templates are kept": {
+			config: `
+[base]
+name = "{{.Name}}-${BUCKET}"
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal("{{.Name}}-my-bucket", conf.Base.Name)
+			},
+		},
+		"empty variable": {
+			config: `
+[base]
+name = "image${EMPTY}"
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal("image", conf.Base.Name)
+			},
+		},
+		"dollar without braces": {
+			config: `
+[base]
+name = "$BUCKET"
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal("$BUCKET", conf.Base.Name)
+			},
+		},
+		"escaped reference": {
+			config: `
+[base]
+name = "$${BUCKET}-${BUCKET}"
+`,
+			checkFunc: func(assert *assert.Assertions, conf ConfigFile) {
+				assert.Equal("${BUCKET}-my-bucket", conf.Base.Name)
+			},
+		},
+		"unset variables": {
+			config: `
+[base.azure]
+subscriptionID = "${AZ_SUBSCRIPTION}"
+
+[variant.a.gcp]
+labels = { team = "${TEAM}" }
+`,
+			wantErr: []string{
+				"base.azure.subscriptionID: environment variable AZ_SUBSCRIPTION is not set",
+				"variant.a.gcp.labels[team]: environment variable TEAM is not set",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			var conf ConfigFile
+			_, err := toml.Decode(tc.config, &conf)
+			require.NoError(err)
+
+			err = conf.ExpandEnv(lookupEnv)
+			if len(tc.wantErr) > 0 {
+				for _, wantErr := range tc.wantErr {
+					assert.ErrorContains(err, wantErr)
+				}
+				return
+			}
+			require.NoError(err)
+			tc.checkFunc(assert, conf)
+		})
+	}
+}
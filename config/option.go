@@ -57,6 +57,24 @@ func (o *Option[T]) UnwrapOrElse(f func() T) T {
 	return o.Val
 }
 
+// UnwrapOrZero returns the value or the zero value of T if the option is None.
+func (o *Option[T]) UnwrapOrZero() T {
+	if !o.Valid {
+		var zero T
+		return zero
+	}
+	return o.Val
+}
+
+// Ptr returns a pointer to a copy of the value or nil if the option is None.
+func (o *Option[T]) Ptr() *T {
+	if !o.Valid {
+		return nil
+	}
+	val := o.Val
+	return &val
+}
+
 func (o *Option[T]) UnmarshalJSON(data []byte) error {
 	if string(data) == "null" {
 		o.Valid = false
@@ -44,6 +44,32 @@ func TestUnwrap(t *testing.T) {
 	assert.Equal(optString.Unwrap(), optString.UnwrapOrElse(func() string { return "foo" }))
 }
 
+func TestUnwrapOrZero(t *testing.T) {
+	assert := assert.New(t)
+	optBool := None[bool]()
+	assert.False(optBool.UnwrapOrZero())
+	optBool = Some(true)
+	assert.True(optBool.UnwrapOrZero())
+	optString := None[string]()
+	assert.Equal("", optString.UnwrapOrZero())
+	optString = Some("bar")
+	assert.Equal("bar", optString.UnwrapOrZero())
+}
+
+func TestPtr(t *testing.T) {
+	assert := assert.New(t)
+	optBool := None[bool]()
+	assert.Nil(optBool.Ptr())
+	optBool = Some(false)
+	ptr := optBool.Ptr()
+	if assert.NotNil(ptr) {
+		assert.False(*ptr)
+	}
+	// the pointer refers to a copy and can't modify the option
+	*ptr = true
+	assert.False(optBool.Unwrap())
+}
+
 func TestJSON(t *testing.T) {
 	assert := assert.New(t)
 	var optInt Option[int]
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed scaffold.toml.tmpl
+var scaffoldTemplate string
+
+// Scaffold returns a commented config file for the provider with a base section
+// and one example variant. Required fields are set to placeholders, optional
+// fields are commented out and show their default value.
+func Scaffold(provider string) ([]byte, error) {
+	provider = strings.ToLower(provider)
+	switch provider {
+	case "aws", "azure", "gcp", "openstack":
+	default:
+		return nil, fmt.Errorf("unknown provider %q, must be one of aws, azure, gcp, openstack", provider)
+	}
+	tmpl, err := template.New("scaffold").Funcs(template.FuncMap{"toml": tomlValue}).Parse(scaffoldTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing scaffold template: %w", err)
+	}
+	data := struct {
+		Provider string
+		Defaults Config
+	}{
+		Provider: provider,
+		Defaults: defaultConfig,
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("executing scaffold template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// tomlValue formats a config value as TOML value.
+func tomlValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case []string:
+		vals := make([]string, 0, len(v))
+		for _, s := range v {
+			vals = append(vals, strconv.Quote(s))
+		}
+		return "[" + strings.Join(vals, ", ") + "]", nil
+	case Option[bool]:
+		return tomlValue(v.UnwrapOrZero())
+	case Option[int]:
+		return tomlValue(v.UnwrapOrZero())
+	default:
+		return "", fmt.Errorf("unsupported TOML value type %T", v)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaffold(t *testing.T) {
+	placeholders := strings.NewReplacer(
+		"<image-name>", "my-image",
+		"<region, e.g. eu-central-1>", "eu-central-1",
+		"<bucket>", "my-bucket",
+		"<subscription ID>", "00000000-0000-0000-0000-000000000000",
+		"<location, e.g. northeurope>", "northeurope",
+		"<resource group>", "my-rg",
+		"<gallery>", "my_gallery",
+		"<project>", "myproject-123456",
+		"<location, e.g. europe-west3>", "europe-west3",
+		"<cloud>", "mycloud",
+	)
+
+	for _, provider := range []string{"aws", "azure", "gcp", "openstack"} {
+		t.Run(provider, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			scaffold, err := Scaffold(provider)
+			require.NoError(err)
+
+			var conf ConfigFile
+			_, err = toml.Decode(string(scaffold), &conf)
+			require.NoError(err)
+			assert.Equal(provider, conf.Base.Provider)
+			assert.Contains(conf.Variants, "example")
+
+			filled := placeholders.Replace(string(scaffold))
+			assert.NotContains(filled, `= "<`)
+			conf = ConfigFile{}
+			_, err = toml.Decode(filled, &conf)
+			require.NoError(err)
+			_, err = conf.RenderedVariant(stubFileLookup{}.Lookup, "example")
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestScaffoldUnknownProvider(t *testing.T) {
+	_, err := Scaffold("foo")
+	assert.Error(t, err)
+}
+
+func TestScaffoldDefaults(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	scaffold, err := Scaffold("aws")
+	require.NoError(err)
+
+	// Uncommenting an option must keep its default value.
+	var uncommented []string
+	for _, line := range strings.Split(string(scaffold), "\n") {
+		if option, ok := strings.CutPrefix(line, "# "); ok && strings.Contains(option, " = ") && !strings.Contains(option, "version.txt") {
+			line = option
+		}
+		uncommented = append(uncommented, line)
+	}
+	var conf ConfigFile
+	_, err = toml.Decode(strings.Join(uncommented, "\n"), &conf)
+	require.NoError(err)
+	assert.Equal(defaultConfig.ImageVersion, conf.Base.ImageVersion)
+	assert.Equal(defaultConfig.APIMaxAttempts, conf.Base.APIMaxAttempts)
+	assert.Equal(defaultConfig.AWS.AMIName, conf.Base.AWS.AMIName)
+	assert.Equal(defaultConfig.AWS.BlobName, conf.Base.AWS.BlobName)
+	assert.Equal(defaultConfig.AWS.Publish, conf.Base.AWS.Publish)
+}
@@ -18,8 +18,31 @@ import (
 //go:embed validation.rego
 var validationPolicy string
 
+// FieldError is a validation error of a single config field.
+type FieldError struct {
+	// Variant is the name of the variant the field belongs to, empty for configs without variants.
+	Variant string
+	// Field is the path of the field in the config, e.g. azure.attestationVariant.
+	Field string
+	// Msg describes what is wrong with the value of the field.
+	Msg string
+}
+
+func (e *FieldError) Error() string {
+	msg := e.Msg
+	if e.Field != "" {
+		msg = e.Field + " " + msg
+	}
+	if e.Variant != "" {
+		msg = fmt.Sprintf("variant %q: %s", e.Variant, msg)
+	}
+	return msg
+}
+
 type Validator struct{}
 
+// Validate checks the config against the validation policy.
+// Every violation is returned as *FieldError, joined with errors.Join.
 func (v *Validator) Validate(ctx context.Context, config Config) error {
 	opts := []func(*rego.Rego){
 		rego.Query("data.config.deny"),
@@ -44,7 +67,12 @@ func (v *Validator) Validate(ctx context.Context, config Config) error {
 				switch val := v.(type) {
 				// Policies that only return a single string (e.g. deny[msg])
 				case string:
-					resErr = errors.Join(resErr, errors.New(val))
+					resErr = errors.Join(resErr, &FieldError{Msg: val})
+				// Policies that return a field_error object
+				case map[string]any:
+					field, _ := val["field"].(string)
+					msg, _ := val["msg"].(string)
+					resErr = errors.Join(resErr, &FieldError{Field: field, Msg: msg})
 				}
 			}
 		}
@@ -52,3 +80,17 @@ func (v *Validator) Validate(ctx context.Context, config Config) error {
 
 	return resErr
 }
+
+// setErrorVariant sets the variant of all field errors in the error tree of err.
+func setErrorVariant(err error, variant string) {
+	switch e := err.(type) {
+	case *FieldError:
+		e.Variant = variant
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			setErrorVariant(err, variant)
+		}
+	case interface{ Unwrap() error }:
+		setErrorVariant(e.Unwrap(), variant)
+	}
+}
@@ -5,26 +5,108 @@ import future.keywords.in
 deny[msg] {
     not input.Provider in valid_csps
 
-    msg = sprintf("cloud provider %q unknown", [input.Provider])
+    msg = field_error("provider", sprintf("%q is not one of %s", [input.Provider, valid_csps]))
 }
 
 deny[msg] {
+    not input.AllowPrerelease == true
     not regex.match(`^\d+\.\d+\.\d+$`, input.ImageVersion)
 
-    msg = sprintf("image version %q must be in format <MAJOR>.<MINOR>.<PATCH>", [input.ImageVersion])
+    msg = field_error("imageVersion", sprintf("%q must be in format <MAJOR>.<MINOR>.<PATCH>", [input.ImageVersion]))
+}
+
+deny[msg] {
+    input.AllowPrerelease == true
+    not regex.match(`^\d+\.\d+\.\d+(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`, input.ImageVersion)
+
+    msg = field_error("imageVersion", sprintf("%q must be in format <MAJOR>.<MINOR>.<PATCH>[-<PRERELEASE>][+<BUILD>]", [input.ImageVersion]))
+}
+
+# Gallery image versions must be numeric.
+deny[msg] {
+    input.Provider == "azure"
+    input.AllowPrerelease == true
+    not regex.match(`^\d+\.\d+\.\d+$`, input.ImageVersion)
+
+    msg = field_error("imageVersion", sprintf("%q must be in format <MAJOR>.<MINOR>.<PATCH> for provider azure", [input.ImageVersion]))
 }
 
 deny[msg] {
     input.Name == ""
 
-    msg = "required field name empty"
+    msg = field_error("name", "must not be empty")
+}
+
+deny[msg] {
+    input.CommitHash != ""
+    not regex.match(`^[0-9a-f]{4,64}$`, input.CommitHash)
+
+    msg = field_error("commitHash", sprintf("must be a lowercase hexadecimal git commit hash, got %q", [input.CommitHash]))
+}
+
+deny[msg] {
+    input.MaxImageSizeGiB < 0
+
+    msg = field_error("maxImageSizeGiB", sprintf("must not be negative, got %d", [input.MaxImageSizeGiB]))
+}
+
+deny[msg] {
+    is_number(input.APIMaxAttempts)
+    input.APIMaxAttempts < 1
+
+    msg = field_error("apiMaxAttempts", sprintf("must be at least 1, got %d", [input.APIMaxAttempts]))
+}
+
+deny[msg] {
+    is_number(input.PollIntervalSeconds)
+    input.PollIntervalSeconds < 1
+
+    msg = field_error("pollIntervalSeconds", sprintf("must be at least 1, got %d", [input.PollIntervalSeconds]))
+}
+
+deny[msg] {
+    is_number(input.OperationTimeoutSeconds)
+    input.OperationTimeoutSeconds < 1
+
+    msg = field_error("operationTimeoutSeconds", sprintf("must be at least 1, got %d", [input.OperationTimeoutSeconds]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some region in input.AWS.ReplicationRegions
+    aws_partition(region) != aws_partition(input.AWS.Region)
+
+    msg = field_error("aws.replicationRegions", sprintf("region %q must be in the same partition as region %q", [region, input.AWS.Region]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.EndpointURL != ""
+    not regex.match(`^https?://[^\s/?#]+(/[^\s]*)?$`, input.AWS.EndpointURL)
+
+    msg = field_error("aws.endpointURL", sprintf("must be an http or https URL, got %q", [input.AWS.EndpointURL]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    is_number(input.AWS.SnapshotImportTimeoutMinutes)
+    input.AWS.SnapshotImportTimeoutMinutes < 1
+
+    msg = field_error("aws.snapshotImportTimeoutMinutes", sprintf("must be at least 1, got %d", [input.AWS.SnapshotImportTimeoutMinutes]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.MaxConcurrentReplications < 0
+
+    msg = field_error("aws.maxConcurrentReplications", sprintf("must not be negative, got %d", [input.AWS.MaxConcurrentReplications]))
 }
 
 deny[msg] {
     input.Provider == "aws"
     some "" in input.AWS.ReplicationRegions
 
-    msg = "member of list replicationRegions empty for provider aws"
+    msg = field_error("aws.replicationRegions", "must not contain empty members")
 }
 
 deny[msg] {
@@ -32,7 +114,7 @@ deny[msg] {
     input.AWS.AMIName != ""
     not length_in_range(input.AWS.AMIName, 3, 128)
 
-    msg = sprintf("field amiName must be between 3 and 128 characters for provider aws, got %d", [count(input.AWS.AMIName)])
+    msg = field_error("aws.amiName", sprintf("must be between 3 and 128 characters, got %d", [count(input.AWS.AMIName)]))
 }
 
 deny[msg] {
@@ -40,7 +122,7 @@ deny[msg] {
     input.AWS.AMIName != ""
     not regex.match(`^[a-zA-Z0-9().\-/_]+$`, input.AWS.AMIName)
 
-    msg = sprintf("ami name %q should only contain letters, numbers, '(', ')', '.', '-', '/' and '_'", [input.AWS.AMIName])
+    msg = field_error("aws.amiName", sprintf("%q should only contain letters, numbers, '(', ')', '.', '-', '/' and '_'", [input.AWS.AMIName]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 1
@@ -49,7 +131,7 @@ deny[msg] {
     input.AWS.Bucket != ""
     not length_in_range(input.AWS.Bucket, 3, 63)
 
-    msg = sprintf("field bucket must be between 3 and 63 characters for provider aws, got %d", [count(input.AWS.Bucket)])
+    msg = field_error("aws.bucket", sprintf("must be between 3 and 63 characters, got %d", [count(input.AWS.Bucket)]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 2
@@ -58,7 +140,7 @@ deny[msg] {
     input.AWS.Bucket != ""
     not regex.match(`^[a-z0-9.\-]+$`, input.AWS.Bucket)
 
-    msg = sprintf("bucket name %q should only contain lowercase letters, numbers, dots (.) and hyphens", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q should only contain lowercase letters, numbers, dots (.) and hyphens", [input.AWS.Bucket]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 3
@@ -67,7 +149,7 @@ deny[msg] {
     input.AWS.Bucket != ""
     not begin_and_end_with(input.AWS.Bucket, lowercase_letters | digits)
 
-    msg = sprintf("bucket name %q must begin and end with a letter or number", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q must begin and end with a letter or number", [input.AWS.Bucket]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 4
@@ -75,7 +157,7 @@ deny[msg] {
     input.Provider == "aws"
     regex.match(`[.]{2}`, input.AWS.Bucket)
 
-    msg = sprintf("bucket name %q must not contain two adjacent periods", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q must not contain two adjacent periods", [input.AWS.Bucket]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 6
@@ -83,7 +165,7 @@ deny[msg] {
     input.Provider == "aws"
     regex.match(`^xn--`, input.AWS.Bucket)
 
-    msg = sprintf("bucket name %q must not start with the prefix xn--", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q must not start with the prefix xn--", [input.AWS.Bucket]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 7
@@ -91,7 +173,7 @@ deny[msg] {
     input.Provider == "aws"
     regex.match(`^sthree-`, input.AWS.Bucket)
 
-    msg = sprintf("bucket name %q must not start with the prefix sthree- and the prefix sthree-configurator", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q must not start with the prefix sthree- and the prefix sthree-configurator", [input.AWS.Bucket]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 8
@@ -99,7 +181,7 @@ deny[msg] {
     input.Provider == "aws"
     regex.match(`-s3alias$`, input.AWS.Bucket)
 
-    msg = sprintf("bucket name %q must not end with the suffix -s3alias", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q must not end with the suffix -s3alias", [input.AWS.Bucket]))
 }
 
 # https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html - 9
@@ -107,7 +189,18 @@ deny[msg] {
     input.Provider == "aws"
     regex.match(`--ol-s3$`, input.AWS.Bucket)
 
-    msg = sprintf("bucket name %q must not end with the suffix --ol-s3", [input.AWS.Bucket])
+    msg = field_error("aws.bucket", sprintf("%q must not end with the suffix --ol-s3", [input.AWS.Bucket]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some fieldName, fieldValue in {
+        "snapshotName": input.AWS.SnapshotName,
+        "dataSnapshotName": input.AWS.DataSnapshotName,
+    }
+    count(fieldValue) > 256
+
+    msg = field_error(sprintf("aws.%s", [fieldName]), sprintf("must be at most 256 characters, got %d", [count(fieldValue)]))
 }
 
 deny[msg] {
@@ -143,14 +236,215 @@ deny[msg] {
 		"eu-south-2",
     ]
 
-    msg = sprintf("%q is not a valid bucket location constraint", [ input.AWS.BucketLocationConstraint ] )
+    msg = field_error("aws.bucketLocationConstraint", sprintf("%q is not a valid bucket location constraint", [input.AWS.BucketLocationConstraint]))
+}
+
+# https://docs.aws.amazon.com/AmazonS3/latest/userguide/tagging-managing.html
+deny[msg] {
+    input.Provider == "aws"
+    count(input.AWS.BucketTags) > 50
+
+    msg = field_error("aws.bucketTags", sprintf("must have at most 50 entries, got %d", [count(input.AWS.BucketTags)]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, _ in input.AWS.BucketTags
+    not length_in_range(key, 1, 128)
+
+    msg = field_error("aws.bucketTags", sprintf("key %q must be between 1 and 128 characters", [key]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, value in input.AWS.BucketTags
+    count(value) > 256
+
+    msg = field_error("aws.bucketTags", sprintf("value for key %q must be at most 256 characters, got %d", [key, count(value)]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, _ in input.AWS.BucketTags
+    startswith(lower(key), "aws:")
+
+    msg = field_error("aws.bucketTags", sprintf("key %q must not start with the reserved prefix aws:", [key]))
+}
+
+# The tags are applied to the S3 object, which allows fewer tags and characters than EC2 resources.
+# https://docs.aws.amazon.com/AmazonS3/latest/userguide/object-tagging.html
+deny[msg] {
+    input.Provider == "aws"
+    count(input.AWS.Tags) > 10
+
+    msg = field_error("aws.tags", sprintf("must have at most 10 entries, got %d", [count(input.AWS.Tags)]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, _ in input.AWS.Tags
+    not length_in_range(key, 1, 128)
+
+    msg = field_error("aws.tags", sprintf("key %q must be between 1 and 128 characters", [key]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, value in input.AWS.Tags
+    count(value) > 256
+
+    msg = field_error("aws.tags", sprintf("value for key %q must be at most 256 characters, got %d", [key, count(value)]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, _ in input.AWS.Tags
+    startswith(lower(key), "aws:")
+
+    msg = field_error("aws.tags", sprintf("key %q must not start with the reserved prefix aws:", [key]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, value in input.AWS.Tags
+    some s in [key, value]
+    not regex.match(`^[\pL\pZ\pN_.:/=+\-@]*$`, s)
+
+    msg = field_error("aws.tags", sprintf("%q = %q must only contain letters, numbers, spaces and the characters _.:/=+-@", [key, value]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some key, _ in input.AWS.Tags
+    key == "Name"
+
+    msg = field_error("aws.tags", "key \"Name\" is reserved for the amiName")
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.DataImage != ""
+    input.AWS.DataDeviceName == aws_root_device_name
+
+    msg = field_error("aws.dataDeviceName", sprintf("%q must differ from the root device name", [input.AWS.DataDeviceName]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.DataImage != ""
+    some fieldName, fieldValue in {
+        "dataDeviceName": input.AWS.DataDeviceName,
+        "dataBlobName": input.AWS.DataBlobName,
+        "dataSnapshotName": input.AWS.DataSnapshotName,
+    }
+    fieldValue == ""
+
+    msg = field_error(sprintf("aws.%s", [fieldName]), "is required when dataImage is set")
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.DataImage != ""
+    input.AWS.DataBlobName == input.AWS.BlobName
+
+    msg = field_error("aws.dataBlobName", sprintf("%q must differ from blobName", [input.AWS.DataBlobName]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.VirtualizationType != ""
+    allowed := ["hvm", "paravirtual"]
+    not input.AWS.VirtualizationType in allowed
+
+    msg = field_error("aws.virtualizationType", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.Architecture != ""
+    allowed := ["x86_64", "arm64"]
+    not input.AWS.Architecture in allowed
+
+    msg = field_error("aws.architecture", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.EBSVolumeType != ""
+    allowed := ["standard", "io1", "io2", "gp2", "gp3", "sc1", "st1"]
+    not input.AWS.EBSVolumeType in allowed
+
+    msg = field_error("aws.ebsVolumeType", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.KMSKeyID != ""
+    not input.AWS.Encrypted == true
+
+    msg = field_error("aws.kmsKeyID", "requires encrypted to be true")
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.Encrypted == true
+    input.AWS.Publish == true
+
+    msg = field_error("aws.publish", "must be false for encrypted images")
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    some accountID in input.AWS.ShareWithAccountIDs
+    not regex.match(`^[0-9]{12}$`, accountID)
+
+    msg = field_error("aws.shareWithAccountIDs", sprintf("account ID %q must be 12 digits", [accountID]))
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.ShareWithAccountIDs[0]
+    input.AWS.Encrypted == true
+    input.AWS.KMSKeyID == ""
+
+    msg = field_error("aws.shareWithAccountIDs", "requires kmsKeyID for encrypted images")
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.VirtualizationType != ""
+    input.AWS.VirtualizationType != "hvm"
+    some fieldName, fieldValue in {
+        "enaSupport": input.AWS.EnaSupport,
+        "sriovNetSupport": input.AWS.SriovNetSupport,
+    }
+    fieldValue == true
+
+    msg = field_error(sprintf("aws.%s", [fieldName]), "requires virtualizationType hvm")
+}
+
+# Paravirtual AMIs can't boot with UEFI, which NitroTPM and a UEFI variable store rely on.
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.VirtualizationType == "paravirtual"
+    input.AWS.TPMSupport == true
+
+    msg = field_error("aws.tpmSupport", "TPM v2.0 requires UEFI boot, which virtualizationType paravirtual doesn't support")
+}
+
+deny[msg] {
+    input.Provider == "aws"
+    input.AWS.VirtualizationType == "paravirtual"
+    input.AWS.UEFIVarStoreFile != ""
+
+    msg = field_error("aws.uefiVarStoreFile", "requires UEFI boot, which virtualizationType paravirtual doesn't support")
 }
 
 deny[msg] {
     input.Provider == "aws"
     not is_boolean(input.AWS.Publish)
 
-    msg = "required field Publish uninitialized for provider aws"
+    msg = field_error("aws.publish", "must be set")
 }
 
 deny[msg] {
@@ -158,7 +452,41 @@ deny[msg] {
     input.Azure.SubscriptionID != ""
     not regex.match(`^(?:\{{0,1}(?:[0-9a-fA-F]){8}-(?:[0-9a-fA-F]){4}-(?:[0-9a-fA-F]){4}-(?:[0-9a-fA-F]){4}-(?:[0-9a-fA-F]){12}\}{0,1})$$`, input.Azure.SubscriptionID)
 
-    msg = sprintf("subscription id %q must be a valid guid for provider azure", [input.Azure.SubscriptionID])
+    msg = field_error("azure.subscriptionID", sprintf("%q must be a valid guid", [input.Azure.SubscriptionID]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    some field, value in {"tenantID": input.Azure.TenantID, "clientID": input.Azure.ClientID}
+    value != ""
+    not regex.match(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`, value)
+
+    msg = field_error(sprintf("azure.%s", [field]), sprintf("%q must be a valid guid", [value]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.ReplicaCount != 0
+    not valid_replica_count(input.Azure.ReplicaCount)
+
+    msg = field_error("azure.replicaCount", sprintf("must be between 1 and 100, got %d", [input.Azure.ReplicaCount]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    some region, count in input.Azure.ReplicaCounts
+    not valid_replica_count(count)
+
+    msg = field_error("azure.replicaCounts", sprintf("replica count for region %q must be between 1 and 100, got %d", [region, count]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    some region, _ in input.Azure.ReplicaCounts
+    region != input.Azure.Location
+    not region in input.Azure.ReplicationRegions
+
+    msg = field_error("azure.replicaCounts", sprintf("region %q is neither the location nor in replicationRegions", [region]))
 }
 
 deny[msg] {
@@ -166,7 +494,65 @@ deny[msg] {
     input.Azure.AttestationVariant != ""
     not input.Azure.AttestationVariant in ["azure-tdx", "azure-sev-snp", "azure-trustedlaunch"]
 
-    msg = sprintf("attestation variant %q must be one of %s for provider azure", [input.Azure.AttestationVariant, ["azure-tdx", "azure-sev-snp", "azure-trustedlaunch"]])
+    msg = field_error("azure.attestationVariant", sprintf("%q is not one of %s", [input.Azure.AttestationVariant, ["azure-tdx", "azure-sev-snp", "azure-trustedlaunch"]]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.HyperVGeneration != ""
+    allowed := ["V1", "V2"]
+    not input.Azure.HyperVGeneration in allowed
+
+    msg = field_error("azure.hyperVGeneration", sprintf("%q is not one of %s", [input.Azure.HyperVGeneration, allowed]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.OSType != ""
+    allowed := ["Linux", "Windows"]
+    not input.Azure.OSType in allowed
+
+    msg = field_error("azure.osType", sprintf("%q is not one of %s", [input.Azure.OSType, allowed]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.VMGSFile != ""
+    allowed := ["azure-sev-snp", "azure-tdx"]
+    not input.Azure.AttestationVariant in allowed
+
+    msg = field_error("azure.vmgsFile", sprintf("requires attestationVariant to be one of %s, got %q", [allowed, input.Azure.AttestationVariant]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.VMGSFile != ""
+    input.Azure.HyperVGeneration == "V1"
+
+    msg = field_error("azure.vmgsFile", "requires hyperVGeneration V2")
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.ResourceGroup != ""
+    not regex.match(`^[\p{L}\p{N}_().\-]*$`, input.Azure.ResourceGroup)
+
+    msg = field_error("azure.resourceGroup", sprintf("%q must contain only letters, numbers, underscores, parentheses, hyphens and periods", [input.Azure.ResourceGroup]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    endswith(input.Azure.ResourceGroup, ".")
+
+    msg = field_error("azure.resourceGroup", sprintf("%q must not end with a period", [input.Azure.ResourceGroup]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.ResourceGroup != ""
+    not length_in_range(input.Azure.ResourceGroup, 1, 90)
+
+    msg = field_error("azure.resourceGroup", sprintf("must be between 1 and 90 characters, got %d", [count(input.Azure.ResourceGroup)]))
 }
 
 deny[msg] {
@@ -174,7 +560,7 @@ deny[msg] {
     input.Azure.SharedImageGallery != ""
     not regex.match(`^[a-zA-Z0-9_.]*$`, input.Azure.SharedImageGallery)
 
-    msg = sprintf("shared image gallery %q must contain only alphanumerics, underscores and periods for provider azure", [input.Azure.SharedImageGallery])
+    msg = field_error("azure.sharedImageGallery", sprintf("%q must contain only alphanumerics, underscores and periods", [input.Azure.SharedImageGallery]))
 }
 
 deny[msg] {
@@ -182,7 +568,7 @@ deny[msg] {
     input.Azure.SharedImageGallery != ""
     not begin_and_end_with(input.Azure.SharedImageGallery, lowercase_letters | uppercase_letters | digits)
 
-    msg = sprintf("shared image gallery %q must begin and end with a letter or number", [input.Azure.SharedImageGallery])
+    msg = field_error("azure.sharedImageGallery", sprintf("%q must begin and end with a letter or number", [input.Azure.SharedImageGallery]))
 }
 
 deny[msg] {
@@ -190,16 +576,16 @@ deny[msg] {
     input.Azure.SharedImageGallery != ""
     not length_in_range(input.Azure.SharedImageGallery, 1, 80)
 
-    msg = sprintf("field sharedImageGallery must be between 1 and 80 characters for provider azure, got %d", [count(input.Azure.SharedImageGallery)])
+    msg = field_error("azure.sharedImageGallery", sprintf("must be between 1 and 80 characters, got %d", [count(input.Azure.SharedImageGallery)]))
 }
 
 deny[msg] {
     input.Provider == "azure"
     input.Azure.SharingProfile != ""
-    allowed := ["community", "private"]
+    allowed := ["community", "groups", "private"]
     not input.Azure.SharingProfile in allowed
 
-    msg = sprintf("sharing profile %q must be one of %s for provider azure", [input.Azure.SharingProfile, allowed])
+    msg = field_error("azure.sharingProfile", sprintf("%q is not one of %s", [input.Azure.SharingProfile, allowed]))
 }
 
 deny[msg] {
@@ -207,7 +593,23 @@ deny[msg] {
     input.Azure.SharingProfile == "community"
     input.Azure.SharingNamePrefix == ""
 
-    msg = "field sharingNamePrefix is required for sharing profile community and provider azure"
+    msg = field_error("azure.sharingNamePrefix", "is required for sharingProfile community")
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.SharingProfile == "groups"
+    not input.Azure.ShareWith[0]
+
+    msg = field_error("azure.shareWith", "is required for sharingProfile groups")
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    some target in input.Azure.ShareWith
+    not regex.match(`^(subscription|tenant):[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`, target)
+
+    msg = field_error("azure.shareWith", sprintf("target %q must be in the form subscription:<guid> or tenant:<guid>", [target]))
 }
 
 deny[msg] {
@@ -215,7 +617,7 @@ deny[msg] {
     input.Azure.SharingNamePrefix != ""
     not length_in_range(input.Azure.SharingNamePrefix, 5, 16)
 
-    msg = sprintf("field sharingNamePrefix must be between 5 and 16 characters for provider azure, got %d", [count(input.Azure.SharingNamePrefix)])
+    msg = field_error("azure.sharingNamePrefix", sprintf("must be between 5 and 16 characters, got %d", [count(input.Azure.SharingNamePrefix)]))
 }
 
 deny[msg] {
@@ -223,7 +625,15 @@ deny[msg] {
     input.Azure.SharingNamePrefix != ""
     not regex.match(`^[a-zA-Z0-9]*$`, input.Azure.SharingNamePrefix)
 
-    msg = sprintf("sharing name prefix %q must be alphanumeric for provider azure", [input.Azure.SharingNamePrefix])
+    msg = field_error("azure.sharingNamePrefix", sprintf("%q must be alphanumeric", [input.Azure.SharingNamePrefix]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    some prefix in input.Azure.PublicNamePrefixes
+    not regex.match(`^[a-zA-Z0-9]{5,16}$`, prefix)
+
+    msg = field_error("azure.publicNamePrefixes", sprintf("prefix %q must be alphanumeric and between 5 and 16 characters", [prefix]))
 }
 
 deny[msg] {
@@ -231,7 +641,7 @@ deny[msg] {
     input.Azure.ImageDefinitionName != ""
     not regex.match(`^[a-zA-Z0-9_\-.]*$`, input.Azure.ImageDefinitionName)
 
-    msg = sprintf("image definition name %q must contain only alphanumerics, underscores, hyphens, and periods for provider azure", [input.Azure.ImageDefinitionName])
+    msg = field_error("azure.imageDefinitionName", sprintf("%q must contain only alphanumerics, underscores, hyphens, and periods", [input.Azure.ImageDefinitionName]))
 }
 
 deny[msg] {
@@ -239,7 +649,7 @@ deny[msg] {
     input.Azure.ImageDefinitionName != ""
     not begin_and_end_with(input.Azure.ImageDefinitionName, lowercase_letters | uppercase_letters | digits)
 
-    msg = sprintf("image definition name %q must begin and end with a letter or number", [input.Azure.ImageDefinitionName])
+    msg = field_error("azure.imageDefinitionName", sprintf("%q must begin and end with a letter or number", [input.Azure.ImageDefinitionName]))
 }
 
 deny[msg] {
@@ -247,7 +657,7 @@ deny[msg] {
     input.Azure.ImageDefinitionName != ""
     not length_in_range(input.Azure.ImageDefinitionName, 1, 80)
 
-    msg = sprintf("field imageDefinitionName must be between 1 and 80 characters for provider azure, got %d", [count(input.Azure.ImageDefinitionName)])
+    msg = field_error("azure.imageDefinitionName", sprintf("must be between 1 and 80 characters, got %d", [count(input.Azure.ImageDefinitionName)]))
 }
 
 deny[msg] {
@@ -255,7 +665,7 @@ deny[msg] {
     input.Azure.DiskName != ""
     not regex.match(`^[a-zA-Z0-9_\-.]*$`, input.Azure.DiskName)
 
-    msg = sprintf("disk name %q must contain only alphanumerics, underscores, hyphens, and periods for provider azure", [input.Azure.DiskName])
+    msg = field_error("azure.diskName", sprintf("%q must contain only alphanumerics, underscores, hyphens, and periods", [input.Azure.DiskName]))
 }
 
 deny[msg] {
@@ -263,7 +673,48 @@ deny[msg] {
     input.Azure.DiskName != ""
     not length_in_range(input.Azure.DiskName, 1, 80)
 
-    msg = sprintf("field diskName must be between 1 and 80 characters for provider azure, got %d", [count(input.Azure.DiskName)])
+    msg = field_error("azure.diskName", sprintf("must be between 1 and 80 characters, got %d", [count(input.Azure.DiskName)]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.VHDCreatorApp != ""
+    not regex.match(`^[ -~]{4}$`, input.Azure.VHDCreatorApp)
+
+    msg = field_error("azure.vhdCreatorApp", sprintf("must be exactly 4 printable ASCII characters, got %q", [input.Azure.VHDCreatorApp]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.VHDUUID != ""
+    not regex.match(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`, input.Azure.VHDUUID)
+
+    msg = field_error("azure.vhdUUID", sprintf("must be a UUID of 32 hex digits, optionally separated by dashes, got %q", [input.Azure.VHDUUID]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.OSDiskSizeGB < 0
+
+    msg = field_error("azure.osDiskSizeGB", sprintf("must not be negative, got %d", [input.Azure.OSDiskSizeGB]))
+}
+
+# https://learn.microsoft.com/en-us/rest/api/compute/disks/create-or-update#diskstorageaccounttypes
+deny[msg] {
+    input.Provider == "azure"
+    some diskType in input.Azure.DisallowedDiskTypes
+    allowed := ["Standard_LRS", "Premium_LRS", "StandardSSD_LRS", "UltraSSD_LRS", "Premium_ZRS", "StandardSSD_ZRS", "PremiumV2_LRS"]
+    not diskType in allowed
+
+    msg = field_error("azure.disallowedDiskTypes", sprintf("disk type %q is not one of %s", [diskType, allowed]))
+}
+
+deny[msg] {
+    input.Provider == "azure"
+    input.Azure.DefinitionEndOfLifeDate != ""
+    not valid_date(input.Azure.DefinitionEndOfLifeDate)
+
+    msg = field_error("azure.definitionEndOfLifeDate", sprintf("%q must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", [input.Azure.DefinitionEndOfLifeDate]))
 }
 
 deny[msg] {
@@ -271,7 +722,7 @@ deny[msg] {
     input.GCP.Project != ""
     not regex.match(`^[a-z0-9\-]*$`, input.GCP.Project)
 
-    msg = sprintf("project name %q must contain only lowercase letters, digits and hyphens for provider gcp", [input.GCP.Project])
+    msg = field_error("gcp.project", sprintf("%q must contain only lowercase letters, digits and hyphens", [input.GCP.Project]))
 }
 
 deny[msg] {
@@ -280,7 +731,7 @@ deny[msg] {
     not begins_with(input.GCP.Project, lowercase_letters)
     not ends_with(input.GCP.Project, lowercase_letters | digits)
 
-    msg = sprintf("project name %q must begin with a letter and end with a letter or number", [input.GCP.Project])
+    msg = field_error("gcp.project", sprintf("%q must begin with a letter and end with a letter or number", [input.GCP.Project]))
 }
 
 deny[msg] {
@@ -288,7 +739,7 @@ deny[msg] {
     input.GCP.Project != ""
     not length_in_range(input.GCP.Project, 6, 30)
 
-    msg = sprintf("field project must be between 6 and 30 characters for provider gcp, got %d", [count(input.GCP.Project)])
+    msg = field_error("gcp.project", sprintf("must be between 6 and 30 characters, got %d", [count(input.GCP.Project)]))
 }
 
 deny[msg] {
@@ -296,7 +747,7 @@ deny[msg] {
     input.GCP.ImageName != ""
     not regex.match(`^[a-z0-9\-]*$`, input.GCP.ImageName)
 
-    msg = sprintf("image name %q must contain only alphanumerics, underscores, hyphens, and periods for provider gcp", [input.GCP.ImageName])
+    msg = field_error("gcp.imageName", sprintf("%q must contain only alphanumerics, underscores, hyphens, and periods", [input.GCP.ImageName]))
 }
 
 deny[msg] {
@@ -305,7 +756,7 @@ deny[msg] {
     not begins_with(input.GCP.ImageName, lowercase_letters)
     not ends_with(input.GCP.ImageName, lowercase_letters | digits)
 
-    msg = sprintf("image name %q must begin with a letter and end with a letter or number", [input.GCP.ImageName])
+    msg = field_error("gcp.imageName", sprintf("%q must begin with a letter and end with a letter or number", [input.GCP.ImageName]))
 }
 
 deny[msg] {
@@ -313,7 +764,7 @@ deny[msg] {
     input.GCP.ImageName != ""
     not length_in_range(input.GCP.ImageName, 1, 63)
 
-    msg = sprintf("field imageName must be between 1 and 63 characters for provider gcp, got %d", [count(input.GCP.ImageName)])
+    msg = field_error("gcp.imageName", sprintf("must be between 1 and 63 characters, got %d", [count(input.GCP.ImageName)]))
 }
 
 deny[msg] {
@@ -321,7 +772,7 @@ deny[msg] {
     input.GCP.ImageFamily != ""
     not regex.match(`^[a-z0-9\-]*$`, input.GCP.ImageFamily)
 
-    msg = sprintf("image family %q must contain only alphanumerics, underscores, hyphens, and periods for provider gcp", [input.GCP.ImageFamily])
+    msg = field_error("gcp.imageFamily", sprintf("%q must contain only alphanumerics, underscores, hyphens, and periods", [input.GCP.ImageFamily]))
 }
 
 deny[msg] {
@@ -330,7 +781,7 @@ deny[msg] {
     not begins_with(input.GCP.ImageFamily, lowercase_letters)
     not ends_with(input.GCP.ImageFamily, lowercase_letters | digits)
 
-    msg = sprintf("image family %q must begin with a letter and end with a letter or number", [input.GCP.ImageFamily])
+    msg = field_error("gcp.imageFamily", sprintf("%q must begin with a letter and end with a letter or number", [input.GCP.ImageFamily]))
 }
 
 deny[msg] {
@@ -338,7 +789,7 @@ deny[msg] {
     input.GCP.ImageFamily != ""
     not length_in_range(input.GCP.ImageFamily, 1, 63)
 
-    msg = sprintf("field imageFamily must be between 1 and 63 characters for provider gcp, got %d", [count(input.GCP.ImageFamily)])
+    msg = field_error("gcp.imageFamily", sprintf("must be between 1 and 63 characters, got %d", [count(input.GCP.ImageFamily)]))
 }
 
 deny[msg] {
@@ -346,7 +797,7 @@ deny[msg] {
     input.GCP.Bucket != ""
     not regex.match(`^[a-z0-9\-_.]*$`, input.GCP.Bucket)
 
-    msg = sprintf("bucket %q must contain only alphanumerics, underscores, hyphens, and periods for provider gcp", [input.GCP.Bucket])
+    msg = field_error("gcp.bucket", sprintf("%q must contain only alphanumerics, underscores, hyphens, and periods", [input.GCP.Bucket]))
 }
 
 deny[msg] {
@@ -354,7 +805,7 @@ deny[msg] {
     input.GCP.Bucket != ""
     not begin_and_end_with(input.GCP.Bucket, lowercase_letters | digits)
 
-    msg = sprintf("bucket %q must begin with a letter and end with a letter or number", [input.GCP.Bucket])
+    msg = field_error("gcp.bucket", sprintf("%q must begin with a letter and end with a letter or number", [input.GCP.Bucket]))
 }
 
 deny[msg] {
@@ -362,7 +813,215 @@ deny[msg] {
     input.GCP.Bucket != ""
     not length_in_range(input.GCP.Bucket, 3, 63)
 
-    msg = sprintf("field bucket must be between 1 and 63 characters for provider gcp, got %d", [count(input.GCP.Bucket)])
+    msg = field_error("gcp.bucket", sprintf("must be between 1 and 63 characters, got %d", [count(input.GCP.Bucket)]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.SourceImage != ""
+    input.GCP.SourceDisk != ""
+
+    msg = field_error("gcp.sourceDisk", "must not be set together with sourceImage")
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.SourceImage == ""
+    input.GCP.SourceDisk == ""
+    some fieldName, fieldValue in {
+        "bucket": input.GCP.Bucket,
+        "blobName": input.GCP.BlobName,
+    }
+    fieldValue == ""
+
+    msg = field_error(sprintf("gcp.%s", [fieldName]), "is required without sourceImage or sourceDisk")
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.State != ""
+    allowed := ["ACTIVE", "DEPRECATED", "OBSOLETE", "DELETED"]
+    not input.GCP.State in allowed
+
+    msg = field_error("gcp.state", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.State in ["DEPRECATED", "OBSOLETE"]
+    input.GCP.Replacement == ""
+
+    msg = field_error("gcp.replacement", sprintf("is required for state %s", [input.GCP.State]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    count(input.GCP.DeprecateImages) > 0
+    allowed := ["DEPRECATED", "OBSOLETE", "DELETED"]
+    not input.GCP.DeprecateImagesState in allowed
+
+    msg = field_error("gcp.deprecateImagesState", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some "" in input.GCP.DeprecateImages
+
+    msg = field_error("gcp.deprecateImages", "must not contain empty members")
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.ImageName in input.GCP.DeprecateImages
+
+    msg = field_error("gcp.deprecateImages", sprintf("must not contain the image %q itself", [input.GCP.ImageName]))
+}
+
+# Regions like europe-west3 or multi-regions like eu.
+deny[msg] {
+    input.Provider == "gcp"
+    some location in input.GCP.StorageLocations
+    not regex.match(`^[a-z]+(-[a-z]+[0-9]+)?$`, location)
+
+    msg = field_error("gcp.storageLocations", sprintf("location %q must be a region or multi-region", [location]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.Architecture != ""
+    allowed := ["x86_64", "arm64"]
+    not input.GCP.Architecture in allowed
+
+    msg = field_error("gcp.architecture", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some member in input.GCP.ImageUsers
+    not regex.match(`^(user|group|serviceAccount|domain):.+$`, member)
+
+    msg = field_error("gcp.imageUsers", sprintf("member %q must be in the form user:<email>, group:<email>, serviceAccount:<email> or domain:<domain>", [member]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some feature in input.GCP.GuestOSFeatures
+    allowed := [
+        "GVNIC",
+        "IDPF",
+        "MULTI_IP_SUBNET",
+        "SECURE_BOOT",
+        "SEV_CAPABLE",
+        "SEV_LIVE_MIGRATABLE",
+        "SEV_LIVE_MIGRATABLE_V2",
+        "SEV_SNP_CAPABLE",
+        "TDX_CAPABLE",
+        "UEFI_COMPATIBLE",
+        "VIRTIO_SCSI_MULTIQUEUE",
+        "WINDOWS",
+    ]
+    not feature in allowed
+
+    msg = field_error("gcp.guestOSFeatures", sprintf("feature %q is not one of %s", [feature, allowed]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.SecureBoot.Enabled == true
+    input.GCP.GuestOSFeatures[_]
+    not "UEFI_COMPATIBLE" in input.GCP.GuestOSFeatures
+
+    msg = field_error("gcp.guestOSFeatures", "must contain UEFI_COMPATIBLE if secureBoot.enabled is set")
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    not input.GCP.SecureBoot.Enabled == true
+    some fieldName, fieldValue in {
+        "pkFile": input.GCP.SecureBoot.PKFile,
+        "kekFiles": input.GCP.SecureBoot.KEKFiles,
+        "dbFiles": input.GCP.SecureBoot.DBFiles,
+        "dbxFiles": input.GCP.SecureBoot.DBXFiles,
+    }
+    not fieldValue in ["", null, []]
+
+    msg = field_error(sprintf("gcp.secureBoot.%s", [fieldName]), "requires secureBoot.enabled")
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some fieldName, files in {
+        "kekFiles": input.GCP.SecureBoot.KEKFiles,
+        "dbFiles": input.GCP.SecureBoot.DBFiles,
+        "dbxFiles": input.GCP.SecureBoot.DBXFiles,
+    }
+    some "" in files
+
+    msg = field_error(sprintf("gcp.secureBoot.%s", [fieldName]), "must not contain empty members")
+}
+
+# https://cloud.google.com/compute/docs/labeling-resources#requirements
+deny[msg] {
+    input.Provider == "gcp"
+    count(input.GCP.Labels) > 64
+
+    msg = field_error("gcp.labels", sprintf("must have at most 64 entries, got %d", [count(input.GCP.Labels)]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some key, _ in input.GCP.Labels
+    not regex.match(`^[a-z][a-z0-9_\-]{0,62}$`, key)
+
+    msg = field_error("gcp.labels", sprintf("key %q must begin with a lowercase letter, contain only lowercase letters, digits, underscores and hyphens and be at most 63 characters", [key]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some key, value in input.GCP.Labels
+    not regex.match(`^[a-z0-9_\-]{0,63}$`, value)
+
+    msg = field_error("gcp.labels", sprintf("value %q for key %q must contain only lowercase letters, digits, underscores and hyphens and be at most 63 characters", [value, key]))
+}
+
+# https://cloud.google.com/storage/docs/tags-and-labels#bucket-labels
+deny[msg] {
+    input.Provider == "gcp"
+    count(input.GCP.BucketLabels) > 64
+
+    msg = field_error("gcp.bucketLabels", sprintf("must have at most 64 entries, got %d", [count(input.GCP.BucketLabels)]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some key, _ in input.GCP.BucketLabels
+    not regex.match(`^[a-z][a-z0-9_\-]{0,62}$`, key)
+
+    msg = field_error("gcp.bucketLabels", sprintf("key %q must begin with a lowercase letter, contain only lowercase letters, digits, underscores and hyphens and be at most 63 characters", [key]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    some key, value in input.GCP.BucketLabels
+    not regex.match(`^[a-z0-9_\-]{0,63}$`, value)
+
+    msg = field_error("gcp.bucketLabels", sprintf("value %q for key %q must contain only lowercase letters, digits, underscores and hyphens and be at most 63 characters", [value, key]))
+}
+
+deny[msg] {
+    input.Provider == "gcp"
+    input.GCP.PublicAccessPrevention != ""
+    allowed := ["enforced", "inherited"]
+    not input.GCP.PublicAccessPrevention in allowed
+
+    msg = field_error("gcp.publicAccessPrevention", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    count(input.OpenStack.ImageName) > 255
+
+    msg = field_error("openstack.imageName", sprintf("must be at most 255 characters, got %d", [count(input.OpenStack.ImageName)]))
 }
 
 deny[msg] {
@@ -371,7 +1030,143 @@ deny[msg] {
     allowed := ["public", "private", "shared", "community"]
     not input.OpenStack.Visibility in allowed
 
-    msg = sprintf("field visibility must be one of %s for provider openstack", allowed)
+    msg = field_error("openstack.visibility", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.Architecture != ""
+    allowed := ["x86_64", "aarch64", "i686", "ppc64le", "s390x", "riscv64"]
+    not input.OpenStack.Architecture in allowed
+
+    msg = field_error("openstack.architecture", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.FirmwareType != ""
+    allowed := ["bios", "uefi"]
+    not input.OpenStack.FirmwareType in allowed
+
+    msg = field_error("openstack.firmwareType", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.HypervisorType != ""
+    allowed := ["kvm", "qemu", "xen", "vmware", "hyperv", "lxc", "ironic"]
+    not input.OpenStack.HypervisorType in allowed
+
+    msg = field_error("openstack.hypervisorType", sprintf("must be one of %s", [allowed]))
+}
+
+# The typed image metadata is merged into the properties, so a property with the same key must not differ.
+deny[msg] {
+    input.Provider == "openstack"
+    typed := {
+        "architecture": ["architecture", input.OpenStack.Architecture],
+        "hw_firmware_type": ["firmwareType", input.OpenStack.FirmwareType],
+        "hypervisor_type": ["hypervisorType", input.OpenStack.HypervisorType],
+    }
+    some key
+    [field, value] := typed[key]
+    value != ""
+    property := input.OpenStack.Properties[key]
+    property != value
+
+    msg = field_error(sprintf("openstack.properties.%s", [key]), sprintf("%q conflicts with openstack.%s %q", [property, field, value]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.HashAlgorithm != ""
+    allowed := ["sha256", "sha512"]
+    not input.OpenStack.HashAlgorithm in allowed
+
+    msg = field_error("openstack.hashAlgorithm", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    is_number(input.OpenStack.UploadRetries)
+    input.OpenStack.UploadRetries < 0
+
+    msg = field_error("openstack.uploadRetries", sprintf("must not be negative, got %d", [input.OpenStack.UploadRetries]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.DiskFormat != ""
+    allowed := ["ami", "ari", "aki", "vhd", "vhdx", "vmdk", "raw", "qcow2", "vdi", "iso", "ploop"]
+    not input.OpenStack.DiskFormat in allowed
+
+    msg = field_error("openstack.diskFormat", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ContainerFormat != ""
+    allowed := ["ami", "ari", "aki", "bare", "ovf", "ova", "docker", "compressed"]
+    not input.OpenStack.ContainerFormat in allowed
+
+    msg = field_error("openstack.containerFormat", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    not input.OpenStack.ContainerFormat in ["", "bare"]
+    input.OpenStack.DiskFormat == ""
+
+    msg = field_error("openstack.diskFormat", sprintf("is required for containerFormat %s", [input.OpenStack.ContainerFormat]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ConvertToQCOW2 == true
+    not input.OpenStack.DiskFormat in ["", "qcow2"]
+
+    msg = field_error("openstack.diskFormat", sprintf("must be qcow2 if convertToQCOW2 is set, got %s", [input.OpenStack.DiskFormat]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ConvertToQCOW2 == true
+    not input.OpenStack.ContainerFormat in ["", "bare"]
+
+    msg = field_error("openstack.containerFormat", sprintf("must be bare if convertToQCOW2 is set, got %s", [input.OpenStack.ContainerFormat]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ConvertToQCOW2 == true
+    input.OpenStack.ImportMethod == "web-download"
+
+    msg = field_error("openstack.convertToQCOW2", "is not supported for importMethod web-download")
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ImportMethod != ""
+    allowed := ["direct", "web-download"]
+    not input.OpenStack.ImportMethod in allowed
+
+    msg = field_error("openstack.importMethod", sprintf("must be one of %s", [allowed]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ImportMethod == "web-download"
+    not regex.match(`^https?://.+`, input.OpenStack.SourceURL)
+
+    msg = field_error("openstack.sourceURL", sprintf("must be an http or https URL for importMethod web-download, got %q", [input.OpenStack.SourceURL]))
+}
+
+deny[msg] {
+    input.Provider == "openstack"
+    input.OpenStack.ImportMethod != "web-download"
+    input.OpenStack.SourceURL != ""
+
+    msg = field_error("openstack.sourceURL", "is only supported for importMethod web-download")
 }
 
 deny[msg] {
@@ -380,9 +1175,12 @@ deny[msg] {
     some fieldName, fieldValue in required_fields[provider]
     fieldValue == ""
 
-    msg = sprintf("required field %q empty for provider %s", [fieldName, input.Provider])
+    msg = field_error(sprintf("%s.%s", [input.Provider, fieldName]), "is required")
 }
 
+# field_error is a deny message for the field at the given path, e.g. "azure.attestationVariant".
+field_error(field, msg) = {"field": field, "msg": msg}
+
 length_in_range(s, min_len, max_len) = in_range {
     length := count(s)
     in_range := all([min_len <= length, length <= max_len])
@@ -403,6 +1201,29 @@ begin_and_end_with(s, charset) = begin_and_end {
     ])
 }
 
+valid_replica_count(count) {
+    count >= 1
+    count <= 100
+}
+
+valid_date(d) {
+    time.parse_rfc3339_ns(d)
+}
+
+valid_date(d) {
+    time.parse_ns("2006-01-02", d)
+}
+
+aws_partition(region) = "aws-us-gov" {
+    startswith(region, "us-gov-")
+} else = "aws-cn" {
+    startswith(region, "cn-")
+} else = "aws"
+
+aws_root_device_name = input.AWS.RootDeviceName {
+    input.AWS.RootDeviceName != ""
+} else = "/dev/xvda"
+
 valid_csps := [ "aws", "azure", "gcp", "openstack" ]
 
 required_fields := {
@@ -434,8 +1255,6 @@ required_fields := {
         "location": input.GCP.Location,
         "imageName": input.GCP.ImageName,
         "imageFamily": input.GCP.ImageFamily,
-        "bucket": input.GCP.Bucket,
-        "blobName": input.GCP.BlobName,
     },
     "openstack": {
         "cloud": input.OpenStack.Cloud,
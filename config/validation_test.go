@@ -8,10 +8,13 @@ package config
 
 import (
 	"context"
+	"io"
+	"os"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestValidate(t *testing.T) {
@@ -39,6 +42,31 @@ func TestValidate(t *testing.T) {
 			base:      validConfig(),
 			overrides: Config{Provider: "gcp"},
 		},
+		"valid OpenStack config": {
+			base:      validConfig(),
+			overrides: Config{Provider: "openstack"},
+		},
+		"missing OpenStack cloud": {
+			base:      validConfig(),
+			overrides: Config{Provider: "openstack"},
+			mutation:  func(c *Config) { c.OpenStack.Cloud = "" },
+			wantErr:   true,
+		},
+		"missing OpenStack imageName": {
+			base:      validConfig(),
+			overrides: Config{Provider: "openstack"},
+			mutation:  func(c *Config) { c.OpenStack.ImageName = "" },
+			wantErr:   true,
+		},
+		"valid OpenStack visibility": {
+			base:      validConfig(),
+			overrides: Config{Provider: "openstack", OpenStack: OpenStackConfig{Visibility: "community"}},
+		},
+		"invalid OpenStack visibility": {
+			base:      validConfig(),
+			overrides: Config{Provider: "openstack", OpenStack: OpenStackConfig{Visibility: "internal"}},
+			wantErr:   true,
+		},
 		"unknown provider": {
 			base:      validConfig(),
 			overrides: Config{Provider: "foo"},
@@ -54,273 +82,1390 @@ func TestValidate(t *testing.T) {
 			overrides: Config{ImageVersion: "v1.2.3-dev"},
 			wantErr:   true,
 		},
+		"prerelease version not allowed": {
+			base:      validConfig(),
+			overrides: Config{ImageVersion: "1.2.3-rc1"},
+			wantErr:   true,
+		},
+		"prerelease version allowed": {
+			base:      validConfig(),
+			overrides: Config{ImageVersion: "1.2.3-rc.1", AllowPrerelease: Some(true)},
+		},
+		"prerelease version with build allowed": {
+			base:      validConfig(),
+			overrides: Config{ImageVersion: "1.2.3-rc.1+build.5", AllowPrerelease: Some(true)},
+			mutation:  func(c *Config) { c.AWS.AMIName = "my-ami" },
+		},
+		"prerelease version with leading v": {
+			base:      validConfig(),
+			overrides: Config{ImageVersion: "v1.2.3-rc.1", AllowPrerelease: Some(true)},
+			wantErr:   true,
+		},
+		"prerelease version with empty identifier": {
+			base:      validConfig(),
+			overrides: Config{ImageVersion: "1.2.3-", AllowPrerelease: Some(true)},
+			wantErr:   true,
+		},
+		"prerelease version for Azure": {
+			base:      validConfig(),
+			overrides: Config{Provider: "azure", ImageVersion: "1.2.3-rc.1", AllowPrerelease: Some(true)},
+			wantErr:   true,
+		},
 		"missing name": {
 			base:     validConfig(),
 			mutation: func(c *Config) { c.Name = "" },
 			wantErr:  true,
 		},
+		"commitHash": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.CommitHash = "0123456789abcdef0123456789abcdef01234567" },
+		},
+		"invalid commitHash": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.CommitHash = "main" },
+			wantErr:  true,
+		},
+		"negative maxImageSizeGiB": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.MaxImageSizeGiB = -1 },
+			wantErr:  true,
+		},
+		"single apiMaxAttempts": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.APIMaxAttempts = Some(1) },
+		},
+		"zero apiMaxAttempts": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.APIMaxAttempts = Some(0) },
+			wantErr:  true,
+		},
+		"pollIntervalSeconds": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.PollIntervalSeconds = Some(1) },
+		},
+		"zero pollIntervalSeconds": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.PollIntervalSeconds = Some(0) },
+			wantErr:  true,
+		},
+		"operationTimeoutSeconds": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.OperationTimeoutSeconds = Some(3600) },
+		},
+		"negative operationTimeoutSeconds": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.OperationTimeoutSeconds = Some(-1) },
+			wantErr:  true,
+		},
+		"AWS endpointURL": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{EndpointURL: "http://localhost:4566"}},
+		},
+		"AWS endpointURL without scheme": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{EndpointURL: "localhost:4566"}},
+			wantErr:   true,
+		},
+		"AWS endpointURL with invalid scheme": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{EndpointURL: "ftp://localhost"}},
+			wantErr:   true,
+		},
+		"AWS snapshotImportTimeoutMinutes": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{SnapshotImportTimeoutMinutes: Some(120)}},
+		},
+		"zero AWS snapshotImportTimeoutMinutes": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{SnapshotImportTimeoutMinutes: Some(0)}},
+			wantErr:   true,
+		},
 		"missing AWS region": {
 			base:      validConfig(),
 			overrides: Config{Provider: "aws"},
 			mutation: func(c *Config) {
-				c.AWS.Region = ""
+				c.AWS.Region = ""
+			},
+			wantErr: true,
+		},
+		"limited AWS maxConcurrentReplications": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{MaxConcurrentReplications: 4}},
+		},
+		"negative AWS maxConcurrentReplications": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{MaxConcurrentReplications: -1}},
+			wantErr:   true,
+		},
+		"AWS GovCloud regions": {
+			base: validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{
+				Region:             "us-gov-west-1",
+				ReplicationRegions: []string{"us-gov-east-1"},
+			}},
+		},
+		"AWS replication region in other partition": {
+			base: validConfig(),
+			overrides: Config{Provider: "aws", AWS: AWSConfig{
+				Region:             "cn-north-1",
+				ReplicationRegions: []string{"cn-northwest-1", "eu-central-1"},
+			}},
+			wantErr: true,
+		},
+		"missing AWS amiName": {
+			base:      validConfig(),
+			overrides: Config{Provider: "aws"},
+			mutation: func(c *Config) {
+				c.AWS.AMIName = ""
+			},
+			wantErr: true,
+		},
+		"wrong length AWS amiName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					AMIName: strings.Repeat("a", 129),
+				},
+			},
+			wantErr: true,
+		},
+		"invalid AWS amiName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					AMIName: "invalid<ami_name",
+				},
+			},
+			wantErr: true,
+		},
+		"missing AWS blobName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+			},
+			mutation: func(c *Config) {
+				c.AWS.BlobName = ""
+			},
+			wantErr: true,
+		},
+		"missing AWS snapshotName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+			},
+			mutation: func(c *Config) {
+				c.AWS.SnapshotName = ""
+			},
+			wantErr: true,
+		},
+		"valid AWS data image": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					DataImage:        "data.raw",
+					DataDeviceName:   "/dev/xvdb",
+					DataBlobName:     "my-data-blob",
+					DataSnapshotName: "my-data-snapshot",
+				},
+			},
+		},
+		"AWS data device name equals root device": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					DataImage:        "data.raw",
+					DataDeviceName:   "/dev/xvda",
+					DataBlobName:     "my-data-blob",
+					DataSnapshotName: "my-data-snapshot",
+				},
+			},
+			wantErr: true,
+		},
+		"AWS data device name equals custom root device": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					RootDeviceName:   "/dev/sda1",
+					DataImage:        "data.raw",
+					DataDeviceName:   "/dev/sda1",
+					DataBlobName:     "my-data-blob",
+					DataSnapshotName: "my-data-snapshot",
+				},
+			},
+			wantErr: true,
+		},
+		"valid AWS arm64 architecture": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.Architecture = "arm64" },
+		},
+		"invalid AWS architecture": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.Architecture = "aarch64" },
+			wantErr:  true,
+		},
+		"AWS data blob name equals blob name": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					DataImage:        "data.raw",
+					DataDeviceName:   "/dev/xvdb",
+					DataBlobName:     "my-blob",
+					DataSnapshotName: "my-data-snapshot",
+				},
+			},
+			wantErr: true,
+		},
+		"valid AWS bucketTags": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{BucketTags: map[string]string{"team": "os", "empty": ""}},
+			},
+		},
+		"AWS bucketTags with reserved prefix": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{BucketTags: map[string]string{"aws:team": "os"}},
+			},
+			wantErr: true,
+		},
+		"AWS bucketTags value too long": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{BucketTags: map[string]string{"team": strings.Repeat("a", 257)}},
+			},
+			wantErr: true,
+		},
+		"AWS snapshotName too long": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{SnapshotName: strings.Repeat("a", 257)},
+			},
+			wantErr: true,
+		},
+		"valid AWS tags": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{Tags: map[string]string{"cost-center": "1234", "team": "OS Team", "path": "a/b:c@d+e=f", "empty": ""}},
+			},
+		},
+		"AWS tags with reserved prefix": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{Tags: map[string]string{"AWS:team": "os"}},
+			},
+			wantErr: true,
+		},
+		"AWS tags with Name key": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{Tags: map[string]string{"Name": "image"}},
+			},
+			wantErr: true,
+		},
+		"AWS tags with invalid characters": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{Tags: map[string]string{"team": "os&more"}},
+			},
+			wantErr: true,
+		},
+		"AWS tags value too long": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{Tags: map[string]string{"team": strings.Repeat("a", 257)}},
+			},
+			wantErr: true,
+		},
+		"too many AWS tags": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{Tags: map[string]string{
+					"a": "", "b": "", "c": "", "d": "", "e": "", "f": "", "g": "", "h": "", "i": "", "j": "", "k": "",
+				}},
+			},
+			wantErr: true,
+		},
+		"valid AWS paravirtual image": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					EnaSupport:      Some(false),
+					SriovNetSupport: Some(false),
+					TPMSupport:      Some(false),
+				},
+			},
+			mutation: func(c *Config) {
+				c.AWS.VirtualizationType = "paravirtual"
+			},
+		},
+		"invalid AWS virtualizationType": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.VirtualizationType = "pv" },
+			wantErr:  true,
+		},
+		"valid AWS ebsVolumeType": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.EBSVolumeType = "gp3" },
+		},
+		"invalid AWS ebsVolumeType": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.EBSVolumeType = "gp4" },
+			wantErr:  true,
+		},
+		"AWS encrypted with kmsKeyID": {
+			base: validConfig(),
+			mutation: func(c *Config) {
+				c.AWS.Publish = Some(false)
+				c.AWS.Encrypted = Some(true)
+				c.AWS.KMSKeyID = "alias/my-key"
+			},
+		},
+		"AWS kmsKeyID without encrypted": {
+			base: validConfig(),
+			mutation: func(c *Config) {
+				c.AWS.Publish = Some(false)
+				c.AWS.KMSKeyID = "alias/my-key"
+			},
+			wantErr: true,
+		},
+		"AWS encrypted and published": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.Encrypted = Some(true) },
+			wantErr:  true,
+		},
+		"AWS shareWithAccountIDs": {
+			base: validConfig(),
+			mutation: func(c *Config) {
+				c.AWS.Publish = Some(false)
+				c.AWS.ShareWithAccountIDs = []string{"123456789012", "210987654321"}
+			},
+		},
+		"AWS shareWithAccountIDs invalid account ID": {
+			base:     validConfig(),
+			mutation: func(c *Config) { c.AWS.ShareWithAccountIDs = []string{"12345678901"} },
+			wantErr:  true,
+		},
+		"AWS shareWithAccountIDs encrypted without kmsKeyID": {
+			base: validConfig(),
+			mutation: func(c *Config) {
+				c.AWS.Publish = Some(false)
+				c.AWS.Encrypted = Some(true)
+				c.AWS.ShareWithAccountIDs = []string{"123456789012"}
+			},
+			wantErr: true,
+		},
+		"AWS shareWithAccountIDs encrypted with kmsKeyID": {
+			base: validConfig(),
+			mutation: func(c *Config) {
+				c.AWS.Publish = Some(false)
+				c.AWS.Encrypted = Some(true)
+				c.AWS.KMSKeyID = "alias/my-key"
+				c.AWS.ShareWithAccountIDs = []string{"123456789012"}
+			},
+		},
+		"AWS enaSupport without hvm": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{EnaSupport: Some(true)},
+			},
+			mutation: func(c *Config) {
+				c.AWS.VirtualizationType = "paravirtual"
+			},
+			wantErr: true,
+		},
+		"AWS sriovNetSupport without hvm": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS:      AWSConfig{SriovNetSupport: Some(true)},
+			},
+			mutation: func(c *Config) {
+				c.AWS.VirtualizationType = "paravirtual"
+			},
+			wantErr: true,
+		},
+		"AWS tpmSupport without hvm": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					EnaSupport: Some(false),
+					TPMSupport: Some(true),
+				},
+			},
+			mutation: func(c *Config) {
+				c.AWS.VirtualizationType = "paravirtual"
+			},
+			wantErr: true,
+		},
+		"AWS uefiVarStoreFile without hvm": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+				AWS: AWSConfig{
+					EnaSupport: Some(false),
+					TPMSupport: Some(false),
+				},
+			},
+			mutation: func(c *Config) {
+				c.AWS.VirtualizationType = "paravirtual"
+				c.AWS.UEFIVarStoreFile = "uefi.b64"
+			},
+			wantErr: true,
+		},
+		"uninitialized AWS Publish setting": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "aws",
+			},
+			mutation: func(c *Config) {
+				c.AWS.Publish = None[bool]()
+			},
+			wantErr: true,
+		},
+		"missing Azure subscriptionID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.SubscriptionID = ""
+			},
+			wantErr: true,
+		},
+		"invalid Azure subscriptionID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{SubscriptionID: "invalid"},
+			},
+			wantErr: true,
+		},
+		"valid Azure tenantID and clientID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					TenantID: "11111111-1111-1111-1111-111111111111",
+					ClientID: "22222222-2222-2222-2222-222222222222",
+				},
+			},
+		},
+		"invalid Azure tenantID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{TenantID: "contoso.onmicrosoft.com"},
+			},
+			wantErr: true,
+		},
+		"invalid Azure clientID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ClientID: "my-app"},
+			},
+			wantErr: true,
+		},
+		"missing Azure location": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.Location = ""
+			},
+			wantErr: true,
+		},
+		"missing Azure resourceGroup": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.ResourceGroup = ""
+			},
+			wantErr: true,
+		},
+		"missing Azure attestationVariant": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.AttestationVariant = ""
+			},
+			wantErr: true,
+		},
+		"invalid Azure attestationVariant": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{AttestationVariant: "invalid"},
+			},
+			wantErr: true,
+		},
+		"Azure resourceGroup with parentheses": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ResourceGroup: "rg-(images)_1.0"},
+			},
+		},
+		"Azure resourceGroup with invalid characters": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ResourceGroup: "rg+images"},
+			},
+			wantErr: true,
+		},
+		"Azure resourceGroup ending with period": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ResourceGroup: "rg."},
+			},
+			wantErr: true,
+		},
+		"Azure resourceGroup too long": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ResourceGroup: strings.Repeat("a", 91)},
+			},
+			wantErr: true,
+		},
+		"Azure replica counts": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					ReplicationRegions: []string{"eastus2"},
+					ReplicaCount:       3,
+					ReplicaCounts:      map[string]int{"eastus2": 10},
+				},
+			},
+		},
+		"Azure replicaCount too high": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ReplicaCount: 101},
+			},
+			wantErr: true,
+		},
+		"Azure replicaCounts zero": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					ReplicationRegions: []string{"eastus2"},
+					ReplicaCounts:      map[string]int{"eastus2": 0},
+				},
+			},
+			wantErr: true,
+		},
+		"Azure replicaCounts for unknown region": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{ReplicaCounts: map[string]int{"eastus2": 2}},
+			},
+			wantErr: true,
+		},
+		"Azure generation 1 Windows image": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{HyperVGeneration: "V1", OSType: "Windows"},
+			},
+		},
+		"Azure vmgsFile": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VMGSFile: "image.vmgs", AttestationVariant: "azure-tdx"},
+			},
+		},
+		"Azure vmgsFile with trusted launch": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VMGSFile: "image.vmgs", AttestationVariant: "azure-trustedlaunch"},
+			},
+			wantErr: true,
+		},
+		"Azure vmgsFile with generation 1": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VMGSFile: "image.vmgs", HyperVGeneration: "V1"},
+			},
+			wantErr: true,
+		},
+		"invalid Azure hyperVGeneration": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{HyperVGeneration: "V3"},
+			},
+			wantErr: true,
+		},
+		"invalid Azure osType": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{OSType: "linux"},
+			},
+			wantErr: true,
+		},
+		"missing Azure sharedImageGallery": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.SharedImageGallery = ""
+			},
+			wantErr: true,
+		},
+		"missing Azure sharingProfile": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.SharedImageGallery = ""
+			},
+			wantErr: true,
+		},
+		"Azure sharingProfile groups": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					SharingProfile: "groups",
+					ShareWith: []string{
+						"subscription:00000000-0000-0000-0000-000000000000",
+						"tenant:11111111-1111-1111-1111-111111111111",
+					},
+				},
+			},
+		},
+		"Azure sharingProfile groups without shareWith": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{SharingProfile: "groups"},
+			},
+			wantErr: true,
+		},
+		"invalid Azure shareWith": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					SharingProfile: "groups",
+					ShareWith:      []string{"00000000-0000-0000-0000-000000000000"},
+				},
+			},
+			wantErr: true,
+		},
+		"invalid Azure sharingProfile": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{SharingProfile: "invalid"},
+			},
+			wantErr: true,
+		},
+		"missing Azure sharingNamePrefix with sharingProfile community": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					SharingProfile: "community",
+				},
+			},
+			mutation: func(c *Config) {
+				c.Azure.SharingNamePrefix = ""
+			},
+			wantErr: true,
+		},
+		"missing Azure sharingNamePrefix with sharingProfile private": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					SharingProfile: "private",
+				},
+			},
+			mutation: func(c *Config) {
+				c.Azure.SharingNamePrefix = ""
+			},
+		},
+		"missing Azure imageDefinitionName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.ImageDefinitionName = ""
+			},
+			wantErr: true,
+		},
+		"missing Azure diskName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+			},
+			mutation: func(c *Config) {
+				c.Azure.DiskName = ""
+			},
+			wantErr: true,
+		},
+		"valid Azure publicNamePrefixes": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{PublicNamePrefixes: []string{"myimage", "otherimage"}},
+			},
+		},
+		"invalid Azure publicNamePrefixes": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{PublicNamePrefixes: []string{"myimage", "my-image"}},
+			},
+			wantErr: true,
+		},
+		"valid Azure vhdCreatorApp": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VHDCreatorApp: "myos"},
+			},
+		},
+		"wrong length Azure vhdCreatorApp": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VHDCreatorApp: "my-os"},
+			},
+			wantErr: true,
+		},
+		"valid Azure vhdUUID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VHDUUID: "0123abcd-4567-89ef-0123-456789ABCDEF"},
+			},
+		},
+		"valid Azure vhdUUID without dashes": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VHDUUID: "0123abcd456789ef0123456789abcdef"},
+			},
+		},
+		"invalid Azure vhdUUID": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{VHDUUID: "0123abcd-4567-89ef-0123"},
+			},
+			wantErr: true,
+		},
+		"valid Azure osDiskSizeGB": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{OSDiskSizeGB: 64},
+			},
+		},
+		"negative Azure osDiskSizeGB": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{OSDiskSizeGB: -1},
+			},
+			wantErr: true,
+		},
+		"valid Azure image definition lifecycle": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure: AzureConfig{
+					DisallowedDiskTypes:     []string{"Standard_LRS", "StandardSSD_LRS"},
+					DefinitionEndOfLifeDate: "2030-12-31",
+				},
+			},
+		},
+		"valid Azure definitionEndOfLifeDate timestamp": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{DefinitionEndOfLifeDate: "2030-12-31T00:00:00Z"},
+			},
+		},
+		"invalid Azure disallowedDiskTypes": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{DisallowedDiskTypes: []string{"standard_lrs"}},
+			},
+			wantErr: true,
+		},
+		"invalid Azure definitionEndOfLifeDate": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "azure",
+				Azure:    AzureConfig{DefinitionEndOfLifeDate: "31.12.2030"},
+			},
+			wantErr: true,
+		},
+		"missing GCP project": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+			},
+			mutation: func(c *Config) {
+				c.GCP.Project = ""
+			},
+			wantErr: true,
+		},
+		"invalid GCP project": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP: GCPConfig{
+					Project: "-invalid project name",
+				},
+			},
+			wantErr: true,
+		},
+		"GCP secure boot keys": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP: GCPConfig{
+					SecureBoot: GCPSecureBootConfig{
+						Enabled:  Some(true),
+						PKFile:   "pk.pem",
+						KEKFiles: []string{"kek.pem"},
+						DBFiles:  []string{"db.pem", "db2.der"},
+						DBXFiles: []string{"dbx.esl"},
+					},
+				},
+			},
+		},
+		"GCP secure boot enabled without keys": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{SecureBoot: GCPSecureBootConfig{Enabled: Some(true)}},
+			},
+		},
+		"GCP storageLocations": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{StorageLocations: []string{"eu", "europe-west3"}},
+			},
+		},
+		"invalid GCP storageLocations": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{StorageLocations: []string{"europe-west3-a"}},
+			},
+			wantErr: true,
+		},
+		"GCP arm64 architecture": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{Architecture: "arm64"},
+			},
+		},
+		"invalid GCP architecture": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{Architecture: "aarch64"},
+			},
+			wantErr: true,
+		},
+		"GCP imageUsers": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP: GCPConfig{
+					ImageUsers: []string{"user:alice@example.com", "group:team@example.com", "serviceAccount:sa@project.iam.gserviceaccount.com", "domain:example.com"},
+				},
+			},
+		},
+		"invalid GCP imageUsers": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{ImageUsers: []string{"allUsers"}},
+			},
+			wantErr: true,
+		},
+		"GCP guestOSFeatures": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{GuestOSFeatures: []string{"GVNIC", "TDX_CAPABLE", "UEFI_COMPATIBLE"}},
+			},
+		},
+		"invalid GCP guestOSFeatures": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{GuestOSFeatures: []string{"GVNIC", "SEV_SNP"}},
+			},
+			wantErr: true,
+		},
+		"GCP secure boot without UEFI_COMPATIBLE": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP: GCPConfig{
+					GuestOSFeatures: []string{"GVNIC"},
+					SecureBoot:      GCPSecureBootConfig{Enabled: Some(true)},
+				},
+			},
+			wantErr: true,
+		},
+		"GCP secure boot keys without enabled": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{SecureBoot: GCPSecureBootConfig{DBFiles: []string{"db.pem"}}},
+			},
+			wantErr: true,
+		},
+		"empty GCP secure boot db file": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP: GCPConfig{
+					SecureBoot: GCPSecureBootConfig{Enabled: Some(true), DBFiles: []string{""}},
+				},
+			},
+			wantErr: true,
+		},
+		"missing GCP location": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+			},
+			mutation: func(c *Config) {
+				c.GCP.Location = ""
+			},
+			wantErr: true,
+		},
+		"missing GCP imageName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+			},
+			mutation: func(c *Config) {
+				c.GCP.ImageName = ""
+			},
+			wantErr: true,
+		},
+		"missing GCP imageFamily": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+			},
+			mutation: func(c *Config) {
+				c.GCP.ImageFamily = ""
+			},
+			wantErr: true,
+		},
+		"missing GCP bucket": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+			},
+			mutation: func(c *Config) {
+				c.GCP.Bucket = ""
 			},
 			wantErr: true,
 		},
-		"missing AWS amiName": {
-			base:      validConfig(),
-			overrides: Config{Provider: "aws"},
+		"missing GCP blobName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+			},
 			mutation: func(c *Config) {
-				c.AWS.AMIName = ""
+				c.GCP.BlobName = ""
 			},
 			wantErr: true,
 		},
-		"wrong length AWS amiName": {
+		"valid GCP sourceImage without bucket": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "aws",
-				AWS: AWSConfig{
-					AMIName: strings.Repeat("a", 129),
-				},
+				Provider: "gcp",
+				GCP:      GCPConfig{SourceImage: "projects/my-project/global/images/my-image"},
+			},
+			mutation: func(c *Config) {
+				c.GCP.Bucket = ""
+				c.GCP.BlobName = ""
 			},
-			wantErr: true,
 		},
-		"invalid AWS amiName": {
+		"GCP sourceImage and sourceDisk": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "aws",
-				AWS: AWSConfig{
-					AMIName: "invalid<ami_name",
+				Provider: "gcp",
+				GCP: GCPConfig{
+					SourceImage: "projects/my-project/global/images/my-image",
+					SourceDisk:  "projects/my-project/zones/us-central1-a/disks/my-disk",
 				},
 			},
 			wantErr: true,
 		},
-		"missing AWS blobName": {
+		"valid GCP deprecation": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "aws",
+				Provider: "gcp",
+				GCP: GCPConfig{
+					State:                "DEPRECATED",
+					Replacement:          "https://www.googleapis.com/compute/v1/projects/my-project/global/images/new",
+					DeprecateImages:      []string{"my-image-old"},
+					DeprecateImagesState: "OBSOLETE",
+				},
 			},
-			mutation: func(c *Config) {
-				c.AWS.BlobName = ""
+		},
+		"invalid GCP state": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{State: "deprecated"},
 			},
 			wantErr: true,
 		},
-		"missing AWS snapshotName": {
+		"GCP state deprecated without replacement": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "aws",
-			},
-			mutation: func(c *Config) {
-				c.AWS.SnapshotName = ""
+				Provider: "gcp",
+				GCP:      GCPConfig{State: "DEPRECATED"},
 			},
 			wantErr: true,
 		},
-		"uninitialized AWS Publish setting": {
+		"invalid GCP deprecateImagesState": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "aws",
+				Provider: "gcp",
+				GCP: GCPConfig{
+					DeprecateImages:      []string{"my-image-old"},
+					DeprecateImagesState: "ACTIVE",
+				},
 			},
-			mutation: func(c *Config) {
-				c.AWS.Publish = None[bool]()
+			wantErr: true,
+		},
+		"GCP deprecateImages contains imageName": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP: GCPConfig{
+					DeprecateImages:      []string{"my-image"},
+					DeprecateImagesState: "DEPRECATED",
+				},
 			},
 			wantErr: true,
 		},
-		"missing Azure subscriptionID": {
+		"valid GCP labels": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
+				Provider: "gcp",
+				GCP:      GCPConfig{Labels: map[string]string{"team": "platform", "managed-by": "uplosi", "empty": ""}},
 			},
-			mutation: func(c *Config) {
-				c.Azure.SubscriptionID = ""
+		},
+		"invalid GCP labels key": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{Labels: map[string]string{"1team": "platform"}},
 			},
 			wantErr: true,
 		},
-		"invalid Azure subscriptionID": {
+		"GCP labels key too long": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-				Azure:    AzureConfig{SubscriptionID: "invalid"},
+				Provider: "gcp",
+				GCP:      GCPConfig{Labels: map[string]string{strings.Repeat("a", 64): "platform"}},
 			},
 			wantErr: true,
 		},
-		"missing Azure location": {
+		"invalid GCP labels value": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-			},
-			mutation: func(c *Config) {
-				c.Azure.Location = ""
+				Provider: "gcp",
+				GCP:      GCPConfig{Labels: map[string]string{"version": "1.2.3"}},
 			},
 			wantErr: true,
 		},
-		"missing Azure resourceGroup": {
+		"valid GCP bucketLabels": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
+				Provider: "gcp",
+				GCP:      GCPConfig{BucketLabels: map[string]string{"team": "os", "cost-center": ""}},
 			},
-			mutation: func(c *Config) {
-				c.Azure.ResourceGroup = ""
+		},
+		"invalid GCP bucketLabels key": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{BucketLabels: map[string]string{"Team": "os"}},
 			},
 			wantErr: true,
 		},
-		"missing Azure attestationVariant": {
+		"invalid GCP bucketLabels value": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-			},
-			mutation: func(c *Config) {
-				c.Azure.AttestationVariant = ""
+				Provider: "gcp",
+				GCP:      GCPConfig{BucketLabels: map[string]string{"team": "OS"}},
 			},
 			wantErr: true,
 		},
-		"invalid Azure attestationVariant": {
+		"valid GCP publicAccessPrevention": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-				Azure:    AzureConfig{AttestationVariant: "invalid"},
+				Provider: "gcp",
+				GCP:      GCPConfig{PublicAccessPrevention: "inherited"},
+			},
+		},
+		"invalid GCP publicAccessPrevention": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "gcp",
+				GCP:      GCPConfig{PublicAccessPrevention: "unspecified"},
 			},
 			wantErr: true,
 		},
-		"missing Azure sharedImageGallery": {
+		"valid OpenStack image metadata": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					Architecture:   "aarch64",
+					FirmwareType:   "uefi",
+					HypervisorType: "kvm",
+					HashAlgorithm:  "sha512",
+				},
 			},
-			mutation: func(c *Config) {
-				c.Azure.SharedImageGallery = ""
+		},
+		"OpenStack properties matching image metadata": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					Architecture: "aarch64",
+					FirmwareType: "uefi",
+					Properties:   map[string]string{"architecture": "aarch64", "hw_firmware_type": "uefi", "os_distro": "ubuntu"},
+				},
 			},
-			wantErr: true,
 		},
-		"missing Azure sharingProfile": {
+		"OpenStack property without image metadata": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{Properties: map[string]string{"hypervisor_type": "kvm"}},
 			},
-			mutation: func(c *Config) {
-				c.Azure.SharedImageGallery = ""
+		},
+		"OpenStack architecture property conflict": {
+			base: validConfig(),
+			overrides: Config{
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					Architecture: "x86_64",
+					Properties:   map[string]string{"architecture": "aarch64"},
+				},
 			},
 			wantErr: true,
 		},
-		"invalid Azure sharingProfile": {
+		"OpenStack firmware property conflict": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-				Azure:    AzureConfig{SharingProfile: "invalid"},
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					FirmwareType: "uefi",
+					Properties:   map[string]string{"hw_firmware_type": "bios"},
+				},
 			},
 			wantErr: true,
 		},
-		"missing Azure sharingNamePrefix with sharingProfile community": {
+		"invalid OpenStack architecture": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-				Azure: AzureConfig{
-					SharingProfile: "community",
-				},
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{Architecture: "arm64"},
 			},
-			mutation: func(c *Config) {
-				c.Azure.SharingNamePrefix = ""
+			wantErr: true,
+		},
+		"invalid OpenStack firmwareType": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{FirmwareType: "efi"},
 			},
 			wantErr: true,
 		},
-		"missing Azure sharingNamePrefix with sharingProfile private": {
+		"invalid OpenStack hashAlgorithm": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
-				Azure: AzureConfig{
-					SharingProfile: "private",
-				},
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{HashAlgorithm: "md5"},
 			},
-			mutation: func(c *Config) {
-				c.Azure.SharingNamePrefix = ""
+			wantErr: true,
+		},
+		"OpenStack imageName too long": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ImageName: strings.Repeat("a", 256)},
 			},
+			wantErr: true,
 		},
-		"missing Azure imageDefinitionName": {
+		"OpenStack uploadRetries disabled": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{UploadRetries: Some(0)},
 			},
-			mutation: func(c *Config) {
-				c.Azure.ImageDefinitionName = ""
+		},
+		"negative OpenStack uploadRetries": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{UploadRetries: Some(-1)},
 			},
 			wantErr: true,
 		},
-		"missing Azure diskName": {
+		"OpenStack qcow2 disk format": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "azure",
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{DiskFormat: "qcow2", ContainerFormat: "bare"},
 			},
-			mutation: func(c *Config) {
-				c.Azure.DiskName = ""
+		},
+		"invalid OpenStack diskFormat": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{DiskFormat: "img"},
 			},
 			wantErr: true,
 		},
-		"missing GCP project": {
+		"invalid OpenStack containerFormat": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
-			},
-			mutation: func(c *Config) {
-				c.GCP.Project = ""
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ContainerFormat: "tar"},
 			},
 			wantErr: true,
 		},
-		"invalid GCP project": {
+		"OpenStack ova without diskFormat": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
-				GCP: GCPConfig{
-					Project: "-invalid project name",
-				},
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ContainerFormat: "ova"},
 			},
 			wantErr: true,
 		},
-		"missing GCP location": {
+		"OpenStack convertToQCOW2": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ConvertToQCOW2: Some(true), DiskFormat: "qcow2"},
 			},
-			mutation: func(c *Config) {
-				c.GCP.Location = ""
+		},
+		"OpenStack convertToQCOW2 with raw diskFormat": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ConvertToQCOW2: Some(true), DiskFormat: "raw"},
 			},
 			wantErr: true,
 		},
-		"missing GCP imageName": {
+		"OpenStack convertToQCOW2 with web-download": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
-			},
-			mutation: func(c *Config) {
-				c.GCP.ImageName = ""
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					ConvertToQCOW2: Some(true),
+					ImportMethod:   "web-download",
+					SourceURL:      "https://images.example.com/image.raw",
+				},
 			},
 			wantErr: true,
 		},
-		"missing GCP imageFamily": {
+		"OpenStack web-download": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					ImportMethod: "web-download",
+					SourceURL:    "https://images.example.com/image.raw",
+				},
 			},
-			mutation: func(c *Config) {
-				c.GCP.ImageFamily = ""
+		},
+		"invalid OpenStack importMethod": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ImportMethod: "glance-direct"},
 			},
 			wantErr: true,
 		},
-		"missing GCP bucket": {
+		"OpenStack web-download without sourceURL": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
-			},
-			mutation: func(c *Config) {
-				c.GCP.Bucket = ""
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{ImportMethod: "web-download"},
 			},
 			wantErr: true,
 		},
-		"missing GCP blobName": {
+		"OpenStack sourceURL with direct upload": {
 			base: validConfig(),
 			overrides: Config{
-				Provider: "gcp",
+				Provider: "openstack",
+				OpenStack: OpenStackConfig{
+					ImportMethod: "direct",
+					SourceURL:    "https://images.example.com/image.raw",
+				},
 			},
-			mutation: func(c *Config) {
-				c.GCP.BlobName = ""
+			wantErr: true,
+		},
+		"invalid OpenStack hypervisorType": {
+			base: validConfig(),
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{HypervisorType: "virtualbox"},
 			},
 			wantErr: true,
 		},
@@ -354,6 +1499,116 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateFieldErrors(t *testing.T) {
+	testCases := map[string]struct {
+		overrides Config
+		mutation  func(*Config)
+		wantField string
+		wantMsg   string
+	}{
+		"top-level field": {
+			overrides: Config{CommitHash: "XYZ"},
+			wantField: "commitHash",
+			wantMsg:   `commitHash must be a lowercase hexadecimal git commit hash, got "XYZ"`,
+		},
+		"provider field": {
+			overrides: Config{Provider: "azure", Azure: AzureConfig{AttestationVariant: "invalid"}},
+			wantField: "azure.attestationVariant",
+			wantMsg:   `azure.attestationVariant "invalid" is not one of ["azure-tdx", "azure-sev-snp", "azure-trustedlaunch"]`,
+		},
+		"nested field": {
+			overrides: Config{Provider: "gcp", GCP: GCPConfig{SecureBoot: GCPSecureBootConfig{PKFile: "pk.crt"}}},
+			mutation:  func(c *Config) { c.GCP.SecureBoot.Enabled = Some(false) },
+			wantField: "gcp.secureBoot.pkFile",
+			wantMsg:   "gcp.secureBoot.pkFile requires secureBoot.enabled",
+		},
+		"property conflict": {
+			overrides: Config{
+				Provider:  "openstack",
+				OpenStack: OpenStackConfig{HypervisorType: "kvm", Properties: map[string]string{"hypervisor_type": "xen"}},
+			},
+			wantField: "openstack.properties.hypervisor_type",
+			wantMsg:   `openstack.properties.hypervisor_type "xen" conflicts with openstack.hypervisorType "kvm"`,
+		},
+		"required field": {
+			mutation:  func(c *Config) { c.AWS.Bucket = "" },
+			wantField: "aws.bucket",
+			wantMsg:   "aws.bucket is required",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			cfg := Config{}
+			require.NoError(cfg.Merge(validConfig()))
+			require.NoError(cfg.Merge(tc.overrides))
+			if tc.mutation != nil {
+				tc.mutation(&cfg)
+			}
+
+			v := Validator{}
+			err := v.Validate(context.Background(), cfg)
+			require.Error(err)
+			var fieldErr *FieldError
+			require.ErrorAs(err, &fieldErr)
+			assert.Equal(tc.wantField, fieldErr.Field)
+			assert.Contains(err.Error(), tc.wantMsg)
+		})
+	}
+}
+
+func TestFieldErrorVariant(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := ConfigFile{
+		Base: validConfig(),
+		Variants: map[string]Config{
+			"a": {Provider: "azure"},
+			"b": {Provider: "azure", Azure: AzureConfig{AttestationVariant: "invalid"}},
+		},
+	}
+
+	_, err := conf.RenderedVariant(stubFileLookup{}.Lookup, "a")
+	require.NoError(err)
+	_, err = conf.RenderedVariant(stubFileLookup{}.Lookup, "b")
+	require.Error(err)
+	var fieldErr *FieldError
+	require.ErrorAs(err, &fieldErr)
+	assert.Equal("b", fieldErr.Variant)
+	assert.Contains(err.Error(), `variant "b": azure.attestationVariant "invalid" is not one of`)
+
+	err = conf.ForEach(func(string, Config) error { return nil }, stubFileLookup{}.Lookup)
+	assert.ErrorContains(err, `variant "b": azure.attestationVariant "invalid" is not one of`)
+	assert.NotContains(err.Error(), `variant "a"`)
+}
+
+func TestValidateDoesNotWriteStdout(t *testing.T) {
+	require := require.New(t)
+
+	r, w, err := os.Pipe()
+	require.NoError(err)
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+
+	v := Validator{}
+	validErr := v.Validate(context.Background(), validConfig())
+	invalidErr := v.Validate(context.Background(), Config{})
+
+	os.Stdout = stdout
+	require.NoError(w.Close())
+	out, err := io.ReadAll(r)
+	require.NoError(err)
+
+	require.NoError(validErr)
+	require.Error(invalidErr)
+	require.Empty(string(out))
+}
+
 func validConfig() Config {
 	return Config{
 		Provider:     "aws",
@@ -391,5 +1646,35 @@ func validConfig() Config {
 			Bucket:      "my-bucket",
 			BlobName:    "my-blob",
 		},
+		OpenStack: OpenStackConfig{
+			Cloud:     "my-cloud",
+			ImageName: "my-image",
+		},
+	}
+}
+
+func TestValidateVariants(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := ConfigFile{
+		Base: validConfig(),
+		Variants: map[string]Config{
+			"a": {Provider: "azure"},
+			"b": {Provider: "azure", Azure: AzureConfig{AttestationVariant: "invalid"}},
+			"c": {Provider: "foo"},
+		},
 	}
+
+	results, err := conf.ValidateVariants(stubFileLookup{}.Lookup, func(name string) bool { return name != "c" })
+	require.NoError(err)
+	assert.Len(results, 2)
+	assert.NoError(results["a"])
+	var fieldErr *FieldError
+	require.ErrorAs(results["b"], &fieldErr)
+	assert.Equal("azure.attestationVariant", fieldErr.Field)
+	assert.Empty(fieldErr.Variant)
+
+	_, err = conf.ValidateVariants(stubFileLookup{}.Lookup, func(string) bool { return false })
+	assert.Error(err)
 }
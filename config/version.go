@@ -0,0 +1,67 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// semverRegexp matches <MAJOR>.<MINOR>.<PATCH>[-<PRERELEASE>][+<BUILD>].
+// The validation policy uses the same pattern for imageVersion.
+var semverRegexp = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+// Version is a semantic version <MAJOR>.<MINOR>.<PATCH>[-<PRERELEASE>][+<BUILD>].
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+// ParseVersion parses a semantic version. Versions with less than three components
+// or a leading v are rejected.
+func ParseVersion(s string) (Version, error) {
+	parts := semverRegexp.FindStringSubmatch(s)
+	if parts == nil {
+		return Version{}, fmt.Errorf("version %q must be in format <MAJOR>.<MINOR>.<PATCH>[-<PRERELEASE>][+<BUILD>]", s)
+	}
+	var v Version
+	for i, dst := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			return Version{}, fmt.Errorf("parsing version %q: %w", s, err)
+		}
+		*dst = n
+	}
+	v.Prerelease = parts[4]
+	v.Build = parts[5]
+	return v, nil
+}
+
+// NextPatch returns the next patch release. The next release of a prerelease
+// is the release it precedes, e.g. 1.2.3 for 1.2.3-rc.1, as defined by the
+// semver precedence rules. Build metadata is dropped.
+func (v Version) NextPatch() Version {
+	if v.Prerelease != "" {
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	}
+	return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	testCases := map[string]struct {
+		version       string
+		want          Version
+		wantNextPatch string
+		wantErr       bool
+	}{
+		"release": {
+			version:       "1.2.3",
+			want:          Version{Major: 1, Minor: 2, Patch: 3},
+			wantNextPatch: "1.2.4",
+		},
+		"prerelease": {
+			version:       "1.2.3-rc.1",
+			want:          Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"},
+			wantNextPatch: "1.2.3",
+		},
+		"build metadata": {
+			version:       "1.2.3+build.5",
+			want:          Version{Major: 1, Minor: 2, Patch: 3, Build: "build.5"},
+			wantNextPatch: "1.2.4",
+		},
+		"prerelease and build metadata": {
+			version:       "1.2.3-rc.1+build.5",
+			want:          Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1", Build: "build.5"},
+			wantNextPatch: "1.2.3",
+		},
+		"two-part version": {
+			version: "1.15",
+			wantErr: true,
+		},
+		"leading v": {
+			version: "v1.2.3",
+			wantErr: true,
+		},
+		"empty prerelease": {
+			version: "1.2.3-",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			got, err := ParseVersion(tc.version)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.want, got)
+			assert.Equal(tc.version, got.String())
+			assert.Equal(tc.wantNextPatch, got.NextPatch().String())
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/spf13/cobra"
+)
+
+func newDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the images an upload with the current config created",
+		Args:  cobra.NoArgs,
+		RunE:  runDelete,
+	}
+	cmd.Flags().StringSlice("enable-variant-glob", []string{"*"}, "list of variant name globs to enable")
+	cmd.Flags().StringSlice("disable-variant-glob", nil, "list of variant name globs to disable")
+	cmd.Flags().StringP("config", "c", "", fmt.Sprintf("path to directory %s and %s resides in", configName, configDir))
+	cmd.Flags().String("config-dir", "", "path to a directory of *.toml and *.conf config files whose images are deleted")
+	cmd.MarkFlagsMutuallyExclusive("config", "config-dir")
+	cmd.Flags().String("provider", "", "override the provider of every variant (aws, azure, gcp, openstack)")
+	cmd.Flags().Bool("dry-run", false, "only print the operations of every variant as JSON without deleting anything")
+	cmd.Flags().BoolP("quiet", "q", false, "suppress informational log output, only print errors and the deleted resources")
+
+	return cmd
+}
+
+// deleter is implemented by uploaders that can delete the images an upload creates.
+type deleter interface {
+	DeletePlan() uploader.Plan
+	Delete(ctx context.Context) error
+}
+
+func runDelete(cmd *cobra.Command, _ []string) error {
+	flags, err := parseDeleteFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	logOut := cmd.ErrOrStderr()
+	if flags.quiet {
+		logOut = io.Discard
+	}
+	logger := log.New(logOut, "", log.LstdFlags)
+
+	configFiles, err := loadConfigFiles([]string{flags.configPath}, flags.configDirPath)
+	if err != nil {
+		return fmt.Errorf("parsing config files: %w", err)
+	}
+
+	deleted := []plannedVariant{}
+	var deleteErr error
+	for _, configFile := range configFiles {
+		configFile.conf.SetProviderOverride(flags.provider)
+		err := configFile.conf.ForEach(
+			func(name string, cfg config.Config) error {
+				ops, err := deleteVariant(cmd.Context(), name, cfg, flags.dryRun, logger)
+				if err != nil {
+					return fmt.Errorf("variant %q: %w", name, err)
+				}
+				deleted = append(deleted, plannedVariant{
+					ConfigFile:   configFile.path,
+					Variant:      name,
+					Provider:     cfg.Provider,
+					ImageVersion: cfg.ImageVersion,
+					Operations:   ops,
+				})
+				return nil
+			},
+			os.ReadFile,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+		)
+		if err != nil {
+			deleteErr = errors.Join(deleteErr, fmt.Errorf("config file %s: %w", configFile.path, err))
+		}
+	}
+
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(deleted); err != nil {
+		deleteErr = errors.Join(deleteErr, fmt.Errorf("printing deleted resources: %w", err))
+	}
+	if deleteErr != nil {
+		return fmt.Errorf("deleting variants: %w", deleteErr)
+	}
+	return nil
+}
+
+// deleteVariant deletes the images of a single variant and returns the performed operations.
+// With dryRun, the operations are only logged.
+func deleteVariant(ctx context.Context, variant string, cfg config.Config, dryRun bool, logger *log.Logger) (uploader.Plan, error) {
+	if len(variant) > 0 {
+		logger.Println("Deleting variant", variant)
+	}
+
+	_, upload, err := newUploader(cfg, logger, nil)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := upload.(deleter)
+	if !ok {
+		return nil, fmt.Errorf("provider %s doesn't support deleting images", cfg.Provider)
+	}
+
+	plan := d.DeletePlan()
+	if dryRun {
+		for _, op := range plan {
+			logger.Printf("Would %s", op)
+		}
+		return plan, nil
+	}
+	if err := d.Delete(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+type deleteFlags struct {
+	enableVariantGlobs  []string
+	disableVariantGlobs []string
+	configPath          string
+	configDirPath       string
+	provider            string
+	dryRun              bool
+	quiet               bool
+}
+
+func parseDeleteFlags(cmd *cobra.Command) (*deleteFlags, error) {
+	enableVariantGlobs, err := cmd.Flags().GetStringSlice("enable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting enable-variant-glob flag: %w", err)
+	}
+	disableVariantGlobs, err := cmd.Flags().GetStringSlice("disable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting disable-variant-glob flag: %w", err)
+	}
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("getting config flag: %w", err)
+	}
+	configDirPath, err := cmd.Flags().GetString("config-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting config-dir flag: %w", err)
+	}
+	provider, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return nil, fmt.Errorf("getting provider flag: %w", err)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return nil, fmt.Errorf("getting dry-run flag: %w", err)
+	}
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return nil, fmt.Errorf("getting quiet flag: %w", err)
+	}
+	return &deleteFlags{
+		enableVariantGlobs:  enableVariantGlobs,
+		disableVariantGlobs: disableVariantGlobs,
+		configPath:          configPath,
+		configDirPath:       configDirPath,
+		provider:            provider,
+		dryRun:              dryRun,
+		quiet:               quiet,
+	}, nil
+}
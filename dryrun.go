@@ -0,0 +1,116 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// planner is implemented by uploaders that can list the operations of an upload without performing them.
+type planner interface {
+	Plan() uploader.Plan
+}
+
+// plannedVariant is the dry-run summary of a single variant.
+type plannedVariant struct {
+	ConfigFile   string        `json:"configFile"`
+	Variant      string        `json:"variant"`
+	Provider     string        `json:"provider"`
+	ImageVersion string        `json:"imageVersion"`
+	Operations   uploader.Plan `json:"operations"`
+}
+
+// dryRunUpload plans the upload of all enabled variants of all config files and writes
+// a summary of the planned operations to out. No state is changed, neither in the cloud
+// nor in the version files.
+func dryRunUpload(out io.Writer, imagePath string, configFiles []namedConfigFile, flags *uploadFlags,
+	versionFileLookup func(name string) ([]byte, error), logger *log.Logger,
+) error {
+	planned := []plannedVariant{}
+	var planErr error
+	for _, configFile := range configFiles {
+		err := configFile.conf.ForEach(
+			func(name string, cfg config.Config) error {
+				plan, err := planVariant(imagePath, name, cfg, logger)
+				if err != nil {
+					return fmt.Errorf("variant %q: %w", name, err)
+				}
+				planned = append(planned, plannedVariant{
+					ConfigFile:   configFile.path,
+					Variant:      name,
+					Provider:     cfg.Provider,
+					ImageVersion: cfg.ImageVersion,
+					Operations:   plan,
+				})
+				return nil
+			},
+			versionFileLookup,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+			flags.variantSelected,
+		)
+		if err != nil {
+			planErr = errors.Join(planErr, fmt.Errorf("config file %s: %w", configFile.path, err))
+		}
+	}
+	if planErr != nil {
+		return fmt.Errorf("planning variants: %w", planErr)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(planned)
+}
+
+// planVariant returns the operations an upload of the variant would perform and logs them.
+func planVariant(imagePath, variant string, cfg config.Config, logger *log.Logger) (uploader.Plan, error) {
+	if len(variant) > 0 {
+		logger.Println("Planning variant", variant)
+	}
+
+	if err := checkDataImage(cfg); err != nil {
+		return nil, err
+	}
+
+	_, upload, err := newUploader(cfg, logger, nil)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := upload.(planner)
+	if !ok {
+		return nil, fmt.Errorf("provider %s doesn't support dry-run", cfg.Provider)
+	}
+
+	// The size of an image read from stdin is unknown without consuming it.
+	if imagePath != stdinImage && imagePath != noImage {
+		rawImageFi, err := os.Stat(imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("getting image stats: %w", err)
+		}
+		if err := checkImageSize(cfg.Provider, rawImageFi.Size(), cfg.MaxImageSizeGiB); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := p.Plan()
+	for _, op := range plan {
+		logger.Printf("Would %s", op)
+	}
+	return plan, nil
+}
@@ -19,12 +19,16 @@ import (
 type imagesAPI interface {
 	Get(ctx context.Context, req *computepb.GetImageRequest, opts ...gaxv2.CallOption,
 	) (*computepb.Image, error)
+	List(ctx context.Context, req *computepb.ListImagesRequest, opts ...gaxv2.CallOption,
+	) *compute.ImageIterator
 	Insert(ctx context.Context, req *computepb.InsertImageRequest, opts ...gaxv2.CallOption,
 	) (*compute.Operation, error)
 	SetIamPolicy(ctx context.Context, req *computepb.SetIamPolicyImageRequest, opts ...gaxv2.CallOption,
 	) (*computepb.Policy, error)
 	Delete(ctx context.Context, req *computepb.DeleteImageRequest, opts ...gaxv2.CallOption,
 	) (*compute.Operation, error)
+	Deprecate(ctx context.Context, req *computepb.DeprecateImageRequest, opts ...gaxv2.CallOption,
+	) (*compute.Operation, error)
 	io.Closer
 }
 
@@ -0,0 +1,45 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// DeletePlan returns the operations Delete performs, without calling any GCP API.
+func (u *Uploader) DeletePlan() uploader.Plan {
+	var plan uploader.Plan
+	plan.Add(uploader.ActionDeleteIfExists, "image", u.config.GCP.ImageName, u.config.GCP.Project)
+	if u.uploadsBlob() {
+		plan.Add(uploader.ActionDeleteIfExists, "storage object", blobURL(u.config.GCP.Bucket, u.config.GCP.BlobName), u.config.GCP.Location)
+	}
+	return plan
+}
+
+// Delete removes the image an upload with the current config creates,
+// together with a blob left behind by a failed upload. The bucket is shared and kept.
+func (u *Uploader) Delete(ctx context.Context) error {
+	if err := u.ensureImageDeleted(ctx); err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+	if !u.uploadsBlob() {
+		return nil
+	}
+	if err := u.ensureBlobDeleted(ctx); err != nil {
+		return fmt.Errorf("deleting blob: %w", err)
+	}
+	return nil
+}
+
+// uploadsBlob reports whether the image is created from an uploaded blob
+// rather than from a source image or disk.
+func (u *Uploader) uploadsBlob() bool {
+	return u.config.GCP.SourceImage == "" && u.config.GCP.SourceDisk == ""
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// List returns the images of the configured image family.
+func (u *Uploader) List(ctx context.Context) ([]uploader.ListedImage, error) {
+	family := u.config.GCP.ImageFamily
+	if family == "" {
+		return nil, errors.New("no image family configured")
+	}
+
+	imageC, err := u.image(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer imageC.Close()
+
+	familyImages, err := u.listFamilyImages(ctx, imageC, family)
+	if err != nil {
+		return nil, fmt.Errorf("listing images of family %s: %w", family, err)
+	}
+	images := make([]uploader.ListedImage, 0, len(familyImages))
+	for _, image := range familyImages {
+		images = append(images, listedImage(image))
+	}
+	return images, nil
+}
+
+// listedImage converts a compute image to a listed image, referenced like an uploaded image.
+func listedImage(image *computepb.Image) uploader.ListedImage {
+	// Creation timestamps are RFC3339. Unparsable timestamps are left zero.
+	createdAt, _ := time.Parse(time.RFC3339, image.GetCreationTimestamp())
+	return uploader.ListedImage{
+		Provider:  "gcp",
+		Name:      image.GetName(),
+		Reference: strings.TrimPrefix(image.GetSelfLink(), "https://www.googleapis.com/compute/v1/"),
+		CreatedAt: createdAt,
+	}
+}
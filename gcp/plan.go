@@ -0,0 +1,47 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"strings"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// Plan returns the operations an upload with the current config performs, without calling any GCP API.
+func (u *Uploader) Plan() uploader.Plan {
+	var plan uploader.Plan
+	project := u.config.GCP.Project
+	imageName := u.config.GCP.ImageName
+
+	plan.Add(uploader.ActionDeleteIfExists, "image", imageName, project)
+	switch {
+	case u.config.GCP.SourceImage != "":
+		plan.AddDetail(uploader.ActionCreate, "image", imageName, project, "from source image "+u.config.GCP.SourceImage)
+	case u.config.GCP.SourceDisk != "":
+		plan.AddDetail(uploader.ActionCreate, "image", imageName, project, "from source disk "+u.config.GCP.SourceDisk)
+	default:
+		blob := blobURL(u.config.GCP.Bucket, u.config.GCP.BlobName)
+		plan.Add(uploader.ActionDeleteIfExists, "storage object", blob, u.config.GCP.Location)
+		plan.Add(uploader.ActionCreateIfMissing, "storage bucket", u.config.GCP.Bucket, u.config.GCP.Location)
+		plan.Add(uploader.ActionUpload, "storage object", blob, u.config.GCP.Location)
+		plan.Add(uploader.ActionCreate, "image", imageName, project)
+	}
+	if members := u.imageUsers(); len(members) > 0 {
+		plan.AddDetail(uploader.ActionUpdate, "image", imageName, project, "grant "+imageUserRole+" to "+strings.Join(members, ", "))
+	}
+	if u.config.GCP.State != "" {
+		plan.AddDetail(uploader.ActionUpdate, "image", imageName, project, "set state "+u.config.GCP.State)
+	}
+	for _, oldImageName := range u.config.GCP.DeprecateImages {
+		plan.AddDetail(uploader.ActionUpdate, "image", oldImageName, project, "set state "+u.config.GCP.DeprecateImagesState)
+	}
+	if u.uploadsBlob() && !u.config.KeepTempArtifacts.UnwrapOrZero() {
+		plan.Add(uploader.ActionDelete, "storage object", blobURL(u.config.GCP.Bucket, u.config.GCP.BlobName), u.config.GCP.Location)
+	}
+	return plan
+}
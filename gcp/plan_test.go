@@ -0,0 +1,114 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"testing"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	testCases := map[string]struct {
+		gcpConfig config.GCPConfig
+		want      uploader.Plan
+	}{
+		"raw disk upload": {
+			gcpConfig: config.GCPConfig{
+				Project:   "my-project",
+				Location:  "europe-west3",
+				ImageName: "my-image",
+				Bucket:    "my-bucket",
+				BlobName:  "my-image.tar.gz",
+				Public:    config.Some(true),
+			},
+			want: uploader.Plan{
+				{Action: uploader.ActionDeleteIfExists, Kind: "image", Name: "my-image", Location: "my-project"},
+				{Action: uploader.ActionDeleteIfExists, Kind: "storage object", Name: "https://storage.googleapis.com/my-bucket/my-image.tar.gz", Location: "europe-west3"},
+				{Action: uploader.ActionCreateIfMissing, Kind: "storage bucket", Name: "my-bucket", Location: "europe-west3"},
+				{Action: uploader.ActionUpload, Kind: "storage object", Name: "https://storage.googleapis.com/my-bucket/my-image.tar.gz", Location: "europe-west3"},
+				{Action: uploader.ActionCreate, Kind: "image", Name: "my-image", Location: "my-project"},
+				{Action: uploader.ActionUpdate, Kind: "image", Name: "my-image", Location: "my-project", Detail: "grant roles/compute.imageUser to allAuthenticatedUsers"},
+				{Action: uploader.ActionDelete, Kind: "storage object", Name: "https://storage.googleapis.com/my-bucket/my-image.tar.gz", Location: "europe-west3"},
+			},
+		},
+		"source image with deprecation": {
+			gcpConfig: config.GCPConfig{
+				Project:              "my-project",
+				ImageName:            "my-image",
+				SourceImage:          "projects/other/global/images/base",
+				DeprecateImages:      []string{"old-image"},
+				DeprecateImagesState: "DEPRECATED",
+				ImageUsers:           []string{"group:team@example.com"},
+			},
+			want: uploader.Plan{
+				{Action: uploader.ActionDeleteIfExists, Kind: "image", Name: "my-image", Location: "my-project"},
+				{Action: uploader.ActionCreate, Kind: "image", Name: "my-image", Location: "my-project", Detail: "from source image projects/other/global/images/base"},
+				{Action: uploader.ActionUpdate, Kind: "image", Name: "my-image", Location: "my-project", Detail: "grant roles/compute.imageUser to group:team@example.com"},
+				{Action: uploader.ActionUpdate, Kind: "image", Name: "old-image", Location: "my-project", Detail: "set state DEPRECATED"},
+			},
+		},
+		"private source disk image": {
+			gcpConfig: config.GCPConfig{
+				Project:    "my-project",
+				ImageName:  "my-image",
+				SourceDisk: "projects/my-project/zones/europe-west3-a/disks/disk",
+			},
+			want: uploader.Plan{
+				{Action: uploader.ActionDeleteIfExists, Kind: "image", Name: "my-image", Location: "my-project"},
+				{Action: uploader.ActionCreate, Kind: "image", Name: "my-image", Location: "my-project", Detail: "from source disk projects/my-project/zones/europe-west3-a/disks/disk"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u := &Uploader{config: config.Config{GCP: tc.gcpConfig}}
+			assert.Equal(t, tc.want, u.Plan())
+		})
+	}
+}
+
+func TestDeletePlan(t *testing.T) {
+	testCases := map[string]struct {
+		gcpConfig config.GCPConfig
+		want      uploader.Plan
+	}{
+		"raw disk upload": {
+			gcpConfig: config.GCPConfig{
+				Project:   "my-project",
+				Location:  "europe-west3",
+				ImageName: "my-image",
+				Bucket:    "my-bucket",
+				BlobName:  "my-image.tar.gz",
+			},
+			want: uploader.Plan{
+				{Action: uploader.ActionDeleteIfExists, Kind: "image", Name: "my-image", Location: "my-project"},
+				{Action: uploader.ActionDeleteIfExists, Kind: "storage object", Name: "https://storage.googleapis.com/my-bucket/my-image.tar.gz", Location: "europe-west3"},
+			},
+		},
+		"source image": {
+			gcpConfig: config.GCPConfig{
+				Project:     "my-project",
+				ImageName:   "my-image",
+				SourceImage: "projects/other/global/images/base",
+			},
+			want: uploader.Plan{
+				{Action: uploader.ActionDeleteIfExists, Kind: "image", Name: "my-image", Location: "my-project"},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u := &Uploader{config: config.Config{GCP: tc.gcpConfig}}
+			assert.Equal(t, tc.want, u.DeletePlan())
+		})
+	}
+}
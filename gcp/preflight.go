@@ -0,0 +1,40 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/googleapis/gax-go/v2/apierror"
+)
+
+// Preflight performs cheap read-only calls representative of the permissions an upload needs
+// and reports the outcome of each check.
+func (u *Uploader) Preflight(ctx context.Context, report func(check string, err error)) {
+	if u.config.GCP.SourceImage == "" && u.config.GCP.SourceDisk == "" {
+		_, err := u.bucketExists(ctx)
+		report(fmt.Sprintf("storage bucket Attrs %s", u.config.GCP.Bucket), err)
+	}
+
+	imageC, err := u.image(ctx)
+	if err != nil {
+		report("compute images client", err)
+		return
+	}
+	defer imageC.Close()
+	_, err = imageC.Get(ctx, &computepb.GetImageRequest{
+		Image:   u.config.GCP.ImageName,
+		Project: u.config.GCP.Project,
+	})
+	if apiErr, ok := apierror.FromError(err); ok && apiErr.HTTPCode() == http.StatusNotFound {
+		err = nil
+	}
+	report(fmt.Sprintf("compute images Get %s in %s", u.config.GCP.ImageName, u.config.GCP.Project), err)
+}
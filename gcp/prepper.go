@@ -8,14 +8,37 @@ package gcp
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
 )
 
+func init() {
+	uploader.RegisterPrepper("gcp", func(cfg config.Config) (uploader.Prepper, error) {
+		if cfg.GCP.SourceImage != "" || cfg.GCP.SourceDisk != "" {
+			return sourcePrepper{}, nil
+		}
+		return &Prepper{}, nil
+	})
+}
+
+// sourcePrepper leaves the image untouched. Images created from a source image or disk
+// don't upload the local image, so packing it would be wasted work.
+type sourcePrepper struct{}
+
+func (sourcePrepper) Prepare(_ context.Context, imagePath, _ string) (string, error) {
+	return imagePath, nil
+}
+
 type Prepper struct{}
 
 func (p *Prepper) Prepare(_ context.Context, imagePath, tmpDir string) (string, error) {
@@ -28,6 +51,14 @@ func (p *Prepper) Prepare(_ context.Context, imagePath, tmpDir string) (string,
 		return "", err
 	}
 	defer rawImage.Close()
+	packed, err := isPackedImage(rawImage)
+	if err != nil {
+		return "", fmt.Errorf("detecting image format: %w", err)
+	}
+	if packed {
+		return imagePath, nil
+	}
+
 	tarGzName := filepath.Join(tmpDir, "disk.tar.gz")
 	outFile, err := os.Create(tarGzName)
 	if err != nil {
@@ -35,14 +66,68 @@ func (p *Prepper) Prepare(_ context.Context, imagePath, tmpDir string) (string,
 	}
 	defer outFile.Close()
 
-	if err := writeTarGz(rawImage, outFile); err != nil {
+	// Without SOURCE_DATE_EPOCH, the zero time is used, which is deterministic as well.
+	modTime, _, err := uploader.SourceDateEpoch()
+	if err != nil {
+		return "", err
+	}
+	if err := writeTarGz(rawImage, outFile, modTime); err != nil {
 		return "", fmt.Errorf("writing tar.gz: %w", err)
 	}
 
 	return tarGzName, nil
 }
 
-func writeTarGz(rawImage io.ReadSeeker, out io.Writer) error {
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// tarMagic is the magic of ustar and GNU tar headers at tarMagicOffset.
+	tarMagic = []byte("ustar")
+)
+
+const tarMagicOffset = 257
+
+// isPackedImage reports whether image already is a tar.gz that contains disk.raw, as GCP imports it,
+// so it must not be packed again. Other archives and compressed images are rejected,
+// as packing them would result in an image GCP can't import.
+// Raw images are rewound, so they can be packed afterwards.
+func isPackedImage(image io.ReadSeeker) (bool, error) {
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(image, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, err
+	}
+	header = header[:n]
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, zstdMagic):
+		return false, errors.New("image is compressed with zstd, decompress it or pack it as tar.gz containing disk.raw")
+	case len(header) > tarMagicOffset && bytes.HasPrefix(header[tarMagicOffset:], tarMagic):
+		return false, errors.New("image is an uncompressed tar archive, compress it with gzip")
+	case !bytes.HasPrefix(header, gzipMagic):
+		return false, nil
+	}
+
+	gzipR, err := gzip.NewReader(image)
+	if err != nil {
+		return false, fmt.Errorf("image has the gzip magic, but isn't gzip compressed: %w", err)
+	}
+	entry, err := tar.NewReader(gzipR).Next()
+	if err != nil {
+		return false, fmt.Errorf("image is compressed with gzip, but isn't a tar archive: %w", err)
+	}
+	if entry.Name != "disk.raw" || entry.Typeflag != tar.TypeReg {
+		return false, fmt.Errorf("image is a tar.gz, but its first entry is %q instead of the file disk.raw", entry.Name)
+	}
+	return true, nil
+}
+
+// writeTarGz packs rawImage as disk.raw into a tar.gz.
+// modTime is used for the tar and gzip headers, so the output only depends on the inputs.
+func writeTarGz(rawImage io.ReadSeeker, out io.Writer, modTime time.Time) error {
 	rawImageSize, err := rawImage.Seek(0, io.SeekEnd)
 	if err != nil {
 		return err
@@ -52,15 +137,19 @@ func writeTarGz(rawImage io.ReadSeeker, out io.Writer) error {
 	}
 
 	gzipW := gzip.NewWriter(out)
+	if !modTime.IsZero() {
+		gzipW.ModTime = modTime
+	}
 	defer gzipW.Close()
 	tarW := tar.NewWriter(gzipW)
 	defer tarW.Close()
 
 	if err := tarW.WriteHeader(&tar.Header{
-		Name:   "disk.raw",
-		Size:   rawImageSize,
-		Mode:   0o644,
-		Format: tar.FormatGNU,
+		Name:    "disk.raw",
+		Size:    rawImageSize,
+		Mode:    0o644,
+		ModTime: modTime,
+		Format:  tar.FormatGNU,
 	}); err != nil {
 		return err
 	}
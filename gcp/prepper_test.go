@@ -0,0 +1,187 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTarGzReproducible(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	rawImage := bytes.Repeat([]byte("image"), 1024)
+	modTime := time.Unix(1700000000, 0).UTC()
+
+	write := func() []byte {
+		out := new(bytes.Buffer)
+		require.NoError(writeTarGz(bytes.NewReader(rawImage), out, modTime))
+		return out.Bytes()
+	}
+	first := write()
+	assert.Equal(first, write())
+
+	gzipR, err := gzip.NewReader(bytes.NewReader(first))
+	require.NoError(err)
+	assert.True(modTime.Equal(gzipR.ModTime))
+	tarR := tar.NewReader(gzipR)
+	header, err := tarR.Next()
+	require.NoError(err)
+	assert.Equal("disk.raw", header.Name)
+	assert.Equal(int64(len(rawImage)), header.Size)
+	assert.True(modTime.Equal(header.ModTime))
+}
+
+func TestNewPrepper(t *testing.T) {
+	testCases := map[string]struct {
+		gcpConfig     config.GCPConfig
+		wantUntouched bool
+	}{
+		"image upload": {},
+		"source image": {
+			gcpConfig:     config.GCPConfig{SourceImage: "projects/p/global/images/i"},
+			wantUntouched: true,
+		},
+		"source disk": {
+			gcpConfig:     config.GCPConfig{SourceDisk: "projects/p/zones/z/disks/d"},
+			wantUntouched: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			prepper, err := uploader.NewPrepper(config.Config{Provider: "gcp", GCP: tc.gcpConfig})
+			require.NoError(err)
+
+			// The image doesn't exist, so only a prepper that leaves it untouched succeeds.
+			imagePath := filepath.Join(t.TempDir(), "missing.raw")
+			out, err := prepper.Prepare(context.Background(), imagePath, t.TempDir())
+			if !tc.wantUntouched {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			assert.Equal(imagePath, out)
+		})
+	}
+}
+
+func TestPrepperPrepare(t *testing.T) {
+	rawImage := bytes.Repeat([]byte("image"), 1024)
+	testCases := map[string]struct {
+		image      []byte
+		wantPacked bool
+		wantErr    bool
+	}{
+		"raw image": {
+			image: rawImage,
+		},
+		"empty image": {
+			image: []byte{},
+		},
+		"packed image": {
+			image:      tarImage(t, "disk.raw", rawImage, true),
+			wantPacked: true,
+		},
+		"packed image with wrong file name": {
+			image:   tarImage(t, "image.raw", rawImage, true),
+			wantErr: true,
+		},
+		"gzip without tar": {
+			image: func() []byte {
+				out := new(bytes.Buffer)
+				gzipW := gzip.NewWriter(out)
+				_, _ = gzipW.Write(rawImage)
+				_ = gzipW.Close()
+				return out.Bytes()
+			}(),
+			wantErr: true,
+		},
+		"uncompressed tar": {
+			image:   tarImage(t, "disk.raw", rawImage, false),
+			wantErr: true,
+		},
+		"zstd": {
+			image:   append([]byte{0x28, 0xb5, 0x2f, 0xfd}, rawImage...),
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			imagePath := filepath.Join(t.TempDir(), "image")
+			require.NoError(os.WriteFile(imagePath, tc.image, 0o644))
+
+			out, err := (&Prepper{}).Prepare(context.Background(), imagePath, t.TempDir())
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			if tc.wantPacked {
+				assert.Equal(imagePath, out)
+				return
+			}
+
+			prepared, err := os.ReadFile(out)
+			require.NoError(err)
+			gzipR, err := gzip.NewReader(bytes.NewReader(prepared))
+			require.NoError(err)
+			tarR := tar.NewReader(gzipR)
+			header, err := tarR.Next()
+			require.NoError(err)
+			assert.Equal("disk.raw", header.Name)
+			disk, err := io.ReadAll(tarR)
+			require.NoError(err)
+			assert.Equal(tc.image, disk)
+		})
+	}
+}
+
+// tarImage packs disk as a tar archive with a single file, optionally compressed with gzip.
+func tarImage(t *testing.T, name string, disk []byte, compress bool) []byte {
+	t.Helper()
+	require := require.New(t)
+
+	out := new(bytes.Buffer)
+	var w io.WriteCloser = nopWriteCloser{out}
+	if compress {
+		w = gzip.NewWriter(out)
+	}
+	tarW := tar.NewWriter(w)
+	require.NoError(tarW.WriteHeader(&tar.Header{Name: name, Size: int64(len(disk)), Mode: 0o644}))
+	_, err := tarW.Write(disk)
+	require.NoError(err)
+	require.NoError(tarW.Close())
+	require.NoError(w.Close())
+	return out.Bytes()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,117 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/iterator"
+)
+
+// Prune removes all but the newest keep images of the configured image family.
+// If state is empty, the images are deleted. Otherwise their deprecation state is set to state.
+// In dry run mode, nothing is changed. The names of the pruned images are returned.
+func (u *Uploader) Prune(ctx context.Context, keep int, state string, dryRun bool) ([]string, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("number of images to keep must be at least 1, got %d", keep)
+	}
+	family := u.config.GCP.ImageFamily
+	if family == "" {
+		return nil, errors.New("no image family configured")
+	}
+
+	imageC, err := u.image(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer imageC.Close()
+
+	images, err := u.listFamilyImages(ctx, imageC, family)
+	if err != nil {
+		return nil, fmt.Errorf("listing images of family %s: %w", family, err)
+	}
+
+	var pruned []string
+	for _, image := range imagesToPrune(images, keep, state) {
+		imageName := image.GetName()
+		pruned = append(pruned, imageName)
+		switch {
+		case dryRun && state == "":
+			u.log.Printf("Would delete image %s (dry run)", imageName)
+		case dryRun:
+			u.log.Printf("Would set state of image %s to %s (dry run)", imageName, state)
+		case state == "":
+			u.log.Printf("Deleting image %s", imageName)
+			op, err := imageC.Delete(ctx, &computepb.DeleteImageRequest{
+				Image:   imageName,
+				Project: u.config.GCP.Project,
+			})
+			if err != nil {
+				return pruned, fmt.Errorf("deleting image %s: %w", imageName, err)
+			}
+			if err := op.Wait(ctx); err != nil {
+				return pruned, fmt.Errorf("waiting for image %s to be deleted: %w", imageName, err)
+			}
+		default:
+			if err := u.deprecateImage(ctx, imageC, imageName, state, ""); err != nil {
+				return pruned, fmt.Errorf("setting state of image %s: %w", imageName, err)
+			}
+		}
+	}
+	return pruned, nil
+}
+
+func (u *Uploader) listFamilyImages(ctx context.Context, imageC imagesAPI, family string) ([]*computepb.Image, error) {
+	it := imageC.List(ctx, &computepb.ListImagesRequest{
+		Project: u.config.GCP.Project,
+		Filter:  toPtr(fmt.Sprintf("family = %q", family)),
+	})
+	var images []*computepb.Image
+	for {
+		image, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return images, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+}
+
+// imagesToPrune returns all images except the newest keep ones, newest first.
+// Images that already have the target deprecation state are skipped.
+func imagesToPrune(images []*computepb.Image, keep int, state string) []*computepb.Image {
+	sorted := slices.Clone(images)
+	// Creation timestamps are RFC3339 in UTC, so they sort lexicographically.
+	slices.SortStableFunc(sorted, func(a, b *computepb.Image) int {
+		switch {
+		case a.GetCreationTimestamp() > b.GetCreationTimestamp():
+			return -1
+		case a.GetCreationTimestamp() < b.GetCreationTimestamp():
+			return 1
+		default:
+			return 0
+		}
+	})
+	if len(sorted) <= keep {
+		return nil
+	}
+
+	var prune []*computepb.Image
+	for _, image := range sorted[keep:] {
+		if state != "" && image.GetDeprecated().GetState() == state {
+			continue
+		}
+		prune = append(prune, image)
+	}
+	return prune
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagesToPrune(t *testing.T) {
+	images := []*computepb.Image{
+		{Name: toPtr("image-2"), CreationTimestamp: toPtr("2024-02-01T10:00:00.000-00:00")},
+		{Name: toPtr("image-4"), CreationTimestamp: toPtr("2024-04-01T10:00:00.000-00:00")},
+		{
+			Name:              toPtr("image-1"),
+			CreationTimestamp: toPtr("2024-01-01T10:00:00.000-00:00"),
+			Deprecated:        &computepb.DeprecationStatus{State: toPtr("DELETED")},
+		},
+		{Name: toPtr("image-3"), CreationTimestamp: toPtr("2024-03-01T10:00:00.000-00:00")},
+	}
+
+	testCases := map[string]struct {
+		keep  int
+		state string
+		want  []string
+	}{
+		"keep newest": {
+			keep: 2,
+			want: []string{"image-2", "image-1"},
+		},
+		"keep all": {
+			keep: 4,
+		},
+		"keep more than exist": {
+			keep: 10,
+		},
+		"skip images already in target state": {
+			keep:  1,
+			state: "DELETED",
+			want:  []string{"image-3", "image-2"},
+		},
+		"other target state": {
+			keep:  1,
+			state: "OBSOLETE",
+			want:  []string{"image-3", "image-2", "image-1"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var got []string
+			for _, image := range imagesToPrune(images, tc.keep, tc.state) {
+				got = append(got, image.GetName())
+			}
+			assert.Equal(tc.want, got)
+			assert.Equal("image-2", images[0].GetName(), "input must not be reordered")
+		})
+	}
+}
+
+func TestListedImage(t *testing.T) {
+	assert := assert.New(t)
+
+	image := &computepb.Image{
+		Name:              toPtr("image-1"),
+		SelfLink:          toPtr("https://www.googleapis.com/compute/v1/projects/my-project/global/images/image-1"),
+		CreationTimestamp: toPtr("2024-01-01T10:00:00.000-08:00"),
+	}
+	want := uploader.ListedImage{
+		Provider:  "gcp",
+		Name:      "image-1",
+		Reference: "projects/my-project/global/images/image-1",
+		CreatedAt: time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+	}
+	got := listedImage(image)
+	assert.True(want.CreatedAt.Equal(got.CreatedAt))
+	got.CreatedAt = want.CreatedAt
+	assert.Equal(want, got)
+}
@@ -0,0 +1,36 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/grpc/codes"
+)
+
+// retryDelay is the delay before the first retry of a failed operation.
+const retryDelay = 5 * time.Second
+
+// isTransient reports whether a GCP API error is caused by rate limiting or a temporary service failure.
+func isTransient(err error) bool {
+	apiErr, ok := apierror.FromError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.HTTPCode() {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	switch apiErr.GRPCStatus().Code() {
+	case codes.ResourceExhausted, codes.Unavailable:
+		return true
+	}
+	return false
+}
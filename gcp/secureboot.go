@@ -0,0 +1,83 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+)
+
+// shieldedInstanceInitialState returns the secure boot keys and databases the image is created with.
+// If no keys are configured, nil is returned and instances use the default keys of GCP.
+func (u *Uploader) shieldedInstanceInitialState() (*computepb.InitialStateConfig, error) {
+	secureBoot := u.config.GCP.SecureBoot
+	if len(secureBoot.PK) == 0 && len(secureBoot.KEKs) == 0 && len(secureBoot.DBs) == 0 && len(secureBoot.DBXs) == 0 {
+		return nil, nil
+	}
+
+	var state computepb.InitialStateConfig
+	if len(secureBoot.PK) > 0 {
+		pk, err := fileContentBuffer(secureBoot.PK)
+		if err != nil {
+			return nil, fmt.Errorf("platform key: %w", err)
+		}
+		state.Pk = pk
+	}
+	var err error
+	if state.Keks, err = fileContentBuffers(secureBoot.KEKs); err != nil {
+		return nil, fmt.Errorf("key exchange keys: %w", err)
+	}
+	if state.Dbs, err = fileContentBuffers(secureBoot.DBs); err != nil {
+		return nil, fmt.Errorf("signature database: %w", err)
+	}
+	if state.Dbxs, err = fileContentBuffers(secureBoot.DBXs); err != nil {
+		return nil, fmt.Errorf("forbidden signature database: %w", err)
+	}
+	return &state, nil
+}
+
+func fileContentBuffers(contents [][]byte) ([]*computepb.FileContentBuffer, error) {
+	var buffers []*computepb.FileContentBuffer
+	for _, content := range contents {
+		buffer, err := fileContentBuffer(content)
+		if err != nil {
+			return nil, err
+		}
+		buffers = append(buffers, buffer)
+	}
+	return buffers, nil
+}
+
+// fileContentBuffer converts a secure boot key file to the format expected by the compute API.
+// PEM and DER encoded certificates are passed as X509, anything else, e.g. an EFI signature list
+// of forbidden hashes, is passed as binary.
+func fileContentBuffer(content []byte) (*computepb.FileContentBuffer, error) {
+	if block, _ := pem.Decode(content); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unsupported PEM block %q, expected a certificate", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+		return &computepb.FileContentBuffer{
+			Content:  toPtr(base64.StdEncoding.EncodeToString(block.Bytes)),
+			FileType: toPtr(computepb.FileContentBuffer_X509.String()),
+		}, nil
+	}
+	fileType := computepb.FileContentBuffer_BIN
+	if _, err := x509.ParseCertificate(content); err == nil {
+		fileType = computepb.FileContentBuffer_X509
+	}
+	return &computepb.FileContentBuffer{
+		Content:  toPtr(base64.StdEncoding.EncodeToString(content)),
+		FileType: toPtr(fileType.String()),
+	}, nil
+}
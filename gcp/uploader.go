@@ -10,16 +10,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"net/url"
 	"path"
+	"slices"
 	"strings"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
 	"cloud.google.com/go/storage"
 	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/internal/retry"
+	"github.com/edgelesssys/uplosi/uploader"
+	"google.golang.org/api/option"
 )
 
 // Uploader can upload and remove os images on GCP.
@@ -29,33 +35,55 @@ type Uploader struct {
 	image  func(context.Context) (imagesAPI, error)
 	bucket func(context.Context) (bucketAPI, error)
 
-	log *log.Logger
+	retryDelay time.Duration
+
+	log      *log.Logger
+	progress uploader.ProgressReporter
 }
 
 // NewUploader creates a new config.
-func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
+func NewUploader(config config.Config, log *log.Logger, progress uploader.ProgressReporter) (*Uploader, error) {
+	if progress == nil {
+		progress = uploader.NopProgress{}
+	}
+	var opts []option.ClientOption
+	if config.GCP.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(config.GCP.CredentialsFile))
+	}
 	return &Uploader{
 		config: config,
 		image: func(ctx context.Context) (imagesAPI, error) {
-			return compute.NewImagesRESTClient(ctx)
+			return compute.NewImagesRESTClient(ctx, opts...)
 		},
 		bucket: func(ctx context.Context) (bucketAPI, error) {
-			storage, err := storage.NewClient(ctx)
+			storage, err := storage.NewClient(ctx, opts...)
 			if err != nil {
 				return nil, err
 			}
 			return storage.Bucket(config.GCP.Bucket), nil
 		},
-		log: log,
+		retryDelay: retryDelay,
+		log:        log,
+		progress:   progress,
 	}, nil
 }
 
 // Upload uploads an OS image to GCP.
-func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (ref []string, retErr error) {
+// If a source image or disk is configured, the image is created from it and nothing is uploaded.
+func (u *Uploader) Upload(ctx context.Context, req *uploader.Request) (results []uploader.UploadResult, retErr error) {
 	// Ensure new image can be uploaded by deleting existing resources with the same name.
 	if err := u.ensureImageDeleted(ctx); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no image using the same name exists: %w", err)
 	}
+
+	if u.config.GCP.SourceImage != "" || u.config.GCP.SourceDisk != "" {
+		imageRef, err := u.createImage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating image: %w", err)
+		}
+		return u.uploadResults(imageRef), nil
+	}
+
 	if err := u.ensureBlobDeleted(ctx); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no blob using the same name exists: %w", err)
 	}
@@ -66,21 +94,122 @@ func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (re
 	}
 
 	// Upload tar.gz encoded raw image to GCS.
-	if err := u.uploadBlob(ctx, image); err != nil {
+	if err := u.uploadBlob(ctx, req.Image, req.Size); err != nil {
 		return nil, fmt.Errorf("uploading image to GCS: %w", err)
 	}
 	defer func(retErr *error) {
-		if err := u.ensureBlobDeleted(ctx); err != nil {
+		if u.config.KeepTempArtifacts.UnwrapOrZero() {
+			u.log.Printf("Keeping temporary blob %s", blobURL(u.config.GCP.Bucket, u.config.GCP.BlobName))
+			return
+		}
+		cleanupCtx, cancel := uploader.CleanupContext(ctx)
+		defer cancel()
+		if err := u.ensureBlobDeleted(cleanupCtx); err != nil {
 			*retErr = errors.Join(*retErr, fmt.Errorf("post-cleaning: deleting temporary blob from GCS: %w", err))
 		}
 	}(&retErr)
 
+	u.progress.Stage(uploader.StageCreateImage)
 	imageRef, err := u.createImage(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("creating image: %w", err)
 	}
 
-	return []string{imageRef}, nil
+	return u.uploadResults(imageRef), nil
+}
+
+// uploadResults returns the result of an upload of a global image.
+func (u *Uploader) uploadResults(imageRef string) []uploader.UploadResult {
+	return []uploader.UploadResult{{
+		Provider:     "gcp",
+		Reference:    imageRef,
+		ResourceType: "image",
+	}}
+}
+
+// insertImageRequest returns the request to create the image from the uploaded blob,
+// the source image or the source disk.
+func (u *Uploader) insertImageRequest() (*computepb.InsertImageRequest, error) {
+	initialState, err := u.shieldedInstanceInitialState()
+	if err != nil {
+		return nil, fmt.Errorf("reading secure boot keys: %w", err)
+	}
+	req := &computepb.InsertImageRequest{
+		ImageResource: &computepb.Image{
+			Name:                         &u.config.GCP.ImageName,
+			Family:                       &u.config.GCP.ImageFamily,
+			Architecture:                 toPtr(computeArchitecture(u.config.GCP.Architecture)),
+			GuestOsFeatures:              u.guestOSFeatures(),
+			ShieldedInstanceInitialState: initialState,
+			StorageLocations:             u.config.GCP.StorageLocations,
+			Labels:                       u.config.GCP.Labels,
+		},
+		Project: u.config.GCP.Project,
+	}
+	switch {
+	case u.config.GCP.SourceImage != "":
+		req.ImageResource.SourceImage = &u.config.GCP.SourceImage
+	case u.config.GCP.SourceDisk != "":
+		req.ImageResource.SourceDisk = &u.config.GCP.SourceDisk
+	default:
+		blobURL := blobURL(u.config.GCP.Bucket, u.config.GCP.BlobName)
+		req.ImageResource.RawDisk = &computepb.RawDisk{
+			ContainerType: toPtr("TAR"),
+			Source:        &blobURL,
+		}
+	}
+	return req, nil
+}
+
+// computeArchitecture maps the configured architecture to the compute API architecture of the image.
+// X86_64 is used if the architecture is unset.
+func computeArchitecture(arch string) string {
+	switch arch {
+	case "arm64":
+		return computepb.Image_ARM64.String()
+	default:
+		return computepb.Image_X86_64.String()
+	}
+}
+
+// imageUserRole is the IAM role granted to the users of an image.
+const imageUserRole = "roles/compute.imageUser"
+
+// imageUsers returns the IAM members that are allowed to use the image.
+// allAuthenticatedUsers is only included if the image is public. No members means the image stays private.
+func (u *Uploader) imageUsers() []string {
+	members := slices.Clone(u.config.GCP.ImageUsers)
+	if u.config.GCP.Public.UnwrapOrZero() {
+		members = append(members, "allAuthenticatedUsers")
+	}
+	return members
+}
+
+// defaultGuestOSFeatures are the guest OS features of an image if none are configured.
+var defaultGuestOSFeatures = []string{
+	"GVNIC",
+	"SEV_CAPABLE",
+	"SEV_SNP_CAPABLE",
+	"VIRTIO_SCSI_MULTIQUEUE",
+	"UEFI_COMPATIBLE",
+}
+
+// guestOSFeatures returns the configured guest OS features of the image, falling back to defaultGuestOSFeatures.
+// SECURE_BOOT is added if secure boot is enabled.
+func (u *Uploader) guestOSFeatures() []*computepb.GuestOsFeature {
+	names := u.config.GCP.GuestOSFeatures
+	if len(names) == 0 {
+		names = defaultGuestOSFeatures
+	}
+	if u.config.GCP.SecureBoot.Enabled.UnwrapOrZero() && !slices.Contains(names, "SECURE_BOOT") {
+		names = append(slices.Clone(names), "SECURE_BOOT")
+	}
+
+	features := make([]*computepb.GuestOsFeature, 0, len(names))
+	for _, name := range names {
+		features = append(features, &computepb.GuestOsFeature{Type: toPtr(name)})
+	}
+	return features
 }
 
 func (u *Uploader) createImage(ctx context.Context) (string, error) {
@@ -90,52 +219,48 @@ func (u *Uploader) createImage(ctx context.Context) (string, error) {
 		return "", err
 	}
 
-	u.log.Printf("Creating image %s", imageName)
-	blobURL := blobURL(u.config.GCP.Bucket, u.config.GCP.BlobName)
-	req := computepb.InsertImageRequest{
-		ImageResource: &computepb.Image{
-			Name: &imageName,
-			RawDisk: &computepb.RawDisk{
-				ContainerType: toPtr("TAR"),
-				Source:        &blobURL,
-			},
-			Family:       &u.config.GCP.ImageFamily,
-			Architecture: toPtr("X86_64"),
-			GuestOsFeatures: []*computepb.GuestOsFeature{
-				{Type: toPtr("GVNIC")},
-				{Type: toPtr("SEV_CAPABLE")},
-				{Type: toPtr("SEV_SNP_CAPABLE")},
-				{Type: toPtr("VIRTIO_SCSI_MULTIQUEUE")},
-				{Type: toPtr("UEFI_COMPATIBLE")},
-			},
-			// TODO(malt3): enable secure boot support
-			// ShieldedInstanceInitialState: nil,
-		},
-		Project: u.config.GCP.Project,
+	switch {
+	case u.config.GCP.SourceImage != "":
+		u.log.Printf("Creating image %s from source image %s", imageName, u.config.GCP.SourceImage)
+	case u.config.GCP.SourceDisk != "":
+		u.log.Printf("Creating image %s from source disk %s", imageName, u.config.GCP.SourceDisk)
+	default:
+		u.log.Printf("Creating image %s", imageName)
 	}
-	op, err := imageC.Insert(ctx, &req)
+	req, err := u.insertImageRequest()
+	if err != nil {
+		return "", err
+	}
+	var op *compute.Operation
+	err = retry.Transient(ctx, u.log, "Creating image", u.config.APIMaxAttempts.UnwrapOr(retry.DefaultMaxAttempts), isTransient, u.retryDelay, func() (err error) {
+		op, err = imageC.Insert(ctx, req)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("creating image: %w", err)
 	}
 	if err := op.Wait(ctx); err != nil {
 		return "", fmt.Errorf("waiting for image to be created: %w", err)
 	}
-	policy := &computepb.Policy{
-		Bindings: []*computepb.Binding{
-			{
-				Role:    toPtr("roles/compute.imageUser"),
-				Members: []string{"allAuthenticatedUsers"},
+	if members := u.imageUsers(); len(members) > 0 {
+		u.log.Printf("Granting %s to %s", imageUserRole, strings.Join(members, ", "))
+		policy := &computepb.Policy{
+			Bindings: []*computepb.Binding{
+				{
+					Role:    toPtr(imageUserRole),
+					Members: members,
+				},
 			},
-		},
-	}
-	if _, err = imageC.SetIamPolicy(ctx, &computepb.SetIamPolicyImageRequest{
-		Resource: imageName,
-		Project:  u.config.GCP.Project,
-		GlobalSetPolicyRequestResource: &computepb.GlobalSetPolicyRequest{
-			Policy: policy,
-		},
-	}); err != nil {
-		return "", fmt.Errorf("setting iam policy: %w", err)
+		}
+		if _, err = imageC.SetIamPolicy(ctx, &computepb.SetIamPolicyImageRequest{
+			Resource: imageName,
+			Project:  u.config.GCP.Project,
+			GlobalSetPolicyRequestResource: &computepb.GlobalSetPolicyRequest{
+				Policy: policy,
+			},
+		}); err != nil {
+			return "", fmt.Errorf("setting iam policy: %w", err)
+		}
 	}
 	image, err := imageC.Get(ctx, &computepb.GetImageRequest{
 		Image:   imageName,
@@ -144,23 +269,115 @@ func (u *Uploader) createImage(ctx context.Context) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("created image doesn't exist: %w", err)
 	}
+
+	if u.config.GCP.State != "" {
+		if err := u.deprecateImage(ctx, imageC, imageName, u.config.GCP.State, u.config.GCP.Replacement); err != nil {
+			return "", fmt.Errorf("setting state of image %s: %w", imageName, err)
+		}
+	}
+	for _, oldImageName := range u.config.GCP.DeprecateImages {
+		if err := u.deprecateImage(ctx, imageC, oldImageName, u.config.GCP.DeprecateImagesState, image.GetSelfLink()); err != nil {
+			return "", fmt.Errorf("setting state of image %s: %w", oldImageName, err)
+		}
+	}
+
 	return strings.TrimPrefix(image.GetSelfLink(), "https://www.googleapis.com/compute/v1/"), nil
 }
 
-func (u *Uploader) uploadBlob(ctx context.Context, img io.Reader) error {
+// deprecateImage sets the deprecation state of an image, pointing consumers to the replacement if not empty.
+func (u *Uploader) deprecateImage(ctx context.Context, imageC imagesAPI, imageName, state, replacement string) error {
+	u.log.Printf("Setting state of image %s to %s", imageName, state)
+	status := &computepb.DeprecationStatus{State: &state}
+	if replacement != "" {
+		status.Replacement = &replacement
+	}
+	op, err := imageC.Deprecate(ctx, &computepb.DeprecateImageRequest{
+		Image:                     imageName,
+		Project:                   u.config.GCP.Project,
+		DeprecationStatusResource: status,
+	})
+	if err != nil {
+		return err
+	}
+	return op.Wait(ctx)
+}
+
+func (u *Uploader) uploadBlob(ctx context.Context, img io.Reader, size int64) error {
 	blobName := u.config.GCP.BlobName
 	bucketC, err := u.bucket(ctx)
 	if err != nil {
 		return err
 	}
 	u.log.Printf("Uploading os image as temporary blob %s", blobName)
+	u.progress.Stage(uploader.StageUploadBlob)
+	img = uploader.NewProgressReader(img, size, u.progress)
 
-	writer := bucketC.Object(blobName).NewWriter(ctx)
-	_, err = io.Copy(writer, img)
-	if err != nil {
+	// The writer gets its own context so a cancellation aborts the resumable upload
+	// instead of committing a partial object.
+	writeCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	writer := bucketC.Object(blobName).NewWriter(writeCtx)
+	if !u.config.VerifyUpload.UnwrapOrZero() {
+		return copyWithContext(ctx, writer, img, abort)
+	}
+
+	checksum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if err := copyWithContext(ctx, writer, io.TeeReader(img, checksum), abort); err != nil {
 		return err
 	}
-	return writer.Close()
+	u.log.Printf("Verifying checksum of blob %s", blobName)
+	return verifyChecksum(blobName, checksum.Sum32(), writer.Attrs())
+}
+
+// verifyChecksum compares the CRC32C checksum GCS stored for the uploaded blob with the local checksum.
+func verifyChecksum(blobName string, local uint32, attrs *storage.ObjectAttrs) error {
+	if attrs == nil {
+		return fmt.Errorf("blob %s has no attributes", blobName)
+	}
+	if attrs.CRC32C != local {
+		return fmt.Errorf("checksum mismatch for blob %s: GCS stored CRC32C %08x, uploaded image has %08x", blobName, attrs.CRC32C, local)
+	}
+	return nil
+}
+
+// copyWithContext copies src into dst and closes dst, returning as soon as ctx is done.
+// On cancellation, abort is called to tear down the in-flight write and ctx.Err() is
+// returned, so the cancellation isn't masked by the error of the aborted writer.
+// The copy isn't waited for, since a read of src, e.g. from stdin, may block indefinitely.
+// dst is closed once the copy returns.
+func copyWithContext(ctx context.Context, dst io.WriteCloser, src io.Reader, abort func()) error {
+	copyErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(dst, src)
+		copyErr <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		abort()
+		go func() {
+			<-copyErr
+			_ = dst.Close()
+		}()
+		return ctx.Err()
+	case err := <-copyErr:
+		if err != nil {
+			abort()
+			_ = dst.Close()
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+	}
+
+	if err := dst.Close(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
 }
 
 func (u *Uploader) ensureImageDeleted(ctx context.Context) error {
@@ -236,8 +453,9 @@ func (u *Uploader) ensureBucket(ctx context.Context) error {
 	}
 	u.log.Printf("Creating bucket %s", bucket)
 	return bucketC.Create(ctx, u.config.GCP.Project, &storage.BucketAttrs{
-		PublicAccessPrevention: storage.PublicAccessPreventionEnforced,
+		PublicAccessPrevention: publicAccessPrevention(u.config.GCP.PublicAccessPrevention),
 		Location:               u.config.GCP.Location,
+		Labels:                 u.config.GCP.BucketLabels,
 	})
 }
 
@@ -257,6 +475,13 @@ func (u *Uploader) bucketExists(ctx context.Context) (bool, error) {
 	return false, err
 }
 
+func publicAccessPrevention(s string) storage.PublicAccessPrevention {
+	if s == "inherited" {
+		return storage.PublicAccessPreventionInherited
+	}
+	return storage.PublicAccessPreventionEnforced
+}
+
 func blobURL(bucketName, blobName string) string {
 	return (&url.URL{
 		Scheme: "https",
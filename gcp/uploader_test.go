@@ -0,0 +1,446 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"cloud.google.com/go/storage"
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCopyWithContext(t *testing.T) {
+	t.Run("copies and closes", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dst := &bufferWriteCloser{}
+		err := copyWithContext(context.Background(), dst, bytes.NewReader([]byte("image")), func() {})
+		assert.NoError(err)
+		assert.Equal("image", dst.String())
+		assert.True(dst.closed)
+	})
+
+	t.Run("close error is returned", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dst := &bufferWriteCloser{closeErr: errors.New("close failed")}
+		err := copyWithContext(context.Background(), dst, bytes.NewReader([]byte("image")), func() {})
+		assert.ErrorIs(err, dst.closeErr)
+	})
+
+	t.Run("cancel mid-copy", func(t *testing.T) {
+		assert := assert.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		dst := newBlockingWriteCloser()
+		go func() {
+			<-dst.started
+			cancel()
+		}()
+
+		err := copyWithContext(ctx, dst, endlessReader{}, dst.abort)
+		assert.ErrorIs(err, context.Canceled)
+		assert.True(dst.aborted())
+	})
+
+	t.Run("cancel while reading blocks", func(t *testing.T) {
+		assert := assert.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		src := newBlockingReader()
+		t.Cleanup(src.unblock)
+		go func() {
+			<-src.started
+			cancel()
+		}()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- copyWithContext(ctx, &bufferWriteCloser{}, src, func() {})
+		}()
+		select {
+		case err := <-done:
+			assert.ErrorIs(err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("copyWithContext didn't return after cancellation")
+		}
+	})
+}
+
+// blockingReader blocks every read until unblock is called,
+// similar to reading from a pipe that never gets written to.
+type blockingReader struct {
+	started     chan struct{}
+	unblockCh   chan struct{}
+	startOnce   sync.Once
+	unblockOnce sync.Once
+}
+
+func newBlockingReader() *blockingReader {
+	return &blockingReader{
+		started:   make(chan struct{}),
+		unblockCh: make(chan struct{}),
+	}
+}
+
+func (b *blockingReader) Read(_ []byte) (int, error) {
+	b.startOnce.Do(func() { close(b.started) })
+	<-b.unblockCh
+	return 0, io.EOF
+}
+
+func (b *blockingReader) unblock() {
+	b.unblockOnce.Do(func() { close(b.unblockCh) })
+}
+
+type bufferWriteCloser struct {
+	bytes.Buffer
+	closed   bool
+	closeErr error
+}
+
+func (b *bufferWriteCloser) Close() error {
+	b.closed = true
+	return b.closeErr
+}
+
+// blockingWriteCloser blocks the first write until abort is called,
+// similar to a GCS writer whose context gets cancelled.
+type blockingWriteCloser struct {
+	started   chan struct{}
+	abortCh   chan struct{}
+	startOnce sync.Once
+	abortOnce sync.Once
+}
+
+func newBlockingWriteCloser() *blockingWriteCloser {
+	return &blockingWriteCloser{
+		started: make(chan struct{}),
+		abortCh: make(chan struct{}),
+	}
+}
+
+func (b *blockingWriteCloser) Write(_ []byte) (int, error) {
+	b.startOnce.Do(func() { close(b.started) })
+	<-b.abortCh
+	return 0, errors.New("write aborted")
+}
+
+func (b *blockingWriteCloser) Close() error {
+	return errors.New("upload aborted")
+}
+
+func (b *blockingWriteCloser) abort() {
+	b.abortOnce.Do(func() { close(b.abortCh) })
+}
+
+func (b *blockingWriteCloser) aborted() bool {
+	select {
+	case <-b.abortCh:
+		return true
+	default:
+		return false
+	}
+}
+
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func TestNewUploaderCredentialsFile(t *testing.T) {
+	require := require.New(t)
+
+	conf := config.Config{GCP: config.GCPConfig{CredentialsFile: filepath.Join(t.TempDir(), "missing.json")}}
+	u, err := NewUploader(conf, log.New(io.Discard, "", 0), nil)
+	require.NoError(err)
+
+	_, err = u.image(context.Background())
+	require.ErrorContains(err, "missing.json")
+	_, err = u.bucket(context.Background())
+	require.ErrorContains(err, "missing.json")
+}
+
+func TestIsTransient(t *testing.T) {
+	testCases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"too many requests": {
+			err:  &googleapi.Error{Code: http.StatusTooManyRequests},
+			want: true,
+		},
+		"service unavailable": {
+			err:  &googleapi.Error{Code: http.StatusServiceUnavailable},
+			want: true,
+		},
+		"wrapped service unavailable": {
+			err:  fmt.Errorf("inserting image: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}),
+			want: true,
+		},
+		"not found": {
+			err: &googleapi.Error{Code: http.StatusNotFound},
+		},
+		"unavailable grpc status": {
+			err:  status.Error(codes.Unavailable, "unavailable"),
+			want: true,
+		},
+		"invalid argument grpc status": {
+			err: status.Error(codes.InvalidArgument, "invalid"),
+		},
+		"plain error": {
+			err: errors.New("failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isTransient(tc.err))
+		})
+	}
+}
+
+func TestInsertImageRequest(t *testing.T) {
+	certDER := newTestCertificate(t)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	defaultFeatures := []string{"GVNIC", "SEV_CAPABLE", "SEV_SNP_CAPABLE", "VIRTIO_SCSI_MULTIQUEUE", "UEFI_COMPATIBLE"}
+
+	testCases := map[string]struct {
+		guestOSFeatures  []string
+		storageLocations []string
+		labels           map[string]string
+		secureBoot       config.GCPSecureBootConfig
+		wantFeatures     []string
+		wantInitialState *computepb.InitialStateConfig
+		wantErr          bool
+	}{
+		"secure boot unset": {
+			wantFeatures: defaultFeatures,
+		},
+		"storage locations": {
+			storageLocations: []string{"europe-west3"},
+			wantFeatures:     defaultFeatures,
+		},
+		"labels": {
+			labels:       map[string]string{"team": "platform", "managed-by": "uplosi"},
+			wantFeatures: defaultFeatures,
+		},
+		"custom guest OS features": {
+			guestOSFeatures: []string{"GVNIC", "TDX_CAPABLE", "UEFI_COMPATIBLE"},
+			wantFeatures:    []string{"GVNIC", "TDX_CAPABLE", "UEFI_COMPATIBLE"},
+		},
+		"custom guest OS features with secure boot": {
+			guestOSFeatures: []string{"UEFI_COMPATIBLE"},
+			secureBoot:      config.GCPSecureBootConfig{Enabled: config.Some(true)},
+			wantFeatures:    []string{"UEFI_COMPATIBLE", "SECURE_BOOT"},
+		},
+		"secure boot already in guest OS features": {
+			guestOSFeatures: []string{"UEFI_COMPATIBLE", "SECURE_BOOT"},
+			secureBoot:      config.GCPSecureBootConfig{Enabled: config.Some(true)},
+			wantFeatures:    []string{"UEFI_COMPATIBLE", "SECURE_BOOT"},
+		},
+		"secure boot without keys": {
+			secureBoot:   config.GCPSecureBootConfig{Enabled: config.Some(true)},
+			wantFeatures: append(slices.Clone(defaultFeatures), "SECURE_BOOT"),
+		},
+		"secure boot with keys": {
+			secureBoot: config.GCPSecureBootConfig{
+				Enabled: config.Some(true),
+				PK:      certPEM,
+				KEKs:    [][]byte{certDER},
+				DBs:     [][]byte{certPEM, certDER},
+				DBXs:    [][]byte{[]byte("hashes")},
+			},
+			wantFeatures: append(slices.Clone(defaultFeatures), "SECURE_BOOT"),
+			wantInitialState: &computepb.InitialStateConfig{
+				Pk:   x509Buffer(certDER),
+				Keks: []*computepb.FileContentBuffer{x509Buffer(certDER)},
+				Dbs:  []*computepb.FileContentBuffer{x509Buffer(certDER), x509Buffer(certDER)},
+				Dbxs: []*computepb.FileContentBuffer{{
+					Content:  toPtr(base64.StdEncoding.EncodeToString([]byte("hashes"))),
+					FileType: toPtr("BIN"),
+				}},
+			},
+		},
+		"invalid PEM block": {
+			secureBoot: config.GCPSecureBootConfig{
+				Enabled: config.Some(true),
+				PK:      pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}),
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+
+			u := &Uploader{
+				config: config.Config{
+					GCP: config.GCPConfig{
+						Project:          "project",
+						ImageName:        "image",
+						ImageFamily:      "family",
+						Bucket:           "bucket",
+						BlobName:         "image.tar.gz",
+						GuestOSFeatures:  tc.guestOSFeatures,
+						StorageLocations: tc.storageLocations,
+						Labels:           tc.labels,
+						SecureBoot:       tc.secureBoot,
+					},
+				},
+			}
+
+			req, err := u.insertImageRequest()
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			var features []string
+			for _, feature := range req.ImageResource.GuestOsFeatures {
+				features = append(features, feature.GetType())
+			}
+			assert.Equal(tc.wantFeatures, features)
+			assert.Equal(tc.wantInitialState, req.ImageResource.ShieldedInstanceInitialState)
+			assert.Equal("https://storage.googleapis.com/bucket/image.tar.gz", req.ImageResource.RawDisk.GetSource())
+			assert.Equal(tc.storageLocations, req.ImageResource.StorageLocations)
+			assert.Equal(tc.labels, req.ImageResource.Labels)
+		})
+	}
+}
+
+func TestComputeArchitecture(t *testing.T) {
+	testCases := map[string]string{
+		"":       "X86_64",
+		"x86_64": "X86_64",
+		"arm64":  "ARM64",
+	}
+
+	for arch, want := range testCases {
+		t.Run(arch, func(t *testing.T) {
+			assert.Equal(t, want, computeArchitecture(arch))
+		})
+	}
+}
+
+func TestImageUsers(t *testing.T) {
+	testCases := map[string]struct {
+		imageUsers []string
+		public     config.Option[bool]
+		want       []string
+	}{
+		"private by default": {},
+		"explicitly private": {
+			public: config.Some(false),
+		},
+		"public": {
+			public: config.Some(true),
+			want:   []string{"allAuthenticatedUsers"},
+		},
+		"image users": {
+			imageUsers: []string{"user:alice@example.com", "domain:example.com"},
+			want:       []string{"user:alice@example.com", "domain:example.com"},
+		},
+		"image users and public": {
+			imageUsers: []string{"group:team@example.com"},
+			public:     config.Some(true),
+			want:       []string{"group:team@example.com", "allAuthenticatedUsers"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u := &Uploader{config: config.Config{GCP: config.GCPConfig{ImageUsers: tc.imageUsers, Public: tc.public}}}
+			got := u.imageUsers()
+			if len(tc.want) == 0 {
+				assert.Empty(t, got)
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func x509Buffer(der []byte) *computepb.FileContentBuffer {
+	return &computepb.FileContentBuffer{
+		Content:  toPtr(base64.StdEncoding.EncodeToString(der)),
+		FileType: toPtr("X509"),
+	}
+}
+
+func newTestCertificate(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	testCases := map[string]struct {
+		attrs   *storage.ObjectAttrs
+		wantErr bool
+	}{
+		"match": {
+			attrs: &storage.ObjectAttrs{CRC32C: 0x12345678},
+		},
+		"mismatch": {
+			attrs:   &storage.ObjectAttrs{CRC32C: 0x87654321},
+			wantErr: true,
+		},
+		"no attributes": {
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := verifyChecksum("blob", 0x12345678, tc.attrs)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
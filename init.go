@@ -0,0 +1,101 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/spf13/cobra"
+)
+
+func newInitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a commented config file for a provider",
+		Args:  cobra.NoArgs,
+		RunE:  runInit,
+	}
+	cmd.Flags().String("provider", "", "provider of the config (aws, azure, gcp, openstack)")
+	cmd.Flags().StringP("config", "c", "", fmt.Sprintf("path to the directory %s is written to", configName))
+	cmd.Flags().Bool("force", false, fmt.Sprintf("overwrite an existing %s", configName))
+
+	return cmd
+}
+
+func runInit(cmd *cobra.Command, _ []string) error {
+	flags, err := parseInitFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	configPath := path.Join(flags.configPath, configName)
+	if err := writeScaffold(configPath, flags.provider, flags.force); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Wrote %s, replace the <placeholders> before uploading\n", configPath)
+	return nil
+}
+
+// writeScaffold writes the scaffolded config for the provider to configPath.
+// An existing file is only overwritten if force is set.
+func writeScaffold(configPath, provider string, force bool) error {
+	scaffold, err := config.Scaffold(provider)
+	if err != nil {
+		return err
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	configFile, err := os.OpenFile(configPath, flag, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", configPath)
+	}
+	if err != nil {
+		return fmt.Errorf("opening config: %w", err)
+	}
+	defer configFile.Close()
+	if _, err := configFile.Write(scaffold); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+type initFlags struct {
+	provider   string
+	configPath string
+	force      bool
+}
+
+func parseInitFlags(cmd *cobra.Command) (*initFlags, error) {
+	provider, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return nil, fmt.Errorf("getting provider flag: %w", err)
+	}
+	if provider == "" {
+		return nil, errors.New("provider flag is required")
+	}
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("getting config flag: %w", err)
+	}
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return nil, fmt.Errorf("getting force flag: %w", err)
+	}
+	return &initFlags{
+		provider:   provider,
+		configPath: configPath,
+		force:      force,
+	}, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteScaffold(t *testing.T) {
+	testCases := map[string]struct {
+		existing bool
+		provider string
+		force    bool
+		wantErr  bool
+	}{
+		"new file": {
+			provider: "aws",
+		},
+		"existing file": {
+			existing: true,
+			provider: "aws",
+			wantErr:  true,
+		},
+		"existing file with force": {
+			existing: true,
+			provider: "gcp",
+			force:    true,
+		},
+		"unknown provider": {
+			provider: "foo",
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			configPath := filepath.Join(t.TempDir(), configName)
+			if tc.existing {
+				require.NoError(os.WriteFile(configPath, []byte("existing"), 0o644))
+			}
+
+			err := writeScaffold(configPath, tc.provider, tc.force)
+			content, readErr := os.ReadFile(configPath)
+			if tc.wantErr {
+				assert.Error(err)
+				if tc.existing {
+					assert.Equal("existing", string(content))
+				}
+				return
+			}
+			require.NoError(err)
+			require.NoError(readErr)
+			assert.Contains(string(content), `provider = "`+tc.provider+`"`)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package retry retries operations that fail with transient cloud API errors.
+package retry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is the number of attempts if none is configured.
+	DefaultMaxAttempts = 5
+	// MaxTransientDelay caps the backoff between attempts of Transient.
+	MaxTransientDelay = 2 * time.Minute
+)
+
+// Policy describes how an operation is retried.
+type Policy struct {
+	// MaxAttempts is the maximum number of calls, including the first one.
+	// Values below 1 are treated as 1.
+	MaxAttempts int
+	// InitialDelay is the delay before the second attempt. It doubles with every further attempt.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between attempts. Zero means no cap.
+	MaxDelay time.Duration
+	// Retryable reports whether an error is worth retrying.
+	// If nil, no error is retried.
+	Retryable func(error) bool
+	// OnRetry is called before waiting for the next attempt, e.g. to log the error.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't retryable or the maximum
+// number of attempts is reached. The delay between attempts grows exponentially with jitter.
+// The error of the last attempt is returned.
+func Do(ctx context.Context, policy Policy, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || policy.Retryable == nil || !policy.Retryable(err) {
+			return err
+		}
+		delay := policy.delay(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Transient calls fn until it succeeds, fails with an error that classify doesn't report as
+// transient or maxAttempts is reached. The first retry waits about delay, later ones back off
+// exponentially up to MaxTransientDelay. Every retry is logged with the name of the operation.
+func Transient(ctx context.Context, log *log.Logger, operation string, maxAttempts int,
+	classify func(error) bool, delay time.Duration, fn func() error,
+) error {
+	return Do(ctx, Policy{
+		MaxAttempts:  maxAttempts,
+		InitialDelay: delay,
+		MaxDelay:     MaxTransientDelay,
+		Retryable:    classify,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			log.Printf("%s failed with a transient error (attempt %d/%d), retrying in %s: %v", operation, attempt, maxAttempts, delay, err)
+		},
+	}, fn)
+}
+
+// delay returns the delay after the given failed attempt: InitialDelay*2^(attempt-1),
+// capped at MaxDelay and randomized to between half and the full value.
+func (p Policy) delay(attempt int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	transient := errors.New("transient")
+	permanent := errors.New("permanent")
+	isTransient := func(err error) bool { return errors.Is(err, transient) }
+
+	testCases := map[string]struct {
+		errs        []error
+		maxAttempts int
+		retryable   func(error) bool
+		wantCalls   int
+		wantErr     error
+	}{
+		"success": {
+			maxAttempts: 3,
+			retryable:   isTransient,
+			wantCalls:   1,
+		},
+		"transient then success": {
+			errs:        []error{transient, transient},
+			maxAttempts: 3,
+			retryable:   isTransient,
+			wantCalls:   3,
+		},
+		"permanent error": {
+			errs:        []error{permanent},
+			maxAttempts: 3,
+			retryable:   isTransient,
+			wantCalls:   1,
+			wantErr:     permanent,
+		},
+		"attempts exhausted": {
+			errs:        []error{transient, transient, transient, transient},
+			maxAttempts: 3,
+			retryable:   isTransient,
+			wantCalls:   3,
+			wantErr:     transient,
+		},
+		"zero attempts call once": {
+			errs:      []error{transient},
+			retryable: isTransient,
+			wantCalls: 1,
+			wantErr:   transient,
+		},
+		"no classifier": {
+			errs:        []error{transient},
+			maxAttempts: 3,
+			wantCalls:   1,
+			wantErr:     transient,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var calls, retries int
+			policy := Policy{
+				MaxAttempts: tc.maxAttempts,
+				Retryable:   tc.retryable,
+				OnRetry:     func(int, error, time.Duration) { retries++ },
+			}
+			err := Do(context.Background(), policy, func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			assert.ErrorIs(err, tc.wantErr)
+			if tc.wantErr == nil {
+				assert.NoError(err)
+			}
+			assert.Equal(tc.wantCalls, calls)
+			assert.Equal(tc.wantCalls-1, retries)
+		})
+	}
+}
+
+func TestDoContextCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	transient := errors.New("transient")
+	policy := Policy{
+		MaxAttempts:  3,
+		InitialDelay: time.Hour,
+		Retryable:    func(error) bool { return true },
+		OnRetry:      func(int, error, time.Duration) { cancel() },
+	}
+	err := Do(ctx, policy, func() error { return transient })
+	assert.ErrorIs(err, transient)
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func TestTransient(t *testing.T) {
+	assert := assert.New(t)
+
+	transient := errors.New("transient")
+	var logs bytes.Buffer
+	var calls int
+	err := Transient(context.Background(), log.New(&logs, "", 0), "Creating image", 3,
+		func(err error) bool { return errors.Is(err, transient) }, 0, func() error {
+			calls++
+			return transient
+		})
+	assert.ErrorIs(err, transient)
+	assert.Equal(3, calls)
+	assert.Contains(logs.String(), "Creating image failed with a transient error (attempt 1/3)")
+	assert.Contains(logs.String(), "Creating image failed with a transient error (attempt 2/3)")
+	assert.NotContains(logs.String(), "attempt 3/3")
+}
+
+func TestDelay(t *testing.T) {
+	policy := Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	testCases := map[int]struct {
+		min, max time.Duration
+	}{
+		1:  {min: 500 * time.Millisecond, max: time.Second},
+		2:  {min: time.Second, max: 2 * time.Second},
+		3:  {min: 2 * time.Second, max: 4 * time.Second},
+		4:  {min: 2500 * time.Millisecond, max: 5 * time.Second},
+		10: {min: 2500 * time.Millisecond, max: 5 * time.Second},
+	}
+
+	for attempt, tc := range testCases {
+		for range 100 {
+			delay := policy.delay(attempt)
+			assert.GreaterOrEqual(t, delay, tc.min, "attempt %d", attempt)
+			assert.LessOrEqual(t, delay, tc.max, "attempt %d", attempt)
+		}
+	}
+}
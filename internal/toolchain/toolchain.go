@@ -0,0 +1,33 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package toolchain locates the external tools uplosi calls.
+package toolchain
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Load returns the absolute path of the tool named by the environment variable key,
+// or of fallback if the variable isn't set. It returns an empty string if the tool isn't found.
+func Load(key, fallback string) string {
+	toolchain := os.Getenv(key)
+	if toolchain == "" {
+		toolchain = fallback
+	}
+	toolchain, err := exec.LookPath(toolchain)
+	if err != nil {
+		return ""
+	}
+
+	absolutePath, err := filepath.Abs(toolchain)
+	if err != nil {
+		return ""
+	}
+	return absolutePath
+}
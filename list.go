@@ -0,0 +1,186 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/spf13/cobra"
+)
+
+func newListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the existing images of the configured image series",
+		Args:  cobra.NoArgs,
+		RunE:  runList,
+	}
+	cmd.Flags().StringSlice("enable-variant-glob", []string{"*"}, "list of variant name globs to enable")
+	cmd.Flags().StringSlice("disable-variant-glob", nil, "list of variant name globs to disable")
+	cmd.Flags().StringP("config", "c", "", fmt.Sprintf("path to directory %s and %s resides in", configName, configDir))
+	cmd.Flags().String("config-dir", "", "path to a directory of *.toml and *.conf config files whose images are listed")
+	cmd.MarkFlagsMutuallyExclusive("config", "config-dir")
+	cmd.Flags().String("provider", "", "override the provider of every variant (aws, azure, gcp, openstack)")
+	cmd.Flags().StringP("output", "o", "table", "format of the printed images (table, json)")
+
+	return cmd
+}
+
+// lister is implemented by uploaders that can list the existing images of the configured image series.
+type lister interface {
+	List(ctx context.Context) ([]uploader.ListedImage, error)
+}
+
+func runList(cmd *cobra.Command, _ []string) error {
+	flags, err := parseListFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	configFiles, err := loadConfigFiles([]string{flags.configPath}, flags.configDirPath)
+	if err != nil {
+		return fmt.Errorf("parsing config files: %w", err)
+	}
+	logger := log.New(cmd.ErrOrStderr(), "", log.LstdFlags)
+
+	images := []uploader.ListedImage{}
+	// Variants commonly share an image series, whose images are only listed once.
+	seen := make(map[string]struct{})
+	for _, configFile := range configFiles {
+		configFile.conf.SetProviderOverride(flags.provider)
+		err := configFile.conf.ForEach(
+			func(name string, cfg config.Config) error {
+				variantImages, err := listVariant(cmd.Context(), cfg, logger)
+				if err != nil {
+					return fmt.Errorf("variant %q: %w", name, err)
+				}
+				for _, image := range variantImages {
+					key := image.Provider + "/" + image.Reference
+					if _, ok := seen[key]; ok {
+						continue
+					}
+					seen[key] = struct{}{}
+					images = append(images, image)
+				}
+				return nil
+			},
+			os.ReadFile,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", configFile.path, err)
+		}
+	}
+
+	return printListedImages(cmd.OutOrStdout(), flags.outputFormat, images)
+}
+
+// listVariant returns the existing images of a single variant, oldest first.
+func listVariant(ctx context.Context, cfg config.Config, logger *log.Logger) ([]uploader.ListedImage, error) {
+	_, upload, err := newUploader(cfg, logger, nil)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := upload.(lister)
+	if !ok {
+		return nil, fmt.Errorf("provider %s doesn't support listing images", cfg.Provider)
+	}
+	images, err := l.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing images: %w", err)
+	}
+	slices.SortStableFunc(images, func(a, b uploader.ListedImage) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return images, nil
+}
+
+// printListedImages writes the images as a table or as JSON to out.
+func printListedImages(out io.Writer, format string, images []uploader.ListedImage) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(images)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tREGION\tNAME\tCREATED\tREFERENCE")
+	for _, image := range images {
+		region := image.Region
+		if region == "" {
+			region = "-"
+		}
+		created := "-"
+		if !image.CreatedAt.IsZero() {
+			created = image.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", image.Provider, region, image.Name, created, image.Reference)
+	}
+	return w.Flush()
+}
+
+type listFlags struct {
+	enableVariantGlobs  []string
+	disableVariantGlobs []string
+	configPath          string
+	configDirPath       string
+	provider            string
+	outputFormat        string
+}
+
+func parseListFlags(cmd *cobra.Command) (*listFlags, error) {
+	enableVariantGlobs, err := cmd.Flags().GetStringSlice("enable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting enable-variant-glob flag: %w", err)
+	}
+	disableVariantGlobs, err := cmd.Flags().GetStringSlice("disable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting disable-variant-glob flag: %w", err)
+	}
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("getting config flag: %w", err)
+	}
+	configDirPath, err := cmd.Flags().GetString("config-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting config-dir flag: %w", err)
+	}
+	provider, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return nil, fmt.Errorf("getting provider flag: %w", err)
+	}
+	outputFormat, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, fmt.Errorf("getting output flag: %w", err)
+	}
+	if outputFormat != "table" && outputFormat != "json" {
+		return nil, fmt.Errorf("output format must be one of table, json, got %q", outputFormat)
+	}
+	return &listFlags{
+		enableVariantGlobs:  enableVariantGlobs,
+		disableVariantGlobs: disableVariantGlobs,
+		configPath:          configPath,
+		configDirPath:       configDirPath,
+		provider:            provider,
+		outputFormat:        outputFormat,
+	}, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintListedImages(t *testing.T) {
+	images := []uploader.ListedImage{
+		{
+			Provider:  "aws",
+			Region:    "eu-central-1",
+			Name:      "demo-1.2.3",
+			Reference: "arn:aws:ec2:eu-central-1:123456789012:image/ami-1",
+			CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{Provider: "gcp", Name: "demo-1-2-4", Reference: "projects/p/global/images/demo-1-2-4"},
+	}
+
+	testCases := map[string]struct {
+		format string
+		want   string
+	}{
+		"table": {
+			format: "table",
+			want: "PROVIDER  REGION        NAME        CREATED               REFERENCE\n" +
+				"aws       eu-central-1  demo-1.2.3  2024-01-02T03:04:05Z  arn:aws:ec2:eu-central-1:123456789012:image/ami-1\n" +
+				"gcp       -             demo-1-2-4  -                     projects/p/global/images/demo-1-2-4\n",
+		},
+		"json": {
+			format: "json",
+			want: `[
+  {
+    "provider": "aws",
+    "region": "eu-central-1",
+    "name": "demo-1.2.3",
+    "reference": "arn:aws:ec2:eu-central-1:123456789012:image/ami-1",
+    "createdAt": "2024-01-02T03:04:05Z"
+  },
+  {
+    "provider": "gcp",
+    "name": "demo-1-2-4",
+    "reference": "projects/p/global/images/demo-1-2-4",
+    "createdAt": "0001-01-01T00:00:00Z"
+  }
+]
+`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var out bytes.Buffer
+			assert.NoError(printListedImages(&out, tc.format, images))
+			assert.Equal(tc.want, out.String())
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -23,17 +24,18 @@ func main() {
 
 func execute() error {
 	cmd := newRootCmd()
-	ctx, cancel := signalContext(context.Background(), os.Interrupt)
+	ctx, cancel := signalContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 	return cmd.ExecuteContext(ctx)
 }
 
-// signalContext returns a context that is canceled on the handed signal.
-// The signal isn't watched after its first occurrence. Call the cancel
-// function to ensure the internal goroutine is stopped and the signal isn't
+// signalContext returns a context that is canceled on any of the handed signals.
+// The signals aren't watched after the first occurrence. Call the cancel
+// function to ensure the internal goroutine is stopped and the signals aren't
 // watched any longer.
-func signalContext(ctx context.Context, sig os.Signal) (context.Context, context.CancelFunc) {
-	sigCtx, stop := signal.NotifyContext(ctx, sig)
+// Uploaders clean up their temporary resources with a detached context after cancellation.
+func signalContext(ctx context.Context, sigs ...os.Signal) (context.Context, context.CancelFunc) {
+	sigCtx, stop := signal.NotifyContext(ctx, sigs...)
 	done := make(chan struct{}, 1)
 	stopDone := make(chan struct{}, 1)
 
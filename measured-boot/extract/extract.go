@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"path"
 	"sort"
+	"strings"
 
 	"github.com/edgelesssys/uplosi/measured-boot/pesection"
 )
@@ -23,6 +25,49 @@ func CopyFrom(dissectToolchain, image, path, output string) error {
 	return nil
 }
 
+// List is a wrapper for systemd-dissect --list.
+// It returns the absolute paths of all files in the image.
+func List(dissectToolchain, image string) ([]string, error) {
+	if dissectToolchain == "" {
+		dissectToolchain = "systemd-dissect"
+	}
+	out, err := exec.Command(dissectToolchain, "--list", image).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in %s: %v", image, err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, "/") {
+			continue
+		}
+		files = append(files, "/"+strings.TrimPrefix(line, "/"))
+	}
+	return files, nil
+}
+
+// MatchPaths returns the files matching any of the patterns, ordered by the first matching pattern.
+// Matching is case-insensitive, as the ESP is usually a FAT file system.
+func MatchPaths(files, patterns []string) []string {
+	var matches []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		var patternMatches []string
+		for _, file := range files {
+			if seen[file] {
+				continue
+			}
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(file)); ok {
+				patternMatches = append(patternMatches, file)
+				seen[file] = true
+			}
+		}
+		sort.Strings(patternMatches)
+		matches = append(matches, patternMatches...)
+	}
+	return matches
+}
+
 // PeSectionReader returns a reader for the named section of a PE file.
 func PeSectionReader(peFile io.ReaderAt, section string) (io.Reader, error) {
 	f, err := pe.NewFile(peFile)
@@ -45,6 +90,30 @@ func PeSectionReader(peFile io.ReaderAt, section string) (io.Reader, error) {
 	return nil, fmt.Errorf("section %q not found in %v", section, sectionNames)
 }
 
+// PeSectionReaders returns readers for all sections of a PE file with the given name,
+// in the order they appear in the section table.
+func PeSectionReaders(peFile io.ReaderAt, section string) ([]io.Reader, error) {
+	f, err := pe.NewFile(peFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var readers []io.Reader
+	sectionNames := make([]string, len(f.Sections))
+	for i, s := range f.Sections {
+		sectionNames[i] = s.Name
+		if s.Name == section {
+			readers = append(readers, io.LimitReader(s.Open(), int64(s.VirtualSize)))
+		}
+	}
+	if len(readers) == 0 {
+		return nil, fmt.Errorf("section %q not found in %v", section, sectionNames)
+	}
+
+	return readers, nil
+}
+
 // PeFileSectionDigests returns the section digests of a PE file.
 func PeFileSectionDigests(peFile io.ReaderAt) ([]pesection.PESection, error) {
 	f, err := pe.NewFile(peFile)
@@ -2,6 +2,7 @@ package extract
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"io"
 	"testing"
 
@@ -36,6 +37,69 @@ func TestPeSectionReader(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestPeSectionReaders(t *testing.T) {
+	assert := assert.New(t)
+
+	// can read single section
+	readers, err := PeSectionReaders(bytes.NewReader(testdata.UKI()), ".initrd")
+	assert.NoError(err)
+	initrd, err := io.ReadAll(io.MultiReader(readers...))
+	assert.NoError(err)
+	assert.Equal("content of .initrd", string(initrd))
+
+	// can read multiple sections with the same name in order
+	readers, err = PeSectionReaders(bytes.NewReader(testdata.UKIMultiInitrd()), ".initrd")
+	assert.NoError(err)
+	assert.Len(readers, 2)
+	initrdDigest := sha256.New()
+	_, err = io.Copy(initrdDigest, io.MultiReader(readers...))
+	assert.NoError(err)
+	assert.Equal(sha256.Sum256([]byte("content of .initrdcontent of .splash")), [32]byte(initrdDigest.Sum(nil)))
+
+	// fails to read non-existing section
+	_, err = PeSectionReaders(bytes.NewReader(testdata.UKI()), ".non-existing")
+	assert.Error(err)
+}
+
+func TestMatchPaths(t *testing.T) {
+	testCases := map[string]struct {
+		files    []string
+		patterns []string
+		want     []string
+	}{
+		"no match": {
+			files:    []string{"/etc/os-release"},
+			patterns: []string{"/boot/EFI/BOOT/BOOTX64.EFI"},
+		},
+		"exact match": {
+			files:    []string{"/etc/os-release", "/boot/EFI/BOOT/BOOTX64.EFI"},
+			patterns: []string{"/boot/EFI/BOOT/BOOTX64.EFI"},
+			want:     []string{"/boot/EFI/BOOT/BOOTX64.EFI"},
+		},
+		"case-insensitive match": {
+			files:    []string{"/boot/efi/boot/bootx64.efi"},
+			patterns: []string{"/boot/EFI/BOOT/BOOTX64.EFI"},
+			want:     []string{"/boot/efi/boot/bootx64.efi"},
+		},
+		"ordered by pattern": {
+			files:    []string{"/efi/EFI/Linux/b.efi", "/efi/EFI/Linux/a.efi", "/efi/EFI/BOOT/BOOTAA64.EFI"},
+			patterns: []string{"/efi/EFI/BOOT/BOOTAA64.EFI", "/efi/EFI/Linux/*.efi"},
+			want:     []string{"/efi/EFI/BOOT/BOOTAA64.EFI", "/efi/EFI/Linux/a.efi", "/efi/EFI/Linux/b.efi"},
+		},
+		"no duplicates": {
+			files:    []string{"/boot/EFI/BOOT/BOOTX64.EFI"},
+			patterns: []string{"/boot/EFI/BOOT/BOOTX64.EFI", "/boot/EFI/BOOT/*.EFI"},
+			want:     []string{"/boot/EFI/BOOT/BOOTX64.EFI"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, MatchPaths(tc.files, tc.patterns))
+		})
+	}
+}
+
 func TestPeFileSectionDigests(t *testing.T) {
 	assert := assert.New(t)
 
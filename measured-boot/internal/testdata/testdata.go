@@ -7,5 +7,13 @@ func UKI() []byte {
 	return ukiEFI[:]
 }
 
+// UKIMultiInitrd returns a UKI EFI binary with two initrd sections.
+func UKIMultiInitrd() []byte {
+	return ukiMultiInitrdEFI[:]
+}
+
 //go:embed uki.efi
 var ukiEFI []byte
+
+//go:embed uki-multi-initrd.efi
+var ukiMultiInitrdEFI []byte
@@ -0,0 +1,55 @@
+package measure
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// TCGEvent is an extend event in a format resembling the crypto agile TCG event log,
+// as printed by tpm2_eventlog.
+type TCGEvent struct {
+	EventNum    int
+	PCRIndex    uint32
+	EventType   string
+	DigestCount int
+	Digests     []TCGDigest
+	EventSize   int
+	Event       string `json:",omitempty"`
+	Description string
+}
+
+// TCGDigest is a digest of an event for a single hash algorithm.
+type TCGDigest struct {
+	AlgorithmId string
+	Digest      string
+}
+
+// TCGEventLog returns the events extended into the simulator in the order they were measured.
+func (s *Simulator) TCGEventLog() []TCGEvent {
+	events := make([]TCGEvent, 0, len(s.EventLog.Events))
+	for i, event := range s.EventLog.Events {
+		events = append(events, TCGEvent{
+			EventNum:    i,
+			PCRIndex:    event.PCRIndex,
+			EventType:   eventType(event.Description),
+			DigestCount: 1,
+			Digests: []TCGDigest{
+				{AlgorithmId: "sha256", Digest: hex.EncodeToString(event.Digest[:])},
+			},
+			EventSize:   len(event.Data),
+			Event:       hex.EncodeToString(event.Data),
+			Description: event.Description,
+		})
+	}
+	return events
+}
+
+// eventType extracts the TCG event type from the description of an event,
+// which is prefixed with the event type by convention, e.g. "EV_SEPARATOR" or "EV_IPL: ...".
+func eventType(description string) string {
+	if !strings.HasPrefix(description, "EV_") {
+		return ""
+	}
+	eventType, _, _ := strings.Cut(description, ":")
+	return eventType
+}
@@ -0,0 +1,48 @@
+package measure
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCGEventLog(t *testing.T) {
+	assert := assert.New(t)
+
+	sim := NewDefaultSimulator()
+	assert.NoError(sim.ExtendPCR(4, EVEFIActionPCR256(), nil, "EV_EFI_ACTION: Calling EFI Application from Boot Option"))
+	assert.NoError(sim.ExtendPCR(4, EVSeparatorPCR256(), []byte{0x00, 0x00, 0x00, 0x00}, "EV_SEPARATOR"))
+	assert.NoError(sim.ExtendPCR(9, [32]byte{0x01}, []byte("cmdline"), "custom event"))
+
+	separator := EVSeparatorPCR256()
+	assert.Equal([]TCGEvent{
+		{
+			EventNum:    0,
+			PCRIndex:    4,
+			EventType:   "EV_EFI_ACTION",
+			DigestCount: 1,
+			Digests:     []TCGDigest{{AlgorithmId: "sha256", Digest: "3d6772b4f84ed47595d72a2c4c5ffd15f5bb72c7507fe26f2aaee2c69d5633ba"}},
+			Description: "EV_EFI_ACTION: Calling EFI Application from Boot Option",
+		},
+		{
+			EventNum:    1,
+			PCRIndex:    4,
+			EventType:   "EV_SEPARATOR",
+			DigestCount: 1,
+			Digests:     []TCGDigest{{AlgorithmId: "sha256", Digest: hex.EncodeToString(separator[:])}},
+			EventSize:   4,
+			Event:       "00000000",
+			Description: "EV_SEPARATOR",
+		},
+		{
+			EventNum:    2,
+			PCRIndex:    9,
+			DigestCount: 1,
+			Digests:     []TCGDigest{{AlgorithmId: "sha256", Digest: "01" + hex.EncodeToString(make([]byte, 31))}},
+			EventSize:   7,
+			Event:       hex.EncodeToString([]byte("cmdline")),
+			Description: "custom event",
+		},
+	}, sim.TCGEventLog())
+}
@@ -43,7 +43,7 @@ func PredictPCR4(simulator *Simulator, efiBootStages []EFIBootStage) error {
 
 	for i, efiBootStage := range efiBootStages {
 		// TCG PC Client Platform Firmware Profile Family "2.0 Section" 7.2.4.4.e
-		err := simulator.ExtendPCR(4, efiBootStage.Digest, nil, fmt.Sprintf("Boot Stage %d: %s", i+1, efiBootStage.Name))
+		err := simulator.ExtendPCR(4, efiBootStage.Digest, nil, fmt.Sprintf("EV_EFI_BOOT_SERVICES_APPLICATION: Boot Stage %d: %s", i+1, efiBootStage.Name))
 		if err != nil {
 			return err
 		}
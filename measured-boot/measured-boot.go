@@ -20,7 +20,18 @@ const (
 	UkiPath = "/boot/EFI/BOOT/BOOTX64.EFI"
 )
 
+// UkiCandidatePaths are probed in order to find the UKI if no explicit path is given.
+var UkiCandidatePaths = []string{
+	UkiPath,
+	"/boot/EFI/BOOT/BOOTAA64.EFI",
+	"/boot/EFI/Linux/*.efi",
+	"/efi/EFI/BOOT/BOOTX64.EFI",
+	"/efi/EFI/BOOT/BOOTAA64.EFI",
+	"/efi/EFI/Linux/*.efi",
+}
+
 // PrecalculatePCRs precalculates the PCRs for a given image file and saves the PCR banks in the simulator.
+// If ukiPath is empty, the UKI is searched for in UkiCandidatePaths.
 func PrecalculatePCRs(fs afero.Fs, dissectToolchain, ukiPath, imageFile string) (*measure.Simulator, error) {
 	dir, err := afero.TempDir(fs, "", "con-measure")
 	if err != nil {
@@ -32,7 +43,13 @@ func PrecalculatePCRs(fs afero.Fs, dissectToolchain, ukiPath, imageFile string)
 
 	// extract UKI from raw image
 	ukiFile := filepath.Join(dir, "uki.efi")
-	if err := extract.CopyFrom(dissectToolchain, imageFile, ukiPath, ukiFile); err != nil {
+	if ukiPath == "" {
+		ukiPath, err = detectUKI(fs, dissectToolchain, imageFile, ukiFile)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Using UKI at %s\n", ukiPath)
+	} else if err := extract.CopyFrom(dissectToolchain, imageFile, ukiPath, ukiFile); err != nil {
 		return nil, fmt.Errorf("failed to extract UKI: %v", err)
 	}
 
@@ -81,6 +98,38 @@ func PrecalculatePCRs(fs afero.Fs, dissectToolchain, ukiPath, imageFile string)
 	return simulator, nil
 }
 
+// detectUKI extracts the first file matching UkiCandidatePaths that contains a Linux kernel to ukiFile.
+// Candidates without a kernel, like systemd-boot in the fallback boot path, are skipped.
+func detectUKI(fs afero.Fs, dissectToolchain, imageFile, ukiFile string) (string, error) {
+	files, err := extract.List(dissectToolchain, imageFile)
+	if err != nil {
+		return "", err
+	}
+	candidates := extract.MatchPaths(files, UkiCandidatePaths)
+	for _, candidate := range candidates {
+		if err := extract.CopyFrom(dissectToolchain, imageFile, candidate, ukiFile); err != nil {
+			return "", fmt.Errorf("failed to extract UKI: %v", err)
+		}
+		if isUKI(fs, ukiFile) {
+			return candidate, nil
+		}
+		if err := fs.Remove(ukiFile); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no UKI found in image, probed %v (found %v)", UkiCandidatePaths, candidates)
+}
+
+func isUKI(fs afero.Fs, peFile string) bool {
+	f, err := fs.Open(peFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = extract.PeSectionReader(f, ".linux")
+	return err == nil
+}
+
 func measurePE(fs afero.Fs, peFile string) ([]byte, error) {
 	f, err := fs.Open(peFile)
 	if err != nil {
@@ -142,13 +191,15 @@ func precalculatePCR9(simulator *measure.Simulator, fs afero.Fs, ukiFile string)
 		return err
 	}
 
-	initrdSectionReader, err := extract.PeSectionReader(ukiPe, ".initrd")
+	// A UKI may carry multiple initrd sections. They are concatenated
+	// in section table order and passed to the kernel as a single initrd.
+	initrdSectionReaders, err := extract.PeSectionReaders(ukiPe, ".initrd")
 	if err != nil {
 		return fmt.Errorf("uki does not contain initrd: %v", err)
 	}
 
 	initrdDigest := sha256.New()
-	if _, err := io.Copy(initrdDigest, initrdSectionReader); err != nil {
+	if _, err := io.Copy(initrdDigest, io.MultiReader(initrdSectionReaders...)); err != nil {
 		return err
 	}
 
@@ -8,11 +8,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/edgelesssys/uplosi/internal/toolchain"
 	measuredboot "github.com/edgelesssys/uplosi/measured-boot"
 	"github.com/edgelesssys/uplosi/measured-boot/measure"
 	"github.com/spf13/afero"
@@ -21,13 +23,15 @@ import (
 
 func newMeasurementsCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "measurements <image>",
-		Short: "Precalculate TPM PCR measurements for an image. Requires 'systemd-dissect' to be in the PATH.",
-		Args:  cobra.ExactArgs(1),
+		Use:   "measurements <image>...",
+		Short: "Precalculate TPM PCR measurements for one or more images. Requires 'systemd-dissect' to be in the PATH.",
+		Args:  cobra.MinimumNArgs(1),
 		RunE:  runMeasurements,
 	}
 	cmd.Flags().StringP("output-file", "o", "", "Output file for the precalculated measurements")
-	cmd.Flags().StringP("uki-path", "u", measuredboot.UkiPath, "Path to the UKI file in the image")
+	cmd.Flags().StringP("uki-path", "u", "", "Path to the UKI file in the image (default: auto-detect)")
+	cmd.Flags().String("event-log", "", "Output file for the predicted event log in a format resembling the TCG event log")
+	cmd.Flags().Bool("require-identical", false, "Fail if the images don't produce identical PCR values")
 
 	return cmd
 }
@@ -38,27 +42,136 @@ func runMeasurements(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("parsing flags: %w", err)
 	}
 
+	imagePaths, err := expandImagePaths(args)
+	if err != nil {
+		return fmt.Errorf("expanding image paths: %w", err)
+	}
+
 	fs := afero.NewOsFs()
-	dissectToolchain := loadToolchain("DISSECT_TOOLCHAIN", "systemd-dissect")
+	dissectToolchain := toolchain.Load("DISSECT_TOOLCHAIN", "systemd-dissect")
 
-	simulator, err := measuredboot.PrecalculatePCRs(fs, dissectToolchain, flags.ukiPath, args[0])
-	if err != nil {
-		return fmt.Errorf("precalculating PCRs: %w", err)
+	simulators := make(map[string]*measure.Simulator, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		simulator, err := measuredboot.PrecalculatePCRs(fs, dissectToolchain, flags.ukiPath, imagePath)
+		if err != nil {
+			return fmt.Errorf("precalculating PCRs for %s: %w", imagePath, err)
+		}
+		simulators[imagePath] = simulator
+	}
+
+	// A single image path keeps the original output format. Multiple arguments or a glob are keyed
+	// by image path, even if they match one image, so the format doesn't depend on the number of matches.
+	var output, eventLog any
+	if !keyByImagePath(args) {
+		simulator := simulators[imagePaths[0]]
+		output = simulator
+		eventLog = simulator.TCGEventLog()
+	} else {
+		eventLogs := make(map[string][]measure.TCGEvent, len(simulators))
+		for imagePath, simulator := range simulators {
+			eventLogs[imagePath] = simulator.TCGEventLog()
+		}
+		output = simulators
+		eventLog = eventLogs
 	}
 
 	if flags.outputFile != "" {
-		if err := writeOutput(fs, flags.outputFile, simulator); err != nil {
+		if err := writeJSON(fs, flags.outputFile, output); err != nil {
 			return fmt.Errorf("writing output: %w", err)
 		}
 		cmd.Printf("Wrote precalculated measurements to %s\n", flags.outputFile)
 	}
+	if flags.eventLog != "" {
+		if err := writeJSON(fs, flags.eventLog, eventLog); err != nil {
+			return fmt.Errorf("writing event log: %w", err)
+		}
+		cmd.Printf("Wrote predicted event log to %s\n", flags.eventLog)
+	}
+
+	if flags.requireIdentical && len(imagePaths) > 1 {
+		if err := checkIdenticalPCRs(imagePaths, simulators); err != nil {
+			return err
+		}
+		cmd.Printf("All %d images produce identical PCR values\n", len(imagePaths))
+	}
+
+	return nil
+}
+
+// expandImagePaths expands glob patterns in the given image paths.
+// Paths without glob meta characters are used as is. Duplicates are removed, keeping the first occurrence.
+func expandImagePaths(args []string) ([]string, error) {
+	var imagePaths []string
+	seen := make(map[string]struct{})
+	for _, arg := range args {
+		matches := []string{arg}
+		if isGlob(arg) {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("expanding %s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no images match %s", arg)
+			}
+		}
+		for _, match := range matches {
+			if _, ok := seen[match]; ok {
+				continue
+			}
+			seen[match] = struct{}{}
+			imagePaths = append(imagePaths, match)
+		}
+	}
+	return imagePaths, nil
+}
+
+// keyByImagePath reports whether the measurements of the images given by args are keyed by image path.
+func keyByImagePath(args []string) bool {
+	return len(args) > 1 || slices.ContainsFunc(args, isGlob)
+}
+
+// isGlob reports whether arg contains glob meta characters.
+func isGlob(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
 
+// checkIdenticalPCRs returns an error listing the PCRs whose values differ
+// from those of the first image.
+func checkIdenticalPCRs(imagePaths []string, simulators map[string]*measure.Simulator) error {
+	reference := simulators[imagePaths[0]].Bank
+	var errs []error
+	for _, imagePath := range imagePaths[1:] {
+		bank := simulators[imagePath].Bank
+		indices := make([]uint32, 0, len(bank))
+		for index := range reference {
+			indices = append(indices, index)
+		}
+		for index := range bank {
+			if _, ok := reference[index]; !ok {
+				indices = append(indices, index)
+			}
+		}
+		slices.Sort(indices)
+		for _, index := range indices {
+			want, wantOK := reference[index]
+			got, gotOK := bank[index]
+			if wantOK != gotOK || want != got {
+				errs = append(errs, fmt.Errorf("PCR %d of %s differs from %s", index, imagePath, imagePaths[0]))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("images produce different PCR values: %w", errors.Join(errs...))
+	}
 	return nil
 }
 
 type measurementsFlags struct {
-	outputFile string
-	ukiPath    string
+	outputFile       string
+	ukiPath          string
+	eventLog         string
+	requireIdentical bool
 }
 
 func parseMeasurementsFlags(cmd *cobra.Command) (*measurementsFlags, error) {
@@ -70,35 +183,28 @@ func parseMeasurementsFlags(cmd *cobra.Command) (*measurementsFlags, error) {
 	if err != nil {
 		return nil, fmt.Errorf("getting uki-path flag: %w", err)
 	}
-	return &measurementsFlags{
-		outputFile: outputFile,
-		ukiPath:    ukiPath,
-	}, nil
-}
-
-func loadToolchain(key, fallback string) string {
-	toolchain := os.Getenv(key)
-	if toolchain == "" {
-		toolchain = fallback
-	}
-	toolchain, err := exec.LookPath(toolchain)
+	eventLog, err := cmd.Flags().GetString("event-log")
 	if err != nil {
-		return ""
+		return nil, fmt.Errorf("getting event-log flag: %w", err)
 	}
-
-	absolutePath, err := filepath.Abs(toolchain)
+	requireIdentical, err := cmd.Flags().GetBool("require-identical")
 	if err != nil {
-		return ""
+		return nil, fmt.Errorf("getting require-identical flag: %w", err)
 	}
-	return absolutePath
+	return &measurementsFlags{
+		outputFile:       outputFile,
+		ukiPath:          ukiPath,
+		eventLog:         eventLog,
+		requireIdentical: requireIdentical,
+	}, nil
 }
 
-func writeOutput(fs afero.Fs, outputFile string, simulator *measure.Simulator) error {
+func writeJSON(fs afero.Fs, outputFile string, v any) error {
 	out, err := fs.Create(outputFile)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	return json.NewEncoder(out).Encode(simulator)
+	return json.NewEncoder(out).Encode(v)
 }
@@ -0,0 +1,144 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edgelesssys/uplosi/measured-boot/measure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandImagePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.raw", "b.raw", "c.img"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+
+	testCases := map[string]struct {
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		"plain paths": {
+			args: []string{"x.raw", "y.raw"},
+			want: []string{"x.raw", "y.raw"},
+		},
+		"glob": {
+			args: []string{filepath.Join(dir, "*.raw")},
+			want: []string{filepath.Join(dir, "a.raw"), filepath.Join(dir, "b.raw")},
+		},
+		"duplicates removed": {
+			args: []string{filepath.Join(dir, "b.raw"), filepath.Join(dir, "*.raw")},
+			want: []string{filepath.Join(dir, "b.raw"), filepath.Join(dir, "a.raw")},
+		},
+		"glob without match": {
+			args:    []string{filepath.Join(dir, "*.vhd")},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			got, err := expandImagePaths(tc.args)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.want, got)
+		})
+	}
+}
+
+func TestKeyByImagePath(t *testing.T) {
+	testCases := map[string]struct {
+		args []string
+		want bool
+	}{
+		"single path": {
+			args: []string{"image.raw"},
+		},
+		"multiple paths": {
+			args: []string{"a.raw", "b.raw"},
+			want: true,
+		},
+		"glob matching one image": {
+			args: []string{"out/*.raw"},
+			want: true,
+		},
+		"character class": {
+			args: []string{"out/image-[ab].raw"},
+			want: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, keyByImagePath(tc.args))
+		})
+	}
+}
+
+func TestCheckIdenticalPCRs(t *testing.T) {
+	testCases := map[string]struct {
+		banks   []measure.PCR256Bank
+		wantErr bool
+	}{
+		"identical": {
+			banks: []measure.PCR256Bank{
+				{4: {0x01}, 9: {0x02}},
+				{4: {0x01}, 9: {0x02}},
+			},
+		},
+		"different value": {
+			banks: []measure.PCR256Bank{
+				{4: {0x01}, 9: {0x02}},
+				{4: {0x01}, 9: {0x03}},
+			},
+			wantErr: true,
+		},
+		"missing PCR": {
+			banks: []measure.PCR256Bank{
+				{4: {0x01}, 9: {0x02}},
+				{4: {0x01}},
+			},
+			wantErr: true,
+		},
+		"additional PCR": {
+			banks: []measure.PCR256Bank{
+				{4: {0x01}},
+				{4: {0x01}, 11: {0x02}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var imagePaths []string
+			simulators := make(map[string]*measure.Simulator)
+			for i, bank := range tc.banks {
+				imagePath := string(rune('a'+i)) + ".raw"
+				imagePaths = append(imagePaths, imagePath)
+				simulators[imagePath] = &measure.Simulator{Bank: bank}
+			}
+
+			err := checkIdenticalPCRs(imagePaths, simulators)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
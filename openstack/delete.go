@@ -0,0 +1,29 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// DeletePlan returns the operations Delete performs, without calling any OpenStack API.
+func (u *Uploader) DeletePlan() uploader.Plan {
+	var plan uploader.Plan
+	u.planImageDeletion(&plan)
+	return plan
+}
+
+// Delete removes the image an upload with the current config creates.
+func (u *Uploader) Delete(ctx context.Context) error {
+	if err := u.ensureImageDeleted(ctx); err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// diskFormatSignature identifies a Glance disk format by magic bytes at a fixed offset.
+type diskFormatSignature struct {
+	format string
+	offset int
+	magic  []byte
+}
+
+var diskFormatSignatures = []diskFormatSignature{
+	{format: "qcow2", offset: 0, magic: []byte("QFI\xfb")},
+	{format: "vmdk", offset: 0, magic: []byte("KDMV")},
+	{format: "vmdk", offset: 0, magic: []byte("# Disk DescriptorFile")},
+	{format: "vdi", offset: 0x40, magic: []byte{0x7f, 0x10, 0xda, 0xbe}},
+	{format: "vhd", offset: 0, magic: []byte("conectix")},
+	{format: "vhdx", offset: 0, magic: []byte("vhdxfile")},
+	{format: "iso", offset: 0x8001, magic: []byte("CD001")},
+}
+
+// compressionSignatures identify compressed inputs, which Glance can't boot from.
+var compressionSignatures = []diskFormatSignature{
+	{format: "gzip", offset: 0, magic: []byte{0x1f, 0x8b}},
+	{format: "xz", offset: 0, magic: []byte("\xfd7zXZ\x00")},
+	{format: "zstd", offset: 0, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{format: "bzip2", offset: 0, magic: []byte("BZh")},
+}
+
+// undetectableDiskFormats can't be told apart from raw images by their content.
+var undetectableDiskFormats = []string{"ami", "ari", "aki", "ploop"}
+
+// diskFormatHeaderSize is the number of bytes needed to check all signatures.
+const diskFormatHeaderSize = 0x8001 + 5
+
+// detectDiskFormat detects the Glance disk format of an image by its magic bytes.
+// Images without a known signature are treated as raw.
+func detectDiskFormat(image io.Reader) (string, error) {
+	header := make([]byte, diskFormatHeaderSize)
+	n, err := io.ReadFull(image, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading image header: %w", err)
+	}
+	header = header[:n]
+
+	for _, sig := range compressionSignatures {
+		if sig.matches(header) {
+			return "", fmt.Errorf("image is %s compressed, decompress it before uploading", sig.format)
+		}
+	}
+
+	var formats []string
+	for _, sig := range diskFormatSignatures {
+		if sig.matches(header) && !slices.Contains(formats, sig.format) {
+			formats = append(formats, sig.format)
+		}
+	}
+	switch len(formats) {
+	case 0:
+		return "raw", nil
+	case 1:
+		return formats[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous image format, matches %s", strings.Join(formats, ", "))
+	}
+}
+
+// detectDiskFormatSeeker detects the disk format and rewinds the image to the start.
+func detectDiskFormatSeeker(image io.ReadSeeker) (string, error) {
+	format, err := detectDiskFormat(image)
+	if err != nil {
+		return "", err
+	}
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("rewinding image: %w", err)
+	}
+	return format, nil
+}
+
+// resolveDiskFormat returns the disk format to declare for an image with the detected format.
+// A declared format must match the detected one, unless it's a format that looks like raw.
+func resolveDiskFormat(declared, detected string) (string, error) {
+	switch {
+	case declared == "" || declared == detected:
+		return detected, nil
+	case detected == "raw" && slices.Contains(undetectableDiskFormats, declared):
+		return declared, nil
+	default:
+		return "", fmt.Errorf("disk format is set to %s, but the image is %s", declared, detected)
+	}
+}
+
+func (s diskFormatSignature) matches(header []byte) bool {
+	end := s.offset + len(s.magic)
+	return len(header) >= end && bytes.Equal(header[s.offset:end], s.magic)
+}
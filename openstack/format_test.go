@@ -0,0 +1,152 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectDiskFormat(t *testing.T) {
+	withMagic := func(offset int, magic string) []byte {
+		header := make([]byte, diskFormatHeaderSize+512)
+		copy(header[offset:], magic)
+		return header
+	}
+
+	testCases := map[string]struct {
+		image      []byte
+		wantFormat string
+		wantErr    bool
+	}{
+		"raw": {
+			image:      withMagic(0x1fe, "\x55\xaa"),
+			wantFormat: "raw",
+		},
+		"short raw": {
+			image:      []byte("tiny"),
+			wantFormat: "raw",
+		},
+		"empty": {
+			image:      []byte{},
+			wantFormat: "raw",
+		},
+		"qcow2": {
+			image:      withMagic(0, "QFI\xfb"),
+			wantFormat: "qcow2",
+		},
+		"vmdk sparse": {
+			image:      withMagic(0, "KDMV"),
+			wantFormat: "vmdk",
+		},
+		"vmdk descriptor": {
+			image:      withMagic(0, "# Disk DescriptorFile"),
+			wantFormat: "vmdk",
+		},
+		"vdi": {
+			image:      withMagic(0x40, "\x7f\x10\xda\xbe"),
+			wantFormat: "vdi",
+		},
+		"vhd": {
+			image:      withMagic(0, "conectix"),
+			wantFormat: "vhd",
+		},
+		"vhdx": {
+			image:      withMagic(0, "vhdxfile"),
+			wantFormat: "vhdx",
+		},
+		"iso": {
+			image:      withMagic(0x8001, "CD001"),
+			wantFormat: "iso",
+		},
+		"ambiguous": {
+			image: func() []byte {
+				image := withMagic(0, "QFI\xfb")
+				copy(image[0x8001:], "CD001")
+				return image
+			}(),
+			wantErr: true,
+		},
+		"gzip": {
+			image:   withMagic(0, "\x1f\x8b"),
+			wantErr: true,
+		},
+		"zstd": {
+			image:   withMagic(0, "\x28\xb5\x2f\xfd"),
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			image := bytes.NewReader(tc.image)
+			format, err := detectDiskFormatSeeker(image)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantFormat, format)
+			offset, err := image.Seek(0, io.SeekCurrent)
+			assert.NoError(err)
+			assert.Zero(offset)
+		})
+	}
+}
+
+func TestResolveDiskFormat(t *testing.T) {
+	testCases := map[string]struct {
+		declared   string
+		detected   string
+		wantFormat string
+		wantErr    bool
+	}{
+		"detected": {
+			detected:   "qcow2",
+			wantFormat: "qcow2",
+		},
+		"declared matches": {
+			declared:   "vmdk",
+			detected:   "vmdk",
+			wantFormat: "vmdk",
+		},
+		"declared mismatch": {
+			declared: "qcow2",
+			detected: "raw",
+			wantErr:  true,
+		},
+		"declared raw for qcow2": {
+			declared: "raw",
+			detected: "qcow2",
+			wantErr:  true,
+		},
+		"undetectable format": {
+			declared:   "ploop",
+			detected:   "raw",
+			wantFormat: "ploop",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			format, err := resolveDiskFormat(tc.declared, tc.detected)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantFormat, format)
+		})
+	}
+}
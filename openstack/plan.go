@@ -0,0 +1,43 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+)
+
+// Plan returns the operations an upload with the current config performs, without calling any OpenStack API.
+func (u *Uploader) Plan() uploader.Plan {
+	var plan uploader.Plan
+	cloud := u.config.OpenStack.Cloud
+	imageName := u.config.OpenStack.ImageName
+
+	u.planImageDeletion(&plan)
+	visibility := images.ImageVisibility(u.config.OpenStack.Visibility)
+	if visibility == images.ImageVisibility("") {
+		visibility = images.ImageVisibilityPublic
+	}
+	plan.AddDetail(uploader.ActionCreate, "image", imageName, cloud, "visibility "+string(visibility))
+	if u.config.OpenStack.ImportMethod == "web-download" {
+		plan.AddDetail(uploader.ActionUpload, "image data", imageName, cloud, "web-download from "+u.config.OpenStack.SourceURL)
+	} else {
+		plan.Add(uploader.ActionUpload, "image data", imageName, cloud)
+	}
+	return plan
+}
+
+// planImageDeletion adds the operations of ensureImageDeleted.
+func (u *Uploader) planImageDeletion(plan *uploader.Plan) {
+	cloud := u.config.OpenStack.Cloud
+	imageName := u.config.OpenStack.ImageName
+	if u.config.OpenStack.DeleteDuplicates.UnwrapOrZero() {
+		plan.AddDetail(uploader.ActionDeleteIfExists, "image", imageName, cloud, "including duplicates")
+	} else {
+		plan.Add(uploader.ActionDeleteIfExists, "image", imageName, cloud)
+	}
+}
@@ -0,0 +1,30 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+// Preflight performs cheap read-only calls representative of the permissions an upload needs
+// and reports the outcome of each check.
+func (u *Uploader) Preflight(ctx context.Context, report func(check string, err error)) {
+	imageClient, err := u.image(ctx)
+	if err != nil {
+		report(fmt.Sprintf("image service client for cloud %s", u.config.OpenStack.Cloud), err)
+		return
+	}
+	err = images.List(imageClient, images.ListOpts{Limit: 1}).EachPage(func(pagination.Page) (bool, error) {
+		// the first page is enough to prove list permissions
+		return false, nil
+	})
+	report("image list", err)
+}
@@ -8,11 +8,61 @@ package openstack
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/internal/toolchain"
+	"github.com/edgelesssys/uplosi/uploader"
 )
 
-type Prepper struct{}
+func init() {
+	uploader.RegisterPrepper("openstack", func(cfg config.Config) (uploader.Prepper, error) {
+		return &Prepper{
+			ConvertToQCOW2:   cfg.OpenStack.ConvertToQCOW2.UnwrapOrZero(),
+			QemuImgToolchain: toolchain.Load("QEMU_IMG_TOOLCHAIN", "qemu-img"),
+		}, nil
+	})
+}
+
+type Prepper struct {
+	// ConvertToQCOW2 converts raw images to qcow2 before uploading.
+	ConvertToQCOW2 bool
+	// QemuImgToolchain is the path to qemu-img, used for the conversion.
+	QemuImgToolchain string
+}
+
+func (p *Prepper) Prepare(ctx context.Context, imagePath, tmpDir string) (string, error) {
+	// OpenStack accepts the image as-is. The disk format is detected here
+	// to fail early on inputs Glance can't use, and again by the uploader
+	// to declare the matching disk format.
+	image, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer image.Close()
+	format, err := detectDiskFormat(image)
+	if err != nil {
+		return "", fmt.Errorf("detecting disk format: %w", err)
+	}
+	if !p.ConvertToQCOW2 || format == "qcow2" {
+		return imagePath, nil
+	}
+	if format != "raw" {
+		return "", fmt.Errorf("converting to qcow2: only raw images can be converted, image is %s", format)
+	}
+	if p.QemuImgToolchain == "" {
+		return "", errors.New("converting to qcow2: qemu-img not found, install it or set QEMU_IMG_TOOLCHAIN")
+	}
 
-func (p *Prepper) Prepare(_ context.Context, imagePath, _ string) (string, error) {
-	// OpenStack does not need any preparation.
-	return imagePath, nil
+	// Unlike raw images, qcow2 images are sparse, so unallocated blocks aren't uploaded.
+	qcow2Path := filepath.Join(tmpDir, "disk.qcow2")
+	cmd := exec.CommandContext(ctx, p.QemuImgToolchain, "convert", "-f", "raw", "-O", "qcow2", imagePath, qcow2Path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("converting to qcow2: %w: %s", err, out)
+	}
+	return qcow2Path, nil
 }
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepperPrepare(t *testing.T) {
+	// fakeQemuImg writes a qcow2 header to the output path, which is its last argument.
+	const fakeQemuImg = "#!/bin/sh\nfor out; do :; done\nprintf 'QFI\\373' > \"$out\"\n"
+	const failingQemuImg = "#!/bin/sh\necho 'broken image' >&2\nexit 1\n"
+
+	testCases := map[string]struct {
+		image       string
+		convert     bool
+		qemuImg     string
+		wantConvert bool
+		wantErr     bool
+	}{
+		"passthrough": {
+			image:   "raw image",
+			qemuImg: fakeQemuImg,
+		},
+		"convert raw": {
+			image:       "raw image",
+			convert:     true,
+			qemuImg:     fakeQemuImg,
+			wantConvert: true,
+		},
+		"already qcow2": {
+			image:   "QFI\xfb rest of the image",
+			convert: true,
+			qemuImg: fakeQemuImg,
+		},
+		"unconvertible format": {
+			image:   "vhdxfile rest of the image",
+			convert: true,
+			qemuImg: fakeQemuImg,
+			wantErr: true,
+		},
+		"qemu-img missing": {
+			image:   "raw image",
+			convert: true,
+			wantErr: true,
+		},
+		"qemu-img fails": {
+			image:   "raw image",
+			convert: true,
+			qemuImg: failingQemuImg,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			imagePath := filepath.Join(dir, "image.raw")
+			require.NoError(os.WriteFile(imagePath, []byte(tc.image), 0o644))
+			prepper := &Prepper{ConvertToQCOW2: tc.convert}
+			if tc.qemuImg != "" {
+				prepper.QemuImgToolchain = filepath.Join(dir, "qemu-img")
+				require.NoError(os.WriteFile(prepper.QemuImgToolchain, []byte(tc.qemuImg), 0o755))
+			}
+
+			tmpDir := t.TempDir()
+			out, err := prepper.Prepare(context.Background(), imagePath, tmpDir)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			if !tc.wantConvert {
+				assert.Equal(imagePath, out)
+				return
+			}
+			assert.Equal(filepath.Join(tmpDir, "disk.qcow2"), out)
+			converted, err := os.Open(out)
+			require.NoError(err)
+			defer converted.Close()
+			format, err := detectDiskFormat(converted)
+			require.NoError(err)
+			assert.Equal("qcow2", format)
+		})
+	}
+}
@@ -8,29 +8,52 @@ package openstack
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
 
 	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/internal/retry"
+	"github.com/edgelesssys/uplosi/uploader"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/imagedata"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/imageimport"
 	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
 	"github.com/gophercloud/utils/openstack/clientconfig"
 )
 
-const microversion = "2.42"
+const (
+	microversion = "2.42"
+	// uploadRetryDelay is the time to wait before the first retry of a failed image data upload.
+	uploadRetryDelay = 10 * time.Second
+	// importPollInterval is the time between status checks of an image imported via web-download.
+	importPollInterval = 10 * time.Second
+)
 
 type Uploader struct {
 	config config.Config
 
-	image func(context.Context) (*gophercloud.ServiceClient, error)
+	image              func(context.Context) (*gophercloud.ServiceClient, error)
+	retryDelay         time.Duration
+	importPollInterval time.Duration
 
-	log *log.Logger
+	log      *log.Logger
+	progress uploader.ProgressReporter
 }
 
-func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
+func NewUploader(config config.Config, log *log.Logger, progress uploader.ProgressReporter) (*Uploader, error) {
+	if progress == nil {
+		progress = uploader.NopProgress{}
+	}
 	clientOpts := &clientconfig.ClientOpts{
 		Cloud: config.OpenStack.Cloud,
 	}
@@ -45,58 +68,234 @@ func NewUploader(config config.Config, log *log.Logger) (*Uploader, error) {
 			imageClient.Microversion = microversion
 			return imageClient, nil
 		},
-		log: log,
+		retryDelay:         uploadRetryDelay,
+		importPollInterval: importPollInterval,
+		log:                log,
+		progress:           progress,
 	}, nil
 }
 
-func (u *Uploader) Upload(ctx context.Context, image io.ReadSeeker, _ int64) (refs []string, retErr error) {
+func (u *Uploader) Upload(ctx context.Context, req *uploader.Request) (results []uploader.UploadResult, retErr error) {
 	if err := u.ensureImageDeleted(ctx); err != nil {
 		return nil, fmt.Errorf("pre-cleaning: ensuring no image using the same name exists: %w", err)
 	}
-	imageID, err := u.createImage(ctx, image)
+	result, err := u.createImage(ctx, req.Image)
 	if err != nil {
 		return nil, fmt.Errorf("creating image: %w", err)
 	}
-	return []string{imageID}, nil
+	return []uploader.UploadResult{result}, nil
 }
 
-func (u *Uploader) createImage(ctx context.Context, image io.ReadSeeker) (string, error) {
+func (u *Uploader) createImage(ctx context.Context, image io.ReadSeeker) (uploader.UploadResult, error) {
 	visibility := images.ImageVisibility(u.config.OpenStack.Visibility)
 	if visibility == images.ImageVisibility("") {
 		visibility = images.ImageVisibilityPublic
 	}
-	protected := u.config.OpenStack.Protected.UnwrapOr(false)
-	hidden := u.config.OpenStack.Hidden.UnwrapOr(false)
+	protected := u.config.OpenStack.Protected.UnwrapOrZero()
+	hidden := u.config.OpenStack.Hidden.UnwrapOrZero()
+	properties := imageProperties(u.config.OpenStack)
+	diskFormat, err := u.diskFormat(image)
+	if err != nil {
+		return uploader.UploadResult{}, err
+	}
+	containerFormat := u.containerFormat()
 	createOpts := images.CreateOpts{
 		Name:            u.config.OpenStack.ImageName,
-		ContainerFormat: "bare",
-		DiskFormat:      "raw",
+		ContainerFormat: containerFormat,
+		DiskFormat:      diskFormat,
 		Visibility:      &visibility,
 		Hidden:          &hidden,
 		Tags:            u.config.OpenStack.Tags,
 		Protected:       &protected,
 		MinDisk:         u.config.OpenStack.MinDiskGB,
 		MinRAM:          u.config.OpenStack.MinRamMB,
-		Properties:      u.config.OpenStack.Properties,
+		Properties:      properties,
 	}
 
 	imageClient, err := u.image(ctx)
 	if err != nil {
-		return "", err
+		return uploader.UploadResult{}, err
 	}
 
-	u.log.Printf("Creating image %q", u.config.OpenStack.ImageName)
+	u.log.Printf("Creating image %q with disk format %s and container format %s", u.config.OpenStack.ImageName, diskFormat, containerFormat)
 
 	newImage, err := images.Create(imageClient, createOpts).Extract()
 	if err != nil {
-		return "", fmt.Errorf("creating image: %w", err)
+		return uploader.UploadResult{}, fmt.Errorf("creating image: %w", err)
+	}
+
+	var hasher *imageHasher
+	if u.config.OpenStack.ImportMethod == "web-download" {
+		hasher, err = u.importImageData(ctx, imageClient, newImage.ID, image)
+		if err != nil {
+			return uploader.UploadResult{}, fmt.Errorf("importing image data: %w", err)
+		}
+	} else {
+		hasher, err = u.uploadImageData(ctx, imageClient, newImage.ID, image)
+		if err != nil {
+			return uploader.UploadResult{}, fmt.Errorf("uploading image data: %w", err)
+		}
+	}
+
+	uploadedImage, err := images.Get(imageClient, newImage.ID).Extract()
+	if err != nil {
+		return uploader.UploadResult{}, fmt.Errorf("getting uploaded image: %w", err)
+	}
+	algo, value, err := hasher.verify(uploadedImage.Properties)
+	if err != nil {
+		return uploader.UploadResult{}, fmt.Errorf("verifying image hash: %w", err)
+	}
+	if algo == "" {
+		u.log.Printf("Cloud doesn't report an os_hash_value for image %s, skipping hash verification", newImage.ID)
+	} else {
+		u.log.Printf("Verified image %s with os_hash_algo %s and os_hash_value %s", newImage.ID, algo, value)
+	}
+
+	return uploader.UploadResult{
+		Provider:      "openstack",
+		Region:        u.config.OpenStack.Cloud,
+		Reference:     newImage.ID,
+		ResourceType:  "image",
+		HashAlgorithm: algo,
+		HashValue:     value,
+	}, nil
+}
+
+// diskFormat returns the disk format to declare for the image. The format of a bare image
+// is detected from its content and must match the configured disk format, if any.
+func (u *Uploader) diskFormat(image io.ReadSeeker) (string, error) {
+	if u.containerFormat() != "bare" {
+		// The disk is wrapped in a container, so its content can't be inspected.
+		return u.config.OpenStack.DiskFormat, nil
+	}
+	detected, err := detectDiskFormatSeeker(image)
+	if err != nil {
+		return "", fmt.Errorf("detecting disk format: %w", err)
+	}
+	return resolveDiskFormat(u.config.OpenStack.DiskFormat, detected)
+}
+
+func (u *Uploader) containerFormat() string {
+	if u.config.OpenStack.ContainerFormat == "" {
+		return "bare"
 	}
+	return u.config.OpenStack.ContainerFormat
+}
 
-	if err := imagedata.Upload(imageClient, newImage.ID, image).ExtractErr(); err != nil {
-		return "", fmt.Errorf("uploading image data: %w", err)
+// uploadImageData uploads the image data, retrying transient failures up to uploadRetries times.
+// Every attempt rewinds the image and uploads it from the start.
+func (u *Uploader) uploadImageData(ctx context.Context, imageClient *gophercloud.ServiceClient, imageID string, image io.ReadSeeker,
+) (*imageHasher, error) {
+	size, err := image.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("getting image size: %w", err)
 	}
+	attempts := u.config.OpenStack.UploadRetries.UnwrapOrZero() + 1
+	var hasher *imageHasher
+	var attempt int
+	err = retry.Do(ctx, retry.Policy{
+		MaxAttempts:  attempts,
+		InitialDelay: u.retryDelay,
+		MaxDelay:     retry.MaxTransientDelay,
+		Retryable: func(err error) bool {
+			return isTransient(err) && !errors.Is(err, errCannotRetry)
+		},
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			u.log.Printf("Uploading image data failed (attempt %d of %d), retrying in %s: %v", attempt, attempts, delay, err)
+		},
+	}, func() error {
+		attempt++
+		if _, err := image.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewinding image: %w", err)
+		}
+		u.progress.Stage(uploader.StageUploadBlob)
+		hasher = newImageHasher(u.config.OpenStack.HashAlgorithm)
+		data := uploader.NewProgressReader(io.TeeReader(image, hasher), size, u.progress)
+		err := imagedata.Upload(imageClient, imageID, data).ExtractErr()
+		if err == nil || attempt >= attempts || !isTransient(err) {
+			return err
+		}
 
-	return newImage.ID, nil
+		// Glance resets the image to queued after a failed upload. Only then the data can be uploaded again.
+		current, getErr := images.Get(imageClient, imageID).Extract()
+		if getErr != nil {
+			return errors.Join(err, errCannotRetry, fmt.Errorf("getting image status: %w", getErr))
+		}
+		if current.Status != images.ImageStatusQueued {
+			return fmt.Errorf("image is in status %s after failed upload, %w: %w", current.Status, errCannotRetry, err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// importImageData lets Glance download the image data from sourceURL and waits until the image is active.
+// The local image is only hashed to verify the imported data afterwards.
+func (u *Uploader) importImageData(ctx context.Context, imageClient *gophercloud.ServiceClient, imageID string, image io.ReadSeeker,
+) (*imageHasher, error) {
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewinding image: %w", err)
+	}
+	hasher := newImageHasher(u.config.OpenStack.HashAlgorithm)
+	if _, err := io.Copy(hasher, image); err != nil {
+		return nil, fmt.Errorf("hashing image: %w", err)
+	}
+
+	u.log.Printf("Importing image data from %s", u.config.OpenStack.SourceURL)
+	importOpts := imageimport.CreateOpts{
+		Name: imageimport.WebDownloadMethod,
+		URI:  u.config.OpenStack.SourceURL,
+	}
+	if err := imageimport.Create(imageClient, imageID, importOpts).ExtractErr(); err != nil {
+		return nil, fmt.Errorf("starting web-download import: %w", err)
+	}
+
+	for {
+		current, err := images.Get(imageClient, imageID).Extract()
+		if err != nil {
+			return nil, fmt.Errorf("getting image status: %w", err)
+		}
+		if failed, _ := current.Properties["os_glance_failed_import"].(string); failed != "" {
+			return nil, fmt.Errorf("web-download import failed in stores %s", failed)
+		}
+		switch current.Status {
+		case images.ImageStatusActive:
+			return hasher, nil
+		case images.ImageStatusKilled, images.ImageStatusDeleted, images.ImageStatusPendingDelete:
+			return nil, fmt.Errorf("image is in status %s after web-download import", current.Status)
+		}
+
+		u.log.Printf("Waiting for web-download import, image is in status %s", current.Status)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(u.importPollInterval):
+		}
+	}
+}
+
+// errCannotRetry marks a failed upload that can't be retried because of the image status.
+var errCannotRetry = errors.New("cannot retry")
+
+// isTransient reports whether an upload error is worth retrying.
+func isTransient(err error) bool {
+	var statusErr gophercloud.StatusCodeError
+	if errors.As(err, &statusErr) {
+		switch statusErr.GetStatusCode() {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
 }
 
 func (u *Uploader) ensureImageDeleted(ctx context.Context) error {
@@ -117,12 +316,83 @@ func (u *Uploader) ensureImageDeleted(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("extracting images: %w", err)
 	}
-	if len(imgs) == 0 {
-		return nil
+	if len(imgs) > 1 && !u.config.OpenStack.DeleteDuplicates.UnwrapOrZero() {
+		return fmt.Errorf("found %d images with the same name, set deleteDuplicates to delete all of them", len(imgs))
+	}
+	for _, img := range imgs {
+		u.log.Printf("Deleting existing image %q (%s)", u.config.OpenStack.ImageName, img.ID)
+		if err := images.Delete(imageClient, img.ID).ExtractErr(); err != nil {
+			return fmt.Errorf("deleting image %s: %w", img.ID, err)
+		}
+	}
+	return nil
+}
+
+// imageProperties merges the typed image metadata with the user supplied properties.
+// The config validation rejects conflicting values for the same property key.
+func imageProperties(conf config.OpenStackConfig) map[string]string {
+	properties := make(map[string]string, len(conf.Properties)+3)
+	for k, v := range conf.Properties {
+		properties[k] = v
+	}
+	typed := map[string]string{
+		"architecture":     conf.Architecture,
+		"hw_firmware_type": conf.FirmwareType,
+		"hypervisor_type":  conf.HypervisorType,
+	}
+	for k, v := range typed {
+		if v == "" {
+			continue
+		}
+		properties[k] = v
+	}
+	return properties
+}
+
+// imageHasher computes Glance multihash values while the image data is uploaded.
+// Without a configured algorithm, all supported algorithms are computed so the
+// result can be compared with whatever the cloud advertises.
+type imageHasher struct {
+	algorithm string
+	hashes    map[string]hash.Hash
+}
+
+func newImageHasher(algorithm string) *imageHasher {
+	hashes := map[string]hash.Hash{}
+	if algorithm == "" || algorithm == "sha256" {
+		hashes["sha256"] = sha256.New()
+	}
+	if algorithm == "" || algorithm == "sha512" {
+		hashes["sha512"] = sha512.New()
+	}
+	return &imageHasher{algorithm: algorithm, hashes: hashes}
+}
+
+func (h *imageHasher) Write(p []byte) (int, error) {
+	for _, hash := range h.hashes {
+		hash.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify compares the locally computed hash with the os_hash_algo and os_hash_value
+// reported by Glance. An empty algorithm is returned if the cloud doesn't report a hash.
+func (h *imageHasher) verify(properties map[string]any) (algo, value string, err error) {
+	algo, _ = properties["os_hash_algo"].(string)
+	remoteValue, _ := properties["os_hash_value"].(string)
+	if algo == "" || remoteValue == "" {
+		return "", "", nil
+	}
+	if h.algorithm != "" && algo != h.algorithm {
+		return "", "", fmt.Errorf("cloud hashes images with %s, but hashAlgorithm is set to %s", algo, h.algorithm)
+	}
+	hash, ok := h.hashes[algo]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported os_hash_algo %s", algo)
 	}
-	if len(imgs) != 1 {
-		return errors.New("multiple images with the same name found")
+	value = hex.EncodeToString(hash.Sum(nil))
+	if value != remoteValue {
+		return "", "", fmt.Errorf("os_hash_value mismatch for %s: computed %s, cloud reports %s", algo, value, remoteValue)
 	}
-	u.log.Printf("Deleting existing image %q (%s)", u.config.OpenStack.ImageName, imgs[0].ID)
-	return images.Delete(imageClient, imgs[0].ID).ExtractErr()
+	return algo, value, nil
 }
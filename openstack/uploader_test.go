@@ -0,0 +1,447 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openstack
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/uploader"
+	"github.com/gophercloud/gophercloud"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsureImageDeleted(t *testing.T) {
+	testCases := map[string]struct {
+		existing         []string
+		deleteDuplicates config.Option[bool]
+		wantDeleted      []string
+		wantErr          bool
+	}{
+		"no image": {},
+		"single image": {
+			existing:    []string{"id-1"},
+			wantDeleted: []string{"id-1"},
+		},
+		"duplicates": {
+			existing: []string{"id-1", "id-2"},
+			wantErr:  true,
+		},
+		"duplicates not deleted": {
+			existing:         []string{"id-1", "id-2"},
+			deleteDuplicates: config.Some(false),
+			wantErr:          true,
+		},
+		"duplicates deleted": {
+			existing:         []string{"id-1", "id-2"},
+			deleteDuplicates: config.Some(true),
+			wantDeleted:      []string{"id-1", "id-2"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var mu sync.Mutex
+			var deleted []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/images":
+					imgs := []map[string]string{}
+					for _, id := range tc.existing {
+						imgs = append(imgs, map[string]string{"id": id, "name": "image"})
+					}
+					w.Header().Set("Content-Type", "application/json")
+					_ = json.NewEncoder(w).Encode(map[string]any{"images": imgs})
+				case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/images/"):
+					mu.Lock()
+					deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/images/"))
+					mu.Unlock()
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			u := &Uploader{
+				config: config.Config{
+					OpenStack: config.OpenStackConfig{
+						ImageName:        "image",
+						DeleteDuplicates: tc.deleteDuplicates,
+					},
+				},
+				image: func(context.Context) (*gophercloud.ServiceClient, error) {
+					return &gophercloud.ServiceClient{
+						ProviderClient: &gophercloud.ProviderClient{},
+						Endpoint:       server.URL + "/",
+					}, nil
+				},
+				log: log.New(io.Discard, "", 0),
+			}
+
+			err := u.ensureImageDeleted(context.Background())
+			if tc.wantErr {
+				assert.Error(err)
+				assert.Empty(deleted)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantDeleted, deleted)
+		})
+	}
+}
+
+func TestUploadImageDataRetry(t *testing.T) {
+	testCases := map[string]struct {
+		retries     config.Option[int]
+		failures    []int
+		status      string
+		wantErr     bool
+		wantUploads int
+	}{
+		"success": {
+			retries:     config.Some(3),
+			wantUploads: 1,
+		},
+		"transient failure retried": {
+			retries:     config.Some(3),
+			failures:    []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			status:      "queued",
+			wantUploads: 3,
+		},
+		"retries exhausted": {
+			retries:     config.Some(1),
+			failures:    []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			status:      "queued",
+			wantErr:     true,
+			wantUploads: 2,
+		},
+		"retries disabled": {
+			failures:    []int{http.StatusServiceUnavailable},
+			status:      "queued",
+			wantErr:     true,
+			wantUploads: 1,
+		},
+		"permanent failure": {
+			retries:     config.Some(3),
+			failures:    []int{http.StatusBadRequest},
+			status:      "queued",
+			wantErr:     true,
+			wantUploads: 1,
+		},
+		"image not queued after failure": {
+			retries:     config.Some(3),
+			failures:    []int{http.StatusServiceUnavailable},
+			status:      "killed",
+			wantErr:     true,
+			wantUploads: 1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var mu sync.Mutex
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut && r.URL.Path == "/images/id-1/file":
+					body, _ := io.ReadAll(r.Body)
+					mu.Lock()
+					bodies = append(bodies, string(body))
+					attempt := len(bodies) - 1
+					mu.Unlock()
+					if attempt < len(tc.failures) {
+						w.WriteHeader(tc.failures[attempt])
+						return
+					}
+					w.WriteHeader(http.StatusNoContent)
+				case r.Method == http.MethodGet && r.URL.Path == "/images/id-1":
+					w.Header().Set("Content-Type", "application/json")
+					_ = json.NewEncoder(w).Encode(map[string]string{"id": "id-1", "status": tc.status})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			u := &Uploader{
+				config: config.Config{
+					OpenStack: config.OpenStackConfig{UploadRetries: tc.retries},
+				},
+				log:      log.New(io.Discard, "", 0),
+				progress: uploader.NopProgress{},
+			}
+			client := &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{},
+				Endpoint:       server.URL + "/",
+			}
+
+			_, err := u.uploadImageData(context.Background(), client, "id-1", strings.NewReader("image data"))
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Len(bodies, tc.wantUploads)
+			for _, body := range bodies {
+				assert.Equal("image data", body)
+			}
+		})
+	}
+}
+
+func TestCreateImageResult(t *testing.T) {
+	const data = "image data"
+	sha512Sum := sha512.Sum512([]byte(data))
+
+	testCases := map[string]struct {
+		properties map[string]any
+		want       uploader.UploadResult
+	}{
+		"hash reported": {
+			properties: map[string]any{"os_hash_algo": "sha512", "os_hash_value": hex.EncodeToString(sha512Sum[:])},
+			want: uploader.UploadResult{
+				Provider:      "openstack",
+				Region:        "cloud",
+				Reference:     "id-1",
+				ResourceType:  "image",
+				HashAlgorithm: "sha512",
+				HashValue:     hex.EncodeToString(sha512Sum[:]),
+			},
+		},
+		"hash not reported": {
+			properties: map[string]any{},
+			want: uploader.UploadResult{
+				Provider:     "openstack",
+				Region:       "cloud",
+				Reference:    "id-1",
+				ResourceType: "image",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/images":
+					w.WriteHeader(http.StatusCreated)
+					_ = json.NewEncoder(w).Encode(map[string]string{"id": "id-1", "status": "queued"})
+				case r.Method == http.MethodPut && r.URL.Path == "/images/id-1/file":
+					_, _ = io.Copy(io.Discard, r.Body)
+					w.WriteHeader(http.StatusNoContent)
+				case r.Method == http.MethodGet && r.URL.Path == "/images/id-1":
+					image := map[string]any{"id": "id-1", "status": "active"}
+					for k, v := range tc.properties {
+						image[k] = v
+					}
+					_ = json.NewEncoder(w).Encode(image)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			u := &Uploader{
+				config: config.Config{
+					OpenStack: config.OpenStackConfig{Cloud: "cloud", ImageName: "image"},
+				},
+				image: func(context.Context) (*gophercloud.ServiceClient, error) {
+					return &gophercloud.ServiceClient{
+						ProviderClient: &gophercloud.ProviderClient{},
+						Endpoint:       server.URL + "/",
+					}, nil
+				},
+				log:      log.New(io.Discard, "", 0),
+				progress: uploader.NopProgress{},
+			}
+
+			result, err := u.createImage(context.Background(), strings.NewReader(data))
+			assert.NoError(err)
+			assert.Equal(tc.want, result)
+		})
+	}
+}
+
+func TestImportImageData(t *testing.T) {
+	testCases := map[string]struct {
+		statuses    []map[string]string
+		importCode  int
+		wantErr     bool
+		wantImports int
+	}{
+		"imported": {
+			statuses: []map[string]string{
+				{"status": "importing"},
+				{"status": "active"},
+			},
+			importCode:  http.StatusAccepted,
+			wantImports: 1,
+		},
+		"import rejected": {
+			importCode:  http.StatusConflict,
+			wantErr:     true,
+			wantImports: 1,
+		},
+		"import failed": {
+			statuses: []map[string]string{
+				{"status": "queued", "os_glance_failed_import": "file"},
+			},
+			importCode:  http.StatusAccepted,
+			wantErr:     true,
+			wantImports: 1,
+		},
+		"image killed": {
+			statuses: []map[string]string{
+				{"status": "killed"},
+			},
+			importCode:  http.StatusAccepted,
+			wantErr:     true,
+			wantImports: 1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var mu sync.Mutex
+			var imports []map[string]any
+			var gets int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/images/id-1/import":
+					var body map[string]any
+					_ = json.NewDecoder(r.Body).Decode(&body)
+					imports = append(imports, body)
+					w.WriteHeader(tc.importCode)
+				case r.Method == http.MethodGet && r.URL.Path == "/images/id-1":
+					status := map[string]string{"id": "id-1"}
+					for k, v := range tc.statuses[min(gets, len(tc.statuses)-1)] {
+						status[k] = v
+					}
+					gets++
+					w.Header().Set("Content-Type", "application/json")
+					_ = json.NewEncoder(w).Encode(status)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			u := &Uploader{
+				config: config.Config{
+					OpenStack: config.OpenStackConfig{
+						ImportMethod: "web-download",
+						SourceURL:    "https://images.example.com/image.raw",
+					},
+				},
+				log: log.New(io.Discard, "", 0),
+			}
+			client := &gophercloud.ServiceClient{
+				ProviderClient: &gophercloud.ProviderClient{},
+				Endpoint:       server.URL + "/",
+			}
+
+			hasher, err := u.importImageData(context.Background(), client, "id-1", strings.NewReader("image data"))
+			if tc.wantErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				sum := sha256.Sum256([]byte("image data"))
+				assert.Equal(sum[:], hasher.hashes["sha256"].Sum(nil))
+			}
+			assert.Len(imports, tc.wantImports)
+			for _, body := range imports {
+				assert.Equal(map[string]any{
+					"method": map[string]any{"name": "web-download", "uri": "https://images.example.com/image.raw"},
+				}, body)
+			}
+		})
+	}
+}
+
+func TestImageHasherVerify(t *testing.T) {
+	const data = "image data"
+	sha256Sum := sha256.Sum256([]byte(data))
+	sha512Sum := sha512.Sum512([]byte(data))
+
+	testCases := map[string]struct {
+		algorithm  string
+		properties map[string]any
+		wantAlgo   string
+		wantErr    bool
+	}{
+		"advertised sha512": {
+			properties: map[string]any{"os_hash_algo": "sha512", "os_hash_value": hex.EncodeToString(sha512Sum[:])},
+			wantAlgo:   "sha512",
+		},
+		"advertised sha256": {
+			properties: map[string]any{"os_hash_algo": "sha256", "os_hash_value": hex.EncodeToString(sha256Sum[:])},
+			wantAlgo:   "sha256",
+		},
+		"configured sha256": {
+			algorithm:  "sha256",
+			properties: map[string]any{"os_hash_algo": "sha256", "os_hash_value": hex.EncodeToString(sha256Sum[:])},
+			wantAlgo:   "sha256",
+		},
+		"configured algorithm differs from cloud": {
+			algorithm:  "sha256",
+			properties: map[string]any{"os_hash_algo": "sha512", "os_hash_value": hex.EncodeToString(sha512Sum[:])},
+			wantErr:    true,
+		},
+		"mismatch": {
+			properties: map[string]any{"os_hash_algo": "sha512", "os_hash_value": hex.EncodeToString(sha256Sum[:])},
+			wantErr:    true,
+		},
+		"unsupported algorithm": {
+			properties: map[string]any{"os_hash_algo": "md5", "os_hash_value": "abc"},
+			wantErr:    true,
+		},
+		"not reported": {
+			properties: map[string]any{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			hasher := newImageHasher(tc.algorithm)
+			_, err := io.Copy(io.Discard, io.TeeReader(strings.NewReader(data), hasher))
+			assert.NoError(err)
+
+			algo, _, err := hasher.verify(tc.properties)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantAlgo, algo)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// defaultVariantName is used as file name for configs without variants.
+const defaultVariantName = "default"
+
+// variantResult is the result of uploading a single variant.
+type variantResult struct {
+	ConfigFile   string                  `json:"configFile"`
+	Variant      string                  `json:"variant"`
+	Provider     string                  `json:"provider"`
+	ImageVersion string                  `json:"imageVersion"`
+	Refs         []string                `json:"refs"`
+	Results      []uploader.UploadResult `json:"results"`
+	Error        string                  `json:"error,omitempty"`
+}
+
+// outputIndexEntry points to the result file of a variant.
+type outputIndexEntry struct {
+	ConfigFile string   `json:"configFile"`
+	Variant    string   `json:"variant"`
+	File       string   `json:"file"`
+	Refs       []string `json:"refs"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// outputDir writes the result of every variant to <variant>.json and
+// an index of all results to index.json. It's safe for concurrent use.
+type outputDir struct {
+	mu      sync.Mutex
+	path    string
+	entries []outputIndexEntry
+	used    map[string]bool
+	// owners maps a reserved file name to the config file whose variant gets it.
+	owners map[string]string
+	// fileOrder is the position of every reserved config file, the index is sorted by it.
+	fileOrder map[string]int
+}
+
+func newOutputDir(path string) (*outputDir, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("creating output dir: %w", err)
+	}
+	return &outputDir{path: path, used: map[string]bool{}, owners: map[string]string{}, fileOrder: map[string]int{}}, nil
+}
+
+// reserve reserves the file names of the variants of a config file, unless an earlier
+// config file reserved them. Reserving all config files in order before uploading them
+// concurrently keeps file names and index independent of which upload finishes first.
+func (o *outputDir) reserve(configFile string, variants []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.fileOrder[configFile]; !ok {
+		o.fileOrder[configFile] = len(o.fileOrder)
+	}
+	for _, variant := range variants {
+		name := variant
+		if name == "" {
+			name = defaultVariantName
+		}
+		if _, ok := o.owners[name]; !ok {
+			o.owners[name] = configFile
+		}
+	}
+}
+
+// writeResult writes the result of a variant. If multiple config files contain a variant
+// with the same name, the file name is prefixed with the name of the config file.
+func (o *outputDir) writeResult(result variantResult) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	name := result.Variant
+	if name == "" {
+		name = defaultVariantName
+	}
+	owner, reserved := o.owners[name]
+	if o.used[name] || (reserved && owner != result.ConfigFile) {
+		configName := strings.TrimSuffix(filepath.Base(result.ConfigFile), filepath.Ext(result.ConfigFile))
+		name = configName + "-" + name
+	}
+	if o.used[name] || name == "index" {
+		return fmt.Errorf("duplicate output file name %q for variant %q", name, result.Variant)
+	}
+	o.used[name] = true
+
+	fileName := name + ".json"
+	if err := writeJSONFileAtomic(filepath.Join(o.path, fileName), result); err != nil {
+		return fmt.Errorf("writing result of variant %q: %w", result.Variant, err)
+	}
+	o.entries = append(o.entries, outputIndexEntry{
+		ConfigFile: result.ConfigFile,
+		Variant:    result.Variant,
+		File:       fileName,
+		Refs:       result.Refs,
+		Error:      result.Error,
+	})
+	return nil
+}
+
+// writeIndex writes index.json listing all results written so far.
+func (o *outputDir) writeIndex() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entries := slices.Clone(o.entries)
+	if entries == nil {
+		entries = []outputIndexEntry{}
+	}
+	slices.SortStableFunc(entries, func(a, b outputIndexEntry) int {
+		if a.ConfigFile == b.ConfigFile {
+			return strings.Compare(a.Variant, b.Variant)
+		}
+		return cmp.Compare(o.fileOrder[a.ConfigFile], o.fileOrder[b.ConfigFile])
+	})
+	if err := writeJSONFileAtomic(filepath.Join(o.path, "index.json"), entries); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return nil
+}
+
+// writeJSONFileAtomic writes v as JSON to a temporary file in the target directory
+// and renames it, so readers never see a partially written file.
+func writeJSONFileAtomic(path string, v any) (retErr error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
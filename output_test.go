@@ -0,0 +1,100 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputDir(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir := filepath.Join(t.TempDir(), "out")
+	output, err := newOutputDir(dir)
+	require.NoError(err)
+
+	require.NoError(output.writeResult(variantResult{
+		ConfigFile: "a.toml", Variant: "x", Provider: "aws", ImageVersion: "1.0.0", Refs: []string{"ami-1"},
+	}))
+	require.NoError(output.writeResult(variantResult{
+		ConfigFile: "b.toml", Variant: "x", Provider: "gcp", ImageVersion: "1.0.0", Refs: []string{}, Error: "failed",
+	}))
+	require.NoError(output.writeResult(variantResult{
+		ConfigFile: "c.toml", Provider: "azure", ImageVersion: "1.0.0", Refs: []string{"image"},
+	}))
+	require.NoError(output.writeIndex())
+
+	var result variantResult
+	readJSON(t, filepath.Join(dir, "x.json"), &result)
+	assert.Equal([]string{"ami-1"}, result.Refs)
+	readJSON(t, filepath.Join(dir, "b-x.json"), &result)
+	assert.Equal("failed", result.Error)
+	readJSON(t, filepath.Join(dir, "default.json"), &result)
+	assert.Equal("azure", result.Provider)
+
+	var index []outputIndexEntry
+	readJSON(t, filepath.Join(dir, "index.json"), &index)
+	assert.Equal([]outputIndexEntry{
+		{ConfigFile: "a.toml", Variant: "x", File: "x.json", Refs: []string{"ami-1"}},
+		{ConfigFile: "b.toml", Variant: "x", File: "b-x.json", Refs: []string{}, Error: "failed"},
+		{ConfigFile: "c.toml", Variant: "", File: "default.json", Refs: []string{"image"}},
+	}, index)
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(err)
+	assert.Len(entries, 4)
+}
+
+func TestOutputDirDuplicate(t *testing.T) {
+	require := require.New(t)
+
+	output, err := newOutputDir(t.TempDir())
+	require.NoError(err)
+	require.NoError(output.writeResult(variantResult{ConfigFile: "a.toml", Variant: "x"}))
+	require.NoError(output.writeResult(variantResult{ConfigFile: "a.toml", Variant: "a-x"}))
+	require.Error(output.writeResult(variantResult{ConfigFile: "a.toml", Variant: "x"}))
+}
+
+func TestOutputDirReserve(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	output, err := newOutputDir(dir)
+	require.NoError(err)
+	output.reserve("a.toml", []string{"x", "y"})
+	output.reserve("b.toml", []string{"x"})
+
+	// Concurrent uploads finish in any order, b.toml finishes first.
+	require.NoError(output.writeResult(variantResult{ConfigFile: "b.toml", Variant: "x"}))
+	require.NoError(output.writeResult(variantResult{ConfigFile: "a.toml", Variant: "y"}))
+	require.NoError(output.writeResult(variantResult{ConfigFile: "a.toml", Variant: "x"}))
+	require.NoError(output.writeIndex())
+
+	var index []outputIndexEntry
+	readJSON(t, filepath.Join(dir, "index.json"), &index)
+	var files []string
+	for _, entry := range index {
+		files = append(files, entry.File)
+	}
+	assert.Equal([]string{"x.json", "y.json", "b-x.json"}, files)
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/spf13/cobra"
+)
+
+func newPreflightCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "Check cloud provider permissions required for an upload without uploading anything",
+		Args:  cobra.NoArgs,
+		RunE:  runPreflight,
+	}
+	cmd.Flags().StringSlice("enable-variant-glob", []string{"*"}, "list of variant name globs to enable")
+	cmd.Flags().StringSlice("disable-variant-glob", nil, "list of variant name globs to disable")
+	cmd.Flags().StringP("config", "c", "", fmt.Sprintf("path to directory %s and %s resides in", configName, configDir))
+	cmd.Flags().String("config-dir", "", "path to a directory of *.toml and *.conf config files to check")
+	cmd.MarkFlagsMutuallyExclusive("config", "config-dir")
+	cmd.Flags().String("provider", "", "override the provider of every variant (aws, azure, gcp, openstack)")
+
+	return cmd
+}
+
+// preflighter is implemented by uploaders that can check their permissions upfront.
+type preflighter interface {
+	Preflight(ctx context.Context, report func(check string, err error))
+}
+
+func runPreflight(cmd *cobra.Command, _ []string) error {
+	flags, err := parsePreflightFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	configFiles, err := loadConfigFiles([]string{flags.configPath}, flags.configDirPath)
+	if err != nil {
+		return fmt.Errorf("parsing config files: %w", err)
+	}
+
+	var failed int
+	for _, configFile := range configFiles {
+		configFile.conf.SetProviderOverride(flags.provider)
+		err := configFile.conf.ForEach(
+			func(name string, cfg config.Config) error {
+				failed += preflightVariant(cmd.Context(), cmd.OutOrStdout(), name, cfg)
+				return nil
+			},
+			os.ReadFile,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", configFile.path, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d preflight checks failed", failed)
+	}
+	return nil
+}
+
+// preflightVariant runs the preflight checks of a single variant and returns the number of failed checks.
+func preflightVariant(ctx context.Context, out io.Writer, variant string, cfg config.Config) int {
+	prefix := cfg.Provider
+	if len(variant) > 0 {
+		prefix = fmt.Sprintf("%s/%s", variant, cfg.Provider)
+	}
+
+	_, upload, err := newUploader(cfg, log.New(io.Discard, "", 0), nil)
+	if err != nil {
+		fmt.Fprintf(out, "[FAILED] %s: %v\n", prefix, err)
+		return 1
+	}
+	checker, ok := upload.(preflighter)
+	if !ok {
+		fmt.Fprintf(out, "[FAILED] %s: %v\n", prefix, errors.New("provider doesn't support preflight checks"))
+		return 1
+	}
+
+	var failed int
+	checker.Preflight(ctx, func(check string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "[FAILED] %s: %s: %v\n", prefix, check, err)
+			return
+		}
+		fmt.Fprintf(out, "[OK] %s: %s\n", prefix, check)
+	})
+	return failed
+}
+
+type preflightFlags struct {
+	enableVariantGlobs  []string
+	disableVariantGlobs []string
+	configPath          string
+	configDirPath       string
+	provider            string
+}
+
+func parsePreflightFlags(cmd *cobra.Command) (*preflightFlags, error) {
+	enableVariantGlobs, err := cmd.Flags().GetStringSlice("enable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting enable-variant-glob flag: %w", err)
+	}
+	disableVariantGlobs, err := cmd.Flags().GetStringSlice("disable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting disable-variant-glob flag: %w", err)
+	}
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("getting config flag: %w", err)
+	}
+	configDirPath, err := cmd.Flags().GetString("config-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting config-dir flag: %w", err)
+	}
+	provider, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return nil, fmt.Errorf("getting provider flag: %w", err)
+	}
+	return &preflightFlags{
+		enableVariantGlobs:  enableVariantGlobs,
+		disableVariantGlobs: disableVariantGlobs,
+		configPath:          configPath,
+		configDirPath:       configDirPath,
+		provider:            provider,
+	}, nil
+}
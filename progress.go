@@ -0,0 +1,78 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/edgelesssys/uplosi/uploader"
+)
+
+// progressInterval is the minimum time between two byte progress events of the same stage.
+const progressInterval = time.Second
+
+// progressEvent is a single line of the JSON progress output.
+type progressEvent struct {
+	Time    time.Time `json:"time"`
+	Variant string    `json:"variant,omitempty"`
+	Stage   string    `json:"stage"`
+	// Done and Total are only set for byte progress events. Total is -1 if the size is unknown.
+	Done  *int64 `json:"done,omitempty"`
+	Total *int64 `json:"total,omitempty"`
+}
+
+// jsonProgressWriter writes progress events as newline-delimited JSON.
+// It's shared by the progress reporters of all variants.
+type jsonProgressWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func newJSONProgressWriter(out io.Writer) *jsonProgressWriter {
+	return &jsonProgressWriter{enc: json.NewEncoder(out), now: time.Now}
+}
+
+// reporter returns the progress reporter for the upload of a variant.
+func (w *jsonProgressWriter) reporter(variant string) uploader.ProgressReporter {
+	return &jsonProgress{w: w, variant: variant}
+}
+
+func (w *jsonProgressWriter) write(event progressEvent) time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	event.Time = w.now()
+	// Progress is best effort, a failing writer must not fail the upload.
+	_ = w.enc.Encode(event)
+	return event.Time
+}
+
+// jsonProgress reports the progress of a single variant to a jsonProgressWriter.
+// Byte progress is throttled to one event per progressInterval, except for the last one of a stage.
+type jsonProgress struct {
+	w          *jsonProgressWriter
+	variant    string
+	stage      string
+	lastReport time.Time
+}
+
+// Stage writes a stage event.
+func (p *jsonProgress) Stage(name string) {
+	p.stage = name
+	p.lastReport = p.w.write(progressEvent{Variant: p.variant, Stage: name})
+}
+
+// Bytes writes a byte progress event, unless the last one was written less than progressInterval ago.
+func (p *jsonProgress) Bytes(done, total int64) {
+	if done != total && p.w.now().Sub(p.lastReport) < progressInterval {
+		return
+	}
+	p.lastReport = p.w.write(progressEvent{Variant: p.variant, Stage: p.stage, Done: &done, Total: &total})
+}
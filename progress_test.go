@@ -0,0 +1,58 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONProgress(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	w := newJSONProgressWriter(&out)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	progress := w.reporter("x")
+	progress.Stage("upload blob")
+	progress.Bytes(10, 100) // throttled
+	now = now.Add(progressInterval)
+	progress.Bytes(50, 100)
+	progress.Bytes(60, 100) // throttled
+	progress.Bytes(100, 100)
+	w.reporter("").Stage("create image")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(lines, 4)
+	var events []progressEvent
+	for _, line := range lines {
+		var event progressEvent
+		require.NoError(json.Unmarshal([]byte(line), &event))
+		events = append(events, event)
+	}
+
+	assert.Equal("x", events[0].Variant)
+	assert.Equal("upload blob", events[0].Stage)
+	assert.Nil(events[0].Done)
+	assert.Equal("upload blob", events[1].Stage)
+	require.NotNil(events[1].Done)
+	assert.EqualValues(50, *events[1].Done)
+	assert.EqualValues(100, *events[1].Total)
+	assert.EqualValues(100, *events[2].Done)
+	assert.Empty(events[3].Variant)
+	assert.Equal("create image", events[3].Stage)
+	assert.True(now.Equal(events[3].Time))
+}
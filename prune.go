@@ -0,0 +1,159 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/gcp"
+	"github.com/spf13/cobra"
+)
+
+func newPruneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old image versions",
+	}
+	cmd.AddCommand(newPruneGCPCmd())
+
+	return cmd
+}
+
+func newPruneGCPCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gcp",
+		Short: "Remove all but the newest images of the configured GCP image families",
+		Args:  cobra.NoArgs,
+		RunE:  runPruneGCP,
+	}
+	cmd.Flags().Int("keep", 0, "number of newest images to keep per image family (required)")
+	cmd.Flags().Bool("dry-run", false, "only print the images that would be pruned")
+	cmd.Flags().String("deprecate-state", "", "set the deprecation state of pruned images (DEPRECATED, OBSOLETE, DELETED) instead of deleting them")
+	cmd.Flags().StringSlice("enable-variant-glob", []string{"*"}, "list of variant name globs to enable")
+	cmd.Flags().StringSlice("disable-variant-glob", nil, "list of variant name globs to disable")
+	cmd.Flags().StringP("config", "c", "", fmt.Sprintf("path to directory %s and %s resides in", configName, configDir))
+	cmd.Flags().String("config-dir", "", "path to a directory of *.toml and *.conf config files to prune")
+	cmd.MarkFlagsMutuallyExclusive("config", "config-dir")
+
+	return cmd
+}
+
+func runPruneGCP(cmd *cobra.Command, _ []string) error {
+	flags, err := parsePruneFlags(cmd)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	configFiles, err := loadConfigFiles([]string{flags.configPath}, flags.configDirPath)
+	if err != nil {
+		return fmt.Errorf("parsing config files: %w", err)
+	}
+	logger := log.New(cmd.ErrOrStderr(), "", log.LstdFlags)
+
+	// Variants commonly share an image family, which only needs to be pruned once.
+	pruned := make(map[string]struct{})
+	for _, configFile := range configFiles {
+		err := configFile.conf.ForEach(
+			func(name string, cfg config.Config) error {
+				if cfg.Provider != "gcp" {
+					return nil
+				}
+				family := path.Join(cfg.GCP.Project, cfg.GCP.ImageFamily)
+				if _, ok := pruned[family]; ok {
+					return nil
+				}
+				pruned[family] = struct{}{}
+
+				upload, err := gcp.NewUploader(cfg, logger, nil)
+				if err != nil {
+					return fmt.Errorf("creating gcp uploader: %w", err)
+				}
+				images, err := upload.Prune(cmd.Context(), flags.keep, flags.deprecateState, flags.dryRun)
+				if err != nil {
+					return fmt.Errorf("pruning image family %s: %w", family, err)
+				}
+				logger.Printf("Pruned %d images of image family %s", len(images), family)
+				return nil
+			},
+			os.ReadFile,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", configFile.path, err)
+		}
+	}
+	if len(pruned) == 0 {
+		return fmt.Errorf("no variant uses provider gcp")
+	}
+	return nil
+}
+
+type pruneFlags struct {
+	keep                int
+	dryRun              bool
+	deprecateState      string
+	enableVariantGlobs  []string
+	disableVariantGlobs []string
+	configPath          string
+	configDirPath       string
+}
+
+func parsePruneFlags(cmd *cobra.Command) (*pruneFlags, error) {
+	keep, err := cmd.Flags().GetInt("keep")
+	if err != nil {
+		return nil, fmt.Errorf("getting keep flag: %w", err)
+	}
+	if keep < 1 {
+		return nil, fmt.Errorf("keep must be at least 1, got %d", keep)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return nil, fmt.Errorf("getting dry-run flag: %w", err)
+	}
+	deprecateState, err := cmd.Flags().GetString("deprecate-state")
+	if err != nil {
+		return nil, fmt.Errorf("getting deprecate-state flag: %w", err)
+	}
+	if deprecateState != "" && !slices.Contains([]string{"DEPRECATED", "OBSOLETE", "DELETED"}, deprecateState) {
+		return nil, fmt.Errorf("deprecate-state must be one of DEPRECATED, OBSOLETE, DELETED, got %q", deprecateState)
+	}
+	enableVariantGlobs, err := cmd.Flags().GetStringSlice("enable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting enable-variant-glob flag: %w", err)
+	}
+	disableVariantGlobs, err := cmd.Flags().GetStringSlice("disable-variant-glob")
+	if err != nil {
+		return nil, fmt.Errorf("getting disable-variant-glob flag: %w", err)
+	}
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, fmt.Errorf("getting config flag: %w", err)
+	}
+	configDirPath, err := cmd.Flags().GetString("config-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting config-dir flag: %w", err)
+	}
+	return &pruneFlags{
+		keep:                keep,
+		dryRun:              dryRun,
+		deprecateState:      deprecateState,
+		enableVariantGlobs:  enableVariantGlobs,
+		disableVariantGlobs: disableVariantGlobs,
+		configPath:          configPath,
+		configDirPath:       configDirPath,
+	}, nil
+}
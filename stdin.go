@@ -0,0 +1,81 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/edgelesssys/uplosi/config"
+)
+
+// stdinImage is the image argument that reads the image from stdin.
+const stdinImage = "-"
+
+// noImage is the image path if the image argument is omitted.
+// Only variants that create the image from existing cloud resources can be uploaded without an image.
+const noImage = ""
+
+// stdinConfig is the config location that reads the config from stdin.
+const stdinConfig = "-"
+
+// streamingProviders are the providers that upload an image read from stdin without spooling it to disk.
+// Their prepper doesn't touch the image and their uploader reads it exactly once.
+var streamingProviders = map[string]struct{}{
+	"aws": {},
+}
+
+// canStreamStdin reports whether the image can be streamed from stdin. This is only the
+// case for a single enabled variant of a streaming provider, as stdin can only be read once.
+func canStreamStdin(configFiles []namedConfigFile, flags *uploadFlags, versionFileLookup func(name string) ([]byte, error)) (bool, error) {
+	var providers []string
+	for _, configFile := range configFiles {
+		err := configFile.conf.ForEach(
+			func(_ string, cfg config.Config) error {
+				providers = append(providers, strings.ToLower(cfg.Provider))
+				return nil
+			},
+			versionFileLookup,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+			flags.variantSelected,
+		)
+		if err != nil {
+			return false, fmt.Errorf("config file %s: %w", configFile.path, err)
+		}
+	}
+	if len(providers) != 1 {
+		return false, nil
+	}
+	_, ok := streamingProviders[providers[0]]
+	return ok, nil
+}
+
+// spoolImage copies the image from r to a temporary file and returns its path.
+// The caller must remove the file after the upload.
+func spoolImage(r io.Reader) (string, error) {
+	spool, err := os.CreateTemp("", "uplosi-stdin-*.raw")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	if _, err := io.Copy(spool, r); err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return "", fmt.Errorf("copying image to %s: %w", spool.Name(), err)
+	}
+	if err := spool.Close(); err != nil {
+		os.Remove(spool.Name())
+		return "", fmt.Errorf("closing %s: %w", spool.Name(), err)
+	}
+	return spool.Name(), nil
+}
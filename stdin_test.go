@@ -0,0 +1,98 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanStreamStdin(t *testing.T) {
+	const conf = `
+[base]
+imageVersion = "1.0.0"
+name = "my-image"
+
+[base.aws]
+region = "eu-central-1"
+bucket = "my-bucket"
+
+[base.gcp]
+project = "my-project"
+location = "europe-west3"
+bucket = "my-bucket"
+
+[variant.aws-a]
+provider = "aws"
+
+[variant.aws-b]
+provider = "aws"
+
+[variant.gcp]
+provider = "gcp"
+`
+
+	testCases := map[string]struct {
+		enable     []string
+		wantStream bool
+		wantErr    bool
+	}{
+		"single aws variant": {
+			enable:     []string{"aws-a"},
+			wantStream: true,
+		},
+		"multiple aws variants": {
+			enable: []string{"aws-*"},
+		},
+		"single gcp variant": {
+			enable: []string{"gcp"},
+		},
+		"no variant": {
+			enable:  []string{"azure"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			var confFile config.ConfigFile
+			_, err := toml.Decode(conf, &confFile)
+			require.NoError(err)
+			flags := &uploadFlags{enableVariantGlobs: tc.enable}
+
+			stream, err := canStreamStdin([]namedConfigFile{{path: "uplosi.conf", conf: &confFile}}, flags, os.ReadFile)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			assert.Equal(tc.wantStream, stream)
+		})
+	}
+}
+
+func TestSpoolImage(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	path, err := spoolImage(strings.NewReader("image"))
+	require.NoError(err)
+	defer os.Remove(path)
+
+	content, err := os.ReadFile(path)
+	require.NoError(err)
+	assert.Equal("image", string(content))
+}
@@ -6,10 +6,50 @@ SPDX-License-Identifier: Apache-2.0
 
 package template
 
-import "strings"
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
 
+// DefaultFuncMap returns the functions available in config templates.
+// Functions that take a string to transform take it as last argument,
+// so they can be used in pipelines, e.g. {{.Version | trimPrefix "v"}}.
+// replaceAll keeps the argument order of strings.ReplaceAll and regexReplaceAll the one of sprig.
 func DefaultFuncMap() map[string]any {
 	return map[string]any{
 		"replaceAll": strings.ReplaceAll,
+		"toLower":    strings.ToLower,
+		"toUpper":    strings.ToUpper,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"env":        os.Getenv,
+		// Named like the sprig functions with the same behavior.
+		"trunc":           trunc,
+		"regexReplaceAll": regexReplaceAll,
 	}
 }
+
+// trunc returns the first length characters of s.
+// A negative length returns the last -length characters.
+func trunc(length int, s string) string {
+	runes := []rune(s)
+	switch {
+	case length >= 0 && length < len(runes):
+		return string(runes[:length])
+	case length < 0 && -length < len(runes):
+		return string(runes[len(runes)+length:])
+	}
+	return s
+}
+
+// regexReplaceAll replaces all matches of regex in s with replacement,
+// which can refer to submatches, e.g. ${1}.
+func regexReplaceAll(regex, s, replacement string) (string, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return "", fmt.Errorf("regexReplaceAll: %w", err)
+	}
+	return re.ReplaceAllString(s, replacement), nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package template
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultFuncMap(t *testing.T) {
+	testCases := map[string]struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		"trunc": {
+			text: `{{trunc 5 "my-image-1.2.3"}}`,
+			want: "my-im",
+		},
+		"trunc negative length": {
+			text: `{{trunc -5 "my-image-1.2.3"}}`,
+			want: "1.2.3",
+		},
+		"trunc longer than string": {
+			text: `{{"short" | trunc 128}}`,
+			want: "short",
+		},
+		"trunc counts characters": {
+			text: `{{trunc 3 "äöüß"}}`,
+			want: "äöü",
+		},
+		"regexReplaceAll": {
+			text: `{{regexReplaceAll "[^a-zA-Z0-9().\\-/_]" "image+build~1" "-"}}`,
+			want: "image-build-1",
+		},
+		"regexReplaceAll with submatch": {
+			text: `{{regexReplaceAll "^v([0-9]+)\\..*$" "v12.3.4" "${1}"}}`,
+			want: "12",
+		},
+		"regexReplaceAll invalid regex": {
+			text:    `{{regexReplaceAll "(" "image" "-"}}`,
+			wantErr: true,
+		},
+		"pipeline": {
+			text: `{{regexReplaceAll "[^a-z0-9]+" ("My_Image+Build" | toLower) "-" | trunc 8}}`,
+			want: "my-image",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			tmpl, err := template.New(name).Funcs(DefaultFuncMap()).Parse(tc.text)
+			assert.NoError(err)
+			rendered := new(strings.Builder)
+			err = tmpl.Execute(rendered, nil)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.want, rendered.String())
+		})
+	}
+}
@@ -8,6 +8,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,8 +16,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"unicode"
 
 	"github.com/BurntSushi/toml"
 	"github.com/edgelesssys/uplosi/aws"
@@ -24,8 +29,8 @@ import (
 	"github.com/edgelesssys/uplosi/config"
 	"github.com/edgelesssys/uplosi/gcp"
 	"github.com/edgelesssys/uplosi/openstack"
+	"github.com/edgelesssys/uplosi/uploader"
 	"github.com/spf13/cobra"
-	"golang.org/x/mod/semver"
 )
 
 const (
@@ -35,125 +40,398 @@ const (
 
 func newUploadCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "upload <image>",
+		Use:   "upload [image|-]",
 		Short: "Upload an image to a cloud provider",
-		Args:  cobra.ExactArgs(1),
+		Args:  cobra.MaximumNArgs(1),
 		RunE:  runUpload,
 	}
-	cmd.Flags().BoolP("increment-version", "i", false, "increment version number after upload")
+	cmd.Flags().BoolP("increment-version", "i", false, "upload with the incremented version number and write it back to the version files")
 	cmd.Flags().StringSlice("enable-variant-glob", []string{"*"}, "list of variant name globs to enable")
 	cmd.Flags().StringSlice("disable-variant-glob", nil, "list of variant name globs to disable")
-	cmd.Flags().StringP("config", "c", "", fmt.Sprintf("path to directory %s and %s resides in", configName, configDir))
+	cmd.Flags().StringSlice("variant", nil, "name of a variant to upload, can be repeated to upload multiple variants (default all)")
+	cmd.Flags().StringSlice("exclude-variant", nil, "name of a variant to skip, can be repeated")
+	cmd.Flags().StringSliceP("config", "c", nil, fmt.Sprintf("path to directory %s and %s reside in or to a config file (- for stdin), can be repeated to merge multiple configs where later ones take precedence", configName, configDir))
+	cmd.Flags().String("config-dir", "", "path to a directory of *.toml and *.conf config files that are uploaded, up to --parallel variants at a time")
+	cmd.MarkFlagsMutuallyExclusive("config", "config-dir")
+	cmd.Flags().BoolP("quiet", "q", false, "suppress informational log output, only print errors and image references")
+	cmd.Flags().StringP("output", "o", "table", "format of the printed image references (table, json)")
+	cmd.Flags().String("provider", "", "override the provider of every variant (aws, azure, gcp, openstack)")
+	cmd.Flags().Bool("keep-going", false, "continue uploading the remaining variants if a variant fails")
+	cmd.Flags().String("output-dir", "", "directory to write the result of every variant to <variant>.json and an index to index.json")
+	cmd.Flags().Bool("dry-run", false, "print the planned operations of every variant as JSON without changing any cloud resources")
+	cmd.Flags().String("progress", "log", "format of the upload progress written to stderr (log, json)")
+	cmd.Flags().Int("parallel", 1, "number of variants of a config file that are uploaded concurrently")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "increment-version")
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "output-dir")
 
 	return cmd
 }
 
 func runUpload(cmd *cobra.Command, args []string) error {
-	logger := log.New(cmd.ErrOrStderr(), "", log.LstdFlags)
-	imagePath := args[0]
+	imagePath := noImage
+	if len(args) > 0 {
+		imagePath = args[0]
+	}
 
 	flags, err := parseUploadFlags(cmd)
 	if err != nil {
 		return fmt.Errorf("parsing flags: %w", err)
 	}
+	if imagePath == stdinImage && slices.Contains(flags.configPaths, stdinConfig) {
+		return errors.New("image and config can't both be read from stdin")
+	}
+
+	logOut := cmd.ErrOrStderr()
+	// JSON progress events replace the log output, so stderr stays parseable.
+	if flags.quiet || flags.progress == "json" {
+		logOut = io.Discard
+	}
+	logger := log.New(logOut, "", log.LstdFlags)
+	newProgress := func(string) uploader.ProgressReporter { return uploader.NopProgress{} }
+	if flags.progress == "json" {
+		newProgress = newJSONProgressWriter(cmd.ErrOrStderr()).reporter
+	}
 
-	conf, err := parseConfigFiles(flags.configPath)
+	configFiles, err := loadConfigFiles(flags.configPaths, flags.configDirPath)
 	if err != nil {
 		return fmt.Errorf("parsing config files: %w", err)
 	}
+	for _, configFile := range configFiles {
+		configFile.conf.SetProviderOverride(flags.provider)
+	}
+	if err := checkVariantNames(configFiles, flags.variants, flags.excludeVariants); err != nil {
+		return err
+	}
 
-	versionFiles := map[string][]byte{}
-	versionFileLookup := func(name string) ([]byte, error) {
-		if _, ok := versionFiles[name]; !ok {
-			ver, err := os.ReadFile(name)
-			if err != nil {
-				return nil, fmt.Errorf("reading version file: %w", err)
-			}
-			versionFiles[name] = ver
+	versionFiles := newVersionFiles(flags.incrementVersion)
+
+	if imagePath == noImage {
+		if err := checkNoImage(configFiles, flags, versionFiles.lookup); err != nil {
+			return err
 		}
-		return versionFiles[name], nil
 	}
 
-	allRefs := []string{}
-	err = conf.ForEach(
-		func(name string, cfg config.Config) error {
-			refs, err := uploadVariant(cmd.Context(), imagePath, name, cfg, logger)
+	if flags.dryRun {
+		return dryRunUpload(cmd.OutOrStdout(), imagePath, configFiles, flags, versionFiles.lookup, logger)
+	}
+
+	if imagePath == stdinImage {
+		stream, err := canStreamStdin(configFiles, flags, versionFiles.lookup)
+		if err != nil {
+			return fmt.Errorf("checking if image can be streamed: %w", err)
+		}
+		if stream {
+			logger.Println("Streaming image from stdin")
+		} else {
+			logger.Println("Spooling image from stdin to a temporary file")
+			imagePath, err = spoolImage(os.Stdin)
 			if err != nil {
-				return err
+				return fmt.Errorf("spooling image from stdin: %w", err)
 			}
-			allRefs = append(allRefs, refs...)
-			return nil
-		},
-		versionFileLookup,
-		func(name string) bool {
-			return filterGlobAny(flags.enableVariantGlobs, name)
-		},
-		func(name string) bool {
-			return !filterGlobAny(flags.disableVariantGlobs, name)
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("uploading variants: %w", err)
+			defer os.Remove(imagePath)
+		}
+	}
+
+	var output *outputDir
+	if flags.outputDir != "" {
+		output, err = newOutputDir(flags.outputDir)
+		if err != nil {
+			return err
+		}
+		for _, configFile := range configFiles {
+			output.reserve(configFile.path, selectedVariants(configFile.conf, flags))
+		}
+	}
+
+	// Slots limit the concurrent variant uploads of all config files to flags.parallel.
+	slots := make(chan struct{}, flags.parallel)
+	fileResults := make([][]uploader.UploadResult, len(configFiles))
+	fileErrs := make([]error, len(configFiles))
+	uploadFile := func(i int, fileLogger *log.Logger) {
+		configFile := configFiles[i]
+		if len(configFiles) > 1 {
+			fileLogger.Println("Uploading images for config file", configFile.path)
+		}
+		results, err := uploadConfigFile(cmd.Context(), imagePath, configFile, flags, versionFiles.lookup, output, newProgress, slots, fileLogger)
+		fileResults[i] = results
+		if err != nil {
+			fileErrs[i] = fmt.Errorf("config file %s: %w", configFile.path, err)
+			return
+		}
+		if len(configFiles) > 1 {
+			fileLogger.Printf("Uploaded %d images for config file %s", len(results), configFile.path)
+		}
+	}
+	if flags.parallel > 1 && len(configFiles) > 1 {
+		var wg sync.WaitGroup
+		for i, configFile := range configFiles {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				uploadFile(i, newPrefixLogger(logger, filepath.Base(configFile.path)))
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := range configFiles {
+			uploadFile(i, logger)
+		}
 	}
 
-	for _, ref := range allRefs {
-		fmt.Println(ref)
+	allResults := []uploader.UploadResult{}
+	var uploadErr error
+	for i := range configFiles {
+		allResults = append(allResults, fileResults[i]...)
+		uploadErr = errors.Join(uploadErr, fileErrs[i])
+	}
+
+	if err := printUploadResults(cmd.OutOrStdout(), flags.outputFormat, allResults); err != nil {
+		uploadErr = errors.Join(uploadErr, fmt.Errorf("printing results: %w", err))
+	}
+	if output != nil {
+		if err := output.writeIndex(); err != nil {
+			uploadErr = errors.Join(uploadErr, err)
+		}
+	}
+	if uploadErr != nil {
+		return fmt.Errorf("uploading variants: %w", uploadErr)
 	}
 
 	if !flags.incrementVersion {
 		return nil
 	}
-	if len(versionFiles) == 0 {
-		return errors.New("increment-version flag set but no version files found")
+	return versionFiles.write()
+}
+
+// versionFiles reads every version file once and caches its content.
+// If increment is set, the cached content holds the next patch release of the version,
+// so the images are uploaded with it, and write stores it back in the files.
+type versionFiles struct {
+	increment bool
+
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newVersionFiles(increment bool) *versionFiles {
+	return &versionFiles{increment: increment, files: map[string][]byte{}}
+}
+
+// lookup returns the content of the version file name.
+// It can be called concurrently.
+func (v *versionFiles) lookup(name string) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if content, ok := v.files[name]; ok {
+		return content, nil
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
 	}
-	for versionFileName, version := range versionFiles {
-		newVer, err := incrementSemver(strings.TrimSpace(string(version)))
+	if v.increment {
+		content, err = incrementVersionFile(content)
 		if err != nil {
-			return fmt.Errorf("incrementing semver: %w", err)
+			return nil, fmt.Errorf("incrementing version of %s: %w", name, err)
 		}
-		if err := writeVersionFile(versionFileName, []byte(newVer)); err != nil {
-			return fmt.Errorf("writing version file: %w", err)
+	}
+	v.files[name] = content
+	return content, nil
+}
+
+// write writes the incremented versions back to the version files that were looked up.
+func (v *versionFiles) write() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.files) == 0 {
+		return errors.New("increment-version flag set but no version files found")
+	}
+	for name, content := range v.files {
+		if err := writeVersionFile(name, content); err != nil {
+			return fmt.Errorf("writing version file %s: %w", name, err)
 		}
 	}
 	return nil
 }
 
-func uploadVariant(ctx context.Context, imagePath, variant string, config config.Config, logger *log.Logger) ([]string, error) {
-	var prepper Prepper
-	var upload Uploader
+// incrementVersionFile returns the content of a version file with the version replaced
+// by its next patch release. Trailing whitespace, like a final newline, is kept.
+func incrementVersionFile(content []byte) ([]byte, error) {
+	trimmed := strings.TrimRightFunc(string(content), unicode.IsSpace)
+	newVer, err := incrementSemver(strings.TrimSpace(trimmed))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(newVer + string(content[len(trimmed):])), nil
+}
+
+// uploadConfigFile uploads all enabled variants of a config file.
+// The results of successfully uploaded variants are returned even if an error occurs.
+// If output is not nil, the result of every variant is written to it.
+// The progress of every variant is reported to the reporter returned by newProgress.
+// If flags.parallel is greater than one, up to that many variants are uploaded concurrently
+// and a summary of all variants is logged at the end. Every upload holds one of the slots,
+// which are shared by all config files.
+func uploadConfigFile(ctx context.Context, imagePath string, configFile namedConfigFile, flags *uploadFlags,
+	versionFileLookup func(name string) ([]byte, error), output *outputDir,
+	newProgress func(variant string) uploader.ProgressReporter, slots chan struct{}, logger *log.Logger,
+) ([]uploader.UploadResult, error) {
+	var mu sync.Mutex
+	variantResults := map[string][]uploader.UploadResult{}
+	variantStatus := map[string]error{}
+	var variantErrs error
+	upload := func(name string, cfg config.Config) error {
+		variantLogger := logger
+		if flags.parallel > 1 {
+			variantLogger = newVariantLogger(logger, name)
+		}
+		slots <- struct{}{}
+		results, err := uploadVariant(ctx, imagePath, name, cfg, newProgress(name), variantLogger)
+		<-slots
+		mu.Lock()
+		defer mu.Unlock()
+		variantStatus[name] = err
+		if output != nil {
+			result := variantResult{
+				ConfigFile:   configFile.path,
+				Variant:      name,
+				Provider:     cfg.Provider,
+				ImageVersion: cfg.ImageVersion,
+				Refs:         []string{},
+				Results:      []uploader.UploadResult{},
+			}
+			for _, r := range results {
+				result.Refs = append(result.Refs, r.Reference)
+				result.Results = append(result.Results, r)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			if writeErr := output.writeResult(result); writeErr != nil {
+				return errors.Join(err, writeErr)
+			}
+		}
+		if err != nil && flags.keepGoing {
+			variantLogger.Printf("Uploading variant %q failed, continuing with remaining variants: %v", name, err)
+			variantErrs = errors.Join(variantErrs, fmt.Errorf("variant %q: %w", name, err))
+			return nil
+		}
+		if err != nil {
+			if flags.parallel > 1 {
+				return fmt.Errorf("variant %q: %w", name, err)
+			}
+			return err
+		}
+		variantResults[name] = results
+		return nil
+	}
+	enabled := func(name string) bool {
+		return filterGlobAny(flags.enableVariantGlobs, name)
+	}
+	disabled := func(name string) bool {
+		return !filterGlobAny(flags.disableVariantGlobs, name)
+	}
+
 	var err error
+	if flags.parallel > 1 {
+		err = configFile.conf.ForEachParallel(upload, flags.parallel, versionFileLookup, enabled, disabled, flags.variantSelected)
+		logUploadSummary(logger, variantStatus)
+	} else {
+		err = configFile.conf.ForEach(upload, versionFileLookup, enabled, disabled, flags.variantSelected)
+	}
 
-	if len(variant) > 0 {
-		log.Println("Uploading variant", variant)
+	// Variants are uploaded in the order of their names, return the results in the same order.
+	names := make([]string, 0, len(variantResults))
+	for name := range variantResults {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	results := []uploader.UploadResult{}
+	for _, name := range names {
+		results = append(results, variantResults[name]...)
+	}
+	if err != nil {
+		return results, err
 	}
+	return results, variantErrs
+}
 
-	switch strings.ToLower(config.Provider) {
-	case "aws":
-		prepper = &aws.Prepper{}
-		upload, err = aws.NewUploader(config, logger)
+// newVariantLogger returns a logger that prefixes every message with the variant name,
+// so the interleaved output of concurrent uploads can be told apart.
+func newVariantLogger(logger *log.Logger, variant string) *log.Logger {
+	if variant == "" {
+		return logger
+	}
+	return newPrefixLogger(logger, variant)
+}
+
+// newPrefixLogger returns a logger that adds [name] to the prefix of logger.
+func newPrefixLogger(logger *log.Logger, name string) *log.Logger {
+	return log.New(logger.Writer(), fmt.Sprintf("%s[%s] ", logger.Prefix(), name), logger.Flags()|log.Lmsgprefix)
+}
+
+// logUploadSummary logs whether the upload of every started variant succeeded.
+func logUploadSummary(logger *log.Logger, status map[string]error) {
+	names := make([]string, 0, len(status))
+	var failed int
+	for name, err := range status {
+		names = append(names, name)
 		if err != nil {
-			return nil, fmt.Errorf("creating aws uploader: %w", err)
+			failed++
 		}
-	case "azure":
-		prepper = &azure.Prepper{}
-		upload, err = azure.NewUploader(config, logger)
-		if err != nil {
-			return nil, fmt.Errorf("creating azure uploader: %w", err)
+	}
+	slices.Sort(names)
+	logger.Printf("Uploaded %d of %d variants, %d failed", len(names)-failed, len(names), failed)
+	for _, name := range names {
+		if err := status[name]; err != nil {
+			logger.Printf("  %s: failed: %v", name, err)
+			continue
 		}
-	case "gcp":
-		prepper = &gcp.Prepper{}
-		upload, err = gcp.NewUploader(config, logger)
+		logger.Printf("  %s: ok", name)
+	}
+}
+
+func uploadVariant(ctx context.Context, imagePath, variant string, config config.Config,
+	progress uploader.ProgressReporter, logger *log.Logger,
+) ([]uploader.UploadResult, error) {
+	if len(variant) > 0 {
+		logger.Println("Uploading variant", variant)
+	}
+
+	if err := checkDataImage(config); err != nil {
+		return nil, err
+	}
+
+	prepper, upload, err := newUploader(config, logger, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	if imagePath == noImage {
+		results, err := upload.Upload(ctx, &uploader.Request{})
 		if err != nil {
-			return nil, fmt.Errorf("creating gcp uploader: %w", err)
+			return nil, fmt.Errorf("uploading image: %w", err)
 		}
-	case "openstack":
-		prepper = &openstack.Prepper{}
-		upload, err = openstack.NewUploader(config, logger)
+		return results, nil
+	}
+
+	// Only streaming providers read the image from stdin, their prepper doesn't touch the image.
+	if imagePath == stdinImage {
+		req := uploader.NewStreamRequest(os.Stdin)
+		defer req.Close()
+		results, err := upload.Upload(ctx, req)
 		if err != nil {
-			return nil, fmt.Errorf("creating openstack uploader: %w", err)
+			return nil, fmt.Errorf("uploading image: %w", err)
 		}
-	default:
-		return nil, fmt.Errorf("unknown provider: %s", config.Provider)
+		return results, nil
+	}
+
+	rawImageFi, err := os.Stat(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("getting image stats: %w", err)
+	}
+	if err := checkImageSize(config.Provider, rawImageFi.Size(), config.MaxImageSizeGiB); err != nil {
+		return nil, err
 	}
 
 	tmpDir, err := os.MkdirTemp("", "uplosi-")
@@ -166,29 +444,64 @@ func uploadVariant(ctx context.Context, imagePath, variant string, config config
 	if err != nil {
 		return nil, fmt.Errorf("preparing image: %w", err)
 	}
-	image, err := os.Open(imagePath)
-	if err != nil {
-		return nil, fmt.Errorf("opening image: %w", err)
-	}
-	defer image.Close()
-	imageFi, err := image.Stat()
+	req, err := uploader.OpenRequest(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("getting image stats: %w", err)
+		return nil, err
 	}
+	defer req.Close()
 
-	refs, err := upload.Upload(ctx, image, imageFi.Size())
+	results, err := upload.Upload(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("uploading image: %w", err)
 	}
 
-	return refs, nil
+	return results, nil
+}
+
+// newUploader creates the prepper and uploader for the configured provider.
+// The prepper is resolved from the prepper registry, so custom preppers can be registered.
+// progress may be nil if the progress isn't reported.
+func newUploader(config config.Config, logger *log.Logger, progress uploader.ProgressReporter) (uploader.Prepper, Uploader, error) {
+	var upload Uploader
+	var err error
+	switch strings.ToLower(config.Provider) {
+	case "aws":
+		upload, err = aws.NewUploader(config, logger, progress)
+	case "azure":
+		upload, err = azure.NewUploader(config, logger, progress)
+	case "gcp":
+		upload, err = gcp.NewUploader(config, logger, progress)
+	case "openstack":
+		upload, err = openstack.NewUploader(config, logger, progress)
+	default:
+		return nil, nil, fmt.Errorf("unknown provider: %s", config.Provider)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating %s uploader: %w", strings.ToLower(config.Provider), err)
+	}
+	prepper, err := uploader.NewPrepper(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return prepper, upload, nil
 }
 
 type uploadFlags struct {
 	incrementVersion    bool
 	enableVariantGlobs  []string
 	disableVariantGlobs []string
-	configPath          string
+	variants            []string
+	excludeVariants     []string
+	configPaths         []string
+	configDirPath       string
+	quiet               bool
+	provider            string
+	keepGoing           bool
+	outputDir           string
+	dryRun              bool
+	outputFormat        string
+	progress            string
+	parallel            int
 }
 
 func parseUploadFlags(cmd *cobra.Command) (*uploadFlags, error) {
@@ -204,18 +517,228 @@ func parseUploadFlags(cmd *cobra.Command) (*uploadFlags, error) {
 	if err != nil {
 		return nil, fmt.Errorf("getting disable-variant-glob flag: %w", err)
 	}
-	configPath, err := cmd.Flags().GetString("config")
+	variants, err := cmd.Flags().GetStringSlice("variant")
+	if err != nil {
+		return nil, fmt.Errorf("getting variant flag: %w", err)
+	}
+	excludeVariants, err := cmd.Flags().GetStringSlice("exclude-variant")
+	if err != nil {
+		return nil, fmt.Errorf("getting exclude-variant flag: %w", err)
+	}
+	configPaths, err := cmd.Flags().GetStringSlice("config")
 	if err != nil {
 		return nil, fmt.Errorf("getting config flag: %w", err)
 	}
+	configDirPath, err := cmd.Flags().GetString("config-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting config-dir flag: %w", err)
+	}
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return nil, fmt.Errorf("getting quiet flag: %w", err)
+	}
+	provider, err := cmd.Flags().GetString("provider")
+	if err != nil {
+		return nil, fmt.Errorf("getting provider flag: %w", err)
+	}
+	keepGoing, err := cmd.Flags().GetBool("keep-going")
+	if err != nil {
+		return nil, fmt.Errorf("getting keep-going flag: %w", err)
+	}
+	outputDir, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting output-dir flag: %w", err)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return nil, fmt.Errorf("getting dry-run flag: %w", err)
+	}
+	outputFormat, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, fmt.Errorf("getting output flag: %w", err)
+	}
+	if outputFormat != "table" && outputFormat != "json" {
+		return nil, fmt.Errorf("output format must be one of table, json, got %q", outputFormat)
+	}
+	progress, err := cmd.Flags().GetString("progress")
+	if err != nil {
+		return nil, fmt.Errorf("getting progress flag: %w", err)
+	}
+	if progress != "log" && progress != "json" {
+		return nil, fmt.Errorf("progress format must be one of log, json, got %q", progress)
+	}
+	parallel, err := cmd.Flags().GetInt("parallel")
+	if err != nil {
+		return nil, fmt.Errorf("getting parallel flag: %w", err)
+	}
+	if parallel < 1 {
+		return nil, fmt.Errorf("parallel must be at least 1, got %d", parallel)
+	}
 	return &uploadFlags{
 		incrementVersion:    incrementVersion,
 		enableVariantGlobs:  enableVariantGlobs,
 		disableVariantGlobs: disableVariantGlobs,
-		configPath:          configPath,
+		variants:            variants,
+		excludeVariants:     excludeVariants,
+		configPaths:         configPaths,
+		configDirPath:       configDirPath,
+		quiet:               quiet,
+		provider:            provider,
+		keepGoing:           keepGoing,
+		outputDir:           outputDir,
+		dryRun:              dryRun,
+		outputFormat:        outputFormat,
+		progress:            progress,
+		parallel:            parallel,
 	}, nil
 }
 
+// selectedVariants returns the names of the variants of conf that are uploaded with flags,
+// the empty name for a config without variants.
+func selectedVariants(conf *config.ConfigFile, flags *uploadFlags) []string {
+	if len(conf.Variants) == 0 {
+		return []string{""}
+	}
+	var names []string
+	for name := range conf.Variants {
+		if filterGlobAny(flags.enableVariantGlobs, name) && !filterGlobAny(flags.disableVariantGlobs, name) && flags.variantSelected(name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// variantSelected reports whether the variant is selected by the --variant and --exclude-variant flags.
+func (f *uploadFlags) variantSelected(name string) bool {
+	return variantSelected(f.variants, f.excludeVariants, name)
+}
+
+// variantSelected reports whether the variant is in variants and not in excludeVariants.
+// All variants are selected if variants is empty.
+func variantSelected(variants, excludeVariants []string, name string) bool {
+	if slices.Contains(excludeVariants, name) {
+		return false
+	}
+	return len(variants) == 0 || slices.Contains(variants, name)
+}
+
+// checkVariantNames ensures every variant passed to --variant or --exclude-variant
+// exists in at least one of the config files.
+func checkVariantNames(configFiles []namedConfigFile, variants, excludeVariants []string) error {
+	var errs error
+	for _, name := range slices.Concat(variants, excludeVariants) {
+		found := slices.ContainsFunc(configFiles, func(configFile namedConfigFile) bool {
+			_, ok := configFile.conf.Variants[name]
+			return ok
+		})
+		if !found {
+			errs = errors.Join(errs, fmt.Errorf("variant %q not found in any config file", name))
+		}
+	}
+	return errs
+}
+
+// printUploadResults writes the results as a table or as JSON to out.
+func printUploadResults(out io.Writer, format string, results []uploader.UploadResult) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tREGION\tTYPE\tREFERENCE")
+	for _, result := range results {
+		region := result.Region
+		if region == "" {
+			region = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Provider, region, result.ResourceType, result.Reference)
+	}
+	return w.Flush()
+}
+
+// maxImageSizes are the documented maximum raw image sizes per provider.
+var maxImageSizes = map[string]int64{
+	"aws":   16 << 40, // VM Import/Export snapshots are limited by the EBS maximum of 16 TiB
+	"azure": 4 << 40,  // managed OS disks are limited to 4 TiB
+	"gcp":   2 << 40,  // imported GCE images are limited to 2 TiB
+}
+
+// checkImageSize ensures an image of the given size can be uploaded to the provider.
+// A positive overrideGiB replaces the default limit, e.g. for raised quotas.
+func checkImageSize(provider string, size int64, overrideGiB int) error {
+	limit, ok := maxImageSizes[strings.ToLower(provider)]
+	if overrideGiB > 0 {
+		limit, ok = int64(overrideGiB)<<30, true
+	}
+	if !ok || size <= limit {
+		return nil
+	}
+	return fmt.Errorf(
+		"image size of %d bytes exceeds the maximum of %d bytes (%d GiB) for provider %s, "+
+			"shrink the image or set maxImageSizeGiB if your quota allows larger images",
+		size, limit, limit>>30, provider,
+	)
+}
+
+// checkDataImage ensures the data image of an AWS variant is a readable file,
+// so a missing data image fails the upload before any cloud resources are touched.
+func checkDataImage(config config.Config) error {
+	if !strings.EqualFold(config.Provider, "aws") || config.AWS.DataImage == "" {
+		return nil
+	}
+	dataImage, err := os.Open(config.AWS.DataImage)
+	if err != nil {
+		return fmt.Errorf("opening data image: %w", err)
+	}
+	defer dataImage.Close()
+	fi, err := dataImage.Stat()
+	if err != nil {
+		return fmt.Errorf("getting data image stats: %w", err)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("data image %s is a directory", config.AWS.DataImage)
+	}
+	return nil
+}
+
+// needsImage reports whether the upload of a variant reads the image.
+// GCP images created from a sourceImage or sourceDisk don't.
+func needsImage(cfg config.Config) bool {
+	if strings.EqualFold(cfg.Provider, "gcp") {
+		return cfg.GCP.SourceImage == "" && cfg.GCP.SourceDisk == ""
+	}
+	return true
+}
+
+// checkNoImage ensures every selected variant can be uploaded without an image argument.
+func checkNoImage(configFiles []namedConfigFile, flags *uploadFlags, versionFileLookup func(name string) ([]byte, error)) error {
+	for _, configFile := range configFiles {
+		err := configFile.conf.ForEach(
+			func(name string, cfg config.Config) error {
+				if needsImage(cfg) {
+					return fmt.Errorf("variant %q needs an image argument, only GCP images created from a sourceImage or sourceDisk don't", name)
+				}
+				return nil
+			},
+			versionFileLookup,
+			func(name string) bool {
+				return filterGlobAny(flags.enableVariantGlobs, name)
+			},
+			func(name string) bool {
+				return !filterGlobAny(flags.disableVariantGlobs, name)
+			},
+			flags.variantSelected,
+		)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", configFile.path, err)
+		}
+	}
+	return nil
+}
+
 func filterGlobAny(globs []string, name string) bool {
 	for _, glob := range globs {
 		if ok, _ := filepath.Match(glob, name); ok {
@@ -238,7 +761,7 @@ func readTOMLFile(path string, data any) error {
 }
 
 func writeVersionFile(path string, data []byte) error {
-	versionFile, err := os.OpenFile(path, os.O_WRONLY, os.ModeAppend)
+	versionFile, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, os.ModeAppend)
 	if err != nil {
 		return fmt.Errorf("opening file: %w", err)
 	}
@@ -249,12 +772,124 @@ func writeVersionFile(path string, data []byte) error {
 	return nil
 }
 
-type Prepper interface {
-	Prepare(ctx context.Context, imagePath, tmpDir string) (string, error)
+type Uploader interface {
+	Upload(ctx context.Context, req *uploader.Request) (results []uploader.UploadResult, retErr error)
 }
 
-type Uploader interface {
-	Upload(ctx context.Context, image io.ReadSeeker, size int64) (refs []string, retErr error)
+type namedConfigFile struct {
+	path string
+	conf *config.ConfigFile
+}
+
+// loadConfigFiles loads either the configs from the --config locations merged into one config
+// or every *.toml file in the --config-dir directory.
+// References to environment variables are expanded, see config.ConfigFile.ExpandEnv.
+func loadConfigFiles(configPaths []string, configDirPath string) ([]namedConfigFile, error) {
+	configFiles, err := readConfigFiles(configPaths, configDirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, configFile := range configFiles {
+		if err := configFile.conf.ExpandEnv(os.LookupEnv); err != nil {
+			return nil, fmt.Errorf("expanding environment variables in config %s: %w", configFile.path, err)
+		}
+	}
+	return configFiles, nil
+}
+
+func readConfigFiles(configPaths []string, configDirPath string) ([]namedConfigFile, error) {
+	if configDirPath == "" {
+		return mergeConfigPaths(configPaths)
+	}
+
+	dirEntries, err := os.ReadDir(configDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading config dir: %w", err)
+	}
+	var configFiles []namedConfigFile
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		configPath := filepath.Join(configDirPath, dirEntry.Name())
+		switch filepath.Ext(dirEntry.Name()) {
+		case ".toml", ".conf":
+		case ".yaml", ".yml":
+			return nil, fmt.Errorf("config %s: YAML configs aren't supported, convert it to TOML", configPath)
+		default:
+			continue
+		}
+		var conf config.ConfigFile
+		if err := readTOMLFile(configPath, &conf); err != nil {
+			return nil, fmt.Errorf("reading config %s: %w", configPath, err)
+		}
+		configFiles = append(configFiles, namedConfigFile{path: configPath, conf: &conf})
+	}
+	if len(configFiles) == 0 {
+		return nil, fmt.Errorf("no *.toml or *.conf config files found in %s", configDirPath)
+	}
+	return configFiles, nil
+}
+
+// mergeConfigPaths loads every config location and merges them in order,
+// so settings of later locations override the ones of earlier locations.
+// A location is either a directory uplosi.conf and uplosi.conf.d reside in, a single config file
+// or stdin. No locations is the same as the working directory.
+func mergeConfigPaths(configPaths []string) ([]namedConfigFile, error) {
+	if len(configPaths) == 0 {
+		configPaths = []string{""}
+	}
+	if i := slices.Index(configPaths, stdinConfig); i >= 0 && slices.Contains(configPaths[i+1:], stdinConfig) {
+		return nil, errors.New("config can be read from stdin only once")
+	}
+
+	var merged *config.ConfigFile
+	var names []string
+	for _, configPath := range configPaths {
+		name, conf, err := loadConfigPath(configPath)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if merged == nil {
+			merged = conf
+			continue
+		}
+		if err := merged.Merge(*conf); err != nil {
+			return nil, fmt.Errorf("merging config %s: %w", name, err)
+		}
+	}
+	return []namedConfigFile{{path: strings.Join(names, "+"), conf: merged}}, nil
+}
+
+// loadConfigPath loads the config from a directory uplosi.conf and uplosi.conf.d reside in,
+// from a single config file or from stdin. It returns the name of the loaded config for logging.
+func loadConfigPath(configPath string) (string, *config.ConfigFile, error) {
+	if configPath == stdinConfig {
+		var conf config.ConfigFile
+		if _, err := toml.NewDecoder(os.Stdin).Decode(&conf); err != nil {
+			return "", nil, fmt.Errorf("reading config from stdin: decoding: %w", err)
+		}
+		return "stdin", &conf, nil
+	}
+	if configPath != "" {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			return "", nil, fmt.Errorf("reading config: %w", err)
+		}
+		if !info.IsDir() {
+			var conf config.ConfigFile
+			if err := readTOMLFile(configPath, &conf); err != nil {
+				return "", nil, fmt.Errorf("reading config %s: %w", configPath, err)
+			}
+			return configPath, &conf, nil
+		}
+	}
+	conf, err := parseConfigFiles(configPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return path.Join(configPath, configName), conf, nil
 }
 
 func parseConfigFiles(configPath string) (*config.ConfigFile, error) {
@@ -281,7 +916,7 @@ func parseConfigFiles(configPath string) (*config.ConfigFile, error) {
 		if filepath.Ext(dirEntry.Name()) != ".conf" {
 			continue
 		}
-		if err := readTOMLFile(filepath.Join(configDir, dirEntry.Name()), &cfgOverlay); err != nil {
+		if err := readTOMLFile(filepath.Join(configDirLocation, dirEntry.Name()), &cfgOverlay); err != nil {
 			return nil, fmt.Errorf("reading config: %w", err)
 		}
 		if err := conf.Merge(cfgOverlay); err != nil {
@@ -291,19 +926,18 @@ func parseConfigFiles(configPath string) (*config.ConfigFile, error) {
 	return &conf, nil
 }
 
+// majorMinorRegexp matches a two-part version <MAJOR>.<MINOR>.
+var majorMinorRegexp = regexp.MustCompile(`^\d+\.\d+$`)
+
+// incrementSemver returns the next patch release of version, see config.Version.NextPatch.
+// A two-part version <MAJOR>.<MINOR> is treated as <MAJOR>.<MINOR>.0, so 1.15 becomes 1.15.1.
 func incrementSemver(version string) (string, error) {
-	canonical := strings.TrimPrefix(semver.Canonical("v"+version), "v")
-	parts := strings.Split(canonical, ".")
-	if len(parts) != 3 {
-		return "", fmt.Errorf("splitting canonical version: %s, %v", canonical, parts)
+	if majorMinorRegexp.MatchString(version) {
+		version += ".0"
 	}
-
-	patch := parts[2]
-	patchNum, err := strconv.Atoi(patch)
+	ver, err := config.ParseVersion(version)
 	if err != nil {
-		return "", fmt.Errorf("converting patch number: %w", err)
+		return "", err
 	}
-
-	patchNum++
-	return fmt.Sprintf("%s.%s.%d", parts[0], parts[1], patchNum), nil
+	return ver.NextPatch().String(), nil
 }
@@ -7,9 +7,23 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/edgelesssys/uplosi/aws"
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/edgelesssys/uplosi/gcp"
+	"github.com/edgelesssys/uplosi/openstack"
+	"github.com/edgelesssys/uplosi/uploader"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIncrementSemver(t *testing.T) {
@@ -24,6 +38,9 @@ func TestIncrementSemver(t *testing.T) {
 		{ver: "0.0.10", want: "0.0.11"},
 		{ver: "1.15", want: "1.15.1"},
 		{ver: "1.15.1", want: "1.15.2"},
+		{ver: "1.2.3-rc.1", want: "1.2.3"},
+		{ver: "1.2.3+build.5", want: "1.2.4"},
+		{ver: "v1.2.3", wantErr: true},
 	}
 
 	for _, tc := range testCases {
@@ -39,3 +56,921 @@ func TestIncrementSemver(t *testing.T) {
 		})
 	}
 }
+
+func TestVersionFiles(t *testing.T) {
+	testCases := map[string]struct {
+		increment   bool
+		versions    map[string]string
+		wantLookup  map[string]string
+		want        map[string]string
+		wantErr     bool
+		wantNoFiles bool
+	}{
+		"not incremented": {
+			versions:   map[string]string{"version.txt": "1.2.3\n"},
+			wantLookup: map[string]string{"version.txt": "1.2.3\n"},
+		},
+		"patch": {
+			increment:  true,
+			versions:   map[string]string{"version.txt": "1.2.3"},
+			wantLookup: map[string]string{"version.txt": "1.2.4"},
+			want:       map[string]string{"version.txt": "1.2.4"},
+		},
+		"trailing newline is kept": {
+			increment:  true,
+			versions:   map[string]string{"version.txt": "1.2.9\n"},
+			wantLookup: map[string]string{"version.txt": "1.2.10\n"},
+			want:       map[string]string{"version.txt": "1.2.10\n"},
+		},
+		"shorter version is truncated": {
+			increment:  true,
+			versions:   map[string]string{"version.txt": "1.2.3-rc.1\n"},
+			wantLookup: map[string]string{"version.txt": "1.2.3\n"},
+			want:       map[string]string{"version.txt": "1.2.3\n"},
+		},
+		"two-part version": {
+			increment:  true,
+			versions:   map[string]string{"version.txt": "1.15\n"},
+			wantLookup: map[string]string{"version.txt": "1.15.1\n"},
+			want:       map[string]string{"version.txt": "1.15.1\n"},
+		},
+		"multiple files": {
+			increment:  true,
+			versions:   map[string]string{"a.txt": "0.1.0", "b.txt": "2.0.0+build.5"},
+			wantLookup: map[string]string{"a.txt": "0.1.1", "b.txt": "2.0.1"},
+			want:       map[string]string{"a.txt": "0.1.1", "b.txt": "2.0.1"},
+		},
+		"invalid version": {
+			increment: true,
+			versions:  map[string]string{"version.txt": "v1.2"},
+			wantErr:   true,
+		},
+		"no version files": {
+			increment:   true,
+			wantNoFiles: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			for name, version := range tc.versions {
+				require.NoError(os.WriteFile(filepath.Join(dir, name), []byte(version), 0o644))
+			}
+
+			versionFiles := newVersionFiles(tc.increment)
+			for name := range tc.versions {
+				got, err := versionFiles.lookup(filepath.Join(dir, name))
+				if tc.wantErr {
+					assert.Error(err)
+					continue
+				}
+				require.NoError(err)
+				assert.Equal(tc.wantLookup[name], string(got))
+				// Variants sharing a version file get the same version.
+				got, err = versionFiles.lookup(filepath.Join(dir, name))
+				require.NoError(err)
+				assert.Equal(tc.wantLookup[name], string(got))
+			}
+
+			// The incremented versions are only written back by write.
+			for name, version := range tc.versions {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				require.NoError(err)
+				assert.Equal(version, string(got))
+			}
+			if !tc.increment || tc.wantErr {
+				return
+			}
+
+			err := versionFiles.write()
+			if tc.wantNoFiles {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			for name, want := range tc.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				require.NoError(err)
+				assert.Equal(want, string(got))
+			}
+		})
+	}
+}
+
+func TestIncrementVersionDataFiles(t *testing.T) {
+	testCases := map[string]struct {
+		config    string
+		dataFiles map[string][]byte
+		check     func(assert *assert.Assertions, cfg config.Config)
+	}{
+		"aws uefi var store": {
+			config: `
+[base]
+provider = "aws"
+name = "img"
+imageVersionFile = "version.txt"
+
+[base.aws]
+region = "eu-central-1"
+bucket = "bucket"
+uefiVarStoreFile = "uefi.b64"
+`,
+			dataFiles: map[string][]byte{"uefi.b64": []byte("dGVzdA==\n")},
+			check: func(assert *assert.Assertions, cfg config.Config) {
+				assert.Equal("dGVzdA==", cfg.AWS.UEFIData)
+			},
+		},
+		"azure vmgs": {
+			config: `
+[base]
+provider = "azure"
+name = "img"
+imageVersionFile = "version.txt"
+
+[base.azure]
+subscriptionID = "00000000-0000-0000-0000-000000000000"
+location = "northeurope"
+resourceGroup = "rg"
+sharedImageGallery = "gallery"
+vmgsFile = "image.vmgs"
+`,
+			dataFiles: map[string][]byte{"image.vmgs": {0x01, 0x02, '\n'}},
+			check: func(assert *assert.Assertions, cfg config.Config) {
+				assert.Equal([]byte{0x01, 0x02, '\n'}, cfg.Azure.VMGS)
+			},
+		},
+		"gcp secure boot keys": {
+			config: `
+[base]
+provider = "gcp"
+name = "img"
+imageVersionFile = "version.txt"
+
+[base.gcp]
+project = "project"
+location = "europe-west3"
+bucket = "bucket"
+
+[base.gcp.secureBoot]
+enabled = true
+pkFile = "pk.der"
+kekFiles = ["kek.der"]
+dbFiles = ["db.der"]
+dbxFiles = ["dbx.der"]
+`,
+			dataFiles: map[string][]byte{
+				"pk.der":  []byte("pk\n"),
+				"kek.der": []byte("kek\n"),
+				"db.der":  []byte("db\n"),
+				"dbx.der": []byte("dbx\n"),
+			},
+			check: func(assert *assert.Assertions, cfg config.Config) {
+				assert.Equal([]byte("pk\n"), cfg.GCP.SecureBoot.PK)
+				assert.Equal([][]byte{[]byte("kek\n")}, cfg.GCP.SecureBoot.KEKs)
+				assert.Equal([][]byte{[]byte("db\n")}, cfg.GCP.SecureBoot.DBs)
+				assert.Equal([][]byte{[]byte("dbx\n")}, cfg.GCP.SecureBoot.DBXs)
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			require.NoError(os.WriteFile(filepath.Join(dir, configName), []byte(tc.config), 0o644))
+			require.NoError(os.WriteFile(filepath.Join(dir, "version.txt"), []byte("1.2.3\n"), 0o644))
+			for name, data := range tc.dataFiles {
+				require.NoError(os.WriteFile(filepath.Join(dir, name), data, 0o644))
+			}
+			// The files referenced by the config are relative to the working directory.
+			wd, err := os.Getwd()
+			require.NoError(err)
+			require.NoError(os.Chdir(dir))
+			t.Cleanup(func() { require.NoError(os.Chdir(wd)) })
+
+			configFiles, err := loadConfigFiles([]string{configName}, "")
+			require.NoError(err)
+			require.Len(configFiles, 1)
+
+			// --increment-version uploads with the incremented version and writes it back afterwards.
+			versionFiles := newVersionFiles(true)
+			var uploaded []config.Config
+			require.NoError(configFiles[0].conf.ForEach(func(_ string, cfg config.Config) error {
+				uploaded = append(uploaded, cfg)
+				return nil
+			}, versionFiles.lookup))
+			require.Len(uploaded, 1)
+			assert.Equal("1.2.4", uploaded[0].ImageVersion)
+			tc.check(assert, uploaded[0])
+			require.NoError(versionFiles.write())
+
+			version, err := os.ReadFile(filepath.Join(dir, "version.txt"))
+			require.NoError(err)
+			assert.Equal("1.2.4\n", string(version))
+			for name, want := range tc.dataFiles {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				require.NoError(err)
+				assert.Equal(want, data, name)
+			}
+		})
+	}
+}
+
+func TestCheckImageSize(t *testing.T) {
+	testCases := map[string]struct {
+		provider    string
+		size        int64
+		overrideGiB int
+		wantErr     bool
+	}{
+		"aws under limit":         {provider: "aws", size: 8 << 30},
+		"aws over limit":          {provider: "aws", size: 17 << 40, wantErr: true},
+		"azure at limit":          {provider: "azure", size: 4 << 40},
+		"azure over limit":        {provider: "azure", size: 4<<40 + 1, wantErr: true},
+		"gcp over limit":          {provider: "gcp", size: 3 << 40, wantErr: true},
+		"gcp over raised limit":   {provider: "gcp", size: 3 << 40, overrideGiB: 4096},
+		"aws over lowered limit":  {provider: "aws", size: 11 << 30, overrideGiB: 10, wantErr: true},
+		"openstack unlimited":     {provider: "openstack", size: 100 << 40},
+		"openstack over override": {provider: "openstack", size: 2 << 30, overrideGiB: 1, wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := checkImageSize(tc.provider, tc.size, tc.overrideGiB)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckDataImage(t *testing.T) {
+	dir := t.TempDir()
+	dataImage := filepath.Join(dir, "data.raw")
+	require.NoError(t, os.WriteFile(dataImage, []byte("data"), 0o644))
+
+	testCases := map[string]struct {
+		provider  string
+		dataImage string
+		wantErr   bool
+	}{
+		"no data image":       {provider: "aws"},
+		"existing data image": {provider: "aws", dataImage: dataImage},
+		"missing data image":  {provider: "aws", dataImage: filepath.Join(dir, "missing.raw"), wantErr: true},
+		"directory":           {provider: "aws", dataImage: dir, wantErr: true},
+		"other provider":      {provider: "gcp", dataImage: filepath.Join(dir, "missing.raw")},
+		"provider case":       {provider: "AWS", dataImage: filepath.Join(dir, "missing.raw"), wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := checkDataImage(config.Config{Provider: tc.provider, AWS: config.AWSConfig{DataImage: tc.dataImage}})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPrintUploadResults(t *testing.T) {
+	results := []uploader.UploadResult{
+		{Provider: "aws", Region: "eu-central-1", Reference: "arn:aws:ec2:eu-central-1::image/ami-1", ResourceType: "ami"},
+		{Provider: "gcp", Reference: "projects/p/global/images/i", ResourceType: "image"},
+	}
+
+	testCases := map[string]struct {
+		format string
+		want   string
+	}{
+		"table": {
+			format: "table",
+			want: "PROVIDER  REGION        TYPE   REFERENCE\n" +
+				"aws       eu-central-1  ami    arn:aws:ec2:eu-central-1::image/ami-1\n" +
+				"gcp       -             image  projects/p/global/images/i\n",
+		},
+		"json": {
+			format: "json",
+			want: `[
+  {
+    "provider": "aws",
+    "region": "eu-central-1",
+    "reference": "arn:aws:ec2:eu-central-1::image/ami-1",
+    "resourceType": "ami"
+  },
+  {
+    "provider": "gcp",
+    "reference": "projects/p/global/images/i",
+    "resourceType": "image"
+  }
+]
+`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var out bytes.Buffer
+			assert.NoError(printUploadResults(&out, tc.format, results))
+			assert.Equal(tc.want, out.String())
+		})
+	}
+}
+
+func TestNewUploaderDefaultPreppers(t *testing.T) {
+	testCases := map[string]uploader.Prepper{
+		"aws":       &aws.Prepper{},
+		"gcp":       &gcp.Prepper{},
+		"openstack": &openstack.Prepper{},
+	}
+
+	for provider, wantPrepper := range testCases {
+		t.Run(provider, func(t *testing.T) {
+			assert := assert.New(t)
+
+			prepper, _, err := newUploader(config.Config{Provider: provider}, log.New(io.Discard, "", 0), nil)
+			assert.NoError(err)
+			assert.IsType(wantPrepper, prepper)
+		})
+	}
+}
+
+func TestLogUploadSummary(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+	logUploadSummary(logger, map[string]error{
+		"b": errors.New("quota exceeded"),
+		"a": nil,
+		"c": nil,
+	})
+	assert.Equal("Uploaded 2 of 3 variants, 1 failed\n"+
+		"  a: ok\n"+
+		"  b: failed: quota exceeded\n"+
+		"  c: ok\n", out.String())
+}
+
+func TestNewVariantLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+	newVariantLogger(logger, "a").Println("Uploading image")
+	newVariantLogger(logger, "").Println("Uploading image")
+	assert.Equal("[a] Uploading image\nUploading image\n", out.String())
+
+	// Concurrent uploads of multiple config files prefix the variant with the config file.
+	out.Reset()
+	newVariantLogger(newPrefixLogger(logger, "a.toml"), "a").Println("Uploading image")
+	assert.Equal("[a.toml] [a] Uploading image\n", out.String())
+}
+
+func TestLoadConfigFilesDir(t *testing.T) {
+	const conf = "[base]\nname = %q\n"
+
+	testCases := map[string]struct {
+		files     map[string]string
+		wantPaths []string
+		wantNames []string
+		wantErr   string
+	}{
+		"alphabetical order": {
+			files: map[string]string{
+				"b.toml": fmt.Sprintf(conf, "b"),
+				"a.toml": fmt.Sprintf(conf, "a"),
+				"c.conf": fmt.Sprintf(conf, "c"),
+			},
+			wantPaths: []string{"a.toml", "b.toml", "c.conf"},
+			wantNames: []string{"a", "b", "c"},
+		},
+		"other files and directories are skipped": {
+			files: map[string]string{
+				"a.toml":               fmt.Sprintf(conf, "a"),
+				"README.md":            "# configs",
+				"version.txt":          "1.0.0",
+				"sub.toml/b.toml":      fmt.Sprintf(conf, "b"),
+				"uplosi.conf.d/c.conf": fmt.Sprintf(conf, "c"),
+			},
+			wantPaths: []string{"a.toml"},
+			wantNames: []string{"a"},
+		},
+		"uplosi.conf": {
+			files:     map[string]string{configName: fmt.Sprintf(conf, "uplosi")},
+			wantPaths: []string{configName},
+			wantNames: []string{"uplosi"},
+		},
+		"yaml is rejected": {
+			files: map[string]string{
+				"a.toml": fmt.Sprintf(conf, "a"),
+				"b.yaml": "base:\n  name: b\n",
+			},
+			wantErr: "YAML configs aren't supported",
+		},
+		"invalid toml": {
+			files:   map[string]string{"a.toml": "[base"},
+			wantErr: "a.toml",
+		},
+		"empty directory": {
+			wantErr: "no *.toml or *.conf config files found",
+		},
+		"no config files": {
+			files:   map[string]string{"README.md": "# configs"},
+			wantErr: "no *.toml or *.conf config files found",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				require.NoError(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+				require.NoError(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+			}
+
+			configFiles, err := loadConfigFiles(nil, dir)
+			if tc.wantErr != "" {
+				assert.ErrorContains(err, tc.wantErr)
+				return
+			}
+			require.NoError(err)
+			var paths, names []string
+			for _, configFile := range configFiles {
+				paths = append(paths, filepath.Base(configFile.path))
+				names = append(names, configFile.conf.Base.Name)
+			}
+			assert.Equal(tc.wantPaths, paths)
+			assert.Equal(tc.wantNames, names)
+		})
+	}
+}
+
+func TestVariantSelected(t *testing.T) {
+	testCases := map[string]struct {
+		variants        []string
+		excludeVariants []string
+		wantSelected    []string
+	}{
+		"no flags": {
+			wantSelected: []string{"aws-sev", "azure-tdx", "gcp-sev"},
+		},
+		"variant": {
+			variants:     []string{"azure-tdx"},
+			wantSelected: []string{"azure-tdx"},
+		},
+		"multiple variants": {
+			variants:     []string{"azure-tdx", "gcp-sev"},
+			wantSelected: []string{"azure-tdx", "gcp-sev"},
+		},
+		"exclude variant": {
+			excludeVariants: []string{"azure-tdx"},
+			wantSelected:    []string{"aws-sev", "gcp-sev"},
+		},
+		"exclude wins": {
+			variants:        []string{"azure-tdx", "gcp-sev"},
+			excludeVariants: []string{"azure-tdx"},
+			wantSelected:    []string{"gcp-sev"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			flags := &uploadFlags{variants: tc.variants, excludeVariants: tc.excludeVariants}
+			var selected []string
+			for _, variant := range []string{"aws-sev", "azure-tdx", "gcp-sev"} {
+				if flags.variantSelected(variant) {
+					selected = append(selected, variant)
+				}
+			}
+			assert.Equal(tc.wantSelected, selected)
+		})
+	}
+}
+
+func TestCheckVariantNames(t *testing.T) {
+	configFiles := []namedConfigFile{
+		{path: "a.toml", conf: &config.ConfigFile{Variants: map[string]config.Config{"aws-sev": {}}}},
+		{path: "b.toml", conf: &config.ConfigFile{Variants: map[string]config.Config{"azure-tdx": {}}}},
+	}
+
+	testCases := map[string]struct {
+		variants        []string
+		excludeVariants []string
+		wantErr         bool
+	}{
+		"no flags": {},
+		"existing variants": {
+			variants:        []string{"aws-sev"},
+			excludeVariants: []string{"azure-tdx"},
+		},
+		"unknown variant": {
+			variants: []string{"gcp-sev"},
+			wantErr:  true,
+		},
+		"unknown excluded variant": {
+			excludeVariants: []string{"gcp-sev"},
+			wantErr:         true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			err := checkVariantNames(configFiles, tc.variants, tc.excludeVariants)
+			if tc.wantErr {
+				assert.ErrorContains(err, "gcp-sev")
+				return
+			}
+			assert.NoError(err)
+		})
+	}
+}
+
+func TestLoadConfigFilesMerge(t *testing.T) {
+	const base = `
+[base]
+imageVersion = "1.0.0"
+provider = "aws"
+name = "base"
+
+[base.aws]
+region = "eu-central-1"
+bucket = "base-bucket"
+`
+	const overlay = `
+[base]
+imageVersion = "2.0.0"
+`
+
+	const variantBase = base + `
+[variant.a.aws]
+region = "eu-west-1"
+replicationRegions = ["eu-west-2"]
+`
+	const variantOverlay = `
+[variant.a.aws]
+region = "us-east-1"
+
+[variant.b.aws]
+region = "us-east-2"
+`
+
+	testCases := map[string]struct {
+		files       map[string]string
+		configPaths []string
+		wantNames   []string
+		wantVersion string
+		wantAWS     map[string]config.AWSConfig
+		wantErr     bool
+	}{
+		"single file": {
+			files:       map[string]string{"base.conf": base},
+			configPaths: []string{"base.conf"},
+			wantNames:   []string{"base.conf"},
+			wantVersion: "1.0.0",
+		},
+		"overlay overrides base": {
+			files:       map[string]string{"base.conf": base, "overlay.conf": overlay},
+			configPaths: []string{"base.conf", "overlay.conf"},
+			wantNames:   []string{"base.conf", "overlay.conf"},
+			wantVersion: "2.0.0",
+		},
+		"base overrides overlay": {
+			files:       map[string]string{"base.conf": base, "overlay.conf": overlay},
+			configPaths: []string{"overlay.conf", "base.conf"},
+			wantNames:   []string{"overlay.conf", "base.conf"},
+			wantVersion: "1.0.0",
+		},
+		"directory and overlay": {
+			files: map[string]string{
+				filepath.Join("team", configName):                base,
+				filepath.Join("team", configDir, "version.conf"): `[base]` + "\n" + `imageVersion = "1.5.0"`,
+				"overlay.conf": overlay,
+			},
+			configPaths: []string{"team", "overlay.conf"},
+			wantNames:   []string{filepath.Join("team", configName), "overlay.conf"},
+			wantVersion: "2.0.0",
+		},
+		"directory with overlay dir": {
+			files: map[string]string{
+				filepath.Join("team", configName):                base,
+				filepath.Join("team", configDir, "version.conf"): `[base]` + "\n" + `imageVersion = "1.5.0"`,
+			},
+			configPaths: []string{"team"},
+			wantNames:   []string{filepath.Join("team", configName)},
+			wantVersion: "1.5.0",
+		},
+		"overlay overrides variant": {
+			files:       map[string]string{"base.conf": variantBase, "overlay.conf": variantOverlay},
+			configPaths: []string{"base.conf", "overlay.conf"},
+			wantNames:   []string{"base.conf", "overlay.conf"},
+			wantVersion: "1.0.0",
+			wantAWS: map[string]config.AWSConfig{
+				"a": {Region: "us-east-1", ReplicationRegions: []string{"eu-west-2"}},
+				"b": {Region: "us-east-2"},
+			},
+		},
+		"missing file": {
+			files:       map[string]string{"base.conf": base},
+			configPaths: []string{"base.conf", "missing.conf"},
+			wantErr:     true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				require.NoError(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+				require.NoError(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+			}
+			var configPaths []string
+			for _, configPath := range tc.configPaths {
+				configPaths = append(configPaths, filepath.Join(dir, configPath))
+			}
+
+			configFiles, err := loadConfigFiles(configPaths, "")
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			require.Len(configFiles, 1)
+			var wantNames []string
+			for _, name := range tc.wantNames {
+				wantNames = append(wantNames, filepath.Join(dir, name))
+			}
+			assert.Equal(strings.Join(wantNames, "+"), configFiles[0].path)
+			assert.Equal(tc.wantVersion, configFiles[0].conf.Base.ImageVersion)
+			assert.Equal("base-bucket", configFiles[0].conf.Base.AWS.Bucket)
+			for variant, wantAWS := range tc.wantAWS {
+				assert.Equal(wantAWS, configFiles[0].conf.Variants[variant].AWS, variant)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFilesStdin(t *testing.T) {
+	const stdinConf = `
+[base]
+imageVersionFile = "version.txt"
+provider = "aws"
+name = "stdin"
+
+[base.aws]
+region = "eu-central-1"
+bucket = "stdin-bucket"
+`
+
+	testCases := map[string]struct {
+		configPaths []string
+		wantName    string
+		wantBucket  string
+		wantErr     bool
+	}{
+		"stdin only": {
+			configPaths: []string{stdinConfig},
+			wantName:    "stdin",
+			wantBucket:  "stdin-bucket",
+		},
+		"stdin overlay": {
+			configPaths: []string{"base.conf", stdinConfig},
+			wantName:    "base.conf+stdin",
+			wantBucket:  "stdin-bucket",
+		},
+		"stdin base": {
+			configPaths: []string{stdinConfig, "base.conf"},
+			wantName:    "stdin+base.conf",
+			wantBucket:  "base-bucket",
+		},
+		"stdin twice": {
+			configPaths: []string{stdinConfig, stdinConfig},
+			wantErr:     true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			require.NoError(os.WriteFile(filepath.Join(dir, "base.conf"), []byte("[base.aws]\nbucket = \"base-bucket\"\n"), 0o644))
+			require.NoError(os.WriteFile(filepath.Join(dir, "version.txt"), []byte("1.2.3\n"), 0o644))
+			stdinPath := filepath.Join(t.TempDir(), "stdin")
+			require.NoError(os.WriteFile(stdinPath, []byte(stdinConf), 0o644))
+			stdinFile, err := os.Open(stdinPath)
+			require.NoError(err)
+			defer stdinFile.Close()
+			stdin := os.Stdin
+			os.Stdin = stdinFile
+			t.Cleanup(func() { os.Stdin = stdin })
+
+			// The imageVersionFile of a config read from stdin is relative to the working directory.
+			wd, err := os.Getwd()
+			require.NoError(err)
+			require.NoError(os.Chdir(dir))
+			t.Cleanup(func() { require.NoError(os.Chdir(wd)) })
+
+			configFiles, err := loadConfigFiles(tc.configPaths, "")
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			require.Len(configFiles, 1)
+			assert.Equal(tc.wantName, configFiles[0].path)
+			cfg, err := configFiles[0].conf.RenderedVariant(os.ReadFile, "")
+			require.NoError(err)
+			assert.Equal("1.2.3", cfg.ImageVersion)
+			assert.Equal(tc.wantBucket, cfg.AWS.Bucket)
+		})
+	}
+}
+
+func TestUploadWithoutImage(t *testing.T) {
+	const sourceConf = `
+[base]
+imageVersion = "1.0.0"
+provider = "gcp"
+name = "img"
+
+[base.gcp]
+project = "project"
+location = "europe-west3"
+
+[variant.from-image.gcp]
+sourceImage = "projects/project/global/images/base"
+
+[variant.from-disk.gcp]
+sourceDisk = "projects/project/zones/europe-west3-a/disks/disk"
+`
+	const uploadConf = `
+[base]
+imageVersion = "1.0.0"
+provider = "gcp"
+name = "img"
+
+[base.gcp]
+project = "project"
+location = "europe-west3"
+bucket = "bucket"
+
+[variant.from-image.gcp]
+sourceImage = "projects/project/global/images/base"
+
+[variant.upload]
+`
+
+	testCases := map[string]struct {
+		conf     string
+		variants []string
+		wantErr  string
+	}{
+		"source image and disk": {
+			conf: sourceConf,
+		},
+		"upload variant not selected": {
+			conf:     uploadConf,
+			variants: []string{"from-image"},
+		},
+		"upload variant": {
+			conf:    uploadConf,
+			wantErr: `variant "upload" needs an image argument`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			require.NoError(os.WriteFile(filepath.Join(dir, configName), []byte(tc.conf), 0o644))
+
+			args := []string{"--dry-run", "--quiet", "-c", dir}
+			for _, variant := range tc.variants {
+				args = append(args, "--variant", variant)
+			}
+			cmd := newUploadCmd()
+			cmd.SetArgs(args)
+			var stdout bytes.Buffer
+			cmd.SetOut(&stdout)
+			cmd.SetErr(io.Discard)
+
+			err := cmd.Execute()
+			if tc.wantErr != "" {
+				assert.ErrorContains(err, tc.wantErr)
+				return
+			}
+			require.NoError(err)
+			assert.Contains(stdout.String(), `"variant": "from-image"`)
+		})
+	}
+}
+
+func TestUploadQuiet(t *testing.T) {
+	const validConf = `
+[base]
+imageVersion = "1.0.0"
+provider = "aws"
+name = "img"
+
+[base.aws]
+region = "eu-central-1"
+bucket = "bucket"
+
+[variant.a]
+`
+	const invalidConf = `
+[base]
+imageVersion = "1.0"
+provider = "aws"
+name = "img"
+
+[base.aws]
+region = "eu-central-1"
+bucket = "bucket"
+`
+
+	testCases := map[string]struct {
+		conf       string
+		quiet      bool
+		wantLogs   bool
+		wantStdout string
+		wantErr    string
+	}{
+		"logs": {
+			conf:       validConf,
+			wantLogs:   true,
+			wantStdout: `"variant": "a"`,
+		},
+		"quiet discards logs but prints results": {
+			conf:       validConf,
+			quiet:      true,
+			wantStdout: `"variant": "a"`,
+		},
+		"quiet prints errors": {
+			conf:    invalidConf,
+			quiet:   true,
+			wantErr: "imageVersion",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			dir := t.TempDir()
+			require.NoError(os.WriteFile(filepath.Join(dir, configName), []byte(tc.conf), 0o644))
+			imagePath := filepath.Join(dir, "image.raw")
+			require.NoError(os.WriteFile(imagePath, []byte("image"), 0o644))
+
+			args := []string{"--dry-run", "-c", dir, imagePath}
+			if tc.quiet {
+				args = append(args, "--quiet")
+			}
+			cmd := newUploadCmd()
+			cmd.SetArgs(args)
+			var stdout, stderr bytes.Buffer
+			cmd.SetOut(&stdout)
+			cmd.SetErr(&stderr)
+
+			err := cmd.Execute()
+			if tc.wantErr != "" {
+				assert.ErrorContains(err, tc.wantErr)
+				assert.Contains(stderr.String(), tc.wantErr)
+				assert.NotContains(stderr.String(), "Planning variant")
+				return
+			}
+			require.NoError(err)
+			assert.Contains(stdout.String(), tc.wantStdout)
+			if tc.wantLogs {
+				assert.Contains(stderr.String(), "Planning variant a")
+			} else {
+				assert.Empty(stderr.String())
+			}
+		})
+	}
+}
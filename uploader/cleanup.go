@@ -0,0 +1,22 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"context"
+	"time"
+)
+
+// CleanupTimeout limits the cleanup of temporary resources after an upload.
+const CleanupTimeout = 10 * time.Minute
+
+// CleanupContext returns the context for the cleanup of temporary resources deferred during an upload.
+// It isn't canceled with ctx, so temporary resources are still deleted if the upload is interrupted,
+// e.g. with Ctrl-C, but it times out after CleanupTimeout.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), CleanupTimeout)
+}
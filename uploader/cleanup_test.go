@@ -0,0 +1,37 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ctxKey struct{}
+
+func TestCleanupContext(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	cancel()
+
+	cleanupCtx, cleanupCancel := CleanupContext(ctx)
+	defer cleanupCancel()
+	assert.NoError(cleanupCtx.Err())
+	assert.Equal("value", cleanupCtx.Value(ctxKey{}))
+	deadline, ok := cleanupCtx.Deadline()
+	require.True(ok)
+	assert.WithinDuration(time.Now().Add(CleanupTimeout), deadline, time.Minute)
+
+	cleanupCancel()
+	assert.ErrorIs(cleanupCtx.Err(), context.Canceled)
+}
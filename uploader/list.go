@@ -0,0 +1,23 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import "time"
+
+// ListedImage describes an existing image of the configured image series.
+type ListedImage struct {
+	// Provider is the cloud provider the image exists in.
+	Provider string `json:"provider"`
+	// Region is the region or location of the image. It's empty for global images.
+	Region string `json:"region,omitempty"`
+	// Name is the name or version of the image.
+	Name string `json:"name"`
+	// Reference is the identifier of the image, like the reference of an UploadResult.
+	Reference string `json:"reference"`
+	// CreatedAt is the time the image was created or published.
+	CreatedAt time.Time `json:"createdAt"`
+}
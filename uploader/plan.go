@@ -0,0 +1,66 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import "fmt"
+
+// Actions of planned operations.
+const (
+	// ActionDeleteIfExists deletes a resource with the given name if it exists.
+	ActionDeleteIfExists = "delete-if-exists"
+	// ActionCreateIfMissing creates a shared resource if it doesn't exist yet.
+	ActionCreateIfMissing = "create-if-missing"
+	// ActionCreate creates a resource.
+	ActionCreate = "create"
+	// ActionUpload uploads image data.
+	ActionUpload = "upload"
+	// ActionCopy copies a resource to another location.
+	ActionCopy = "copy"
+	// ActionUpdate modifies an existing resource.
+	ActionUpdate = "update"
+	// ActionDelete deletes a resource created during the upload.
+	ActionDelete = "delete"
+)
+
+// Operation is a change to cloud state an upload performs.
+type Operation struct {
+	// Action is what happens to the resource, one of the Action constants.
+	Action string `json:"action"`
+	// Kind is the provider specific type of the resource, e.g. "ami" or "bucket".
+	Kind string `json:"kind"`
+	// Name is the name, path or ID of the resource.
+	Name string `json:"name"`
+	// Location is the region, location or project the resource lives in, if any.
+	Location string `json:"location,omitempty"`
+	// Detail describes the operation further, e.g. the settings that are changed.
+	Detail string `json:"detail,omitempty"`
+}
+
+// String returns a human readable description of the operation.
+func (o Operation) String() string {
+	s := fmt.Sprintf("%s %s %s", o.Action, o.Kind, o.Name)
+	if o.Location != "" {
+		s += " in " + o.Location
+	}
+	if o.Detail != "" {
+		s += " (" + o.Detail + ")"
+	}
+	return s
+}
+
+// Plan is an ordered list of operations.
+type Plan []Operation
+
+// Add appends an operation to the plan.
+func (p *Plan) Add(action, kind, name, location string) {
+	*p = append(*p, Operation{Action: action, Kind: kind, Name: name, Location: location})
+}
+
+// AddDetail appends an operation with a detail to the plan.
+func (p *Plan) AddDetail(action, kind, name, location, detail string) {
+	*p = append(*p, Operation{Action: action, Kind: kind, Name: name, Location: location, Detail: detail})
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/edgelesssys/uplosi/config"
+)
+
+// Prepper prepares an image for the upload to a provider, e.g. by converting it
+// to the format the provider imports.
+type Prepper interface {
+	// Prepare returns the path of the prepared image. Files it creates must be placed in tmpDir,
+	// which is removed after the upload.
+	Prepare(ctx context.Context, imagePath, tmpDir string) (string, error)
+}
+
+// PrepperFactory creates the prepper for the upload of a variant with the given config.
+type PrepperFactory func(config.Config) (Prepper, error)
+
+var (
+	preppersMu sync.RWMutex
+	preppers   = map[string]PrepperFactory{}
+)
+
+// RegisterPrepper registers the prepper factory of a provider, replacing the previously registered one.
+// The provider packages register their preppers on import, so a custom prepper registered
+// afterwards, e.g. from an init function of package main, takes precedence.
+func RegisterPrepper(provider string, factory PrepperFactory) {
+	preppersMu.Lock()
+	defer preppersMu.Unlock()
+	preppers[strings.ToLower(provider)] = factory
+}
+
+// NewPrepper creates the prepper registered for the provider of the config.
+func NewPrepper(cfg config.Config) (Prepper, error) {
+	preppersMu.RLock()
+	factory, ok := preppers[strings.ToLower(cfg.Provider)]
+	preppersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no prepper registered for provider %s", cfg.Provider)
+	}
+	prepper, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s prepper: %w", cfg.Provider, err)
+	}
+	return prepper, nil
+}
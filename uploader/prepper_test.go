@@ -0,0 +1,82 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubPrepper struct {
+	suffix string
+}
+
+func (p *stubPrepper) Prepare(_ context.Context, imagePath, _ string) (string, error) {
+	return imagePath + p.suffix, nil
+}
+
+func TestNewPrepper(t *testing.T) {
+	RegisterPrepper("Test-Stub", func(cfg config.Config) (Prepper, error) {
+		return &stubPrepper{suffix: "." + cfg.Name}, nil
+	})
+	RegisterPrepper("test-failing", func(config.Config) (Prepper, error) {
+		return nil, errors.New("failed")
+	})
+	RegisterPrepper("test-replaced", func(config.Config) (Prepper, error) {
+		return nil, errors.New("replaced prepper used")
+	})
+	RegisterPrepper("test-replaced", func(config.Config) (Prepper, error) {
+		return &stubPrepper{suffix: ".custom"}, nil
+	})
+
+	testCases := map[string]struct {
+		cfg      config.Config
+		wantPath string
+		wantErr  bool
+	}{
+		"registered": {
+			cfg:      config.Config{Provider: "test-stub", Name: "image"},
+			wantPath: "disk.raw.image",
+		},
+		"provider is case insensitive": {
+			cfg:      config.Config{Provider: "TEST-STUB", Name: "image"},
+			wantPath: "disk.raw.image",
+		},
+		"replaced": {
+			cfg:      config.Config{Provider: "test-replaced"},
+			wantPath: "disk.raw.custom",
+		},
+		"factory fails": {
+			cfg:     config.Config{Provider: "test-failing"},
+			wantErr: true,
+		},
+		"not registered": {
+			cfg:     config.Config{Provider: "test-unknown"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			prepper, err := NewPrepper(tc.cfg)
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			path, err := prepper.Prepare(context.Background(), "disk.raw", t.TempDir())
+			assert.NoError(err)
+			assert.Equal(tc.wantPath, path)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import "io"
+
+// Stages of an upload reported to a ProgressReporter.
+const (
+	StageUploadBlob     = "upload blob"
+	StageImportSnapshot = "import snapshot"
+	StageCreateImage    = "create image"
+	StageReplicateImage = "replicate image"
+)
+
+// ProgressReporter receives the progress of an upload.
+// Uploaders never call it concurrently.
+type ProgressReporter interface {
+	// Stage is called when the upload enters a new stage, e.g. StageUploadBlob.
+	Stage(name string)
+	// Bytes is called with the number of bytes of the current stage that are done.
+	// total is -1 if the size is unknown, e.g. for streamed images.
+	Bytes(done, total int64)
+}
+
+// NopProgress is a ProgressReporter that discards all progress.
+type NopProgress struct{}
+
+// Stage does nothing.
+func (NopProgress) Stage(string) {}
+
+// Bytes does nothing.
+func (NopProgress) Bytes(int64, int64) {}
+
+// NewProgressReader returns a reader that reports the number of bytes read from r to reporter.
+func NewProgressReader(r io.Reader, total int64, reporter ProgressReporter) io.Reader {
+	return &progressReader{r: r, total: total, reporter: reporter}
+}
+
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	reporter ProgressReporter
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.reporter.Bytes(p.done, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,32 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SourceDateEpochEnv is the environment variable that fixes the timestamps of generated artifacts,
+// see https://reproducible-builds.org/specs/source-date-epoch/.
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// SourceDateEpoch returns the time set in SOURCE_DATE_EPOCH.
+// If the variable is unset or empty, ok is false.
+func SourceDateEpoch() (epoch time.Time, ok bool, err error) {
+	value := os.Getenv(SourceDateEpochEnv)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false, fmt.Errorf("%s must be a non-negative number of seconds, got %q", SourceDateEpochEnv, value)
+	}
+	return time.Unix(seconds, 0).UTC(), true, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceDateEpoch(t *testing.T) {
+	testCases := map[string]struct {
+		value   string
+		want    time.Time
+		wantOK  bool
+		wantErr bool
+	}{
+		"unset": {},
+		"epoch": {
+			value:  "1700000000",
+			want:   time.Unix(1700000000, 0).UTC(),
+			wantOK: true,
+		},
+		"zero": {
+			value:  "0",
+			want:   time.Unix(0, 0).UTC(),
+			wantOK: true,
+		},
+		"negative": {
+			value:   "-1",
+			wantErr: true,
+		},
+		"not a number": {
+			value:   "yesterday",
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			t.Setenv(SourceDateEpochEnv, tc.value)
+
+			epoch, ok, err := SourceDateEpoch()
+			if tc.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tc.wantOK, ok)
+			assert.Equal(tc.want, epoch)
+		})
+	}
+}
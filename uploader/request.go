@@ -0,0 +1,67 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package uploader contains the types shared by the provider specific uploaders.
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Request is a request to upload a prepared OS image.
+type Request struct {
+	// Image is the prepared OS image.
+	// Providers that stream the image only read from it, others may seek.
+	Image io.ReadSeekCloser
+	// Size is the size of Image in bytes. It's -1 for streamed images.
+	Size int64
+}
+
+// OpenRequest opens the image at path and creates an upload request for it.
+// The caller must close the request after the upload.
+func OpenRequest(path string) (*Request, error) {
+	image, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening image: %w", err)
+	}
+	fi, err := image.Stat()
+	if err != nil {
+		image.Close()
+		return nil, fmt.Errorf("getting image stats: %w", err)
+	}
+	return &Request{Image: image, Size: fi.Size()}, nil
+}
+
+// NewStreamRequest creates an upload request that streams the image from r.
+// A streamed image has an unknown size and can't seek, so it can only be uploaded
+// by providers that read the image exactly once.
+// The caller must close the request after the upload.
+func NewStreamRequest(r io.ReadCloser) *Request {
+	return &Request{Image: &stream{ReadCloser: r}, Size: -1}
+}
+
+// Streamed reports whether the image is streamed, see NewStreamRequest.
+func (r *Request) Streamed() bool {
+	return r.Size < 0
+}
+
+// Close closes the image of the request.
+func (r *Request) Close() error {
+	return r.Image.Close()
+}
+
+// stream is an image that can only be read once.
+type stream struct {
+	io.ReadCloser
+}
+
+// Seek always fails, as streams can't seek.
+func (s *stream) Seek(int64, int) (int64, error) {
+	return 0, errors.New("seeking streamed image: not supported")
+}
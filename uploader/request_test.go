@@ -0,0 +1,58 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRequest(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "image.raw")
+	require.NoError(os.WriteFile(path, []byte("image"), 0o644))
+
+	req, err := OpenRequest(path)
+	require.NoError(err)
+	assert.Equal(int64(5), req.Size)
+
+	content, err := io.ReadAll(req.Image)
+	require.NoError(err)
+	assert.Equal("image", string(content))
+	_, err = req.Image.Seek(0, io.SeekStart)
+	assert.NoError(err)
+
+	assert.NoError(req.Close())
+
+	_, err = OpenRequest(filepath.Join(t.TempDir(), "missing.raw"))
+	assert.Error(err)
+}
+
+func TestNewStreamRequest(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	req := NewStreamRequest(io.NopCloser(strings.NewReader("image")))
+	assert.True(req.Streamed())
+	assert.Equal(int64(-1), req.Size)
+
+	content, err := io.ReadAll(req.Image)
+	require.NoError(err)
+	assert.Equal("image", string(content))
+	_, err = req.Image.Seek(0, io.SeekStart)
+	assert.Error(err)
+
+	assert.NoError(req.Close())
+}
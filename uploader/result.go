@@ -0,0 +1,46 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+// UploadResult describes a single image created by an upload.
+type UploadResult struct {
+	// Provider is the cloud provider the image was uploaded to.
+	Provider string `json:"provider"`
+	// Region is the region, location or cloud the image is available in.
+	// It's empty for global images.
+	Region string `json:"region,omitempty"`
+	// Reference is the identifier to use when creating instances from the image.
+	Reference string `json:"reference"`
+	// ResourceType is the provider specific type of the image, e.g. "ami" or "community image version".
+	ResourceType string `json:"resourceType"`
+	// ImageID is the provider specific ID of the image, if it differs from Reference, e.g. the AMI ID.
+	ImageID string `json:"imageID,omitempty"`
+	// SnapshotIDs are the IDs of the snapshots backing the image, if the provider exposes them.
+	SnapshotIDs []string `json:"snapshotIDs,omitempty"`
+	// Encrypted reports whether the disks of the image are encrypted.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Public reports whether the image is available to everyone.
+	Public bool `json:"public,omitempty"`
+	// SharedWith lists the accounts the image was explicitly shared with.
+	SharedWith []string `json:"sharedWith,omitempty"`
+	// HashAlgorithm is the algorithm of HashValue, e.g. "sha512".
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	// HashValue is the hex encoded hash of the image data, if the provider reports and uplosi verified it.
+	HashValue string `json:"hashValue,omitempty"`
+}
+
+// References flattens results to their references.
+//
+// Deprecated: References only exists to ease the migration from the plain references
+// previously returned by Upload and will be removed in the next release.
+func References(results []UploadResult) []string {
+	refs := make([]string, 0, len(results))
+	for _, result := range results {
+		refs = append(refs, result.Reference)
+	}
+	return refs
+}
@@ -0,0 +1,24 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package uploader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferences(t *testing.T) {
+	assert := assert.New(t)
+
+	results := []UploadResult{
+		{Provider: "aws", Region: "eu-central-1", Reference: "arn-1", ResourceType: "ami"},
+		{Provider: "aws", Region: "us-east-1", Reference: "arn-2", ResourceType: "ami"},
+	}
+	assert.Equal([]string{"arn-1", "arn-2"}, References(results))
+	assert.Equal([]string{}, References(nil))
+}
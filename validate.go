@@ -0,0 +1,192 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate [config...]",
+		Short: "Validate the config of every variant without uploading",
+		Long: fmt.Sprintf("Validate the config of every variant without uploading.\n"+
+			"config is the path to the directory %s and %s reside in or to a config file, - reads the config from stdin.\n"+
+			"The working directory is used by default.\n"+
+			"Multiple configs are merged in order, later ones take precedence.", configName, configDir),
+		Args: cobra.ArbitraryArgs,
+		RunE: runValidate,
+	}
+	cmd.Flags().StringSlice("variant", nil, "name of a variant to validate, can be repeated (default all)")
+	cmd.Flags().StringSlice("exclude-variant", nil, "name of a variant to skip, can be repeated")
+	cmd.Flags().String("config-dir", "", "path to a directory of *.toml and *.conf config files to validate")
+	cmd.Flags().StringP("output", "o", "table", "format of the printed results (table, json)")
+
+	return cmd
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	flags, err := parseValidateFlags(cmd, args)
+	if err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	configFiles, err := loadConfigFiles(flags.configPaths, flags.configDirPath)
+	if err != nil {
+		return fmt.Errorf("parsing config files: %w", err)
+	}
+	if err := checkVariantNames(configFiles, flags.variants, flags.excludeVariants); err != nil {
+		return err
+	}
+
+	results, err := validateConfigFiles(configFiles, flags.variants, flags.excludeVariants)
+	if err != nil {
+		return err
+	}
+	if err := printValidationResults(cmd.OutOrStdout(), flags.outputFormat, results); err != nil {
+		return fmt.Errorf("printing results: %w", err)
+	}
+
+	var invalid int
+	for _, result := range results {
+		if !result.Valid {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d variants invalid", invalid, len(results))
+	}
+	return nil
+}
+
+// validationResult is the validation result of a single variant.
+type validationResult struct {
+	ConfigFile string   `json:"configFile"`
+	Variant    string   `json:"variant"`
+	Valid      bool     `json:"valid"`
+	Errors     []string `json:"errors"`
+}
+
+// validateConfigFiles validates the selected variants of all config files.
+// The results are ordered by config file and variant name.
+func validateConfigFiles(configFiles []namedConfigFile, variants, excludeVariants []string) ([]validationResult, error) {
+	results := []validationResult{}
+	for _, configFile := range configFiles {
+		variantErrs, err := configFile.conf.ValidateVariants(os.ReadFile, func(name string) bool {
+			return variantSelected(variants, excludeVariants, name)
+		})
+		if err != nil {
+			// Variants selected by name may only exist in other config files.
+			if len(variants) > 0 {
+				continue
+			}
+			return nil, fmt.Errorf("config file %s: %w", configFile.path, err)
+		}
+		names := make([]string, 0, len(variantErrs))
+		for name := range variantErrs {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			result := validationResult{ConfigFile: configFile.path, Variant: name, Valid: true, Errors: []string{}}
+			if err := variantErrs[name]; err != nil {
+				result.Valid = false
+				result.Errors = errorMessages(err)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// errorMessages returns the messages of joined errors, one per error.
+func errorMessages(err error) []string {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []string{err.Error()}
+	}
+	var msgs []string
+	for _, err := range joined.Unwrap() {
+		msgs = append(msgs, errorMessages(err)...)
+	}
+	return msgs
+}
+
+// printValidationResults writes the results as a table or as JSON to out.
+// The table has one row per error, valid variants are listed as ok.
+func printValidationResults(out io.Writer, format string, results []validationResult) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG\tVARIANT\tSTATUS")
+	for _, result := range results {
+		variant := result.Variant
+		if variant == "" {
+			variant = "-"
+		}
+		if result.Valid {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.ConfigFile, variant, "ok")
+			continue
+		}
+		for _, msg := range result.Errors {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.ConfigFile, variant, msg)
+		}
+	}
+	return w.Flush()
+}
+
+type validateFlags struct {
+	variants        []string
+	excludeVariants []string
+	configPaths     []string
+	configDirPath   string
+	outputFormat    string
+}
+
+func parseValidateFlags(cmd *cobra.Command, args []string) (*validateFlags, error) {
+	variants, err := cmd.Flags().GetStringSlice("variant")
+	if err != nil {
+		return nil, fmt.Errorf("getting variant flag: %w", err)
+	}
+	excludeVariants, err := cmd.Flags().GetStringSlice("exclude-variant")
+	if err != nil {
+		return nil, fmt.Errorf("getting exclude-variant flag: %w", err)
+	}
+	configDirPath, err := cmd.Flags().GetString("config-dir")
+	if err != nil {
+		return nil, fmt.Errorf("getting config-dir flag: %w", err)
+	}
+	if len(args) > 0 && configDirPath != "" {
+		return nil, errors.New("config argument and config-dir flag are mutually exclusive")
+	}
+	outputFormat, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return nil, fmt.Errorf("getting output flag: %w", err)
+	}
+	if outputFormat != "table" && outputFormat != "json" {
+		return nil, fmt.Errorf("output format must be one of table, json, got %q", outputFormat)
+	}
+	return &validateFlags{
+		variants:        variants,
+		excludeVariants: excludeVariants,
+		configPaths:     args,
+		configDirPath:   configDirPath,
+		outputFormat:    outputFormat,
+	}, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) Edgeless Systems GmbH
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/edgelesssys/uplosi/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfigFiles(t *testing.T) {
+	base := config.Config{
+		Provider:     "azure",
+		Name:         "my-image",
+		ImageVersion: "1.2.3",
+		Azure: config.AzureConfig{
+			SubscriptionID:     "00000000-0000-0000-0000-000000000000",
+			Location:           "northeurope",
+			ResourceGroup:      "my-rg",
+			SharedImageGallery: "my_gallery",
+		},
+	}
+	newConfigFiles := func() []namedConfigFile {
+		return []namedConfigFile{
+			{path: "a.toml", conf: &config.ConfigFile{
+				Base: base,
+				Variants: map[string]config.Config{
+					"valid":   {},
+					"invalid": {Azure: config.AzureConfig{AttestationVariant: "invalid"}},
+				},
+			}},
+			{path: "b.toml", conf: &config.ConfigFile{Base: base}},
+		}
+	}
+
+	testCases := map[string]struct {
+		variants        []string
+		excludeVariants []string
+		want            []validationResult
+	}{
+		"all variants": {
+			want: []validationResult{
+				{ConfigFile: "a.toml", Variant: "invalid", Errors: []string{
+					`azure.attestationVariant "invalid" is not one of ["azure-tdx", "azure-sev-snp", "azure-trustedlaunch"]`,
+				}},
+				{ConfigFile: "a.toml", Variant: "valid", Valid: true, Errors: []string{}},
+				{ConfigFile: "b.toml", Variant: "", Valid: true, Errors: []string{}},
+			},
+		},
+		"selected variant": {
+			variants: []string{"valid"},
+			want: []validationResult{
+				{ConfigFile: "a.toml", Variant: "valid", Valid: true, Errors: []string{}},
+				{ConfigFile: "b.toml", Variant: "", Valid: true, Errors: []string{}},
+			},
+		},
+		"excluded variant": {
+			excludeVariants: []string{"invalid"},
+			want: []validationResult{
+				{ConfigFile: "a.toml", Variant: "valid", Valid: true, Errors: []string{}},
+				{ConfigFile: "b.toml", Variant: "", Valid: true, Errors: []string{}},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			results, err := validateConfigFiles(newConfigFiles(), tc.variants, tc.excludeVariants)
+			require.NoError(err)
+			assert.Equal(tc.want, results)
+		})
+	}
+}
+
+func TestPrintValidationResults(t *testing.T) {
+	assert := assert.New(t)
+
+	results := []validationResult{
+		{ConfigFile: "uplosi.conf", Variant: "a", Valid: true},
+		{ConfigFile: "uplosi.conf", Variant: "b", Errors: []string{"aws.bucket is required", "aws.region is required"}},
+		{ConfigFile: "other.toml", Valid: true},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(printValidationResults(&out, "table", results))
+	assert.Equal("CONFIG       VARIANT  STATUS\n"+
+		"uplosi.conf  a        ok\n"+
+		"uplosi.conf  b        aws.bucket is required\n"+
+		"uplosi.conf  b        aws.region is required\n"+
+		"other.toml   -        ok\n", out.String())
+}